@@ -34,6 +34,7 @@ import (
 	"strings"
 
 	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/selection"
 	"github.com/rmera/gochem/traj/dcd"
 	"github.com/rmera/gochem/traj/stf"
 	"github.com/rmera/gochem/traj/xtc"
@@ -191,6 +192,7 @@ func mobileLimit(limit float64, tolerance int, RMSD *rMSD) (int, float64) {
 //most rigid from mol, along the traj trajectory, considering only atoms with names in atomnames (normally, PDB names are used)
 //and belonging to one of the chains in chains (only the first slice given in chains will be considered, if nothing is given,
 //atoms from any chain will be considred.
+//If a selection is given in the options, the atoms in the selection are considered instead.
 //If you use this function in your research, please cite the references for the LOVO alignment method:
 //10.1371/journal.pone.0119264.
 //10.1186/1471-2105-8-306
@@ -206,6 +208,13 @@ func LOVO(mol chem.Atomer, ref *v3.Matrix, trajname string, opt ...*Options) (*L
 	//these are atom, not residue indexes!
 	const defaultTopRigid int = 10 //by default we obtain the 1/topdefaultTopRigid top rigid
 	var fullindexes []int = res2atoms(mol, o.atomNames, o.chains, nil)
+	if o.selection != "" {
+		var err error
+		fullindexes, err = selection.Select(mol, o.selection, ref)
+		if err != nil {
+			return nil, fmt.Errorf("LOVO: %w", err)
+		}
+	}
 	var printtraj string
 	printtraj = o.writeTraj
 	o.writeTraj = ""
@@ -281,8 +290,9 @@ func concproc(v chan *v3.Matrix, ref, t *v3.Matrix, r chan *rmsdandcoords, index
 }
 
 //RMSDTraj returns the RMSD for all atoms in a structure, averaged over the trajectory traj.
-//Frames are read in sets of cpus at the time: The read starts at the set begin/cpus and we skip a set every skip/cpu
-//(the numbers are, of course, rounded). The RMSDs and the total number of frames read are returned, together with an error or nil.
+//Frames are read in sets of cpus at the time: The read starts at the set begin/cpus and, after that, only one set
+//out of every skip/cpus+1 is used (the numbers are, of course, rounded). The RMSDs and the total number of frames read are returned, together with an error or nil.
+//If traj implements chem.Seeker, the frames skipped are not read at all.
 func RMSDTraj(mol chem.Atomer, ref *v3.Matrix, traj chem.ConcTraj, indexes []int, o *Options) ([]float64, int, error) {
 	RMSD := make([]float64, mol.Len())
 	var err error
@@ -312,13 +322,24 @@ func RMSDTraj(mol chem.Atomer, ref *v3.Matrix, traj chem.ConcTraj, indexes []int
 	}
 	var chans []chan *v3.Matrix
 	var chunksread int = 0
-	for read := 0; ; read++ {
+	//If the trajectory allows it, we jump over the frames we don't use, instead of reading them.
+	//read is always the index of the current set in the trajectory, so the same sets are used
+	//whether we jump or not.
+	seeker, _ := traj.(chem.Seeker)
+	start := 0
+	if seeker != nil && begin > 0 && seeker.Seek(begin*chunksize) == nil {
+		start = begin
+	}
+	for read := start; ; read++ {
 		if (read) < begin || (read)%(skip+1) != 0 {
-			c := make([]*v3.Matrix, chunksize) //all nil
-			_, err = traj.NextConc(c)
-			if err != nil {
-				break
+			if seeker == nil || seeker.Seek(seeker.Tell()+chunksize) != nil {
+				c := make([]*v3.Matrix, chunksize) //all nil
+				_, err = traj.NextConc(c)
+				if err != nil {
+					break
+				}
 			}
+			continue
 		}
 		chans, err = traj.NextConc(chunk)
 		if err != nil {
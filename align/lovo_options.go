@@ -10,6 +10,7 @@ type Options struct {
 	//The following are ignored by the RMSDTraj function
 	atomNames []string
 	chains    []string
+	selection string //if given, overrides atomNames and chains.
 	//	nMostRigid   int
 	writeTraj    string  //the name of a file to where the aligned trajectory will be written. Nothing will be written if empty.
 	lessThanRMSD float64 //instead of using a N for the most rigid, selects all residues with RMSD (the square root of the RMSD) < LessThanRMSD, in A. If >0, this overrrides NMostRigid
@@ -132,6 +133,16 @@ func (O *Options) Chains(chains ...[]string) []string {
 	return O.chains
 }
 
+//Returns the atom selection (see the selection package) considered for the
+//alignment, and sets it to a new value, if given. If the selection is not
+//empty, it is used instead of the atom names and chains.
+func (O *Options) Selection(sel ...string) string {
+	if len(sel) > 0 {
+		O.selection = sel[0]
+	}
+	return O.selection
+}
+
 //Returns the name of the files where the aligned trajectory will be written, and sets it to a
 //new value, if given. No trajectory file will be given if this value is set to an empty string.
 func (O *Options) TrajName(name ...string) string {
@@ -26,9 +26,11 @@ package align
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
 	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
 )
 
 func TestLovo(Te *testing.T) {
@@ -57,3 +59,52 @@ func TestLovo(Te *testing.T) {
 	fmt.Println(ret.String())
 	fmt.Println(ret.GMX("A"))
 }
+
+// noSeek hides the Seek method of a trajectory.
+type noSeek struct {
+	chem.ConcTraj
+}
+
+func TestRMSDTrajSkip(Te *testing.T) {
+	//A rigid triangle and an atom that moves 0.1 A along z in each frame.
+	const nframes = 12
+	ref, _ := v3.NewMatrix([]float64{0, 0, 0, 3, 0, 0, 0, 4, 0, 1, 1, 0})
+	frames := make([]*v3.Matrix, nframes)
+	for f := range frames {
+		frames[f] = v3.Zeros(4)
+		frames[f].Copy(ref)
+		frames[f].Set(3, 2, 0.1*float64(f))
+	}
+	top := chem.NewTopology(0, 1)
+	for i := 0; i < 4; i++ {
+		top.AppendAtom(&chem.Atom{Name: "CA", Symbol: "C", MolName: "ALA", MolID: i + 1, ID: i + 1})
+	}
+	mol, err := chem.NewMolecule(frames, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	o := DefaultOptions()
+	o.Cpus(2)
+	o.Begin(2)
+	o.Skip(2)
+	//The sets 1, 2, 3, 4 and 5 are after begin, and only the sets 2 and 4, the frames 4, 5, 8 and 9, are used.
+	seek, n, err := RMSDTraj(mol, ref, mol, []int{0, 1, 2}, o)
+	if err != nil || n != 4 {
+		Te.Fatalf("Expected 4 frames with Seek, got %d, error: %v", n, err)
+	}
+	mol.InitRead()
+	read, n, err := RMSDTraj(mol, ref, noSeek{mol}, []int{0, 1, 2}, o)
+	if err != nil || n != 4 {
+		Te.Fatalf("Expected 4 frames without Seek, got %d, error: %v", n, err)
+	}
+	for i := range seek {
+		if math.Abs(seek[i]-read[i]) > 1e-9 {
+			Te.Errorf("Different RMSDs with and without Seek: %v %v", seek, read)
+			break
+		}
+	}
+	//The last atom is 0.4, 0.5, 0.8 and 0.9 A away from its position in the reference.
+	if math.Abs(seek[0]) > 1e-6 || math.Abs(seek[3]-0.65) > 1e-6 {
+		Te.Errorf("Wrong RMSDs: %v", seek)
+	}
+}
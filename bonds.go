@@ -363,3 +363,40 @@ func FindRings(coords *v3.Matrix, mol Atomer, addHs ...bool) []*Ring {
 	}
 	return rings
 }
+
+// atomerBonds returns the index of each atom in mol, and the bonds between atoms
+// in mol, each bond only once. Atoms may not have their indexes set, which is
+// why we build our own.
+func atomerBonds(mol Atomer) (map[*Atom]int, []*Bond) {
+	natoms := mol.Len()
+	indexes := make(map[*Atom]int, natoms)
+	for i := 0; i < natoms; i++ {
+		indexes[mol.Atom(i)] = i
+	}
+	var bonds []*Bond
+	seen := make(map[*Bond]bool)
+	for i := 0; i < natoms; i++ {
+		for _, b := range mol.Atom(i).Bonds {
+			if seen[b] {
+				continue
+			}
+			seen[b] = true
+			_, ok1 := indexes[b.At1]
+			_, ok2 := indexes[b.At2]
+			if ok1 && ok2 {
+				bonds = append(bonds, b)
+			}
+		}
+	}
+	return indexes, bonds
+}
+
+// atomsBonded returns true if there is a bond between at1 and at2.
+func atomsBonded(at1, at2 *Atom) bool {
+	for _, b := range at1.Bonds {
+		if (b.At1 == at1 && b.At2 == at2) || (b.At1 == at2 && b.At2 == at1) {
+			return true
+		}
+	}
+	return false
+}
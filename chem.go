@@ -26,8 +26,10 @@ package chem
 
 import (
 	"fmt"
+	"math"
 	"sort"
 
+	"github.com/rmera/gochem/spatial"
 	v3 "github.com/rmera/gochem/v3"
 )
 
@@ -49,13 +51,17 @@ type Atom struct {
 	MolName   string  //PDB name of the residue or molecule (3-letter code for residues)
 	MolName1  byte    //the one letter name for residues and nucleotids
 	Char16    byte    //Whatever is in the column 16 (counting from 0) in a PDB file, anything.
+	AltLoc    string  //Alternate location indicator, empty for atoms with only one location.
 	MolID     int     //PDB index of the corresponding residue or molecule
+	InsCode   string  //Insertion code of the residue, as in 52A. Empty if not used.
 	Chain     string  //One-character PDB name for a chain.
+	SegID     string  //Segment identifier, as in CHARMM/NAMD PSF files.
 	Mass      float64 //hopefully all these float64 are not too much memory
 	Occupancy float64 //a PDB crystallographic field, often used to store values of interest.
 	Vdw       float64 //radius
 	Charge    float64 //Partial charge on an atom
 	Symbol    string
+	Type      string  //Atom type (SYBYL, force field, etc), if available.
 	Het       bool    // is the atom an hetatm in the pdb file? (if applicable)
 	Bonds     []*Bond //The bonds connecting the atom to others.
 }
@@ -73,13 +79,17 @@ func (N *Atom) Copy(A *Atom) {
 	N.Tag = A.Tag
 	N.MolName = A.MolName
 	N.MolName1 = A.MolName1
+	N.AltLoc = A.AltLoc
 	N.MolID = A.MolID
+	N.InsCode = A.InsCode
 	N.Chain = A.Chain
+	N.SegID = A.SegID
 	N.Mass = A.Mass
 	N.Occupancy = A.Occupancy
 	N.Vdw = A.Vdw
 	N.Charge = A.Charge
 	N.Symbol = A.Symbol
+	N.Type = A.Type
 	N.Het = A.Het
 }
 
@@ -311,50 +321,41 @@ func (T *Topology) RemoveBonds() {
 // criterium, similar to that described in DOI:10.1186/1758-2946-3-33
 func (T *Topology) AssignBonds(coord *v3.Matrix) error {
 	T.RemoveBonds()
-	// might get slow for
-	//large systems. It's really not thought
-	//for proteins or macromolecules.
-	//For this reason, this method is not called automatically when building a new topology.
-	//Well, that and that it requires a Matrix object, which would mean changing the
+	//The possible bonds are found with a cell list, so this
+	//works also for large systems. Still, this method is not called automatically
+	//when building a new topology, as it requires a Matrix object, which would mean changing the
 	//signature of the NewTopology function.
-	var t1, t2 *v3.Matrix
-	var at1, at2 *Atom
 	T.FillIndexes()
-	t3 := v3.Zeros(1)
-	bonds := make([]*Bond, 0, T.Len())
 	tot := T.Len()
-	var nextIndex int
-	for i := 0; i < tot; i++ {
-		t1 = coord.VecView(i)
-		at1 = T.Atoms[i]
-		cov1 := symbolCovrad[at1.Symbol]
-		if cov1 == 0 {
+	covs := make([]float64, tot)
+	maxcov := 0.0
+	for i, at := range T.Atoms {
+		covs[i] = symbolCovrad[at.Symbol]
+		if covs[i] == 0 {
 			err := new(CError)
-			err.msg = fmt.Sprintf("Couldn't find the covalent radii  for %s %d", at1.Symbol, i)
+			err.msg = fmt.Sprintf("Couldn't find the covalent radii  for %s %d", at.Symbol, i)
 			err.Decorate("AssignBonds")
 			return err
 		}
-		for j := i + 1; j < tot; j++ {
-			t2 = coord.VecView(j)
-			at2 = T.Atoms[j]
-			cov2 := symbolCovrad[at2.Symbol]
-			if cov2 == 0 {
-				err := new(CError)
-				err.msg = fmt.Sprintf("Couldn't find the covalent radii  for %s %d", at2.Symbol, j)
-				err.Decorate("AssignBonds")
-				return err
-			}
-
-			t3.Sub(t2, t1)
-			d := t3.Norm(2)
-			if d < cov1+cov2+bondtol && d > tooclose {
+		maxcov = math.Max(maxcov, covs[i])
+	}
+	bonds := make([]*Bond, 0, tot)
+	var nextIndex int
+	if tot > 1 {
+		cells, err := spatial.NewCellList(coord, 2*maxcov+bondtol)
+		if err != nil {
+			return CError{err.Error(), []string{"spatial.NewCellList", "AssignBonds"}}
+		}
+		for _, p := range cells.Pairs(2*maxcov + bondtol) {
+			d := p.Dist
+			if d < covs[p.I]+covs[p.J]+bondtol && d > tooclose {
+				at1, at2 := T.Atoms[p.I], T.Atoms[p.J]
 				b := &Bond{Index: nextIndex, Dist: d, At1: at1, At2: at2}
 				at1.Bonds = append(at1.Bonds, b)
 				at2.Bonds = append(at2.Bonds, b)
 				bonds = append(bonds, b) //just to easily keep track of them.
 				nextIndex++
 			}
-
 		}
 	}
 
@@ -418,9 +419,33 @@ type Molecule struct {
 	XYZFileData []string //This can be anything. The main rationale for including it is that XYZ files have a "comment"
 	//line after the first one. This line is sometimes used to write the energy of the structure.
 	//So here the line can be kept for each XYZ frame, and parse later
+	Cell    *UnitCell //The crystallographic unit cell, if given in the file read. nil otherwise.
 	current int
 }
 
+// UnitCell is a crystallographic unit cell, with lengths in A and angles in degrees.
+type UnitCell struct {
+	Lengths    [3]float64 //a, b, c
+	Angles     [3]float64 //alpha, beta, gamma
+	SpaceGroup string     //In Hermann-Mauguin notation
+	Z          int        //Number of polymeric chains (or molecules) in the cell.
+}
+
+// Vectors returns the cell vectors a, b and c, one after the other, as in the
+// box slices of trajectories. a is along the x axis, and b is in the xy plane.
+func (U *UnitCell) Vectors() []float64 {
+	rad := math.Pi / 180
+	cosa, cosb, cosg := math.Cos(U.Angles[0]*rad), math.Cos(U.Angles[1]*rad), math.Cos(U.Angles[2]*rad)
+	sing := math.Sin(U.Angles[2] * rad)
+	cy := (cosa - cosb*cosg) / sing
+	a, b, c := U.Lengths[0], U.Lengths[1], U.Lengths[2]
+	return []float64{
+		a, 0, 0,
+		b * cosg, b * sing, 0,
+		c * cosb, c * cy, c * math.Sqrt(math.Max(0, 1-cosb*cosb-cy*cy)),
+	}
+}
+
 // NewMolecule makes a molecule with ats atoms, coords coordinates, bfactors b-factors
 // charge charge and unpaired unpaired electrons, and returns it. It doesnt check for
 // consitency across slices or correct charge or unpaired electrons.
@@ -508,6 +533,10 @@ func (M *Molecule) Copy(A *Molecule) {
 		tmp2 := copyB(A.Bfactors[key])
 		M.Bfactors = append(M.Bfactors, tmp2)
 	}
+	if A.Cell != nil {
+		cell := *A.Cell
+		M.Cell = &cell
+	}
 	if err := M.Corrupted(); err != nil {
 		panic(PanicMsg(fmt.Sprintf("goChem: Molecule creation error: %s", err.Error())))
 	}
@@ -699,13 +728,17 @@ func (M *Molecule) Readable() bool {
 }
 
 // Next puts the next frame into V and returns  an error or nil
-// The box argument is never used.
+// If a box is given and the molecule has a unit cell, the cell vectors
+// are put in the box. The box is not touched otherwise.
 func (M *Molecule) Next(V *v3.Matrix, box ...[]float64) error {
 	if M.current >= len(M.Coords) {
 		return newlastFrameError("", len(M.Coords)-1)
 	}
 	//	fmt.Println("CURR", M.current, len(M.Coords), V.NVecs(), M.Coords[M.current].NVecs()) ////////////////
 	M.current++
+	if len(box) > 0 && len(box[0]) >= 9 && M.Cell != nil {
+		copy(box[0], M.Cell.Vectors())
+	}
 	if V == nil {
 		return nil
 	}
@@ -754,6 +787,20 @@ func (M *Molecule) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 	return toreturn, nil
 }
 
+// Seek sets the molecule, as a trajectory, so the next frame read is frame.
+func (M *Molecule) Seek(frame int) error {
+	if frame < 0 || frame >= len(M.Coords) {
+		return CError{fmt.Sprintf("Frame %d out of range, the molecule has %d frames", frame, len(M.Coords)), []string{"Molecule.Seek"}}
+	}
+	M.current = frame
+	return nil
+}
+
+// Tell returns the index of the next frame to be read by Next.
+func (M *Molecule) Tell() int {
+	return M.current
+}
+
 // Close just sets the "current" counter to 0.
 // If you are using it as a trajectory, you can always just discard the molecule
 // and let the CG take care of it, as there is nothing on disk linked to it..
@@ -298,3 +298,32 @@ func EncodeCoords(coords *v3.Matrix, enc *json.Encoder) *Error {
 	}
 	return nil
 }
+
+//EncodeTopology returns the atoms in mol as a one-line JSON array. The bonds of the atoms are
+//not included, as they refer back to the atoms.
+func EncodeTopology(mol chem.Atomer) ([]byte, *Error) {
+	const funcname = "EncodeTopology"
+	atoms := make([]chem.Atom, mol.Len())
+	for i := range atoms {
+		atoms[i] = *mol.Atom(i)
+		atoms[i].Bonds = nil
+	}
+	ret, err := json.Marshal(atoms)
+	if err != nil {
+		return nil, NewError("postprocess", funcname, err)
+	}
+	return ret, nil
+}
+
+//DecodeTopology decodes a JSON array of atoms, as produced by EncodeTopology, into a topology
+//with the given charge and multiplicity.
+func DecodeTopology(data []byte, charge, multi int) (*chem.Topology, *Error) {
+	const funcname = "DecodeTopology"
+	var atoms []*chem.Atom
+	if err := json.Unmarshal(data, &atoms); err != nil {
+		return nil, NewError("selection", funcname, err)
+	}
+	top := chem.NewTopology(charge, multi, atoms)
+	top.FillIndexes()
+	return top, nil
+}
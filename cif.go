@@ -0,0 +1,389 @@
+/*
+ * cif.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cifToken is a token in a CIF file. Keywords (data_, loop_, etc.) and item names
+// are never quoted, so quoted tokens are always values.
+type cifToken struct {
+	val    string
+	quoted bool
+}
+
+// isName returns true if the token is an item name (i.e. _atom_site.id).
+func (t cifToken) isName() bool {
+	return !t.quoted && strings.HasPrefix(t.val, "_")
+}
+
+// isKeyword returns true if the token is a reserved word (data_, loop_, save_, global_ or stop_).
+func (t cifToken) isKeyword() bool {
+	if t.quoted {
+		return false
+	}
+	v := tl(t.val)
+	for _, k := range []string{"data_", "loop_", "save_", "global_", "stop_"} {
+		if strings.HasPrefix(v, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the value of the token. The unquoted '.' and '?', which mean
+// "not applicable" and "unknown", are returned as empty strings.
+func (t cifToken) value() string {
+	if !t.quoted && (t.val == "." || t.val == "?") {
+		return ""
+	}
+	return t.val
+}
+
+// cifTokenizer splits a CIF file in tokens.
+type cifTokenizer struct {
+	in     *bufio.Reader
+	line   string
+	pos    int
+	lineno int
+	back   *cifToken //a token returned with unread.
+}
+
+func newCIFTokenizer(r io.Reader) *cifTokenizer {
+	return &cifTokenizer{in: bufio.NewReader(r)}
+}
+
+// readLine reads the next line, without the line ending.
+func (c *cifTokenizer) readLine() (string, error) {
+	line, err := c.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	c.lineno++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// unread makes t the next token returned by next.
+func (c *cifTokenizer) unread(t cifToken) {
+	c.back = &t
+}
+
+// next returns the next token in the file. At the end of the file, it returns io.EOF.
+func (c *cifTokenizer) next() (cifToken, error) {
+	if c.back != nil {
+		t := *c.back
+		c.back = nil
+		return t, nil
+	}
+	for {
+		for c.pos < len(c.line) && (c.line[c.pos] == ' ' || c.line[c.pos] == '\t') {
+			c.pos++
+		}
+		if c.pos < len(c.line) && c.line[c.pos] != '#' {
+			break
+		}
+		//We are done with this line, on to the next one.
+		line, err := c.readLine()
+		if err != nil {
+			return cifToken{}, err
+		}
+		c.line, c.pos = line, 0
+		if strings.HasPrefix(line, ";") {
+			return c.textField()
+		}
+	}
+	start := c.pos
+	if q := c.line[start]; q == '\'' || q == '"' {
+		//A quoted string ends with a quote followed by a blank or the end of the line,
+		//so it can contain the quote character (as in 'O5'').
+		for i := start + 1; i < len(c.line); i++ {
+			if c.line[i] == q && (i+1 == len(c.line) || c.line[i+1] == ' ' || c.line[i+1] == '\t') {
+				c.pos = i + 1
+				return cifToken{val: c.line[start+1 : i], quoted: true}, nil
+			}
+		}
+		return cifToken{}, fmt.Errorf("unterminated quoted string in line %d", c.lineno)
+	}
+	for c.pos < len(c.line) && c.line[c.pos] != ' ' && c.line[c.pos] != '\t' {
+		c.pos++
+	}
+	return cifToken{val: c.line[start:c.pos]}, nil
+}
+
+// textField reads a multi-line text field. The current line, which starts with ';',
+// is the first one of the field, which ends with a line starting with ';'.
+func (c *cifTokenizer) textField() (cifToken, error) {
+	lines := []string{c.line[1:]}
+	start := c.lineno
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return cifToken{}, fmt.Errorf("unterminated text field starting in line %d", start)
+		}
+		if strings.HasPrefix(line, ";") {
+			c.line, c.pos = line, 1
+			return cifToken{val: strings.TrimSpace(strings.Join(lines, "\n")), quoted: true}, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// cifCategory contains the items in a category (i.e. _atom_site) of a CIF file.
+// Categories not given as a loop have only one row.
+type cifCategory struct {
+	items map[string]int //the column for each item, by name (in lowercase and without the category).
+	rows  [][]string
+}
+
+// col returns the column of the first of the given items present in the category, or -1
+// if none are present.
+func (c *cifCategory) col(items ...string) int {
+	for _, it := range items {
+		if i, ok := c.items[it]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the value in the given row for the first of the given items that is
+// present in the category and has a value. If none does, it returns an empty string.
+func (c *cifCategory) get(row int, items ...string) string {
+	for _, it := range items {
+		if i, ok := c.items[it]; ok && i < len(c.rows[row]) && c.rows[row][i] != "" {
+			return c.rows[row][i]
+		}
+	}
+	return ""
+}
+
+// float returns the value in the given row for the first of the given items present, as a float.
+// If there is no value, it returns 0 and no error.
+func (c *cifCategory) float(row int, items ...string) (float64, error) {
+	s := c.get(row, items...)
+	if s == "" {
+		return 0, nil
+	}
+	//Standard uncertainties can be given in parenthesis, as in 12.345(6).
+	if i := strings.Index(s, "("); i > 0 {
+		s = s[:i]
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// cifBlock is a data block of a CIF file, with its categories, by name (in lowercase).
+type cifBlock struct {
+	name       string
+	categories map[string]*cifCategory
+}
+
+// category returns the category with the given name or nil, if there is no such category.
+func (b *cifBlock) category(name string) *cifCategory {
+	return b.categories[name]
+}
+
+// splitCIFName returns the category and item of an item name. _atom_site.id gives
+// "_atom_site" and "id". Names from older dictionaries, with no dot,
+// are taken as a category with one item with an empty name.
+func splitCIFName(name string) (string, string) {
+	name = tl(name)
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// readCIF reads the first data block in a CIF file. Save frames are skipped.
+func readCIF(r io.Reader) (*cifBlock, error) {
+	c := newCIFTokenizer(r)
+	var block *cifBlock
+	wrap := func(err error) error {
+		return fmt.Errorf("readCIF: line %d: %w", c.lineno, err)
+	}
+	for {
+		t, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, wrap(err)
+		}
+		low := tl(t.val)
+		switch {
+		case !t.quoted && strings.HasPrefix(low, "data_"):
+			if block != nil {
+				return block, nil //we only read one block.
+			}
+			block = &cifBlock{name: t.val[5:], categories: make(map[string]*cifCategory)}
+		case block == nil:
+			return nil, wrap(fmt.Errorf("data found before the first data block: %s", t.val))
+		case !t.quoted && strings.HasPrefix(low, "save_"):
+			if err := c.skipSaveFrame(); err != nil {
+				return nil, wrap(err)
+			}
+		case !t.quoted && low == "loop_":
+			if err := c.readLoop(block); err != nil {
+				return nil, wrap(err)
+			}
+		case t.isName():
+			v, err := c.next()
+			if err != nil || v.isName() || v.isKeyword() {
+				return nil, wrap(fmt.Errorf("no value for %s", t.val))
+			}
+			catname, item := splitCIFName(t.val)
+			cat, ok := block.categories[catname]
+			if !ok {
+				cat = &cifCategory{items: make(map[string]int), rows: [][]string{nil}}
+				block.categories[catname] = cat
+			}
+			cat.items[item] = len(cat.rows[0])
+			cat.rows[0] = append(cat.rows[0], v.value())
+		case t.isKeyword(): //global_ and stop_ are not used in CIF files, we just ignore them.
+		default:
+			return nil, wrap(fmt.Errorf("value without a name: %s", t.val))
+		}
+	}
+	if block == nil {
+		return nil, fmt.Errorf("readCIF: no data block found")
+	}
+	return block, nil
+}
+
+// readLoop reads the names and values of a loop, and adds them as a category to block.
+func (c *cifTokenizer) readLoop(block *cifBlock) error {
+	var cat *cifCategory
+	var catname string
+	ncols := 0
+	for {
+		t, err := c.next()
+		if err != nil {
+			return fmt.Errorf("loop with no values")
+		}
+		if !t.isName() {
+			c.unread(t)
+			break
+		}
+		name, item := splitCIFName(t.val)
+		if cat == nil {
+			catname = name
+			cat = &cifCategory{items: make(map[string]int)}
+		} else if name != catname {
+			return fmt.Errorf("loop with items from several categories: %s and %s", catname, name)
+		}
+		cat.items[item] = ncols
+		ncols++
+	}
+	if cat == nil {
+		return fmt.Errorf("loop with no names")
+	}
+	var vals []string
+	for {
+		t, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if t.isName() || t.isKeyword() {
+			c.unread(t)
+			break
+		}
+		vals = append(vals, t.value())
+	}
+	if len(vals)%ncols != 0 {
+		return fmt.Errorf("the number of values in the loop for %s is not a multiple of the number of items", catname)
+	}
+	prev, ok := block.categories[catname]
+	if !ok {
+		cat.rows = make([][]string, 0, len(vals)/ncols)
+		for i := 0; i < len(vals); i += ncols {
+			cat.rows = append(cat.rows, vals[i:i+ncols:i+ncols])
+		}
+		block.categories[catname] = cat
+		return nil
+	}
+	//Several loops for the same category, as in the files written by PDBxCompactFileWrite.
+	//The new rows are added to the previous ones, with empty values for the missing items.
+	cols := make([]int, ncols)
+	for item, j := range cat.items {
+		k, ok := prev.items[item]
+		if !ok {
+			k = len(prev.items)
+			prev.items[item] = k
+		}
+		cols[j] = k
+	}
+	width := len(prev.items)
+	for i, r := range prev.rows {
+		if len(r) < width {
+			prev.rows[i] = append(r, make([]string, width-len(r))...)
+		}
+	}
+	for i := 0; i < len(vals); i += ncols {
+		row := make([]string, width)
+		for j, k := range cols {
+			row[k] = vals[i+j]
+		}
+		prev.rows = append(prev.rows, row)
+	}
+	return nil
+}
+
+// skipSaveFrame skips the tokens until the end of a save frame (a save_ token).
+func (c *cifTokenizer) skipSaveFrame() error {
+	for {
+		t, err := c.next()
+		if err != nil {
+			return fmt.Errorf("unterminated save frame")
+		}
+		if !t.quoted && tl(t.val) == "save_" {
+			return nil
+		}
+	}
+}
+
+// cifQuote returns s quoted as needed to be written as a value in a CIF file.
+func cifQuote(s string) string {
+	switch {
+	case s == "":
+		return "?"
+	case strings.ContainsAny(s, "\n\r"):
+		return "\n;" + s + "\n;\n"
+	case !strings.ContainsAny(s, " \t'\"") && !strings.ContainsAny(s[:1], "_#$;[]") &&
+		!(cifToken{val: s}).isKeyword() && s != "." && s != "?":
+		return s
+	case !strings.Contains(s, "' ") && !strings.HasSuffix(s, "'"):
+		return "'" + s + "'"
+	default:
+		return "\"" + s + "\""
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * compress.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The magic numbers at the beginning of the compressed formats supported.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// compressedFile is a file wrapped in a decompressor or compressor.
+// Close closes both, in order.
+type compressedFile struct {
+	io.Reader
+	io.Writer
+	closers []func() error
+}
+
+func (c *compressedFile) Close() error {
+	var err error
+	for _, f := range c.closers {
+		if e := f(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// openRead opens the file name for reading. If the file is compressed with gzip,
+// bzip2, zstd or xz, which is detected from its first bytes, not from its extension,
+// the returned reader decompresses it transparently.
+func openRead(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	in := bufio.NewReader(f)
+	magic, _ := in.Peek(len(xzMagic)) //the longest one. Shorter files just can't be compressed.
+	c := &compressedFile{Reader: in, closers: []func() error{f.Close}}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		c.Reader = gz
+		c.closers = []func() error{gz.Close, f.Close}
+	case bytes.HasPrefix(magic, bzip2Magic):
+		c.Reader = bzip2.NewReader(in)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zs, err := zstd.NewReader(in)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		c.Reader = zs
+		c.closers = []func() error{func() error { zs.Close(); return nil }, f.Close}
+	case bytes.HasPrefix(magic, xzMagic):
+		x, err := xz.NewReader(in)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		c.Reader = x
+	}
+	return c, nil
+}
+
+// createWrite creates the file name for writing. If name ends in .gz, .zst or .xz,
+// what is written to the returned writer is compressed accordingly. Writing bzip2 (.bz2)
+// files is not supported. The file is complete only after the writer is closed.
+func createWrite(name string) (io.WriteCloser, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".bz2" {
+		return nil, fmt.Errorf("%s: writing bzip2-compressed files is not supported", name)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &compressedFile{Writer: f, closers: []func() error{f.Close}}
+	var w io.WriteCloser
+	switch ext {
+	case ".gz":
+		w = gzip.NewWriter(f)
+	case ".zst", ".zstd":
+		w, err = zstd.NewWriter(f, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	case ".xz":
+		w, err = xz.NewWriter(f)
+	default:
+		return c, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	c.Writer = w
+	c.closers = []func() error{w.Close, f.Close}
+	return c, nil
+}
@@ -0,0 +1,373 @@
+/*
+ * contacts.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package contacts analyzes the contacts between residues, as needed in folding and binding studies:
+// Residue-residue contact maps, for structures and averaged over trajectories, and the fraction
+// of native contacts, Q, as defined by R. B. Best, G. Hummer and W. A. Eaton (PNAS 110, 17874, 2013).
+//
+// Contact maps are returned as [][]float64, with one row and column per residue, so they can be
+// plotted directly, or put in a histo.Matrix.
+package contacts
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/spatial"
+	"github.com/rmera/gochem/traj"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Options contains the options for the contact analyses.
+type Options struct {
+	cutoff     float64
+	smooth     bool
+	beta       float64
+	lambda     float64
+	separation int
+}
+
+// DefaultOptions returns the options of Best, Hummer and Eaton for all-atom
+// structures: A cutoff of 4.5 A, beta=5 1/A, lambda=1.8, and native contacts only between residues
+// at least 4 positions apart in the sequence. Contact maps use the cutoff, without switching
+// function.
+func DefaultOptions() *Options {
+	return &Options{cutoff: 4.5, beta: 5, lambda: 1.8, separation: 4}
+}
+
+// Cutoff returns the distance, in A, under which two atoms are in contact, and sets it to a new value,
+// if a valid one is given.
+func (O *Options) Cutoff(c ...float64) float64 {
+	if len(c) > 0 && c[0] > 0 {
+		O.cutoff = c[0]
+	}
+	return O.cutoff
+}
+
+// Smooth returns true if contact maps use the switching function 1/(1+exp(beta*(r-cutoff))) of the
+// distance r between residues, instead of 1 for residues closer than the cutoff and 0 otherwise,
+// and sets it to a new value, if given.
+func (O *Options) Smooth(s ...bool) bool {
+	if len(s) > 0 {
+		O.smooth = s[0]
+	}
+	return O.smooth
+}
+
+// Beta returns the steepness of the switching functions, in 1/A, and sets it to a new value, if a
+// valid one is given.
+func (O *Options) Beta(b ...float64) float64 {
+	if len(b) > 0 && b[0] > 0 {
+		O.beta = b[0]
+	}
+	return O.beta
+}
+
+// Lambda returns the factor by which the distance of a native contact in the reference is multiplied to
+// obtain the distance at which the contact is half formed, and sets it to a new value, if a valid one is given.
+// Best, Hummer and Eaton use 1.8 for all-atom models and 1.5 for coarse-grained ones.
+func (O *Options) Lambda(l ...float64) float64 {
+	if len(l) > 0 && l[0] > 0 {
+		O.lambda = l[0]
+	}
+	return O.lambda
+}
+
+// Separation returns the minimum number of positions between two residues of the same chain for their atoms
+// to be native contacts, and sets it to a new value, if a valid one is given.
+func (O *Options) Separation(n ...int) int {
+	if len(n) > 0 && n[0] >= 0 {
+		O.separation = n[0]
+	}
+	return O.separation
+}
+
+// Residue is a residue, with the indexes of its atoms.
+type Residue struct {
+	Chain   string
+	MolID   int
+	InsCode string
+	MolName string
+	Atoms   []int
+}
+
+// Residues returns the residues with the given MolIDs, in the given chains, in mol, with their atoms,
+// excluding hydrogens. As in chem.Molecules2Atoms, a nil chains selects all the chains, and a nil
+// molIDs, all the residues. Residues are consecutive atoms with the same MolID, chain and insertion code.
+func Residues(mol chem.Atomer, molIDs []int, chains []string) []*Residue {
+	var atoms []int
+	if molIDs == nil {
+		for i := 0; i < mol.Len(); i++ {
+			if at := mol.Atom(i); len(chains) == 0 || contains(chains, at.Chain) {
+				atoms = append(atoms, i)
+			}
+		}
+	} else {
+		atoms = chem.Molecules2Atoms(mol, molIDs, chains)
+	}
+	var ret []*Residue
+	var current *Residue
+	for _, i := range atoms {
+		at := mol.Atom(i)
+		if at.Symbol == "H" {
+			continue
+		}
+		if current == nil || at.MolID != current.MolID || at.Chain != current.Chain || at.InsCode != current.InsCode {
+			current = &Residue{Chain: at.Chain, MolID: at.MolID, InsCode: at.InsCode, MolName: at.MolName}
+			ret = append(ret, current)
+		}
+		current.Atoms = append(current.Atoms, i)
+	}
+	return ret
+}
+
+func contains(s []string, v string) bool {
+	for _, w := range s {
+		if w == v {
+			return true
+		}
+	}
+	return false
+}
+
+// switching returns 1/(1+exp(beta*(r-r0))).
+func switching(r, r0, beta float64) float64 {
+	return 1 / (1 + math.Exp(beta*(r-r0)))
+}
+
+// MinDistances returns, for each pair of residues, the shortest distance between their atoms, if it is shorter than
+// maxdist, or +Inf otherwise. The diagonal is 0.
+func MinDistances(coords *v3.Matrix, res []*Residue, maxdist float64) ([][]float64, error) {
+	var atoms, owner []int
+	for i, r := range res {
+		for _, a := range r.Atoms {
+			if a < 0 || a >= coords.NVecs() {
+				return nil, fmt.Errorf("MinDistances: atom %d out of range for a structure with %d atoms", a, coords.NVecs())
+			}
+			atoms = append(atoms, a)
+			owner = append(owner, i)
+		}
+	}
+	ret := make([][]float64, len(res))
+	for i := range ret {
+		ret[i] = make([]float64, len(res))
+		for j := range ret[i] {
+			if i != j {
+				ret[i][j] = math.Inf(1)
+			}
+		}
+	}
+	if len(atoms) < 2 {
+		return ret, nil
+	}
+	sub := v3.Zeros(len(atoms))
+	sub.SomeVecs(coords, atoms)
+	cells, err := spatial.NewCellList(sub, maxdist)
+	if err != nil {
+		return nil, fmt.Errorf("MinDistances: %w", err)
+	}
+	for _, p := range cells.Pairs(maxdist) {
+		i, j := owner[p.I], owner[p.J]
+		if i != j && p.Dist < ret[i][j] {
+			ret[i][j], ret[j][i] = p.Dist, p.Dist
+		}
+	}
+	return ret, nil
+}
+
+// Map returns the contact map of the residues res, in the structure with the coordinates coords.
+// The element i, j is 1 if the residues i and j have atoms closer than options.Cutoff(), and 0
+// otherwise or, if options.Smooth() is true, the switching function of the shortest distance
+// between their atoms. The diagonal is 0.
+func Map(coords *v3.Matrix, res []*Residue, options ...*Options) ([][]float64, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	maxdist := o.cutoff
+	if o.smooth {
+		maxdist += 10 / o.beta //Beyond this, the switching function is less than 5e-5.
+	}
+	ret, err := MinDistances(coords, res, maxdist)
+	if err != nil {
+		return nil, fmt.Errorf("Map: %w", err)
+	}
+	for i := range ret {
+		for j, d := range ret[i] {
+			switch {
+			case i == j || math.IsInf(d, 1):
+				ret[i][j] = 0
+			case o.smooth:
+				ret[i][j] = switching(d, o.cutoff, o.beta)
+			case d < o.cutoff:
+				ret[i][j] = 1
+			default:
+				ret[i][j] = 0
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Frequencies returns the average of the contact maps (see Map) of the residues res over the remaining
+// frames of the trajectory t, i.e., the fraction of the frames in which each pair of residues is in
+// contact, and the number of frames read. If options is nil, the defaults are used. The frames are processed
+// concurrently (see traj.Parallel) with the parallel options given. If a frame can't be read, the average over
+// the frames processed before is returned, with their number, together with the error.
+func Frequencies(t chem.Traj, res []*Residue, options *Options, parallel ...*traj.ParallelOptions) ([][]float64, int, error) {
+	o := options
+	if o == nil {
+		o = DefaultOptions()
+	}
+	ret := make([][]float64, len(res))
+	for i := range ret {
+		ret[i] = make([]float64, len(res))
+	}
+	var mu sync.Mutex
+	read := 0 //the frames added to ret.
+	_, err := traj.Parallel(t, func(frame int, coords *v3.Matrix) (struct{}, error) {
+		m, err := Map(coords, res, o)
+		if err != nil {
+			return struct{}{}, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range m {
+			for j, v := range m[i] {
+				ret[i][j] += v
+			}
+		}
+		read++
+		return struct{}{}, nil
+	}, parallel...)
+	if read > 0 {
+		for i := range ret {
+			for j := range ret[i] {
+				ret[i][j] /= float64(read)
+			}
+		}
+	}
+	if err != nil {
+		return ret, read, fmt.Errorf("Frequencies: %w", err)
+	}
+	return ret, read, nil
+}
+
+// Contact is a pair of atoms, by index, in contact in a reference structure, at the distance R0.
+type Contact struct {
+	I, J int
+	R0   float64
+}
+
+// Native is a set of native contacts, obtained from a reference structure.
+type Native struct {
+	Contacts []Contact
+	beta     float64
+	lambda   float64
+}
+
+// NewNative returns the native contacts between the atoms of the residues res in the reference structure
+// with the coordinates ref: All the pairs of atoms closer than options.Cutoff(), from residues of different
+// chains, or from residues of the same chain separated by at least options.Separation() positions in res.
+func NewNative(ref *v3.Matrix, res []*Residue, options ...*Options) (*Native, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	var atoms, owner []int
+	for i, r := range res {
+		for _, a := range r.Atoms {
+			if a < 0 || a >= ref.NVecs() {
+				return nil, fmt.Errorf("NewNative: atom %d out of range for a structure with %d atoms", a, ref.NVecs())
+			}
+			atoms = append(atoms, a)
+			owner = append(owner, i)
+		}
+	}
+	N := &Native{beta: o.beta, lambda: o.lambda}
+	if len(atoms) < 2 {
+		return N, nil
+	}
+	sub := v3.Zeros(len(atoms))
+	sub.SomeVecs(ref, atoms)
+	cells, err := spatial.NewCellList(sub, o.cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("NewNative: %w", err)
+	}
+	for _, p := range cells.Pairs(o.cutoff) {
+		ri, rj := owner[p.I], owner[p.J]
+		if p.Dist >= o.cutoff || ri == rj || (res[ri].Chain == res[rj].Chain && abs(ri-rj) < o.separation) {
+			continue
+		}
+		N.Contacts = append(N.Contacts, Contact{I: atoms[p.I], J: atoms[p.J], R0: p.Dist})
+	}
+	return N, nil
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// Q returns the fraction of native contacts formed in the structure with the coordinates coords:
+// The average, over the native contacts, of 1/(1+exp(beta*(r-lambda*R0))), where r is the distance
+// between the atoms in coords. It returns 0 if there are no native contacts.
+func (N *Native) Q(coords *v3.Matrix) float64 {
+	if len(N.Contacts) == 0 {
+		return 0
+	}
+	var q float64
+	for _, c := range N.Contacts {
+		var d2 float64
+		for k := 0; k < 3; k++ {
+			d := coords.At(c.I, k) - coords.At(c.J, k)
+			d2 += d * d
+		}
+		q += switching(math.Sqrt(d2), N.lambda*c.R0, N.beta)
+	}
+	return q / float64(len(N.Contacts))
+}
+
+// Traj returns Q (see Native.Q) for each of the remaining frames of the trajectory t, which are processed
+// concurrently (see traj.Parallel) with the options given. If a frame can't be read, the values for the
+// previous frames are returned, together with the error.
+func (N *Native) Traj(t chem.Traj, options ...*traj.ParallelOptions) ([]float64, error) {
+	for _, c := range N.Contacts {
+		if c.I >= t.Len() || c.J >= t.Len() {
+			return nil, fmt.Errorf("Native.Traj: contact %d-%d out of range for a trajectory with %d atoms", c.I, c.J, t.Len())
+		}
+	}
+	ret, err := traj.Parallel(t, func(frame int, coords *v3.Matrix) (float64, error) {
+		return N.Q(coords), nil
+	}, options...)
+	if err != nil {
+		return ret, fmt.Errorf("Native.Traj: %w", err)
+	}
+	return ret, nil
+}
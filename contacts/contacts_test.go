@@ -0,0 +1,128 @@
+/*
+ * contacts_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package contacts
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func TestContacts(Te *testing.T) {
+	//A chain of 8 one-atom residues, 3.8 A apart. When folded, it is a hairpin with the residues
+	//i and 7-i 4 A apart, when unfolded, a straight line. There is also a hydrogen, which is ignored.
+	const nres = 8
+	folded, unfolded := v3.Zeros(nres+1), v3.Zeros(nres+1)
+	top := chem.NewTopology(0, 1)
+	for i := 0; i < nres; i++ {
+		top.AppendAtom(&chem.Atom{Name: "CA", Symbol: "C", MolName: "GLY", MolID: i + 1, Chain: "A", ID: i + 1})
+		if i < nres/2 {
+			folded.Set(i, 0, 3.8*float64(i))
+		} else {
+			folded.Set(i, 0, 3.8*float64(nres-1-i))
+			folded.Set(i, 1, 4)
+		}
+		unfolded.Set(i, 0, 3.8*float64(i))
+	}
+	top.AppendAtom(&chem.Atom{Name: "H", Symbol: "H", MolName: "GLY", MolID: nres, Chain: "A", ID: nres + 1})
+	folded.Set(nres, 1, 1)
+	res := Residues(top, nil, nil)
+	if len(res) != nres || len(res[nres-1].Atoms) != 1 {
+		Te.Fatalf("Wrong residues: %d", len(res))
+	}
+	if r := Residues(top, []int{1, 2, 3}, []string{"A"}); len(r) != 3 || r[2].MolID != 3 {
+		Te.Errorf("Wrong selected residues: %v", r)
+	}
+	m, err := Map(folded, res)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var n float64
+	for i := range m {
+		for _, v := range m[i] {
+			n += v
+		}
+	}
+	if n != 20 || m[0][7] != 1 || m[7][0] != 1 || m[0][6] != 0 || m[3][3] != 0 {
+		Te.Errorf("Wrong contact map: %v", m)
+	}
+	o := DefaultOptions()
+	o.Smooth(true)
+	if m, _ = Map(folded, res, o); math.Abs(m[0][1]-1/(1+math.Exp(5*(3.8-4.5)))) > 1e-9 {
+		Te.Errorf("Wrong smooth contact map: %v", m[0])
+	}
+	//Only the contacts 0-7 and 1-6 are between residues separated enough.
+	native, err := NewNative(folded, res)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if len(native.Contacts) != 2 || math.Abs(native.Contacts[0].R0-4) > 1e-9 {
+		Te.Fatalf("Wrong native contacts: %v", native.Contacts)
+	}
+	mol, err := chem.NewMolecule([]*v3.Matrix{folded, unfolded, folded}, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	q, err := native.Traj(mol)
+	if err != nil || len(q) != 3 {
+		Te.Fatalf("Expected Q for 3 frames, got %v, error: %v", q, err)
+	}
+	if q[0] < 0.99 || q[1] > 0.01 || q[2] != q[0] {
+		Te.Errorf("Wrong Q: %v", q)
+	}
+	mol.InitRead()
+	f, read, err := Frequencies(mol, res, nil)
+	if err != nil || read != 3 {
+		Te.Fatalf("Expected 3 frames, got %d, error: %v", read, err)
+	}
+	if math.Abs(f[0][7]-2.0/3) > 1e-9 || f[0][1] != 1 || f[0][2] != 0 {
+		Te.Errorf("Wrong contact frequencies: %v", f[0])
+	}
+	//If a frame can't be read, the frequencies for the frames before it are returned.
+	mol.InitRead()
+	f, read, err = Frequencies(&failingTraj{Traj: mol, fail: 2}, res, nil)
+	if err == nil || read != 2 || f == nil || math.Abs(f[0][7]-0.5) > 1e-9 || f[0][1] != 1 {
+		Te.Errorf("Expected an error and the frequencies for 2 frames, got %d frames, error: %v", read, err)
+	}
+}
+
+// failingTraj is a trajectory where the frame fail, and the following ones, can't be read.
+type failingTraj struct {
+	chem.Traj
+	fail int
+	read int
+}
+
+func (F *failingTraj) Next(coords *v3.Matrix, box ...[]float64) error {
+	if F.read >= F.fail {
+		return fmt.Errorf("corrupted frame %d", F.read)
+	}
+	F.read++
+	return F.Traj.Next(coords, box...)
+}
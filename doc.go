@@ -24,6 +24,11 @@ It provides atom and molecule structures, facilities for reading and
 writing some files used in computational chemistry and functions for
 geometric manipulations and shape, among others indicators.
 
+The functions that read files (the *FileRead functions) transparently
+decompress files compressed with gzip, bzip2, zstd or xz. The ones that
+write files (*FileWrite) compress their output if the name of the file ends in
+.gz, .zst or .xz.
+
 See www.gochem.org for more information.
 
 */
@@ -0,0 +1,559 @@
+/*
+ * dssp.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package dssp assigns the secondary structure of proteins from their coordinates,
+// with the DSSP algorithm (W. Kabsch and C. Sander, Biopolymers 22, 2577, 1983).
+//
+// Backbone hydrogen bonds are identified with the electrostatic energy of DSSP, and
+// from them, the helices (3-10, alpha and pi), beta bridges and strands, and turns,
+// are assigned. Bends are assigned from the CA trace. The codes used are those of DSSP,
+// also used by chem.SSElement: 'H' alpha helix, 'B' isolated beta bridge, 'E' strand,
+// 'G' 3-10 helix, 'I' pi helix, 'T' turn, 'S' bend, and ' ' for none.
+//
+// The hydrogens bonded to the backbone nitrogens are placed, as in DSSP, if the
+// structure doesn't have them.
+package dssp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/spatial"
+	"github.com/rmera/gochem/traj"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+const (
+	maxHBondEnergy = -0.5   //kcal/mol, hydrogen bonds have lower energies.
+	minHBondEnergy = -9.9   //The energy given to pairs of atoms that are too close.
+	minDistance    = 0.5    //Closer than this, the energy is not computed (A).
+	maxCADistance  = 9.0    //No hydrogen bonds are searched between residues with CAs farther than this (A).
+	maxPeptideBond = 2.5    //C-N distances longer than this are chain breaks (A).
+	minBendAngle   = 70.0   //Degrees.
+	couplingConst  = 27.888 //0.084*332, the DSSP charges times the conversion factor to kcal/mol.
+)
+
+// Residue is a protein residue, with its secondary structure.
+type Residue struct {
+	Chain   string
+	MolID   int
+	InsCode string
+	MolName string
+	Code    byte   //The DSSP code of the residue, ' ' if it has no secondary structure.
+	Sheet   string //The ID of the sheet, for strands and bridges.
+	First   int    //The indexes of the first and last atoms of the residue.
+	Last    int
+}
+
+// SS is the secondary structure of a protein, residue by residue.
+type SS []*Residue
+
+// String returns the DSSP codes for all the residues, in order.
+func (S SS) String() string {
+	b := make([]byte, len(S))
+	for i, v := range S {
+		b[i] = v.Code
+	}
+	return string(b)
+}
+
+// AtomCodes returns a slice with one code per atom, for a molecule with natoms atoms, as
+// in chem.PDBxInfo.SS. The atoms that are not part of any residue get ' '.
+func (S SS) AtomCodes(natoms int) []byte {
+	ret := make([]byte, natoms)
+	for i := range ret {
+		ret[i] = ' '
+	}
+	for _, v := range S {
+		for i := v.First; i <= v.Last && i < natoms; i++ {
+			ret[i] = v.Code
+		}
+	}
+	return ret
+}
+
+// Elements returns the secondary structure elements, one per stretch of consecutive residues
+// of the same chain with the same code (and sheet). Residues without secondary structure are
+// not included.
+func (S SS) Elements() []*chem.SSElement {
+	var ret []*chem.SSElement
+	var current *chem.SSElement
+	for i, v := range S {
+		if current != nil && v.Code == current.Code && v.Chain == current.Chain && v.Sheet == current.Sheet && S[i-1].MolID+1 >= v.MolID {
+			current.End = v.MolID
+			current.EndIns = v.InsCode
+			continue
+		}
+		current = nil
+		if v.Code == ' ' {
+			continue
+		}
+		current = &chem.SSElement{ID: strconv.Itoa(len(ret) + 1), Sheet: v.Sheet, Code: v.Code, Chain: v.Chain, Start: v.MolID, End: v.MolID, StartIns: v.InsCode, EndIns: v.InsCode}
+		ret = append(ret, current)
+	}
+	return ret
+}
+
+// backbone contains the indexes of the backbone atoms of a residue. h is -1 if
+// the residue has no backbone hydrogen.
+type backbone struct {
+	n, ca, c, o, h int
+	proline        bool
+	res            Residue
+}
+
+// residues returns the backbone atoms of the amino acid residues in mol. Residues without
+// N, CA, C or O are skipped.
+func residues(mol chem.Atomer) ([]*backbone, error) {
+	var ret []*backbone
+	var current *backbone
+	for i := 0; i < mol.Len(); i++ {
+		at := mol.Atom(i)
+		if current == nil || at.MolID != current.res.MolID || at.Chain != current.res.Chain || at.InsCode != current.res.InsCode {
+			if current != nil && current.n >= 0 && current.ca >= 0 && current.c >= 0 && current.o >= 0 {
+				ret = append(ret, current)
+			}
+			current = &backbone{n: -1, ca: -1, c: -1, o: -1, h: -1, proline: at.MolName == "PRO"}
+			current.res = Residue{Chain: at.Chain, MolID: at.MolID, InsCode: at.InsCode, MolName: at.MolName, Code: ' ', First: i}
+		}
+		current.res.Last = i
+		switch at.Name {
+		case "N":
+			current.n = i
+		case "CA":
+			current.ca = i
+		case "C":
+			current.c = i
+		case "O", "O1", "OT1":
+			if current.o < 0 || at.Name == "O" {
+				current.o = i
+			}
+		case "H", "HN":
+			current.h = i
+		}
+	}
+	if current != nil && current.n >= 0 && current.ca >= 0 && current.c >= 0 && current.o >= 0 {
+		ret = append(ret, current)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("residues: no protein residues found")
+	}
+	return ret, nil
+}
+
+type vec [3]float64
+
+func (a vec) sub(b vec) vec { return vec{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func (a vec) dot(b vec) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+func (a vec) dist(b vec) float64 { d := a.sub(b); return math.Sqrt(d.dot(d)) }
+
+func row(coords *v3.Matrix, i int) vec {
+	return vec{coords.At(i, 0), coords.At(i, 1), coords.At(i, 2)}
+}
+
+// hbond is a hydrogen bond from the N-H of a residue (the donor) to the C=O of partner.
+type hbond struct {
+	partner int
+	energy  float64
+}
+
+// frame contains the data needed to assign the secondary structure of one frame.
+type frame struct {
+	n, ca, c, o, h []vec
+	hasH           []bool
+	brk            []bool         //brk[i] is true if there is a chain break between residues i-1 and i.
+	acceptors      [][2]hbond     //The two best acceptors for the N-H of each residue.
+	pairs          []spatial.Pair //Pairs of residues with their CAs closer than maxCADistance.
+}
+
+// energy returns the DSSP energy of the hydrogen bond between the N-H of the residue d
+// and the C=O of the residue a, in kcal/mol.
+func (F *frame) energy(d, a int) float64 {
+	rON := F.o[a].dist(F.n[d])
+	rCH := F.c[a].dist(F.h[d])
+	rOH := F.o[a].dist(F.h[d])
+	rCN := F.c[a].dist(F.n[d])
+	if rON < minDistance || rCH < minDistance || rOH < minDistance || rCN < minDistance {
+		return minHBondEnergy
+	}
+	e := couplingConst * (1/rON + 1/rCH - 1/rOH - 1/rCN)
+	e = math.Round(e*1000) / 1000
+	return math.Max(e, minHBondEnergy)
+}
+
+// addHBond computes the energy between the N-H of d and the C=O of a, and keeps it,
+// if it is one of the two lowest for d.
+func (F *frame) addHBond(d, a int) {
+	e := F.energy(d, a)
+	acc := &F.acceptors[d]
+	if e < acc[0].energy {
+		acc[1] = acc[0]
+		acc[0] = hbond{partner: a, energy: e}
+	} else if e < acc[1].energy {
+		acc[1] = hbond{partner: a, energy: e}
+	}
+}
+
+// bonded returns true if the C=O of i is hydrogen bonded to the N-H of j.
+func (F *frame) bonded(i, j int) bool {
+	if i < 0 || j < 0 || i >= len(F.n) || j >= len(F.n) {
+		return false
+	}
+	for _, v := range F.acceptors[j] {
+		if v.partner == i && v.energy < maxHBondEnergy {
+			return true
+		}
+	}
+	return false
+}
+
+// noBreak returns true if there are no chain breaks between the residues a and b (a<=b).
+func (F *frame) noBreak(a, b int) bool {
+	if a < 0 || b >= len(F.brk) {
+		return false
+	}
+	for i := a + 1; i <= b; i++ {
+		if F.brk[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newFrame obtains the backbone coordinates, places the missing hydrogens and finds the hydrogen bonds.
+func newFrame(bb []*backbone, coords *v3.Matrix) *frame {
+	nr := len(bb)
+	F := &frame{n: make([]vec, nr), ca: make([]vec, nr), c: make([]vec, nr), o: make([]vec, nr), h: make([]vec, nr),
+		hasH: make([]bool, nr), brk: make([]bool, nr), acceptors: make([][2]hbond, nr)}
+	ca := v3.Zeros(nr)
+	for i, r := range bb {
+		F.n[i], F.ca[i], F.c[i], F.o[i] = row(coords, r.n), row(coords, r.ca), row(coords, r.c), row(coords, r.o)
+		for k, v := range F.ca[i] {
+			ca.Set(i, k, v)
+		}
+		F.acceptors[i] = [2]hbond{{partner: -1}, {partner: -1}}
+		if i > 0 {
+			F.brk[i] = r.res.Chain != bb[i-1].res.Chain || F.c[i-1].dist(F.n[i]) > maxPeptideBond
+		}
+		switch {
+		case r.proline:
+		case r.h >= 0:
+			F.h[i], F.hasH[i] = row(coords, r.h), true
+		case i > 0 && !F.brk[i]:
+			//As in DSSP, the H is placed 1 A from the N, in the direction of the previous C=O, from O to C.
+			d := F.c[i-1].sub(F.o[i-1])
+			norm := math.Sqrt(d.dot(d))
+			for k := range d {
+				F.h[i][k] = F.n[i][k] + d[k]/norm
+			}
+			F.hasH[i] = true
+		}
+	}
+	F.pairs = spatial.NewKDTree(ca).Pairs(maxCADistance)
+	for _, p := range F.pairs {
+		if F.hasH[p.I] {
+			F.addHBond(p.I, p.J)
+		}
+		if p.J != p.I+1 && F.hasH[p.J] {
+			F.addHBond(p.J, p.I)
+		}
+	}
+	return F
+}
+
+// ladder is a set of consecutive bridges of the same type.
+type ladder struct {
+	parallel bool
+	iLo, iHi int
+	jLo, jHi int
+	bridges  int
+	set      int //the ladder set (ladders linked by bulges) and
+	sheet    int //the sheet it belongs to.
+}
+
+// ladders finds the beta bridges of F and returns them, grouped in ladders.
+func (F *frame) ladders() []*ladder {
+	type bridge struct {
+		i, j     int
+		parallel bool
+	}
+	var bridges []bridge
+	for _, p := range F.pairs {
+		i, j := p.I, p.J
+		if j-i < 3 || !F.noBreak(i-1, i+1) || !F.noBreak(j-1, j+1) {
+			continue
+		}
+		if (F.bonded(i-1, j) && F.bonded(j, i+1)) || (F.bonded(j-1, i) && F.bonded(i, j+1)) {
+			bridges = append(bridges, bridge{i, j, true})
+		} else if (F.bonded(i, j) && F.bonded(j, i)) || (F.bonded(i-1, j+1) && F.bonded(j-1, i+1)) {
+			bridges = append(bridges, bridge{i, j, false})
+		}
+	}
+	//The bridges are extended into ladders in order of the first residue.
+	sortBridges := func(a, b bridge) bool { return a.i < b.i || (a.i == b.i && a.j < b.j) }
+	for i := 1; i < len(bridges); i++ {
+		for k := i; k > 0 && sortBridges(bridges[k], bridges[k-1]); k-- {
+			bridges[k], bridges[k-1] = bridges[k-1], bridges[k]
+		}
+	}
+	var ret []*ladder
+	for _, b := range bridges {
+		var l *ladder
+		for _, v := range ret {
+			if v.parallel != b.parallel || v.iHi != b.i-1 {
+				continue
+			}
+			if (b.parallel && v.jHi == b.j-1) || (!b.parallel && v.jLo == b.j+1) {
+				l = v
+				break
+			}
+		}
+		if l == nil {
+			ret = append(ret, &ladder{parallel: b.parallel, iLo: b.i, iHi: b.i, jLo: b.j, jHi: b.j, bridges: 1, set: len(ret), sheet: len(ret)})
+			continue
+		}
+		l.iHi = b.i
+		l.jLo, l.jHi = min(l.jLo, b.j), max(l.jHi, b.j)
+		l.bridges++
+	}
+	return ret
+}
+
+// find returns the root of the set of i, in the union-find forest parent.
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+// strands assigns 'E' and 'B' to the residues in ladders, and the sheet IDs.
+func (F *frame) strands(ret SS) {
+	ladders := F.ladders()
+	sets := make([]int, len(ladders))   //ladders linked by beta bulges
+	sheets := make([]int, len(ladders)) //ladders sharing residues, or linked by bulges.
+	for i := range ladders {
+		sets[i], sheets[i] = i, i
+	}
+	union := func(parent []int, a, b int) {
+		parent[find(parent, b)] = find(parent, a)
+	}
+	type span struct{ lo, hi int }
+	var bulges []span
+	for i, a := range ladders {
+		for k, b := range ladders[i+1:] {
+			k += i + 1
+			if a.parallel != b.parallel {
+				continue
+			}
+			gi := b.iLo - a.iHi
+			gj := b.jLo - a.jHi
+			if !a.parallel {
+				gj = a.jLo - b.jHi
+			}
+			if gi > 0 && gj > 0 && ((gi < 6 && gj < 3) || (gi < 3 && gj < 6)) && F.noBreak(a.iHi, b.iLo) {
+				union(sets, i, k)
+				bulges = append(bulges, span{a.iLo, b.iHi}, span{min(a.jLo, b.jLo), max(a.jHi, b.jHi)})
+			}
+		}
+	}
+	for i, a := range ladders {
+		for k, b := range ladders[i+1:] {
+			k += i + 1
+			shared := func(lo1, hi1, lo2, hi2 int) bool { return lo1 <= hi2 && lo2 <= hi1 }
+			if find(sets, i) == find(sets, k) || shared(a.iLo, a.iHi, b.iLo, b.iHi) || shared(a.iLo, a.iHi, b.jLo, b.jHi) ||
+				shared(a.jLo, a.jHi, b.iLo, b.iHi) || shared(a.jLo, a.jHi, b.jLo, b.jHi) {
+				union(sheets, i, k)
+			}
+		}
+	}
+	//Sheets are labeled A, B... in the order of their first ladder.
+	labels := make(map[int]string)
+	sheetOf := func(i int) string {
+		root := find(sheets, i)
+		if l, ok := labels[root]; ok {
+			return l
+		}
+		labels[root] = sheetLabel(len(labels))
+		return labels[root]
+	}
+	setSize := make(map[int]int)
+	for i, l := range ladders {
+		setSize[find(sets, i)] += l.bridges
+	}
+	mark := func(lo, hi int, code byte, sheet string) {
+		for r := lo; r <= hi; r++ {
+			if code == 'E' || ret[r].Code != 'E' {
+				ret[r].Code = code
+			}
+			ret[r].Sheet = sheet
+		}
+	}
+	for i, l := range ladders {
+		code := byte('E')
+		if setSize[find(sets, i)] < 2 {
+			code = 'B'
+		}
+		s := sheetOf(i)
+		mark(l.iLo, l.iHi, code, s)
+		mark(l.jLo, l.jHi, code, s)
+	}
+	for _, b := range bulges {
+		mark(b.lo, b.hi, 'E', ret[b.lo].Sheet)
+	}
+}
+
+// sheetLabel returns A, B... Z, AA, AB... for n=0, 1...
+func sheetLabel(n int) string {
+	label := string(rune('A' + n%26))
+	for n /= 26; n > 0; n /= 26 {
+		n--
+		label = string(rune('A'+n%26)) + label
+	}
+	return label
+}
+
+// bend returns true if the angle between the CA(i-2)->CA(i) and CA(i)->CA(i+2) vectors is larger than 70 degrees.
+func (F *frame) bend(i int) bool {
+	if !F.noBreak(i-2, i+2) {
+		return false
+	}
+	a := F.ca[i].sub(F.ca[i-2])
+	b := F.ca[i+2].sub(F.ca[i])
+	cos := a.dot(b) / math.Sqrt(a.dot(a)*b.dot(b))
+	return math.Acos(math.Max(-1, math.Min(1, cos)))*chem.Rad2Deg > minBendAngle
+}
+
+// assign returns the secondary structure for the residues bb, in the frame coords.
+func assign(bb []*backbone, coords *v3.Matrix) SS {
+	nr := len(bb)
+	ret := make(SS, nr)
+	for i, v := range bb {
+		r := v.res
+		ret[i] = &r
+	}
+	F := newFrame(bb, coords)
+	//turns[n-3][i] is true if there is an n-turn starting at i, i.e. the C=O of i is bonded to the N-H of i+n.
+	var turns [3][]bool
+	for n := 3; n <= 5; n++ {
+		turns[n-3] = make([]bool, nr)
+		for i := 0; i+n < nr; i++ {
+			turns[n-3][i] = F.noBreak(i, i+n) && F.bonded(i, i+n)
+		}
+	}
+	//A helix starts where two consecutive n-turns are, and covers n residues.
+	helix := func(n, i int) bool {
+		return i > 0 && turns[n-3][i-1] && turns[n-3][i]
+	}
+	F.strands(ret)
+	for i := 1; i+4 <= nr; i++ {
+		if helix(4, i) {
+			for k := i; k < i+4; k++ {
+				ret[k].Code = 'H'
+				ret[k].Sheet = ""
+			}
+		}
+	}
+	//3-10 and pi helices are only assigned where all their residues are free, or already in a helix of the same type.
+	for _, h := range []struct {
+		n    int
+		code byte
+	}{{3, 'G'}, {5, 'I'}} {
+		for i := 1; i+h.n <= nr; i++ {
+			if !helix(h.n, i) {
+				continue
+			}
+			free := true
+			for k := i; k < i+h.n; k++ {
+				free = free && (ret[k].Code == ' ' || ret[k].Code == h.code)
+			}
+			if !free {
+				continue
+			}
+			for k := i; k < i+h.n; k++ {
+				ret[k].Code = h.code
+			}
+		}
+	}
+	for n := 3; n <= 5; n++ {
+		for i, t := range turns[n-3] {
+			if !t {
+				continue
+			}
+			for k := i + 1; k < i+n; k++ {
+				if ret[k].Code == ' ' {
+					ret[k].Code = 'T'
+				}
+			}
+		}
+	}
+	for i := 2; i+2 < nr; i++ {
+		if ret[i].Code == ' ' && F.bend(i) {
+			ret[i].Code = 'S'
+		}
+	}
+	return ret
+}
+
+// Assign returns the secondary structure of the protein residues in mol, with the coordinates coords,
+// assigned with the DSSP algorithm. Residues are identified by their MolID, chain and insertion
+// code, and only those with N, CA, C and O atoms are considered. Hydrogens bonded to
+// the backbone N are used if they are named H or HN, and placed otherwise.
+func Assign(mol chem.Atomer, coords *v3.Matrix) (SS, error) {
+	if mol.Len() != coords.NVecs() {
+		return nil, fmt.Errorf("Assign: %d atoms in the molecule, but %d coordinates", mol.Len(), coords.NVecs())
+	}
+	bb, err := residues(mol)
+	if err != nil {
+		return nil, fmt.Errorf("Assign: %w", err)
+	}
+	return assign(bb, coords), nil
+}
+
+// Traj returns the secondary structure of the protein residues in mol, for each frame of
+// the trajectory t, which must have the same atoms as mol. The frames are processed
+// concurrently, with traj.Parallel, which takes the options given. If a frame can't be
+// read, the secondary structures for the previous frames are returned, together with the error.
+func Traj(mol chem.Atomer, t chem.Traj, options ...*traj.ParallelOptions) ([]SS, error) {
+	if mol.Len() != t.Len() {
+		return nil, fmt.Errorf("Traj: %d atoms in the molecule, but %d in the trajectory", mol.Len(), t.Len())
+	}
+	bb, err := residues(mol)
+	if err != nil {
+		return nil, fmt.Errorf("Traj: %w", err)
+	}
+	ret, err := traj.Parallel(t, func(frame int, coords *v3.Matrix) (SS, error) {
+		return assign(bb, coords), nil
+	}, options...)
+	if err != nil {
+		return ret, fmt.Errorf("Traj: %w", err)
+	}
+	return ret, nil
+}
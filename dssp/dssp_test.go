@@ -0,0 +1,135 @@
+/*
+ * dssp_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package dssp
+
+import (
+	"math"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// place returns the position of a point bonded to c, at the distance bond, with the angle b-c-d
+// and the dihedral a-b-c-d given, in degrees.
+func place(a, b, c vec, bond, angle, dihedral float64) vec {
+	angle, dihedral = angle*chem.Deg2Rad, dihedral*chem.Deg2Rad
+	bc := c.sub(b)
+	n := math.Sqrt(bc.dot(bc))
+	for k := range bc {
+		bc[k] /= n
+	}
+	ab := b.sub(a)
+	nv := vec{ab[1]*bc[2] - ab[2]*bc[1], ab[2]*bc[0] - ab[0]*bc[2], ab[0]*bc[1] - ab[1]*bc[0]}
+	n = math.Sqrt(nv.dot(nv))
+	for k := range nv {
+		nv[k] /= n
+	}
+	m := vec{nv[1]*bc[2] - nv[2]*bc[1], nv[2]*bc[0] - nv[0]*bc[2], nv[0]*bc[1] - nv[1]*bc[0]}
+	d2 := vec{-bond * math.Cos(angle), bond * math.Sin(angle) * math.Cos(dihedral), bond * math.Sin(angle) * math.Sin(dihedral)}
+	var d vec
+	for k := range d {
+		d[k] = c[k] + d2[0]*bc[k] + d2[1]*m[k] + d2[2]*nv[k]
+	}
+	return d
+}
+
+// peptide builds the backbone (N, CA, C and O atoms) of a peptide chain with the given
+// phi and psi angles, in degrees, and ideal bond lengths and angles.
+func peptide(phipsi [][2]float64) (*chem.Topology, *v3.Matrix) {
+	top := chem.NewTopology(0, 1)
+	coords := v3.Zeros(4 * len(phipsi))
+	n, ca := vec{0, 0, 0}, vec{1.458, 0, 0}
+	c := place(vec{0, 1, 0}, n, ca, 1.525, 111.2, 0)
+	for i, v := range phipsi {
+		next := place(n, ca, c, 1.329, 116.2, v[1])
+		o := place(n, ca, c, 1.231, 120.5, v[1]+180)
+		for j, p := range []vec{n, ca, c, o} {
+			coords.Set(4*i+j, 0, p[0])
+			coords.Set(4*i+j, 1, p[1])
+			coords.Set(4*i+j, 2, p[2])
+			name := []string{"N", "CA", "C", "O"}[j]
+			top.AppendAtom(&chem.Atom{Name: name, Symbol: name[:1], MolName: "ALA", MolID: i + 1, Chain: "A"})
+		}
+		if i+1 < len(phipsi) {
+			nca := place(ca, c, next, 1.458, 121.7, 180)
+			c = place(c, next, nca, 1.525, 111.2, phipsi[i+1][0])
+			n, ca = next, nca
+		}
+	}
+	return top, coords
+}
+
+func TestDSSP(Te *testing.T) {
+	repeat := func(n int, phipsi [2]float64) [][2]float64 {
+		var ret [][2]float64
+		for i := 0; i < n; i++ {
+			ret = append(ret, phipsi)
+		}
+		return ret
+	}
+	turn := [][2]float64{{60, 30}, {90, 0}} //type I'
+	cases := []struct {
+		phipsi   [][2]float64
+		expected string
+	}{
+		{repeat(12, [2]float64{-57, -47}), " HHHHHHHHHH "},
+		{repeat(10, [2]float64{-49, -26}), " GGGGGGGG "},
+		{repeat(12, [2]float64{-57, -70}), " IIIIIIIIII "},
+		{append(append(repeat(6, [2]float64{-120, 130}), turn...), repeat(6, [2]float64{-120, 130})...), "    EETTEE    "},
+	}
+	for _, c := range cases {
+		top, coords := peptide(c.phipsi)
+		ss, err := Assign(top, coords)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if ss.String() != c.expected {
+			Te.Errorf("Wrong secondary structure: %q, expected %q", ss.String(), c.expected)
+		}
+	}
+	//The hairpin, in a trajectory, together with the alpha helix, which has the same number of residues.
+	hairpin, coords := peptide(cases[3].phipsi)
+	_, helix := peptide(repeat(14, [2]float64{-57, -47}))
+	mol, err := chem.NewMolecule([]*v3.Matrix{coords, helix}, hairpin, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frames, err := Traj(mol, mol)
+	if err != nil || len(frames) != 2 {
+		Te.Fatalf("Expected 2 frames, got %d, error: %v", len(frames), err)
+	}
+	if frames[1].String() != " HHHHHHHHHHHH " {
+		Te.Errorf("Wrong secondary structure for the second frame: %q", frames[1].String())
+	}
+	el := frames[0].Elements()
+	if len(el) != 3 || el[0].Code != 'E' || el[0].Start != 5 || el[0].End != 6 || el[0].Sheet != "A" || el[2].Sheet != "A" {
+		Te.Errorf("Wrong secondary structure elements for the hairpin: %v", el)
+	}
+	if atoms := frames[0].AtomCodes(mol.Len()); string(atoms[16:24]) != "EEEEEEEE" || atoms[15] != ' ' {
+		Te.Errorf("Wrong secondary structure for the atoms: %q", atoms)
+	}
+}
@@ -30,7 +30,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 
@@ -123,12 +122,20 @@ func read_full_pdb_line(line string, read_additional bool, contlines int) (*Atom
 	atom.ID, err[0] = strconv.Atoi(strings.TrimSpace(line[6:12]))
 	atom.Name = strings.TrimSpace(line[12:16])
 	atom.Char16 = line[16]
+	atom.AltLoc = strings.TrimSpace(line[16:17])
 	//PDB says that pos. 17 is for other thing but I see that is
 	//used for residue name in many cases*/
 	atom.MolName = line[17:20]
 	atom.MolName1 = three2OneLetter[atom.MolName]
 	atom.Chain = string(line[21])
-	atom.MolID, err[1] = strconv.Atoi(strings.TrimSpace(line[22:30]))
+	//VMD, CHARMM and several MD programs write residue numbers with 5 or more digits, which take the
+	//column of the insertion code (and, sometimes, the next ones), so a digit there is part of the number.
+	if c := line[26]; c >= '0' && c <= '9' {
+		atom.MolID, err[1] = strconv.Atoi(strings.TrimSpace(line[22:30]))
+	} else {
+		atom.MolID, err[1] = strconv.Atoi(strings.TrimSpace(line[22:26]))
+		atom.InsCode = strings.TrimSpace(line[26:27])
+	}
 	//Here we shouldn't need TrimSpace, but I keep it just in case someone
 	// doesn's use all the fields when writting a PDB*/
 	coords[0], err[2] = strconv.ParseFloat(strings.TrimSpace(line[30:38]), 64)
@@ -203,41 +210,81 @@ func read_onlycoords_pdb_line(line string, contlines int) ([]float64, float64, e
 	return coords, bfactor, nil
 }
 
+// PDBOptions contains options for reading PDB and PDBx/mmCIF files.
+type PDBOptions struct {
+	//AltLoc selects which alternate location is read for the atoms that have several.
+	//With an empty string (the default) the one with the highest occupancy is read
+	//(the first one, in case of ties). With "*", all of them are read, as separate atoms,
+	//which is what goChem did before alternate locations were supported.
+	//Otherwise, the alternate location with the given ID is read or, for atoms that
+	//don't have that one, the one with the highest occupancy.
+	AltLoc string
+	//Model is the number of the only model to be read, counting from 1. If 0 (the default) all the models
+	//are read, each one as a frame.
+	Model int
+}
+
 //PDBRRead reads a pdb file from an io.Reader. Returns a Molecule. If there is one frame in the PDB
 // the coordinates array will be of lenght 1. It also returns an error which is not
 // really well set up right now.
 //read_additional is now "deprecated", it will be set to true regardless. I have made it into
+//
+//For atoms with alternate locations, only the one with the highest occupancy is read. Previously,
+//all of them were read, as separate atoms. Use PDBReadOptions with AltLoc "*" to get that behaviour.
 func PDBRead(pdb io.Reader, read_additional ...bool) (*Molecule, error) {
 	bufiopdb := bufio.NewReader(pdb)
-	mol, err := pdbBufIORead(bufiopdb, read_additional...)
+	mol, err := pdbBufIORead(bufiopdb, len(read_additional) == 0 || read_additional[0], nil)
 	return mol, errDecorate(err, "PDBReaderREad")
 }
 
 //PDBFileRead reads a pdb file from an io.Reader. Returns a Molecule. If there is one frame in the PDB
 // the coordinates array will be of lenght 1. It also returns an error which is not
 // really well set up right now. read_additional is now deprecated. The reader will just read
+//
+//For atoms with alternate locations, only the one with the highest occupancy is read. Previously,
+//all of them were read, as separate atoms. Use PDBFileReadOptions with AltLoc "*" to get that behaviour.
 func PDBFileRead(pdbname string, read_additional ...bool) (*Molecule, error) {
-	pdbfile, err := os.Open(pdbname)
+	pdbfile, err := openRead(pdbname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
 		return nil, err
 	}
 	defer pdbfile.Close()
 	pdb := bufio.NewReader(pdbfile)
-	mol, err := pdbBufIORead(pdb, read_additional...)
+	mol, err := pdbBufIORead(pdb, len(read_additional) == 0 || read_additional[0], nil)
 	return mol, err
 }
 
+//PDBReadOptions reads a PDB file from an io.Reader, as PDBRead, with the alternate locations and
+//models selected in options.
+func PDBReadOptions(pdb io.Reader, options *PDBOptions) (*Molecule, error) {
+	mol, err := pdbBufIORead(bufio.NewReader(pdb), true, options)
+	return mol, errDecorate(err, "PDBReadOptions")
+}
+
+//PDBFileReadOptions reads the PDB file pdbname, as PDBFileRead, with the alternate locations and
+//models selected in options.
+func PDBFileReadOptions(pdbname string, options *PDBOptions) (*Molecule, error) {
+	pdbfile, err := openRead(pdbname)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"openRead", "PDBFileReadOptions"}}
+	}
+	defer pdbfile.Close()
+	mol, err := pdbBufIORead(bufio.NewReader(pdbfile), true, options)
+	return mol, errDecorate(err, "PDBFileReadOptions")
+}
+
 //pdbBufIORead reads the atomic entries for a PDB bufio.IO, reads a pdb file from an io.Reader.
 //Returns a Molecule. If there is one frame in the PDB the coordinates array will be of lenght 1.
 //It also returns an error which is not really well set up right now.
-//The read_additional_opt allows not reading the last fields of a PDB, if you know they are wrong.
-//if true (the default), the fields are read if they are available. Otherwise we attempt to figure
+//The read_additional allows not reading the last fields of a PDB, if you know they are wrong.
+//if true, the fields are read if they are available. Otherwise we attempt to figure
 //out the symbol from the atom name, which doesn't always work.
-func pdbBufIORead(pdb *bufio.Reader, read_additional_opt ...bool) (*Molecule, error) {
-	read_additional := true
-	if len(read_additional_opt) > 0 {
-		read_additional = read_additional_opt[0]
+//The bonds in CONECT, SSBOND and LINK records are added to the molecule, and the unit cell
+//in the CRYST1 record, if any, is put in its Cell field. opt can be nil.
+func pdbBufIORead(pdb *bufio.Reader, read_additional bool, opt *PDBOptions) (*Molecule, error) {
+	if opt == nil {
+		opt = &PDBOptions{}
 	}
 	var symbolerrorlogged bool
 	molecule := make([]*Atom, 0)
@@ -248,6 +295,8 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional_opt ...bool) (*Molecule, er
 	bfactors[0] = make([]float64, 0)
 	first_model := true //are we reading the first model? if not we only save coordinates
 	contlines := 1      //count the lines read to better report errors
+	var links []string  //CONECT, SSBOND and LINK records
+	var cell *UnitCell
 	for {
 		line, err := pdb.ReadString('\n')
 		if err != nil {
@@ -302,11 +351,54 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional_opt ...bool) (*Molecule, er
 				coords = append(coords, make([]float64, 0)) //new bunch of coords for a new frame
 				bfactors = append(bfactors, make([]float64, 0))
 			}
+		} else if strings.HasPrefix(line, "CONECT") || strings.HasPrefix(line, "SSBOND") || strings.HasPrefix(line, "LINK  ") {
+			links = append(links, line)
+		} else if strings.HasPrefix(line, "CRYST1") {
+			cell, err = pdbReadCryst1(line)
+			if err != nil {
+				return nil, errDecorate(err, "pdbBufIORead")
+			}
 		}
 	}
+	if opt.Model > 0 {
+		if opt.Model > len(coords) {
+			return nil, CError{fmt.Sprintf("Model %d requested, but the file has %d", opt.Model, len(coords)), []string{"pdbBufIORead"}}
+		}
+		coords = coords[opt.Model-1 : opt.Model]
+		bfactors = bfactors[opt.Model-1 : opt.Model]
+	}
+	//Now we remove the alternate locations not requested, from the atoms and every frame.
+	keep := selectAltLocs(molecule, opt.AltLoc)
+	kept := make([]*Atom, 0, len(molecule))
+	for i, at := range molecule {
+		if keep[i] {
+			kept = append(kept, at)
+		}
+	}
+	if len(kept) < len(molecule) {
+		for f := range coords {
+			if len(bfactors[f]) != len(molecule) {
+				return nil, CError{fmt.Sprintf("Model %d doesn't have the same atoms as the first one", f+1), []string{"pdbBufIORead"}}
+			}
+			c := make([]float64, 0, 3*len(kept))
+			b := make([]float64, 0, len(kept))
+			for i := range molecule {
+				if keep[i] {
+					c = append(c, coords[f][3*i:3*i+3]...)
+					b = append(b, bfactors[f][i])
+				}
+			}
+			coords[f], bfactors[f] = c, b
+		}
+		molecule = kept
+	}
 	//This could be done faster if done in the same loop where the coords are read
 	//Instead of having another loop just for them.
 	top := NewTopology(0, 1, molecule)
+	top.FillIndexes()
+	if err := pdbReadLinks(top, links); err != nil {
+		return nil, errDecorate(err, "pdbBufIORead")
+	}
 	var err error
 	frames := len(coords)
 	mcoords := make([]*v3.Matrix, frames, frames) //Final thing to return
@@ -321,7 +413,123 @@ func pdbBufIORead(pdb *bufio.Reader, read_additional_opt ...bool) (*Molecule, er
 		return nil, errDecorate(err, "pdbBufIORead")
 	}
 	returned, err := NewMolecule(mcoords, top, bfactors)
-	return returned, errDecorate(err, "pdbBufIORead")
+	if err != nil {
+		return nil, errDecorate(err, "pdbBufIORead")
+	}
+	returned.Cell = cell
+	return returned, nil
+}
+
+//pdbReadCryst1 returns the unit cell in a CRYST1 record, or nil if the cell is the 1 A cube
+//used in the PDB for structures not determined by crystallography.
+func pdbReadCryst1(line string) (*UnitCell, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 54 {
+		return nil, CError{"CRYST1 record too short", []string{"pdbReadCryst1"}}
+	}
+	cell := new(UnitCell)
+	var err error
+	for i := 0; i < 3; i++ {
+		cell.Lengths[i], err = strconv.ParseFloat(strings.TrimSpace(line[6+9*i:15+9*i]), 64)
+		if err != nil {
+			return nil, CError{err.Error(), []string{"strconv.ParseFloat", "pdbReadCryst1"}}
+		}
+		cell.Angles[i], err = strconv.ParseFloat(strings.TrimSpace(line[33+7*i:40+7*i]), 64)
+		if err != nil {
+			return nil, CError{err.Error(), []string{"strconv.ParseFloat", "pdbReadCryst1"}}
+		}
+	}
+	if cell.Lengths == [3]float64{1, 1, 1} {
+		return nil, nil
+	}
+	if len(line) > 55 {
+		cell.SpaceGroup = strings.TrimSpace(line[55:min(66, len(line))])
+	}
+	if len(line) > 66 {
+		cell.Z, _ = strconv.Atoi(strings.TrimSpace(line[66:min(70, len(line))]))
+	}
+	return cell, nil
+}
+
+//pdbReadLinks adds to top the bonds in the CONECT, SSBOND and LINK records of a PDB file.
+//Links to atoms not present in top, or to atoms in other asymmetric units, are ignored.
+func pdbReadLinks(top *Topology, records []string) error {
+	res := newPDBResidues(top.Atoms)
+	byID := make(map[int]int, top.Len())
+	for i, at := range top.Atoms {
+		byID[at.ID] = i
+	}
+	//col returns the columns from i to j-1 of the line, or the part of them present.
+	col := func(l string, i, j int) string {
+		j = min(j, len(l))
+		if i >= j {
+			return ""
+		}
+		return l[i:j]
+	}
+	field := func(l string, i, j int) string {
+		return strings.TrimSpace(col(l, i, j))
+	}
+	bond := func(i, j int, order, dist float64) error {
+		if i < 0 || j < 0 || i == j || atomsBonded(top.Atoms[i], top.Atoms[j]) {
+			return nil
+		}
+		if err := topologyBond(top, i, j, order); err != nil {
+			return err
+		}
+		top.Bonds[len(top.Bonds)-1].Dist = dist
+		return nil
+	}
+	for _, l := range records {
+		l = strings.TrimRight(l, "\r\n")
+		var err error
+		switch {
+		case strings.HasPrefix(l, "CONECT"):
+			id, err := strconv.Atoi(field(l, 6, 11))
+			if err != nil {
+				return CError{"Wrong CONECT record: " + l, []string{"strconv.Atoi", "pdbReadLinks"}}
+			}
+			i, ok := byID[id]
+			if !ok {
+				continue
+			}
+			for k := 11; k < 31; k += 5 {
+				f := field(l, k, k+5)
+				if f == "" {
+					continue
+				}
+				id2, err := strconv.Atoi(f)
+				if err != nil {
+					return CError{"Wrong CONECT record: " + l, []string{"strconv.Atoi", "pdbReadLinks"}}
+				}
+				if j, ok := byID[id2]; ok {
+					if err := bond(i, j, 0, 0); err != nil {
+						return CError{err.Error(), []string{"pdbReadLinks"}}
+					}
+				}
+			}
+		case strings.HasPrefix(l, "SSBOND"):
+			if s1, s2 := field(l, 59, 65), field(l, 66, 72); s1 != s2 && s1 != "" && s2 != "" {
+				continue
+			}
+			dist, _ := strconv.ParseFloat(field(l, 73, 78), 64)
+			i := res.atom(col(l, 15, 16), field(l, 17, 21), field(l, 21, 22), "SG", "")
+			j := res.atom(col(l, 29, 30), field(l, 31, 35), field(l, 35, 36), "SG", "")
+			err = bond(i, j, 1, dist)
+		default: //LINK
+			if s1, s2 := field(l, 59, 65), field(l, 66, 72); s1 != s2 && s1 != "" && s2 != "" {
+				continue
+			}
+			dist, _ := strconv.ParseFloat(field(l, 73, 78), 64)
+			i := res.atom(col(l, 21, 22), field(l, 22, 26), field(l, 26, 27), field(l, 12, 16), field(l, 16, 17))
+			j := res.atom(col(l, 51, 52), field(l, 52, 56), field(l, 56, 57), field(l, 42, 46), field(l, 46, 47))
+			err = bond(i, j, 0, dist)
+		}
+		if err != nil {
+			return CError{err.Error(), []string{"pdbReadLinks"}}
+		}
+	}
+	return nil
 }
 
 //End PDB_read family
@@ -354,41 +562,53 @@ func writePDBLine(atom *Atom, coord *v3.Matrix, bfact float64, chainprev string)
 	if atom.Het {
 		first = "HETATM"
 	}
-	formatstring := "%-6s%5d  %-3s %-4s%1s%4d    %8.3f%8.3f%8.3f%6.2f%6.2f          %2s  \n"
-	//4 chars for the atom name are used when hydrogens are included.
-	//This has not been tested
+	formatstring := "%-6s%5d %-4s%1s%-4s%1s%4d%1s   %8.3f%8.3f%8.3f%6.2f%6.2f          %2s  \n"
+	//4 chars for the atom name are used when hydrogens are included. They start one column
+	//before the shorter names.
+	name := " " + atom.Name
 	if len(atom.Name) == 4 {
-		formatstring = strings.Replace(formatstring, "%-3s ", "%-4s", 1)
+		name = atom.Name
 	} else if len(atom.Name) > 4 {
 		return "", chainprev, CError{"Cant print PDB line", []string{"writePDBLine"}}
 	}
-	//"%-6s%5d  %-3s %3s %1c%4d    %8.3f%8.3f%8.3f%6.2f%6.2f          %2s  \n"
-	out = fmt.Sprintf(formatstring, first, atom.ID, atom.Name, atom.MolName, atom.Chain,
-		atom.MolID, coord.At(0, 0), coord.At(0, 1), coord.At(0, 2), atom.Occupancy, bfact, atom.Symbol)
+	out = fmt.Sprintf(formatstring, first, atom.ID, name, atom.AltLoc, atom.MolName, atom.Chain,
+		atom.MolID, atom.InsCode, coord.At(0, 0), coord.At(0, 1), coord.At(0, 2), atom.Occupancy, bfact, atom.Symbol)
 	out = strings.Join([]string{ter, out}, "")
 	return out, chainprev, nil
 }
 
 //PDBFileWrite writes a PDB for the molecule mol and the coordinates Coords to a file name pdbname.
+//As with PDBWrite, CRYST1 and CONECT records are written when needed.
 func PDBFileWrite(pdbname string, coords *v3.Matrix, mol Atomer, Bfactors []float64) error {
-	out, err := os.Create(pdbname)
+	out, err := createWrite(pdbname)
 	if err != nil {
-		return CError{err.Error(), []string{"os.Create", "PDBFileWrite"}}
+		return CError{err.Error(), []string{"createWrite", "PDBFileWrite"}}
 	}
-	defer out.Close()
 	fmt.Fprintf(out, "REMARK WRITTEN WITH GOCHEM :-) \n")
 	err = PDBWrite(out, coords, mol, Bfactors)
 	if err != nil {
+		out.Close()
 		return errDecorate(err, "PDBFileWrite")
 	}
+	//For compressed files, the last data is written only when the file is closed.
+	if err := out.Close(); err != nil {
+		return CError{err.Error(), []string{"os.File.Close", "PDBFileWrite"}}
+	}
 	return nil
 }
 
 //PDBWrite writes a PDB formatted sequence of bytes to an io.Writer for a given reference, coordinate set and bfactor set, which must match each other. Returns error or nil.
+//The unit cell, if mol is a *Molecule with one, is written in a CRYST1 record, and the bonds that
+//can't be deduced from the residue names (see pdbConectBond), in CONECT records. Note that earlier
+//versions wrote neither. To write a file without CONECT records, give a mol without bonds.
 func PDBWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) error {
-	err := pdbWrite(out, coords, mol, bfact)
+	_, err := out.Write([]byte(pdbCryst1(mol)))
+	if err != nil {
+		return CError{"Failed to write in io.Writer", []string{"io.Write.Write", "PDBWrite"}}
+	}
+	err = pdbWrite(out, coords, mol, bfact, true)
 	if err != nil {
-		errDecorate(err, "PDBWrite")
+		return errDecorate(err, "PDBWrite")
 	}
 	_, err = out.Write([]byte{'\n'}) //This function is just a wrapper to add the newline to what pdbWrite does.
 	if err != nil {
@@ -398,7 +618,79 @@ func PDBWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) err
 	return nil
 }
 
-func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) error {
+//pdbCryst1 returns a CRYST1 record with the unit cell of mol, if mol is a *Molecule with
+//a unit cell, or an empty string otherwise.
+func pdbCryst1(mol Atomer) string {
+	m, ok := mol.(*Molecule)
+	if !ok || m.Cell == nil {
+		return ""
+	}
+	return pdbCryst1Cell(m.Cell)
+}
+
+//pdbCryst1Cell returns a CRYST1 record with the unit cell c.
+func pdbCryst1Cell(c *UnitCell) string {
+	sg := c.SpaceGroup
+	if sg == "" {
+		sg = "P 1"
+	}
+	return fmt.Sprintf("CRYST1%9.3f%9.3f%9.3f%7.2f%7.2f%7.2f %-11s%4d\n", c.Lengths[0], c.Lengths[1], c.Lengths[2],
+		c.Angles[0], c.Angles[1], c.Angles[2], sg, max(c.Z, 1))
+}
+
+//pdbConectBond returns true if the bond b should be written in a CONECT record. That is,
+//if it involves a HETATM, or if it joins different residues in other ways than the
+//peptide and phosphodiester bonds.
+func pdbConectBond(b *Bond) bool {
+	a1, a2 := b.At1, b.At2
+	if a1.Het || a2.Het {
+		return true
+	}
+	if a1.Chain == a2.Chain && a1.MolID == a2.MolID && a1.InsCode == a2.InsCode {
+		return false
+	}
+	names := a1.Name + "-" + a2.Name
+	for _, v := range []string{"C-N", "N-C", "O3'-P", "P-O3'", "O3*-P", "P-O3*"} {
+		if names == v {
+			return false
+		}
+	}
+	return true
+}
+
+//pdbConect returns the CONECT records for the bonds in mol that pass pdbConectBond.
+func pdbConect(mol Atomer) string {
+	_, bonds := atomerBonds(mol)
+	partners := make(map[*Atom][]int)
+	var order []*Atom
+	for _, b := range bonds {
+		if !pdbConectBond(b) {
+			continue
+		}
+		for _, at := range []*Atom{b.At1, b.At2} {
+			if _, ok := partners[at]; !ok {
+				order = append(order, at)
+			}
+			partners[at] = append(partners[at], b.Cross(at).ID)
+		}
+	}
+	var out strings.Builder
+	for _, at := range order {
+		p := partners[at]
+		for i := 0; i < len(p); i += 4 {
+			fmt.Fprintf(&out, "CONECT%5d", at.ID)
+			for _, id := range p[i:min(i+4, len(p))] {
+				fmt.Fprintf(&out, "%5d", id)
+			}
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
+
+//pdbWrite writes the atoms of mol, with coordinates coords and b-factors bfact, to out, followed by
+//CONECT records if conect is true, and "END", with no newline.
+func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64, conect bool) error {
 	if bfact == nil {
 		bfact = make([]float64, mol.Len())
 	}
@@ -427,6 +719,9 @@ func pdbWrite(out io.Writer, coords *v3.Matrix, mol Atomer, bfact []float64) err
 		}
 	}
 	_, err = out.Write([]byte("TER\n")) // New Addition, should help to recognize the end of the chain.
+	if conect {
+		_, err = out.Write([]byte(pdbConect(mol)))
+	}
 	_, err = out.Write([]byte("END"))   //no newline, this is in case the write is part of a PDB and one needs to write "ENDMDEL".
 	if err != nil {
 		return iowriteError(err)
@@ -475,7 +770,7 @@ func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]
 		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "MultiPDBWrite"}}
 	}
 
-	_, err := out.Write([]byte("REMARK WRITTEN WITH GOCHEM :-)")) //The model number starts with one
+	_, err := out.Write([]byte("REMARK WRITTEN WITH GOCHEM :-)\n" + pdbCryst1(mol)))
 	if err != nil {
 		return iowriterError(err)
 	}
@@ -485,7 +780,7 @@ func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]
 		if err != nil {
 			return iowriterError(err)
 		}
-		err = pdbWrite(out, Coords[j], mol, Bfactors[j])
+		err = pdbWrite(out, Coords[j], mol, Bfactors[j], false)
 		if err != nil {
 			return errDecorate(err, "MultiPDBWrite")
 		}
@@ -496,7 +791,7 @@ func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]
 
 	}
 
-	_, err = out.Write([]byte("END\n"))
+	_, err = out.Write([]byte(pdbConect(mol) + "END\n"))
 	if err != nil {
 		return iowriterError(err)
 	}
@@ -508,10 +803,10 @@ func MultiPDBWrite(out io.Writer, Coords []*v3.Matrix, mol Atomer, Bfactors [][]
 
 //XYZFileRead Reads an xyz or multixyz file (as produced by Turbomole). Returns a Molecule and error or nil.
 func XYZFileRead(xyzname string) (*Molecule, error) {
-	xyzfile, err := os.Open(xyzname)
+	xyzfile, err := openRead(xyzname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
-		return nil, CError{err.Error(), []string{"os.Open", "XYZFileRead"}}
+		return nil, CError{err.Error(), []string{"openRead", "XYZFileRead"}}
 	}
 	defer xyzfile.Close()
 	mol, err := XYZRead(xyzfile)
@@ -574,7 +869,7 @@ type XYZTraj struct {
 	natoms     int
 	xyz        *bufio.Reader //The DCD file
 	frames     int
-	xyzfile    *os.File
+	xyzfile    io.Closer
 	readable   bool
 	firstframe *v3.Matrix
 }
@@ -633,10 +928,10 @@ func (X *XYZTraj) Next(coords *v3.Matrix, box ...[]float64) error {
 
 //Reads a multi-xyz file. Returns the first snapshot as a molecule, and the other ones as a XYZTraj
 func XYZFileAsTraj(xyzname string) (*Molecule, *XYZTraj, error) {
-	xyzfile, err := os.Open(xyzname)
+	xyzfile, err := openRead(xyzname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
-		return nil, nil, CError{err.Error(), []string{"os.Open", "XYZFileRead"}}
+		return nil, nil, CError{err.Error(), []string{"openRead", "XYZFileRead"}}
 	}
 	xyz := bufio.NewReader(xyzfile)
 	//the molecule first
@@ -724,15 +1019,18 @@ func xyzReadSnap(xyz *bufio.Reader, toplace *v3.Matrix, ReadTopol bool) (*v3.Mat
 //XYZWrite writes the mol Ref and the Coord coordinates in an XYZ file with name xyzname which will
 //be created fot that. If the file exist it will be overwritten.
 func XYZFileWrite(xyzname string, Coords *v3.Matrix, mol Atomer) error {
-	out, err := os.Create(xyzname)
+	out, err := createWrite(xyzname)
 	if err != nil {
-		return CError{err.Error(), []string{"os.Create", "XYZFileWrite"}}
+		return CError{err.Error(), []string{"createWrite", "XYZFileWrite"}}
 	}
-	defer out.Close()
 	err = XYZWrite(out, Coords, mol)
 	if err != nil {
+		out.Close()
 		return errDecorate(err, "XYZFileWrite")
 	}
+	if err := out.Close(); err != nil {
+		return CError{err.Error(), []string{"os.File.Close", "XYZFileWrite"}}
+	}
 	return nil
 }
 
@@ -756,14 +1054,20 @@ func XYZStringWrite(Coords *v3.Matrix, mol Atomer) (string, error) {
 
 //XYZWrite writes the mol Ref and the Coords coordinates to a io.Writer, in the XYZ format.
 func XYZWrite(out io.Writer, Coords *v3.Matrix, mol Atomer) error {
+	return errDecorate(xyzWrite(out, Coords, mol, ""), "XYZWrite")
+}
+
+//xyzWrite writes the mol Ref and the Coords coordinates to a io.Writer, in the XYZ format,
+//with the given comment line.
+func xyzWrite(out io.Writer, Coords *v3.Matrix, mol Atomer, comment string) error {
 	iowriterError := func(err error) error {
-		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "XYZWrite"}}
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "xyzWrite"}}
 	}
 	if mol.Len() != Coords.NVecs() {
-		return CError{"Ref and Coords dont have the same number of atoms", []string{"XYZWrite"}}
+		return CError{"Ref and Coords dont have the same number of atoms", []string{"xyzWrite"}}
 	}
 	c := make([]float64, 3, 3)
-	_, err := out.Write([]byte(fmt.Sprintf("%-4d\n\n", mol.Len())))
+	_, err := out.Write([]byte(fmt.Sprintf("%-4d\n%s\n", mol.Len(), comment)))
 	if err != nil {
 		return iowriterError(err)
 	}
@@ -782,10 +1086,10 @@ func XYZWrite(out io.Writer, Coords *v3.Matrix, mol Atomer) error {
 
 //GroFileRead reads a file in the Gromacs gro format, returning a molecule.
 func GroFileRead(groname string) (*Molecule, error) {
-	grofile, err := os.Open(groname)
+	grofile, err := openRead(groname)
 	if err != nil {
 		//fmt.Println("Unable to open file!!")
-		return nil, CError{err.Error(), []string{"os.Open", "GroFileRead"}}
+		return nil, CError{err.Error(), []string{"openRead", "GroFileRead"}}
 	}
 	defer grofile.Close()
 	snaps := 1
@@ -932,28 +1236,37 @@ func read_gro_line(line string) (*Atom, []float64, error) {
 //GoFileWrite writes the molecule described by mol and Coords into a file in the Gromacs
 //gro format. If Coords has more than one elements, it will write a multi-state file.
 func GroFileWrite(outname string, Coords []*v3.Matrix, mol Atomer) error {
-	out, err := os.Create(outname)
+	out, err := createWrite(outname)
 	if err != nil {
-		return CError{"Failed to write open file" + err.Error(), []string{"os.Create", "GroFileWrite"}}
+		return CError{"Failed to write open file" + err.Error(), []string{"createWrite", "GroFileWrite"}}
 	}
-	defer out.Close()
 	for _, v := range Coords {
 		err := GroSnapWrite(v, mol, out)
 		if err != nil {
+			out.Close()
 			return errDecorate(err, "GoFileWrite")
 		}
 	}
+	if err := out.Close(); err != nil {
+		return CError{err.Error(), []string{"os.File.Close", "GroFileWrite"}}
+	}
 	return nil
 }
 
 //GroSnapWrite writes a single snapshot of a molecule to an io.Writer, in the Gro format.
 func GroSnapWrite(coords *v3.Matrix, mol Atomer, out io.Writer) error {
+	return errDecorate(groSnapWrite(coords, mol, out, nil), "GroSnapWrite")
+}
+
+//groSnapWrite writes a single snapshot of a molecule to an io.Writer, in the Gro format,
+//with the box vectors of box, or a zero box if box is nil.
+func groSnapWrite(coords *v3.Matrix, mol Atomer, out io.Writer, box *Box) error {
 	A2nm := 0.1
 	iowriterError := func(err error) error {
-		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "GroSnapWrite"}}
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "groSnapWrite"}}
 	}
 	if mol.Len() != coords.NVecs() {
-		return CError{"Ref and Coords dont have the same number of atoms", []string{"GroSnapWrite"}}
+		return CError{"Ref and Coords dont have the same number of atoms", []string{"groSnapWrite"}}
 	}
 	c := make([]float64, 3, 3)
 	_, err := out.Write([]byte(fmt.Sprintf("Written with goChem :-)\n%-4d\n", mol.Len())))
@@ -974,7 +1287,19 @@ func GroSnapWrite(coords *v3.Matrix, mol Atomer, out io.Writer) error {
 
 	}
 	//the box vectors at the end of the snappshot
-	_, err = out.Write([]byte("0.0 0.0 0.0\n"))
+	boxline := "0.0 0.0 0.0\n"
+	if box != nil {
+		v := box.Vectors()
+		//Gromacs order: v1(x) v2(y) v3(z), and for triclinic boxes, v1(y) v1(z) v2(x) v2(z) v3(x) v3(y).
+		boxline = fmt.Sprintf("%10.5f%10.5f%10.5f", v[0]*A2nm, v[4]*A2nm, v[8]*A2nm)
+		if !box.Orthorhombic() {
+			for _, i := range []int{1, 2, 3, 5, 6, 7} {
+				boxline += fmt.Sprintf("%10.5f", v[i]*A2nm)
+			}
+		}
+		boxline += "\n"
+	}
+	_, err = out.Write([]byte(boxline))
 	if err != nil {
 		return iowriterError(err)
 	}
@@ -0,0 +1,399 @@
+/*
+ * gmxtop.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gmxLine is a line of a GROMACS topology, after preprocessing.
+type gmxLine struct {
+	text   string
+	file   string
+	number int
+}
+
+// gmxPreprocessor handles the #include, #define and #ifdef directives in GROMACS topologies.
+type gmxPreprocessor struct {
+	defines map[string]bool
+	dirs    []string
+	lines   []gmxLine
+}
+
+// gmxIncludeDirs returns the directories where GROMACS looks for included files,
+// after the directory of the including file, and those given by the user.
+func gmxIncludeDirs() []string {
+	var dirs []string
+	if lib := os.Getenv("GMXLIB"); lib != "" {
+		dirs = append(dirs, filepath.SplitList(lib)...)
+	}
+	if data := os.Getenv("GMXDATA"); data != "" {
+		dirs = append(dirs, filepath.Join(data, "top"))
+	}
+	return append(dirs, "/usr/share/gromacs/top", "/usr/local/gromacs/share/gromacs/top")
+}
+
+func (p *gmxPreprocessor) findInclude(name, from string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	dirs := append([]string{filepath.Dir(from)}, p.dirs...)
+	for _, d := range dirs {
+		path := filepath.Join(d, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("included file %s not found, searched in %v", name, dirs)
+}
+
+// process reads the file name, adding its active lines, without comments, to p.lines,
+// and processing recursively the included files.
+func (p *gmxPreprocessor) process(name string, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("too many nested includes in %s", name)
+	}
+	f, err := openRead(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in := bufio.NewScanner(f)
+	in.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var active []bool //a stack of conditionals. A line is active if all are true.
+	isActive := func() bool {
+		for _, a := range active {
+			if !a {
+				return false
+			}
+		}
+		return true
+	}
+	number := 0
+	var previous string //for continued lines
+	for in.Scan() {
+		number++
+		line := previous + in.Text()
+		previous = ""
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			previous = strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if line[0] != '#' {
+			if isActive() {
+				p.lines = append(p.lines, gmxLine{line, name, number})
+			}
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) == 0 {
+			continue
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+		switch fields[0] {
+		case "ifdef":
+			active = append(active, p.defines[arg])
+		case "ifndef":
+			active = append(active, !p.defines[arg])
+		case "else":
+			if len(active) == 0 {
+				return fmt.Errorf("%s:%d: #else without #ifdef", name, number)
+			}
+			active[len(active)-1] = !active[len(active)-1]
+		case "endif":
+			if len(active) == 0 {
+				return fmt.Errorf("%s:%d: #endif without #ifdef", name, number)
+			}
+			active = active[:len(active)-1]
+		case "define":
+			if isActive() {
+				p.defines[arg] = true
+			}
+		case "undef":
+			if isActive() {
+				delete(p.defines, arg)
+			}
+		case "include":
+			if !isActive() {
+				continue
+			}
+			inc := strings.Trim(arg, `"<>`)
+			path, err := p.findInclude(inc, name)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", name, number, err)
+			}
+			if err := p.process(path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if len(active) != 0 {
+		return fmt.Errorf("%s: unterminated #ifdef", name)
+	}
+	return in.Err()
+}
+
+type gmxAtomType struct {
+	mass   float64
+	symbol string
+}
+
+// gmxMolType is a molecule type defined in a GROMACS topology.
+type gmxMolType struct {
+	name  string
+	atoms []*Atom
+	bonds [][2]int
+}
+
+// GroTopFileRead reads the GROMACS topology (.top) file name, and returns the topology of the
+// system defined in it. Included files (.itp) are searched for in the directory of the including file,
+// in includeDirs, and in the directories where GROMACS looks for them (GMXLIB, GMXDATA/top and the usual
+// installation directories). Symbols given with -D in grompp can be given as defines, with the
+// same format (i.e. "-DPOSRES" or "POSRES").
+// Residue numbers are put in MolID, renumbered so they continue from one molecule to the next, as in the
+// .gro files written by GROMACS, and the index of each molecule in the system in Tag. Bonds are
+// taken from the bonds, constraints (type 1) and settles sections.
+func GroTopFileRead(name string, includeDirs []string, defines ...string) (*Topology, error) {
+	p := &gmxPreprocessor{defines: make(map[string]bool), dirs: append(append([]string{}, includeDirs...), gmxIncludeDirs()...)}
+	for _, d := range defines {
+		p.defines[strings.TrimPrefix(d, "-D")] = true
+	}
+	if err := p.process(name, 0); err != nil {
+		return nil, fmt.Errorf("GroTopFileRead: %w", err)
+	}
+	top, err := gmxTopology(p.lines)
+	if err != nil {
+		return nil, fmt.Errorf("GroTopFileRead: %w", err)
+	}
+	return top, nil
+}
+
+// gmxTopology builds the topology from the lines of a preprocessed GROMACS topology.
+func gmxTopology(lines []gmxLine) (*Topology, error) {
+	atomtypes := make(map[string]gmxAtomType)
+	moltypes := make(map[string]*gmxMolType)
+	var current *gmxMolType
+	var section string
+	top := NewTopology(0, 1)
+	totalcharge := 0.0
+	nmols := 0
+	for _, l := range lines {
+		if strings.HasPrefix(l.text, "[") {
+			section = strings.TrimSpace(strings.Trim(l.text, "[]"))
+			continue
+		}
+		fields := strings.Fields(l.text)
+		lerr := func(err error) error {
+			return fmt.Errorf("%s:%d: %w", l.file, l.number, err)
+		}
+		switch section {
+		case "atomtypes":
+			name, at, err := gmxReadAtomType(fields)
+			if err != nil {
+				return nil, lerr(err)
+			}
+			atomtypes[name] = at
+		case "moleculetype":
+			current = &gmxMolType{name: fields[0]}
+			moltypes[fields[0]] = current
+		case "atoms":
+			if current == nil {
+				return nil, lerr(fmt.Errorf("atoms outside a moleculetype"))
+			}
+			at, err := gmxReadAtom(fields, atomtypes)
+			if err != nil {
+				return nil, lerr(err)
+			}
+			if at.ID != len(current.atoms)+1 {
+				return nil, lerr(fmt.Errorf("atoms not numbered consecutively in %s", current.name))
+			}
+			current.atoms = append(current.atoms, at)
+		case "bonds", "constraints", "settles":
+			if current == nil {
+				return nil, lerr(fmt.Errorf("%s outside a moleculetype", section))
+			}
+			bonds, err := gmxReadBonds(section, fields)
+			if err != nil {
+				return nil, lerr(err)
+			}
+			current.bonds = append(current.bonds, bonds...)
+		case "molecules":
+			if len(fields) < 2 {
+				return nil, lerr(fmt.Errorf("wrong molecules line"))
+			}
+			mt, ok := moltypes[fields[0]]
+			if !ok {
+				return nil, lerr(fmt.Errorf("undefined molecule type %s", fields[0]))
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, lerr(err)
+			}
+			for i := 0; i < n; i++ {
+				nmols++
+				c, err := mt.instance(top, nmols)
+				if err != nil {
+					return nil, lerr(err)
+				}
+				totalcharge += c
+			}
+		}
+	}
+	if top.Len() == 0 {
+		return nil, fmt.Errorf("no molecules in topology")
+	}
+	top.SetCharge(int(math.Round(totalcharge)))
+	return top, nil
+}
+
+// instance adds a copy of the molecule type to top, with Tag set to molindex.
+// As grompp does, the residues are renumbered, by adding the number of the last
+// residue in top to the ones in the molecule type, so each copy has its own residues.
+// It returns the charge of the molecule.
+func (mt *gmxMolType) instance(top *Topology, molindex int) (float64, error) {
+	offset := top.Len()
+	resoffset := 0
+	if offset > 0 {
+		resoffset = top.Atoms[offset-1].MolID
+	}
+	charge := 0.0
+	for _, a := range mt.atoms {
+		at := new(Atom)
+		at.Copy(a)
+		at.ID = top.Len() + 1
+		at.MolID += resoffset
+		at.Tag = molindex
+		at.SetIndex(top.Len())
+		top.Atoms = append(top.Atoms, at)
+		charge += at.Charge
+	}
+	for _, b := range mt.bonds {
+		if b[0] < 0 || b[1] < 0 || b[0] >= len(mt.atoms) || b[1] >= len(mt.atoms) {
+			return 0, fmt.Errorf("bond between atoms %d and %d out of range in %s", b[0]+1, b[1]+1, mt.name)
+		}
+		if err := topologyBond(top, offset+b[0], offset+b[1], 0); err != nil {
+			return 0, err
+		}
+	}
+	return charge, nil
+}
+
+// gmxReadAtomType parses a line in the atomtypes section. The number of fields varies, but the
+// particle type (A, S, V or D) is always the third field from the end, and the mass and charge
+// come right before it. The atomic number, if present, comes before the mass.
+func gmxReadAtomType(fields []string) (string, gmxAtomType, error) {
+	var at gmxAtomType
+	p := len(fields) - 3
+	if p < 3 || len(fields[p]) != 1 || !strings.Contains("ASVD", fields[p]) {
+		return "", at, fmt.Errorf("wrong atomtypes line")
+	}
+	var err error
+	at.mass, err = strconv.ParseFloat(fields[p-2], 64)
+	if err != nil {
+		return "", at, err
+	}
+	if p >= 4 {
+		if z, err := strconv.Atoi(fields[p-3]); err == nil && z > 0 && z < len(atomicNumberSymbol) {
+			at.symbol = atomicNumberSymbol[z]
+		}
+	}
+	return fields[0], at, nil
+}
+
+// gmxReadAtom parses a line of an atoms section:
+// nr type resnr residue atom cgnr charge [mass]
+func gmxReadAtom(fields []string, types map[string]gmxAtomType) (*Atom, error) {
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("wrong atoms line")
+	}
+	at := &Atom{Type: fields[1], MolName: fields[3], Name: fields[4]}
+	var err error
+	if at.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return nil, err
+	}
+	if at.MolID, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, err
+	}
+	if at.Charge, err = strconv.ParseFloat(fields[6], 64); err != nil {
+		return nil, err
+	}
+	at.MolName1 = three2OneLetter[at.MolName]
+	atype, ok := types[at.Type]
+	at.Mass = atype.mass
+	if len(fields) > 7 {
+		if at.Mass, err = strconv.ParseFloat(fields[7], 64); err != nil {
+			return nil, err
+		}
+	}
+	at.Symbol = atype.symbol
+	if !ok || at.Symbol == "" {
+		at.Symbol = symbolFromMass(at.Mass, at.Name)
+	}
+	if at.Mass == 0 {
+		at.Mass = symbolMass[at.Symbol]
+	}
+	return at, nil
+}
+
+// gmxReadBonds returns the bonds, as 0-based indexes in the molecule, in a line of the
+// bonds, constraints or settles sections.
+func gmxReadBonds(section string, fields []string) ([][2]int, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("wrong %s line", section)
+	}
+	i, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if section == "settles" {
+		//the oxygen is bonded to the next two atoms.
+		return [][2]int{{i - 1, i}, {i - 1, i + 1}}, nil
+	}
+	if section == "constraints" && (len(fields) < 3 || fields[2] != "1") {
+		return nil, nil //type 2 constraints don't mean the atoms are bonded.
+	}
+	j, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return [][2]int{{i - 1, j - 1}}, nil
+}
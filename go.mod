@@ -7,6 +7,7 @@ toolchain go1.22.0
 require (
 	github.com/klauspost/compress v1.15.9
 	github.com/skelterjohn/go.matrix v0.0.0-20130517144113-daa59528eefd
+	github.com/ulikunitz/xz v0.5.15
 	gonum.org/v1/gonum v0.15.1
 	gonum.org/v1/plot v0.14.0
 )
@@ -24,9 +24,14 @@
 package chem
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	v3 "github.com/rmera/gochem/v3"
@@ -633,3 +638,908 @@ func TestBondsPorf(Te *testing.T) {
 	fmt.Printf("Long path %v has %d nodes\n", paths[len(paths)-1], len(paths[len(paths)-1]))
 
 }
+
+// a small, made-up, 2-molecule MOL2 file.
+const testMOL2 = `@<TRIPOS>MOLECULE
+acetate
+ 4 3 1 0 0
+SMALL
+USER_CHARGES
+
+@<TRIPOS>ATOM
+      1 C1          0.0000    0.0000    0.0000 C.3     1  ACT1       -0.2000
+      2 C2          1.5200    0.0000    0.0000 C.2     1  ACT1        0.2000
+      3 O1          2.1500    1.0600    0.0000 O.co2   1  ACT1       -0.5000
+      4 O2          2.1500   -1.0600    0.0000 O.co2   1  ACT1       -0.5000
+@<TRIPOS>BOND
+     1     1     2 1
+     2     2     3 ar
+     3     2     4 ar
+@<TRIPOS>SUBSTRUCTURE
+     1 ACT1        1 GROUP             0 B     ACT
+@<TRIPOS>MOLECULE
+acetate
+ 4 3 1 0 0
+SMALL
+USER_CHARGES
+
+@<TRIPOS>ATOM
+      1 C1          0.1000    0.0000    0.0000 C.3     1  ACT1       -0.2000
+      2 C2          1.6200    0.0000    0.0000 C.2     1  ACT1        0.2000
+      3 O1          2.2500    1.0600    0.0000 O.co2   1  ACT1       -0.5000
+      4 O2          2.2500   -1.0600    0.0000 O.co2   1  ACT1       -0.5000
+@<TRIPOS>BOND
+     1     1     2 1
+     2     2     3 ar
+     3     2     4 ar
+@<TRIPOS>SUBSTRUCTURE
+     1 ACT1        1 GROUP             0 B     ACT
+`
+
+func TestMOL2IO(Te *testing.T) {
+	mol, err := MOL2Read(strings.NewReader(testMOL2))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol.Len() != 4 || len(mol.Coords) != 2 || len(mol.Bonds) != 3 {
+		Te.Fatalf("Expected 4 atoms, 2 frames and 3 bonds, got %d, %d and %d", mol.Len(), len(mol.Coords), len(mol.Bonds))
+	}
+	at := mol.Atom(2)
+	if at.Symbol != "O" || at.Type != "O.co2" || at.Charge != -0.5 || at.MolName != "ACT" || at.MolID != 1 || at.Chain != "B" {
+		Te.Errorf("Atom incorrectly read: %+v", at)
+	}
+	if mol.Charge() != -1 || len(at.Bonds) != 1 || at.Bonds[0].Order != 1.5 {
+		Te.Errorf("Wrong charge (%d) or bonds", mol.Charge())
+	}
+	name := filepath.Join(Te.TempDir(), "acetate.mol2")
+	if err = MOL2FileWrite(name, mol.Coords, mol); err != nil {
+		Te.Fatal(err)
+	}
+	mol2, err := MOL2FileRead(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol2.Len() != 4 || len(mol2.Coords) != 2 || len(mol2.Bonds) != 3 || mol2.Atom(2).Type != "O.co2" || mol2.Atom(0).Bonds[0].Order != 1 {
+		Te.Errorf("MOL2 file incorrectly written or read")
+	}
+	if mol2.Coords[1].At(0, 0) != 0.1 {
+		Te.Errorf("Wrong coordinates for the second frame: %v", mol2.Coords[1].VecView(0))
+	}
+	diff := testMOL2[:strings.LastIndex(testMOL2, "@<TRIPOS>MOLECULE")] + strings.Replace(testMOL2[strings.LastIndex(testMOL2, "@<TRIPOS>MOLECULE"):], "O.co2", "O.3", 1)
+	if _, err = MOL2Read(strings.NewReader(diff)); err == nil {
+		Te.Errorf("Molecules with different topologies should not be read as frames")
+	}
+}
+
+// Two records, a V2000 acetate and a V3000 ammonium.
+const testSDF = `acetate
+  made-up
+
+  4  3  0  0  0  0  0  0  0  0999 V2000
+    0.0000    0.0000    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+    1.5200    0.0000    0.0000 C   0  0  0  0  0  0  0  0  0  0  0  0
+    2.1500    1.0600    0.0000 O   0  0  0  0  0  0  0  0  0  0  0  0
+    2.1500   -1.0600    0.0000 O   0  5  0  0  0  0  0  0  0  0  0  0
+  1  2  1  0
+  2  3  2  0
+  2  4  1  0
+M  CHG  1   4  -1
+M  END
+> <ID>
+lig-1
+
+> <score>
+-7.5
+
+$$$$
+ammonium
+  made-up
+
+  0  0  0     0  0            999 V3000
+M  V30 BEGIN CTAB
+M  V30 COUNTS 2 1 0 0 0
+M  V30 BEGIN ATOM
+M  V30 1 N 0.0000 0.0000 0.0000 0 CHG=1
+M  V30 2 H 1.0100 0.0000 -
+M  V30 0.0000 0
+M  V30 END ATOM
+M  V30 BEGIN BOND
+M  V30 1 1 1 2
+M  V30 END BOND
+M  V30 END CTAB
+M  END
+$$$$
+`
+
+func TestSDFIO(Te *testing.T) {
+	S := SDFRead(strings.NewReader(testSDF))
+	top, coords, props, err := S.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 4 || len(top.Bonds) != 3 || top.Charge() != -1 || top.Atom(3).Charge != -1 || top.Atom(2).Bonds[0].Order != 2 {
+		Te.Errorf("V2000 record incorrectly read")
+	}
+	if props["score"] != "-7.5" || props["ID"] != "lig-1" || props[SDFNameKey] != "acetate" {
+		Te.Errorf("Wrong properties: %v", props)
+	}
+	var buf strings.Builder
+	if err = SDFWrite(&buf, coords, top, props); err != nil {
+		Te.Fatal(err)
+	}
+	top, coords, props, err = S.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 2 || len(top.Bonds) != 1 || top.Charge() != 1 || coords.At(1, 0) != 1.01 || props[SDFNameKey] != "ammonium" {
+		Te.Errorf("V3000 record incorrectly read")
+	}
+	if _, _, _, err = S.Next(); err == nil {
+		Te.Errorf("Expected a LastFrameError after the last record")
+	} else if _, ok := err.(LastFrameError); !ok {
+		Te.Errorf("Expected a LastFrameError, got %v", err)
+	}
+	S = SDFRead(strings.NewReader(buf.String()))
+	top, _, props, err = S.Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 4 || len(top.Bonds) != 3 || top.Charge() != -1 || props["score"] != "-7.5" {
+		Te.Errorf("SDF record incorrectly written or read:\n%s", buf.String())
+	}
+	//The atom indexes in V3000 records need not be consecutive.
+	gapped := strings.Replace(testSDF[strings.Index(testSDF, "ammonium"):], "M  V30 1 1 1 2", "M  V30 1 1 20 7", 1)
+	gapped = strings.Replace(gapped, "M  V30 1 N", "M  V30 20 N", 1)
+	gapped = strings.Replace(gapped, "M  V30 2 H", "M  V30 7 H", 1)
+	top, _, _, err = SDFRead(strings.NewReader(gapped)).Next()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if len(top.Bonds) != 1 || top.Bonds[0].At1 != top.Atom(0) || top.Bonds[0].At2 != top.Atom(1) || top.Atom(0).ID != 20 {
+		Te.Errorf("V3000 record with gapped atom indexes incorrectly read")
+	}
+	if _, _, _, err = SDFRead(strings.NewReader(strings.Replace(gapped, "1 1 20 7", "1 1 20 2", 1))).Next(); err == nil {
+		Te.Errorf("V3000 bond to an undefined atom read")
+	}
+}
+
+const testPrmtop = `%VERSION  VERSION_STAMP = V0001.000  DATE = 01/01/26  00:00:00
+%FLAG TITLE
+%FORMAT(20a4)
+default_name
+%FLAG POINTERS
+%FORMAT(10I8)
+       4       2       2       0       1       0       0       0       0       0
+       0       2       0       0       0       0       0       0       0       0
+%FLAG ATOM_NAME
+%FORMAT(20a4)
+O   H1  H2  NA  
+%FLAG CHARGE
+%FORMAT(5E16.8)
+ -1.51973982E+01  7.59869910E+00  7.59869910E+00  1.82223000E+01
+%FLAG ATOMIC_NUMBER
+%FORMAT(10I8)
+       8       1       1      11
+%FLAG MASS
+%FORMAT(5E16.8)
+  1.60000000E+01  1.00800000E+00  1.00800000E+00  2.29900000E+01
+%FLAG RESIDUE_LABEL
+%FORMAT(20a4)
+WAT Na+ 
+%FLAG RESIDUE_POINTER
+%FORMAT(10I8)
+       1       4
+%FLAG BONDS_INC_HYDROGEN
+%FORMAT(10I8)
+       0       3       1       0       6       1
+%FLAG BONDS_WITHOUT_HYDROGEN
+%FORMAT(10I8)
+
+%FLAG AMBER_ATOM_TYPE
+%FORMAT(20a4)
+OW  HW  HW  Na+ 
+%FLAG ATOMS_PER_MOLECULE
+%FORMAT(10I8)
+       3       1
+`
+
+func TestPrmtop(Te *testing.T) {
+	top, err := PrmtopRead(strings.NewReader(testPrmtop))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 4 || len(top.Bonds) != 2 || top.Charge() != 1 {
+		Te.Fatalf("Wrong topology: %d atoms, %d bonds, charge %d", top.Len(), len(top.Bonds), top.Charge())
+	}
+	o := top.Atom(0)
+	if o.Symbol != "O" || o.Type != "OW" || o.MolName != "WAT" || o.MolID != 1 || len(o.Bonds) != 2 || math.Abs(o.Charge+0.834) > 1e-6 {
+		Te.Errorf("Wrong oxygen: %+v", o)
+	}
+	na := top.Atom(3)
+	if na.Symbol != "Na" || na.MolName != "Na+" || na.MolID != 2 || na.Tag != 2 || math.Abs(na.Mass-22.99) > 1e-6 {
+		Te.Errorf("Wrong sodium: %+v", na)
+	}
+}
+
+const testGroTop = `; A water and a methanol
+#include "test.itp"
+
+#ifdef FLEXIBLE
+#include "missing.itp"
+#endif
+
+[ moleculetype ]
+; name nrexcl
+SOL 2
+
+[ atoms ]
+1 OW 1 SOL OW 1 -0.834
+2 HW 1 SOL HW1 1 0.417
+3 HW 1 SOL HW2 1 0.417
+
+#ifndef FLEXIBLE
+[ settles ]
+1 1 0.09572 0.15139
+#else
+[ bonds ]
+1 2
+1 3
+#endif
+
+[ system ]
+test
+
+[ molecules ]
+MOH 1
+SOL 2
+`
+
+const testItp = `[ atomtypes ]
+;name at.num mass charge ptype sigma epsilon
+OW 8 16.00 0.0 A 3.15e-01 6.36e-01
+HW 1 1.008 0.0 A 0 0
+CT 6 12.01 0.0 A 3.4e-01 4.5e-01
+OH 8 16.00 0.0 A 3.1e-01 8.8e-01
+HO 1 1.008 0.0 A 0 0
+H1 1 1.008 0.0 A 0 0
+
+[ moleculetype ]
+MOH 3
+
+[ atoms ]
+1 CT 1 MOH C 1 0.117
+2 H1 1 MOH H1 1 0.028
+3 H1 1 MOH H2 1 0.028
+4 H1 1 MOH H3 1 0.028
+5 OH 1 MOH O 1 -0.599 \
+   16.00
+6 HO 1 MOH HO 1 0.398
+
+[ bonds ]
+1 2 1
+1 3 1
+1 4 1
+1 5 1
+5 6 1
+`
+
+func TestGroTop(Te *testing.T) {
+	dir := Te.TempDir()
+	name := filepath.Join(dir, "test.top")
+	os.WriteFile(name, []byte(testGroTop), 0644)
+	os.WriteFile(filepath.Join(dir, "test.itp"), []byte(testItp), 0644)
+	top, err := GroTopFileRead(name, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 12 || len(top.Bonds) != 9 || top.Charge() != 0 {
+		Te.Fatalf("Wrong topology: %d atoms, %d bonds, charge %d", top.Len(), len(top.Bonds), top.Charge())
+	}
+	o := top.Atom(4)
+	if o.Symbol != "O" || o.Mass != 16 || o.MolName != "MOH" || o.Tag != 1 || len(o.Bonds) != 2 {
+		Te.Errorf("Wrong methanol oxygen: %+v", o)
+	}
+	h := top.Atom(11)
+	if h.Symbol != "H" || h.Name != "HW2" || h.Tag != 3 || h.ID != 12 || len(h.Bonds) != 1 || h.Bonds[0].Cross(h) != top.Atom(9) {
+		Te.Errorf("Wrong water hydrogen: %+v", h)
+	}
+	//Each copy of a molecule type is a different residue.
+	if top.Atom(0).MolID != 1 || top.Atom(6).MolID != 2 || h.MolID != 3 || len(Molecules2Atoms(top, []int{3}, nil)) != 3 {
+		Te.Errorf("Wrong residue numbers: %d %d %d", top.Atom(0).MolID, top.Atom(6).MolID, h.MolID)
+	}
+	//The missing file is included only with FLEXIBLE defined.
+	if _, err := GroTopFileRead(name, nil, "-DFLEXIBLE"); err == nil || !strings.Contains(err.Error(), "missing.itp") {
+		Te.Errorf("Expected an error for the missing include, got %v", err)
+	}
+}
+
+const testPSF = `PSF EXT CMAP CHEQ XPLOR
+
+         2 !NTITLE
+* METHYLAMMONIUM AND CHLORIDE
+*  DATE:     1/ 1/26
+
+         6 !NATOM
+         1 MOL      1        MAM      N        NH3L    -0.300000       14.0070           0   0.00000     -0.301140E-02
+         2 MOL      1        MAM      HN1      HCL      0.330000        1.0080           0   0.00000     -0.301140E-02
+         3 MOL      1        MAM      HN2      HCL      0.330000        1.0080           0   0.00000     -0.301140E-02
+         4 MOL      1        MAM      HN3      HCL      0.330000        1.0080           0   0.00000     -0.301140E-02
+         5 MOL      1        MAM      C        CTL3     0.310000       12.0110           0   0.00000     -0.301140E-02
+         6 ION      2A       CLA      CLA      CLA     -1.000000       35.4500           0   0.00000     -0.301140E-02
+
+         4 !NBOND: bonds
+         1         2         1         3         1         4         1         5
+
+         0 !NTHETA: angles
+
+`
+
+func TestPSFIO(Te *testing.T) {
+	top, err := PSFRead(strings.NewReader(testPSF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top.Len() != 6 || len(top.Bonds) != 4 || top.Charge() != 0 || len(top.Atom(0).Bonds) != 4 {
+		Te.Fatalf("Wrong topology: %d atoms, %d bonds, charge %d", top.Len(), len(top.Bonds), top.Charge())
+	}
+	cl := top.Atom(5)
+	if cl.SegID != "ION" || cl.MolID != 2 || cl.InsCode != "A" || cl.MolName != "CLA" || cl.Type != "CLA" || cl.Symbol != "Cl" || cl.Charge != -1 {
+		Te.Errorf("Wrong chloride: %+v", cl)
+	}
+	var buf strings.Builder
+	if err := PSFWrite(&buf, top); err != nil {
+		Te.Fatal(err)
+	}
+	top2, err := PSFRead(strings.NewReader(buf.String()))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if top2.Len() != 6 || len(top2.Bonds) != 4 || top2.Atom(4).Name != "C" || top2.Atom(4).Mass != 12.011 || top2.Atom(0).SegID != "MOL" || top2.Atom(5).InsCode != "A" {
+		Te.Errorf("PSF incorrectly written or read:\n%s", buf.String())
+	}
+	//A topology from a PDB file with no chains.
+	pdb := `ATOM      1  OH2 TIP3    1       1.000   0.000   0.000  1.00  0.00           O
+ATOM      2  H1  TIP3    1       1.957   0.000   0.000  1.00  0.00           H
+ATOM      3  H2  TIP3    1       0.760   0.927   0.000  1.00  0.00           H
+`
+	mol, err := PDBRead(strings.NewReader(pdb))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	buf.Reset()
+	if err := PSFWrite(&buf, mol); err != nil {
+		Te.Fatal(err)
+	}
+	top3, err := PSFRead(strings.NewReader(buf.String()))
+	if err != nil {
+		Te.Fatalf("%v:\n%s", err, buf.String())
+	}
+	if top3.Len() != 3 || top3.Atom(0).Name != "OH2" || top3.Atom(0).SegID != "X" || top3.Atom(2).MolID != 1 {
+		Te.Errorf("PSF from a PDB incorrectly written or read:\n%s", buf.String())
+	}
+}
+
+// TestCompressedIO writes and reads back a molecule in plain and compressed
+// files, and reads a bzip2-compressed file, which can't be written.
+func TestCompressedIO(Te *testing.T) {
+	dir := Te.TempDir()
+	//"3\nwater\nO 0.0 0.0 0.0\nH 0.757 0.586 0.0\nH -0.757 0.586 0.0\n", compressed with bzip2.
+	bz2, _ := hex.DecodeString("425a68393141592653593294c1e80000135d80001040034bc00040a2001480200031434d300024a27ea2369a47a8d7b9de864ec7e34108ba5168c8c924b961aaaaa7f8bb9229c2848194a60f40")
+	bz2name := filepath.Join(dir, "water.xyz.bz2")
+	if err := os.WriteFile(bz2name, bz2, 0644); err != nil {
+		Te.Fatal(err)
+	}
+	mol, err := XYZFileRead(bz2name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol.Len() != 3 || mol.Atom(1).Symbol != "H" || mol.Coords[0].At(1, 0) != 0.757 {
+		Te.Fatalf("Wrong molecule read from bzip2 file")
+	}
+	if err := XYZFileWrite(bz2name, mol.Coords[0], mol); err == nil {
+		Te.Errorf("Writing bzip2 files should fail")
+	}
+	for _, ext := range []string{"", ".gz", ".zst", ".xz"} {
+		name := filepath.Join(dir, "water.pdb"+ext)
+		if err := PDBFileWrite(name, mol.Coords[0], mol, nil); err != nil {
+			Te.Fatal(err)
+		}
+		//The compression must be detected from the content, not the name.
+		renamed := filepath.Join(dir, "water"+strings.TrimPrefix(ext, ".")+".pdb")
+		if err := os.Rename(name, renamed); err != nil {
+			Te.Fatal(err)
+		}
+		mol2, err := PDBFileRead(renamed)
+		if err != nil {
+			Te.Fatalf("%s: %v", ext, err)
+		}
+		if mol2.Len() != 3 || mol2.Atom(2).Symbol != "H" || math.Abs(mol2.Coords[0].At(2, 0)+0.757) > 1e-3 {
+			Te.Errorf("%s: wrong molecule read", ext)
+		}
+		if ext != "" {
+			head := make([]byte, 2)
+			f, _ := os.Open(renamed)
+			f.Read(head)
+			f.Close()
+			if string(head) == "RE" {
+				Te.Errorf("%s: file written without compression", ext)
+			}
+		}
+	}
+	//The streaming writers finish compressed files when they are closed.
+	name := filepath.Join(dir, "water2.xyz.gz")
+	w, err := NewXYZFileWriter(name, mol)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.WNext(mol.Coords[0]); err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.CloseErr(); err != nil {
+		Te.Fatal(err)
+	}
+	if mol2, err := XYZFileRead(name); err != nil || mol2.Len() != 3 {
+		Te.Errorf("Wrong molecule read from a compressed trajectory: %v", err)
+	}
+}
+
+const testCIF = `data_TEST
+#
+_cell.length_a 40.000
+_cell.length_b 50.000
+_cell.length_c 60.000
+_cell.angle_alpha 90.00
+_cell.angle_beta 100.00
+_cell.angle_gamma 90.00
+_cell.Z_PDB 4
+#
+_symmetry.space_group_name_H-M 'P 1 21 1'
+#
+loop_
+_entity.id
+_entity.type
+_entity.pdbx_description
+1 polymer 'Test protein, mutant'
+2 non-polymer 'ZINC ION'
+#
+_entity_poly.entity_id 1
+_entity_poly.type 'polypeptide(L)'
+_entity_poly.pdbx_seq_one_letter_code_can
+;CAC
+GA
+;
+_entity_poly.pdbx_strand_id A,B
+#
+loop_
+_chem_comp.id
+_chem_comp.type
+_chem_comp.name
+_chem_comp.formula
+_chem_comp.formula_weight
+CYS 'L-peptide linking' CYSTEINE 'C3 H7 N O2 S' 121.158
+ZN non-polymer 'ZINC ION' 'Zn 2' 65.409
+#
+loop_
+_struct_conf.conf_type_id
+_struct_conf.id
+_struct_conf.pdbx_PDB_helix_class
+_struct_conf.beg_auth_asym_id
+_struct_conf.beg_auth_seq_id
+_struct_conf.pdbx_beg_PDB_ins_code
+_struct_conf.end_auth_asym_id
+_struct_conf.end_auth_seq_id
+_struct_conf.pdbx_end_PDB_ins_code
+HELX_P HELX_P1 1 A 1 ? A 2 A
+#
+_struct_sheet_range.sheet_id AA
+_struct_sheet_range.id 1
+_struct_sheet_range.beg_auth_asym_id A
+_struct_sheet_range.beg_auth_seq_id 3
+_struct_sheet_range.end_auth_asym_id A
+_struct_sheet_range.end_auth_seq_id 3
+#
+loop_
+_struct_conn.id
+_struct_conn.conn_type_id
+_struct_conn.ptnr1_auth_asym_id
+_struct_conn.ptnr1_auth_seq_id
+_struct_conn.ptnr1_label_atom_id
+_struct_conn.pdbx_ptnr1_PDB_ins_code
+_struct_conn.ptnr1_symmetry
+_struct_conn.ptnr2_auth_asym_id
+_struct_conn.ptnr2_auth_seq_id
+_struct_conn.ptnr2_label_atom_id
+_struct_conn.pdbx_ptnr2_PDB_ins_code
+_struct_conn.ptnr2_symmetry
+_struct_conn.pdbx_dist_value
+disulf1 disulf A 1 SG ? 1_555 A 3 SG ? 1_555 2.04
+metalc1 metalc A 3 SG ? 1_555 A 4 ZN ? 1_555 2.30
+metalc2 metalc A 1 SG ? 1_555 A 4 ZN ? 2_655 2.30
+hydrog1 hydrog A 1 N ? 1_555 A 3 SG ? 1_555 3.00
+#
+loop_
+_atom_site.group_PDB
+_atom_site.id
+_atom_site.type_symbol
+_atom_site.label_atom_id
+_atom_site.label_alt_id
+_atom_site.label_comp_id
+_atom_site.label_asym_id
+_atom_site.label_seq_id
+_atom_site.pdbx_PDB_ins_code
+_atom_site.Cartn_x
+_atom_site.Cartn_y
+_atom_site.Cartn_z
+_atom_site.occupancy
+_atom_site.B_iso_or_equiv
+_atom_site.pdbx_formal_charge
+_atom_site.auth_seq_id
+_atom_site.auth_asym_id
+_atom_site.pdbx_PDB_model_num
+ATOM 1 N N . CYS A 1 ? 1.0 2.0 3.0 1.00 10.0 ? 1 A 1
+ATOM 2 S SG A CYS A 1 ? 2.0 2.0 3.0 0.40 11.0 ? 1 A 1
+ATOM 3 S SG B CYS A 1 ? 2.5 2.0 3.0 0.60 12.0 ? 1 A 1
+ATOM 4 C "C1'" . ALA A 2 A 3.0 2.0 3.0 1.00 13.0 ? 2 A 1
+ATOM 5 S SG . CYS A 3 ? 4.0 2.0 3.0 1.00 14.0 ? 3 A 1
+HETATM 6 ZN ZN . ZN B . ? 5.0 2.0 3.0 1.00 15.0 2 4 A 1
+ATOM 7 N N . CYS A 1 ? 1.1 2.0 3.0 1.00 10.0 ? 1 A 2
+ATOM 8 S SG A CYS A 1 ? 2.1 2.0 3.0 0.70 11.0 ? 1 A 2
+ATOM 9 S SG B CYS A 1 ? 2.6 2.0 3.0 0.30 12.0 ? 1 A 2
+ATOM 10 C "C1'" . ALA A 2 A 3.1 2.0 3.0 1.00 13.0 ? 2 A 2
+ATOM 11 S SG . CYS A 3 ? 4.1 2.0 3.0 1.00 14.0 ? 3 A 2
+HETATM 12 ZN ZN . ZN B . ? 5.1 2.0 3.0 1.00 15.0 2 4 A 2
+#
+`
+
+func TestPDBxInfo(Te *testing.T) {
+	mol, info, err := PDBxReadInfo(strings.NewReader(testCIF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	//The SG B alternate location has the highest occupancy in the first model, which decides.
+	if mol.Len() != 5 || mol.NFrames() != 2 || mol.Atom(1).Char16 != 'B' || mol.Coords[1].At(1, 0) != 2.6 {
+		Te.Fatalf("Wrong atoms or models read: %d atoms, %d frames", mol.Len(), mol.NFrames())
+	}
+	if info.Occupancies[1][1] != 0.3 || mol.Bfactors[0][4] != 15 || mol.Atom(4).Symbol != "Zn" || mol.Atom(4).Charge != 2 || !mol.Atom(4).Het {
+		Te.Errorf("Wrong atom data: %v %v %+v", info.Occupancies, mol.Bfactors, mol.Atom(4))
+	}
+	if mol.Atom(2).Name != "C1'" {
+		Te.Errorf("Wrong quoted name: %s", mol.Atom(2).Name)
+	}
+	//The hydrogen bond and the link to another asymmetric unit are not bonds.
+	if len(mol.Bonds) != 2 || len(mol.Atom(3).Bonds) != 2 || mol.Bonds[0].Dist != 2.04 || mol.Bonds[0].Cross(mol.Atom(1)) != mol.Atom(3) {
+		Te.Errorf("Wrong bonds read: %d", len(mol.Bonds))
+	}
+	if string(info.SS) != "HHHE " || len(info.SSElements) != 2 || info.SSElements[1].Sheet != "AA" {
+		Te.Errorf("Wrong secondary structure: %q", info.SS)
+	}
+	if len(info.Entities) != 2 || info.Entities[0].Sequence != "CACGA" || len(info.Entities[0].Chains) != 2 || info.Entities[0].Description != "Test protein, mutant" {
+		Te.Errorf("Wrong entities: %+v", info.Entities[0])
+	}
+	if info.ChemComps["ZN"] == nil || info.ChemComps["CYS"].Formula != "C3 H7 N O2 S" {
+		Te.Errorf("Wrong chemical components: %v", info.ChemComps)
+	}
+	if mol.Cell == nil || mol.Cell.Lengths[1] != 50 || mol.Cell.SpaceGroup != "P 1 21 1" || mol.Cell.Z != 4 {
+		Te.Fatalf("Wrong cell: %+v", mol.Cell)
+	}
+	if v := mol.Cell.Vectors(); math.Abs(v[6]+60*math.Sin(10*math.Pi/180)) > 1e-9 || math.Abs(v[4]-50) > 1e-9 {
+		Te.Errorf("Wrong cell vectors: %v", v)
+	}
+	//Other alternate locations and models.
+	mol, err = PDBxRead(strings.NewReader(testCIF), &PDBOptions{AltLoc: "A", Model: 2})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol.Len() != 5 || mol.NFrames() != 1 || mol.Atom(1).Char16 != 'A' || mol.Coords[0].At(1, 0) != 2.1 {
+		Te.Errorf("Wrong alternate location or model read: %d atoms, %d frames", mol.Len(), mol.NFrames())
+	}
+	mol, err = PDBxRead(strings.NewReader(testCIF), &PDBOptions{AltLoc: "*"})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	//The links refer to one of the alternate locations.
+	if mol.Len() != 6 || len(mol.Bonds) != 2 || len(mol.Atom(2).Bonds) != 0 {
+		Te.Errorf("Wrong molecule with all alternate locations: %d atoms, %d bonds", mol.Len(), len(mol.Bonds))
+	}
+	//Now we write and read back the molecule.
+	mol, err = PDBxRead(strings.NewReader(testCIF))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, compact := range []bool{false, true} {
+		var buf strings.Builder
+		if err := PDBxWrite(&buf, mol.Coords, mol, mol.Bfactors, compact, "test"); err != nil {
+			Te.Fatal(err)
+		}
+		mol2, err := PDBxRead(strings.NewReader(buf.String()))
+		if err != nil {
+			Te.Fatalf("%v\n%s", err, buf.String())
+		}
+		if mol2.Len() != 5 || mol2.NFrames() != 2 || mol2.Atom(2).Name != "C1'" || mol2.Coords[1].At(4, 0) != 5.1 || mol2.Bfactors[1][3] != 14 || mol2.Cell == nil {
+			Te.Errorf("Molecule incorrectly written or read (compact: %t):\n%s", compact, buf.String())
+		}
+	}
+}
+
+const testPDBLinks = `CRYST1   40.000   50.000   60.000  90.00 100.00  90.00 P 1 21 1      4
+SSBOND   1 CYS A    1    CYS A   52A                         1555   1555  2.04
+LINK         SG BCYS A   1                ZN   ZN  A 100     1555   1555  2.30
+MODEL        1
+ATOM      1  N   CYS A   1       1.000   2.000   3.000  1.00 10.00           N
+ATOM      2  SG ACYS A   1       2.000   2.000   3.000  0.40 10.00           S
+ATOM      3  SG BCYS A   1       2.100   2.000   3.000  0.60 10.00           S
+ATOM      4  SG  CYS A  52       4.000   2.000   3.000  1.00 10.00           S
+ATOM      5  SG  CYS A  52A      4.000   4.000   3.000  1.00 10.00           S
+HETATM    6  ZN  ZN  A 100       4.000   4.000   5.000  1.00 10.00          ZN
+HETATM    7  C1  LIG A 101       6.000   4.000   5.000  1.00 10.00           C
+HETATM    8  O1  LIG A 101       7.000   4.000   5.000  1.00 10.00           O
+ENDMDL
+MODEL        2
+ATOM      1  N   CYS A   1       1.100   2.000   3.000  1.00 10.00           N
+ATOM      2  SG ACYS A   1       2.100   2.000   3.000  0.40 10.00           S
+ATOM      3  SG BCYS A   1       2.200   2.000   3.000  0.60 10.00           S
+ATOM      4  SG  CYS A  52       4.100   2.000   3.000  1.00 10.00           S
+ATOM      5  SG  CYS A  52A      4.100   4.000   3.000  1.00 10.00           S
+HETATM    6  ZN  ZN  A 100       4.100   4.000   5.000  1.00 10.00          ZN
+HETATM    7  C1  LIG A 101       6.100   4.000   5.000  1.00 10.00           C
+HETATM    8  O1  LIG A 101       7.100   4.000   5.000  1.00 10.00           O
+ENDMDL
+CONECT    7    8
+CONECT    8    7
+END
+`
+
+func TestPDBAltLocLinks(Te *testing.T) {
+	mol, err := PDBRead(strings.NewReader(testPDBLinks))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	//SG B has the highest occupancy.
+	if mol.Len() != 7 || mol.NFrames() != 2 || mol.Atom(1).AltLoc != "B" || mol.Atom(1).Char16 != 'B' || mol.Coords[1].At(1, 0) != 2.2 {
+		Te.Fatalf("Wrong atoms or models read: %d atoms, %d frames", mol.Len(), mol.NFrames())
+	}
+	if mol.Atom(3).InsCode != "A" || mol.Atom(3).MolID != 52 || mol.Atom(2).InsCode != "" {
+		Te.Errorf("Wrong insertion codes: %+v %+v", mol.Atom(2), mol.Atom(3))
+	}
+	//SSBOND, LINK and one bond from the two CONECT records.
+	if len(mol.Bonds) != 3 || mol.Bonds[0].Dist != 2.04 || mol.Bonds[0].Order != 1 || mol.Bonds[0].Cross(mol.Atom(1)) != mol.Atom(3) {
+		Te.Errorf("Wrong bonds read: %d", len(mol.Bonds))
+	}
+	if len(mol.Atom(4).Bonds) != 1 || mol.Atom(4).Bonds[0].Cross(mol.Atom(4)) != mol.Atom(1) || len(mol.Atom(6).Bonds) != 1 {
+		Te.Errorf("Wrong LINK or CONECT bonds")
+	}
+	if mol.Cell == nil || mol.Cell.Lengths[2] != 60 || mol.Cell.Angles[1] != 100 || mol.Cell.SpaceGroup != "P 1 21 1" || mol.Cell.Z != 4 {
+		Te.Errorf("Wrong cell: %+v", mol.Cell)
+	}
+	//The LINK goes to the SG alternate location present.
+	mol2, err := PDBReadOptions(strings.NewReader(testPDBLinks), &PDBOptions{AltLoc: "A", Model: 2})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol2.Len() != 7 || mol2.NFrames() != 1 || mol2.Atom(1).AltLoc != "A" || mol2.Coords[0].At(0, 0) != 1.1 || len(mol2.Bonds) != 3 {
+		Te.Errorf("Wrong alternate location or model read: %d atoms, %d frames, %d bonds", mol2.Len(), mol2.NFrames(), len(mol2.Bonds))
+	}
+	mol2, err = PDBReadOptions(strings.NewReader(testPDBLinks), &PDBOptions{AltLoc: "*"})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if mol2.Len() != 8 || len(mol2.Atom(1).Bonds) != 1 || len(mol2.Atom(2).Bonds) != 1 {
+		Te.Errorf("Wrong molecule with all alternate locations: %d atoms", mol2.Len())
+	}
+	//Now we write and read back the molecule.
+	for _, multi := range []bool{false, true} {
+		var buf strings.Builder
+		if multi {
+			err = MultiPDBWrite(&buf, mol.Coords, mol, mol.Bfactors)
+		} else {
+			err = PDBWrite(&buf, mol.Coords[0], mol, mol.Bfactors[0])
+		}
+		if err != nil {
+			Te.Fatal(err)
+		}
+		mol2, err = PDBRead(strings.NewReader(buf.String()))
+		if err != nil {
+			Te.Fatalf("%v\n%s", err, buf.String())
+		}
+		if mol2.Len() != 7 || mol2.Atom(1).AltLoc != "B" || mol2.Atom(3).InsCode != "A" || mol2.Cell == nil || mol2.Cell.SpaceGroup != "P 1 21 1" || len(mol2.Atom(6).Bonds) != 1 {
+			Te.Errorf("Molecule incorrectly written or read (multi: %t):\n%s", multi, buf.String())
+		}
+		if multi && mol2.NFrames() != 2 {
+			Te.Errorf("Wrong number of models written: %d", mol2.NFrames())
+		}
+	}
+	//Residue numbers with 5 or more digits take the column of the insertion code.
+	big := `ATOM      1  OH2 TIP W9999       1.000   0.000   0.000  1.00  0.00           O
+ATOM      2  OH2 TIP W10000      2.000   0.000   0.000  1.00  0.00           O
+ATOM      3  OH2 TIP W123456     3.000   0.000   0.000  1.00  0.00           O
+ATOM      4  OH2 TIP W  10A      4.000   0.000   0.000  1.00  0.00           O
+`
+	mol, err = PDBRead(strings.NewReader(big))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for i, id := range []int{9999, 10000, 123456, 10} {
+		if mol.Atom(i).MolID != id || (i < 3 && mol.Atom(i).InsCode != "") {
+			Te.Errorf("Wrong residue number or insertion code: %d%s, expected %d", mol.Atom(i).MolID, mol.Atom(i).InsCode, id)
+		}
+	}
+	if mol.Atom(3).InsCode != "A" {
+		Te.Errorf("Wrong insertion code: %s", mol.Atom(3).InsCode)
+	}
+}
+
+func TestBox(Te *testing.T) {
+	box, err := NewOrthoBox(10, 20, 30)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	a, _ := v3.NewMatrix([]float64{1, 1, 1})
+	b, _ := v3.NewMatrix([]float64{9, 19, 1})
+	if d := box.Distance(a, b); math.Abs(d-math.Sqrt(8)) > 1e-9 {
+		Te.Errorf("Wrong orthorhombic minimum-image distance: %f", d)
+	}
+	//A triclinic (monoclinic) cell, with a strongly skewed c vector.
+	cell := &UnitCell{Lengths: [3]float64{10, 10, 10}, Angles: [3]float64{90, 120, 90}}
+	tbox, err := cell.Box()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if tbox.Orthorhombic() || math.Abs(tbox.Volume()-1000*math.Sin(120*math.Pi/180)) > 1e-9 {
+		Te.Errorf("Wrong triclinic box: %v", tbox.Vectors())
+	}
+	v := tbox.Vectors()
+	//b displaced by 0.9 c is 1 Angstrom from a point displaced 1 Angstrom along -c.
+	c, _ := v3.NewMatrix([]float64{0.9 * v[6], 0.9 * v[7], 0.9 * v[8]})
+	zero := v3.Zeros(1)
+	if d := tbox.Distance(zero, c); math.Abs(d-1) > 1e-9 {
+		Te.Errorf("Wrong triclinic minimum-image distance: %f", d)
+	}
+	//A molecule split by the box boundary.
+	coords, _ := v3.NewMatrix([]float64{
+		0.5, 5, 5,
+		9.5, 5, 5,
+		8.5, 5, 5,
+	})
+	top := NewTopology(0, 1)
+	for _, n := range []string{"C1", "C2", "C3"} {
+		top.AppendAtom(&Atom{Name: n, Symbol: "C"})
+	}
+	top.FillIndexes()
+	for _, p := range [][2]int{{0, 1}, {1, 2}} {
+		if err := topologyBond(top, p[0], p[1], 1); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	if err := box.MakeWhole(coords, top); err != nil {
+		Te.Fatal(err)
+	}
+	if coords.At(1, 0) != -0.5 || coords.At(2, 0) != -1.5 {
+		Te.Errorf("Molecule not made whole: %v", coords)
+	}
+	box.Wrap(coords)
+	if coords.At(1, 0) != 9.5 || coords.At(0, 0) != 0.5 {
+		Te.Errorf("Molecule not wrapped: %v", coords)
+	}
+	//The atom 0 crosses the boundary between two frames.
+	prev, _ := v3.NewMatrix([]float64{9.8, 5, 5, 9.5, 5, 5, 8.5, 5, 5})
+	cur, _ := v3.NewMatrix([]float64{0.1, 5, 5, 9.6, 5, 5, 8.5, 5, 5})
+	if err := box.Unwrap(cur, prev); err != nil {
+		Te.Fatal(err)
+	}
+	if math.Abs(cur.At(0, 0)-10.1) > 1e-9 || cur.At(1, 0) != 9.6 {
+		Te.Errorf("Frame not unwrapped: %v", cur)
+	}
+}
+
+func TestAssignBondsLarge(Te *testing.T) {
+	//A grid of 4000 water molecules.
+	const side = 10
+	top := NewTopology(0, 1)
+	var c []float64
+	for i := 0; i < side; i++ {
+		for j := 0; j < side; j++ {
+			for k := 0; k < 4*side; k++ {
+				x, y, z := 3.1*float64(i), 3.1*float64(j), 3.1*float64(k)
+				c = append(c, x, y, z, x+0.96, y, z, x-0.24, y+0.93, z)
+				for _, s := range []string{"O", "H", "H"} {
+					top.AppendAtom(&Atom{Name: s, Symbol: s, MolName: "HOH"})
+				}
+			}
+		}
+	}
+	coords, err := v3.NewMatrix(c)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if err := top.AssignBonds(coords); err != nil {
+		Te.Fatal(err)
+	}
+	if len(top.Bonds) != 2*side*side*4*side {
+		Te.Errorf("Wrong number of bonds: %d", len(top.Bonds))
+	}
+	for i := 0; i < top.Len(); i += 3 {
+		if len(top.Atoms[i].Bonds) != 2 || len(top.Atoms[i+1].Bonds) != 1 || top.Atoms[i+1].Bonds[0].Cross(top.Atoms[i+1]) != top.Atoms[i] {
+			Te.Fatalf("Wrong bonds for water %d", i/3)
+		}
+	}
+	//The bonds are in the same order as if they were found going over all pairs of atoms.
+	for i, b := range top.Bonds {
+		if b.Index != i || (i > 0 && b.At1.Index() < top.Bonds[i-1].At1.Index()) {
+			Te.Fatalf("Bonds out of order at %d", i)
+		}
+	}
+}
+
+func TestTrajWriters(Te *testing.T) {
+	mol, err := PDBRead(strings.NewReader(testPDBLinks))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	second := v3.Zeros(mol.Len())
+	second.Copy(mol.Coords[0])
+	second.AddFloat(second, 1.5)
+	frames := []*v3.Matrix{mol.Coords[0], second}
+	box, err := NewBox([]float64{30, 0, 0, 5, 40, 0, 0, 0, 50})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var pdb, xyz, gro strings.Builder
+	writers := []TrajWriter{NewPDBWriter(&pdb, mol), NewXYZWriter(&xyz, mol), NewGroWriter(&gro, mol)}
+	for _, w := range writers {
+		for _, f := range frames {
+			if err := w.WNext(f, box.Vectors()); err != nil {
+				Te.Fatal(err)
+			}
+		}
+		w.Close()
+		if err := w.WNext(second); err == nil {
+			Te.Error("Wrote to a closed writer")
+		}
+	}
+	//Errors closing the file are returned by CloseErr.
+	failing := &GroWriter{streamWriter{out: io.Discard, file: failingCloser{}, mol: mol}}
+	if err := failing.WNext(second); err != nil {
+		Te.Fatal(err)
+	}
+	if err := failing.CloseErr(); err == nil {
+		Te.Error("The error closing the file was not returned")
+	}
+	fromPDB, err := PDBRead(strings.NewReader(pdb.String()))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	fromXYZ, err := XYZRead(strings.NewReader(xyz.String()))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for name, m := range map[string]*Molecule{"PDB": fromPDB, "XYZ": fromXYZ} {
+		if len(m.Coords) != len(frames) {
+			Te.Fatalf("%s: read %d frames, wrote %d", name, len(m.Coords), len(frames))
+		}
+		for i, f := range frames {
+			d := v3.Zeros(f.NVecs())
+			d.Sub(f, m.Coords[i])
+			if d.Norm(2) > 1e-2 {
+				Te.Errorf("%s: frame %d differs from the written one", name, i)
+			}
+		}
+	}
+	if fromPDB.Cell == nil || math.Abs(fromPDB.Cell.Lengths[1]-box.UnitCell().Lengths[1]) > 1e-2 || math.Abs(fromPDB.Cell.Angles[2]-box.UnitCell().Angles[2]) > 1e-2 {
+		Te.Errorf("Wrong unit cell from the written PDB: %v", fromPDB.Cell)
+	}
+	if len(fromPDB.Bonds) != len(mol.Bonds) {
+		Te.Errorf("Wrong number of bonds from the written PDB: %d, expected %d", len(fromPDB.Bonds), len(mol.Bonds))
+	}
+	if !strings.Contains(xyz.String(), `Lattice="30.000000 0.000000 0.000000 5.000000 40.000000`) {
+		Te.Error("No lattice in the XYZ comment line")
+	}
+	//A triclinic box gets the 9 numbers in nm, in the Gromacs order.
+	if !strings.Contains(gro.String(), "   3.00000   4.00000   5.00000   0.00000   0.00000   0.50000   0.00000   0.00000   0.00000\n") {
+		Te.Error("Wrong box in the Gro file")
+	}
+}
+
+// failingCloser is an io.Closer that always fails.
+type failingCloser struct{}
+
+func (failingCloser) Close() error { return fmt.Errorf("close failed") }
@@ -0,0 +1,391 @@
+/*
+ * hbond.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package hbond finds hydrogen bonds in structures and trajectories, and analyzes them.
+//
+// Donors and acceptors are obtained from the elements and bonds of the atoms (see Donors and
+// Acceptors), and can be filtered before the search, for instance, to consider only the
+// hydrogen bonds between a protein and a ligand. A hydrogen bond D-H...A is found when the
+// donor-acceptor distance and the D-H...A angle fulfill the criteria in Options.
+// For trajectories, the occupancy of each donor-acceptor pair, and the continuous and
+// intermittent autocorrelation functions, from which lifetimes are obtained, are available.
+package hbond
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/spatial"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// maxDHDistance is the largest distance between a hydrogen and a donor, when
+// there are no bonds to identify the donor (A).
+const maxDHDistance = 1.3
+
+// Donor is a hydrogen bond donor: A heavy atom and a hydrogen bonded to it, by index.
+// Heavy atoms bonded to several hydrogens are several donors.
+type Donor struct {
+	D, H int
+}
+
+// HBond is a hydrogen bond between the donor D, with the hydrogen H, and the acceptor A.
+type HBond struct {
+	D, H, A int
+	Dist    float64 //The donor-acceptor distance (A).
+	Angle   float64 //The D-H...A angle (degrees).
+}
+
+// Options contains the criteria to identify hydrogen bonds.
+type Options struct {
+	distance float64
+	angle    float64
+	pbc      bool
+}
+
+// DefaultOptions returns the usual criteria: A donor-acceptor distance of
+// at most 3.5 A and a D-H...A angle of at least 150 degrees, without periodic boundary conditions.
+func DefaultOptions() *Options {
+	return &Options{distance: 3.5, angle: 150}
+}
+
+// Distance returns the largest donor-acceptor distance for a hydrogen bond, in A,
+// and sets it to a new value, if a valid one is given.
+func (O *Options) Distance(d ...float64) float64 {
+	if len(d) > 0 && d[0] > 0 {
+		O.distance = d[0]
+	}
+	return O.distance
+}
+
+// Angle returns the smallest D-H...A angle for a hydrogen bond, in degrees,
+// and sets it to a new value, if a valid one is given.
+func (O *Options) Angle(a ...float64) float64 {
+	if len(a) > 0 && a[0] >= 0 && a[0] <= 180 {
+		O.angle = a[0]
+	}
+	return O.angle
+}
+
+// PBC returns true if periodic boundary conditions are used, for the frames with box
+// information, and sets it to a new value, if given.
+func (O *Options) PBC(p ...bool) bool {
+	if len(p) > 0 {
+		O.pbc = p[0]
+	}
+	return O.pbc
+}
+
+// isDonorAcceptor returns true for the elements that can be donors or acceptors.
+func isDonorAcceptor(symbol string) bool {
+	return symbol == "N" || symbol == "O"
+}
+
+// Donors returns the donors in mol: Each hydrogen bonded to a nitrogen or oxygen. The bonds of the
+// atoms are used (see chem.Topology.AssignBonds). For hydrogens without bonds, if coords is given,
+// the closest nitrogen or oxygen, if it is closer than 1.3 A, is taken as the donor.
+func Donors(mol chem.Atomer, coords ...*v3.Matrix) []Donor {
+	var ret []Donor
+	var tree *spatial.KDTree
+	var heavy []int
+	for i := 0; i < mol.Len(); i++ {
+		at := mol.Atom(i)
+		if at.Symbol != "H" {
+			continue
+		}
+		if len(at.Bonds) > 0 {
+			for _, b := range at.Bonds {
+				if d := b.Cross(at); isDonorAcceptor(d.Symbol) {
+					ret = append(ret, Donor{D: d.Index(), H: i})
+				}
+			}
+			continue
+		}
+		if len(coords) == 0 || coords[0] == nil {
+			continue
+		}
+		if tree == nil {
+			for j := 0; j < mol.Len(); j++ {
+				if isDonorAcceptor(mol.Atom(j).Symbol) {
+					heavy = append(heavy, j)
+				}
+			}
+			if len(heavy) == 0 {
+				return ret
+			}
+			h := v3.Zeros(len(heavy))
+			h.SomeVecs(coords[0], heavy)
+			tree = spatial.NewKDTree(h)
+		}
+		idx, d := tree.Nearest(coords[0].VecView(i), 1)
+		if len(idx) > 0 && d[0] <= maxDHDistance {
+			ret = append(ret, Donor{D: heavy[idx[0]], H: i})
+		}
+	}
+	return ret
+}
+
+// Acceptors returns the acceptors in mol: All the oxygens, and the nitrogens with less than 3
+// bonds, as those with 3 or more bonds (amides, amines, etc.) have no free electron pair, or have it
+// delocalized. Nitrogens without bonds are not considered acceptors, so the bonds of the atoms must
+// be assigned (see chem.Topology.AssignBonds) to obtain the nitrogen acceptors.
+func Acceptors(mol chem.Atomer) []int {
+	var ret []int
+	for i := 0; i < mol.Len(); i++ {
+		at := mol.Atom(i)
+		if at.Symbol == "O" || (at.Symbol == "N" && len(at.Bonds) > 0 && len(at.Bonds) < 3) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// Frame returns the hydrogen bonds between donors and acceptors in the structure with the coordinates
+// coords, sorted by donor, in the order given, and then by distance. If options.PBC() is true, box must
+// contain the box vectors (9 numbers, the vectors a, b and c, one after the other) and the minimum-image
+// distances are used. box can be nil otherwise.
+func Frame(coords *v3.Matrix, donors []Donor, acceptors []int, box []float64, options ...*Options) ([]HBond, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	if len(donors) == 0 || len(acceptors) == 0 {
+		return nil, nil
+	}
+	for _, v := range acceptors {
+		if v < 0 || v >= coords.NVecs() {
+			return nil, fmt.Errorf("Frame: acceptor %d out of range for a structure with %d atoms", v, coords.NVecs())
+		}
+	}
+	var pbc *spatial.Periodic
+	var err error
+	var boxes [][]float64
+	if o.pbc {
+		if pbc, err = spatial.NewPeriodic(box); err != nil {
+			return nil, fmt.Errorf("Frame: %w", err)
+		}
+		boxes = append(boxes, box)
+	}
+	acc := v3.Zeros(len(acceptors))
+	acc.SomeVecs(coords, acceptors)
+	cells, err := spatial.NewCellList(acc, o.distance, boxes...)
+	if err != nil {
+		return nil, fmt.Errorf("Frame: %w", err)
+	}
+	var ret []HBond
+	for _, dn := range donors {
+		if dn.D < 0 || dn.H < 0 || dn.D >= coords.NVecs() || dn.H >= coords.NVecs() {
+			return nil, fmt.Errorf("Frame: donor %v out of range for a structure with %d atoms", dn, coords.NVecs())
+		}
+		idx, dists := cells.Within(coords.VecView(dn.D), o.distance)
+		for k, j := range idx {
+			a := acceptors[j]
+			if a == dn.D || a == dn.H {
+				continue
+			}
+			angle := dhaAngle(coords, dn.D, dn.H, a, pbc)
+			if angle < o.angle {
+				continue
+			}
+			ret = append(ret, HBond{D: dn.D, H: dn.H, A: a, Dist: dists[k], Angle: angle})
+		}
+	}
+	return ret, nil
+}
+
+// dhaAngle returns the angle D-H...A, in degrees. If pbc is not nil, the minimum-image
+// vectors are used.
+func dhaAngle(coords *v3.Matrix, d, h, a int, pbc *spatial.Periodic) float64 {
+	hd := make([]float64, 3)
+	ha := make([]float64, 3)
+	for k := 0; k < 3; k++ {
+		hd[k] = coords.At(d, k) - coords.At(h, k)
+		ha[k] = coords.At(a, k) - coords.At(h, k)
+	}
+	if pbc != nil {
+		pbc.MinImage(hd)
+		pbc.MinImage(ha)
+	}
+	var dot, nd, na float64
+	for k := 0; k < 3; k++ {
+		dot += hd[k] * ha[k]
+		nd += hd[k] * hd[k]
+		na += ha[k] * ha[k]
+	}
+	cos := dot / math.Sqrt(nd*na)
+	return math.Acos(math.Max(-1, math.Min(1, cos))) * chem.Rad2Deg
+}
+
+// Traj returns the hydrogen bonds between donors and acceptors in each of the remaining frames
+// of the trajectory t (see Frame). If options.PBC() is true, the box of each frame is used, and
+// frames without box information are an error. If a frame can't be read, the hydrogen bonds
+// for the previous frames are returned, together with the error.
+func Traj(t chem.Traj, donors []Donor, acceptors []int, options ...*Options) ([][]HBond, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	coords := v3.Zeros(t.Len())
+	box := make([]float64, 9)
+	var ret [][]HBond
+	for frame := 0; ; frame++ {
+		for i := range box {
+			box[i] = 0
+		}
+		if err := t.Next(coords, box); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				return ret, nil
+			}
+			return ret, fmt.Errorf("Traj: reading frame %d: %w", frame, err)
+		}
+		hb, err := Frame(coords, donors, acceptors, box, o)
+		if err != nil {
+			return ret, fmt.Errorf("Traj: frame %d: %w", frame, err)
+		}
+		ret = append(ret, hb)
+	}
+}
+
+// Pair is a donor heavy atom and an acceptor, by index. The hydrogen is not
+// considered, so a pair is hydrogen bonded if any of the hydrogens of the donor is.
+type Pair struct {
+	D, A int
+}
+
+// Occupancy is the fraction of the frames in which a donor-acceptor pair is hydrogen bonded.
+type Occupancy struct {
+	Pair
+	Frames   int //The number of frames with the hydrogen bond.
+	Fraction float64
+}
+
+// series returns the pairs hydrogen bonded in any frame, in the order of their first
+// hydrogen bond, and whether each one is hydrogen bonded in each frame.
+func series(frames [][]HBond) ([]Pair, map[Pair][]bool) {
+	var pairs []Pair
+	ret := make(map[Pair][]bool)
+	for f, hbs := range frames {
+		for _, v := range hbs {
+			p := Pair{D: v.D, A: v.A}
+			s, ok := ret[p]
+			if !ok {
+				s = make([]bool, len(frames))
+				ret[p] = s
+				pairs = append(pairs, p)
+			}
+			s[f] = true
+		}
+	}
+	return pairs, ret
+}
+
+// Occupancies returns the occupancy of each donor-acceptor pair that is hydrogen bonded in at least
+// one of the frames, as returned by Traj, sorted from the most to the least occupied.
+func Occupancies(frames [][]HBond) []*Occupancy {
+	pairs, s := series(frames)
+	ret := make([]*Occupancy, 0, len(pairs))
+	for _, p := range pairs {
+		o := &Occupancy{Pair: p}
+		for _, v := range s[p] {
+			if v {
+				o.Frames++
+			}
+		}
+		o.Fraction = float64(o.Frames) / float64(len(frames))
+		ret = append(ret, o)
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Frames > ret[j].Frames })
+	return ret
+}
+
+// Autocorrelation returns the autocorrelation function of the hydrogen bonds of all the
+// donor-acceptor pairs in frames, as returned by Traj, for lags from 0 to maxlag frames:
+// C(t) = <h(0)h(t)>/<h(0)>, averaged over all pairs and time origins, where h(t) is 1 if
+// the pair is hydrogen bonded at the time t, and 0 otherwise.
+// If continuous is true, h(t) is 1 only if the pair has been hydrogen bonded all
+// the time from 0 to t (the continuous autocorrelation), otherwise, breaking and reforming the
+// hydrogen bond doesn't matter (the intermittent one). The integral of the function (see
+// Lifetime) is the lifetime of the hydrogen bonds.
+func Autocorrelation(frames [][]HBond, maxlag int, continuous bool) []float64 {
+	n := len(frames)
+	maxlag = min(maxlag, n-1)
+	if maxlag < 0 {
+		return nil
+	}
+	pairs, s := series(frames)
+	sums := make([]float64, maxlag+1)
+	run := make([]int, n+1)
+	for _, p := range pairs {
+		h := s[p]
+		if continuous {
+			//run[t] is the number of consecutive frames, from t, with the hydrogen bond.
+			for t := n - 1; t >= 0; t-- {
+				run[t] = 0
+				if h[t] {
+					run[t] = run[t+1] + 1
+				}
+			}
+			for t := 0; t < n; t++ {
+				for lag := 0; lag < min(run[t], maxlag+1); lag++ {
+					sums[lag]++
+				}
+			}
+			continue
+		}
+		for t := 0; t < n; t++ {
+			if !h[t] {
+				continue
+			}
+			for lag := 0; lag <= maxlag && t+lag < n; lag++ {
+				if h[t+lag] {
+					sums[lag]++
+				}
+			}
+		}
+	}
+	ret := make([]float64, maxlag+1)
+	if sums[0] == 0 {
+		return ret
+	}
+	//Each lag has n-lag time origins.
+	norm := sums[0] / float64(n)
+	for lag, v := range sums {
+		ret[lag] = v / float64(n-lag) / norm
+	}
+	return ret
+}
+
+// Lifetime returns the integral of the autocorrelation function c, with the time dt between frames,
+// obtained with the trapezoidal rule. For a function that doesn't decay to 0 in the lags
+// given, the lifetime is underestimated.
+func Lifetime(c []float64, dt float64) float64 {
+	var ret float64
+	for i := 1; i < len(c); i++ {
+		ret += (c[i-1] + c[i]) * dt / 2
+	}
+	return ret
+}
@@ -0,0 +1,110 @@
+/*
+ * hbond_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package hbond
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// waters returns the coordinates of two water molecules, where the first donates a hydrogen bond
+// to the second if shift is 0. The second water is moved shift A along the x axis.
+func waters(shift float64) *v3.Matrix {
+	c, _ := v3.NewMatrix([]float64{
+		0, 0, 0, 0.96, 0, 0, -0.24, 0.93, 0,
+		2.9 + shift, 0, 0, 3.2 + shift, 0.9, 0, 3.2 + shift, -0.45, 0.8,
+	})
+	return c
+}
+
+func TestHBond(Te *testing.T) {
+	top := chem.NewTopology(0, 1)
+	for i, name := range []string{"O", "H1", "H2", "O", "H1", "H2"} {
+		top.AppendAtom(&chem.Atom{Name: name, Symbol: name[:1], MolName: "HOH", MolID: i/3 + 1, ID: i + 1})
+	}
+	//Frames with and without the hydrogen bond. In the last one, the second water is across
+	//the boundary of a 10 A box, so the hydrogen bond is found only with PBC.
+	frames := []*v3.Matrix{waters(0), waters(0), waters(5), waters(0), waters(-10)}
+	if err := top.AssignBonds(frames[0]); err != nil {
+		Te.Fatal(err)
+	}
+	donors, acceptors := Donors(top), Acceptors(top)
+	if len(donors) != 4 || donors[0] != (Donor{D: 0, H: 1}) || fmt.Sprint(acceptors) != "[0 3]" {
+		Te.Fatalf("Wrong donors or acceptors: %v %v", donors, acceptors)
+	}
+	//Without bonds, the donors are found from the coordinates.
+	top.RemoveBonds()
+	if d := Donors(top, frames[0]); fmt.Sprint(d) != fmt.Sprint(donors) {
+		Te.Errorf("Wrong donors obtained from the coordinates: %v", d)
+	}
+	mol, err := chem.NewMolecule(frames, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	hb, err := Traj(mol, donors, acceptors)
+	if err != nil || len(hb) != 5 {
+		Te.Fatalf("Expected 5 frames, got %d, error: %v", len(hb), err)
+	}
+	if len(hb[0]) != 1 || hb[0][0].D != 0 || hb[0][0].H != 1 || hb[0][0].A != 3 || math.Abs(hb[0][0].Dist-2.9) > 1e-9 || math.Abs(hb[0][0].Angle-180) > 1e-3 {
+		Te.Errorf("Wrong hydrogen bonds in the first frame: %v", hb[0])
+	}
+	if len(hb[2]) != 0 || len(hb[4]) != 0 {
+		Te.Errorf("Unexpected hydrogen bonds: %v %v", hb[2], hb[4])
+	}
+	o := DefaultOptions()
+	o.PBC(true)
+	mol.Cell = &chem.UnitCell{Lengths: [3]float64{10, 10, 10}, Angles: [3]float64{90, 90, 90}}
+	mol.InitRead()
+	hb, err = Traj(mol, donors, acceptors, o)
+	if err != nil || len(hb[4]) != 1 || math.Abs(hb[4][0].Dist-2.9) > 1e-9 {
+		Te.Errorf("Hydrogen bond across the box boundary not found: %v %v", hb, err)
+	}
+	hb = hb[:4]
+	occ := Occupancies(hb)
+	if len(occ) != 1 || occ[0].Pair != (Pair{D: 0, A: 3}) || occ[0].Frames != 3 || occ[0].Fraction != 0.75 {
+		Te.Errorf("Wrong occupancies: %v", occ)
+	}
+	//The series is 1 1 0 1.
+	for _, c := range []struct {
+		continuous bool
+		expected   []float64
+	}{{false, []float64{1, 4.0 / 9, 2.0 / 3}}, {true, []float64{1, 4.0 / 9, 0}}} {
+		ac := Autocorrelation(hb, 2, c.continuous)
+		for i, v := range c.expected {
+			if len(ac) != 3 || math.Abs(ac[i]-v) > 1e-9 {
+				Te.Errorf("Wrong autocorrelation (continuous: %v): %v, expected %v", c.continuous, ac, c.expected)
+				break
+			}
+		}
+	}
+	if l := Lifetime([]float64{1, 0.5, 0}, 2); l != 2 {
+		Te.Errorf("Wrong lifetime: %f", l)
+	}
+}
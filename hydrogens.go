@@ -122,7 +122,7 @@ func Reduce(mol Atomer, coords *v3.Matrix, build int, report *os.File, executabl
 		}
 	}
 
-	mol2, err := pdbBufIORead(bufiopdb, false)
+	mol2, err := pdbBufIORead(bufiopdb, false, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Reduce: %w", err)
 	}
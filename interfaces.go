@@ -63,6 +63,30 @@ type ConcTraj interface {
 	Len() int
 }
 
+// Seeker is an interface for trajectories that allow random access to their frames.
+type Seeker interface {
+
+	//Sets the trajectory so the next frame read is the given one, counting from 0.
+	Seek(frame int) error
+
+	//Returns the number of frames in the trajectory, or -1 if it can't be determined.
+	NFrames() int
+
+	//Returns the index of the next frame to be read, counting from 0.
+	Tell() int
+}
+
+// TrajWriter is an interface for any object that writes trajectories, frame by frame.
+type TrajWriter interface {
+
+	//Writes the coordinates as the next frame of the trajectory. It can also
+	//write the (optional) box vectors, if the format supports it.
+	WNext(coords *v3.Matrix, box ...[]float64) error
+
+	//Finishes the trajectory and closes the underlying file, if any.
+	Close()
+}
+
 // Atomer is the basic interface for a topology.
 type Atomer interface {
 
@@ -0,0 +1,493 @@
+/*
+ * mol2.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// The Tripos MOL2 format. Only the MOLECULE, ATOM, BOND and SUBSTRUCTURE
+// records are read, everything else is ignored.
+
+// mol2Record contains the data for one @<TRIPOS>MOLECULE entry of a MOL2 file.
+type mol2Record struct {
+	name    string
+	natoms  int
+	atoms   []*Atom
+	coords  []float64
+	bonds   [][2]int //0-based indexes of the bonded atoms
+	orders  []float64
+	substs  map[int]*mol2Subst
+	charged bool
+}
+
+type mol2Subst struct {
+	name  string
+	chain string
+}
+
+// mol2BondOrders maps the SYBYL bond types to bond orders.
+// Aromatic bonds are given order 1.5 and amide bonds order 1.
+var mol2BondOrders = map[string]float64{
+	"1":  1,
+	"2":  2,
+	"3":  3,
+	"ar": 1.5,
+	"am": 1,
+	"du": 0,
+	"un": 0,
+	"nc": 0,
+}
+
+// MOL2FileRead reads a Tripos MOL2 file with name mol2name. See MOL2Read.
+func MOL2FileRead(mol2name string) (*Molecule, error) {
+	mol2file, err := openRead(mol2name)
+	if err != nil {
+		return nil, fmt.Errorf("MOL2FileRead: %w", err)
+	}
+	defer mol2file.Close()
+	mol, err := MOL2Read(mol2file)
+	if err != nil {
+		return nil, fmt.Errorf("MOL2FileRead: file %s: %w", mol2name, err)
+	}
+	return mol, nil
+}
+
+// MOL2Read reads a Tripos MOL2 stream and returns a Molecule. Atom charges are read into the
+// Charge field, the SYBYL atom types into the Type field and the element (obtained from the SYBYL type)
+// into Symbol. Residue names and numbers are taken from the substructure information, and the bonds
+// in the file are added, with their orders, to both the atoms and the topology.
+// If the stream contains several molecules, they are read as frames of the returned Molecule, which
+// requires all the molecules to have the same topology. An error is returned otherwise.
+func MOL2Read(r io.Reader) (*Molecule, error) {
+	records, err := mol2ReadRecords(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("MOL2Read: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("MOL2Read: no @<TRIPOS>MOLECULE record found")
+	}
+	first := records[0]
+	coords := make([]*v3.Matrix, 0, len(records))
+	for i, rec := range records {
+		if !mol2SameTopology(first, rec) {
+			return nil, fmt.Errorf("MOL2Read: molecule %d (%s) has a different topology than the first one (%s)", i+1, rec.name, first.name)
+		}
+		c, err := v3.NewMatrix(rec.coords)
+		if err != nil {
+			return nil, fmt.Errorf("MOL2Read: %w", err)
+		}
+		coords = append(coords, c)
+	}
+	mol2AssignResidues(first)
+	top := NewTopology(0, 1, first.atoms)
+	top.FillIndexes()
+	charge := 0.0
+	for _, at := range first.atoms {
+		charge += at.Charge
+	}
+	if first.charged {
+		top.SetCharge(int(mol2round(charge)))
+	}
+	tmp := v3.Zeros(1)
+	for i, b := range first.bonds {
+		at1 := first.atoms[b[0]]
+		at2 := first.atoms[b[1]]
+		bond := &Bond{Index: i, At1: at1, At2: at2, Order: first.orders[i]}
+		tmp.Sub(coords[0].VecView(b[0]), coords[0].VecView(b[1]))
+		bond.Dist = tmp.Norm(2)
+		at1.Bonds = append(at1.Bonds, bond)
+		at2.Bonds = append(at2.Bonds, bond)
+		top.Bonds = append(top.Bonds, bond)
+	}
+	mol, err := NewMolecule(coords, top, nil)
+	if err != nil {
+		return nil, fmt.Errorf("MOL2Read: %w", err)
+	}
+	return mol, nil
+}
+
+// mol2round rounds to the closest integer.
+func mol2round(f float64) float64 {
+	if f < 0 {
+		return float64(int(f - 0.5))
+	}
+	return float64(int(f + 0.5))
+}
+
+// mol2SameTopology returns true if both records have the same atoms, in the same order,
+// and the same bonds.
+func mol2SameTopology(a, b *mol2Record) bool {
+	if len(a.atoms) != len(b.atoms) || len(a.bonds) != len(b.bonds) {
+		return false
+	}
+	for i, at := range a.atoms {
+		if at.Name != b.atoms[i].Name || at.Type != b.atoms[i].Type {
+			return false
+		}
+	}
+	for i, bo := range a.bonds {
+		if bo != b.bonds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mol2ReadRecords reads all the molecules in a MOL2 stream.
+func mol2ReadRecords(in *bufio.Reader) ([]*mol2Record, error) {
+	var records []*mol2Record
+	var rec *mol2Record
+	section := ""
+	molline := 0 //lines read so far in the MOLECULE section
+	lineno := 0
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		lineno++
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "@<TRIPOS>") {
+			section = strings.TrimPrefix(trimmed, "@<TRIPOS>")
+			if section == "MOLECULE" {
+				rec = &mol2Record{substs: make(map[int]*mol2Subst)}
+				records = append(records, rec)
+				molline = 0
+			} else if rec == nil {
+				return nil, fmt.Errorf("line %d: section %s found before any MOLECULE section", lineno, section)
+			}
+			continue
+		}
+		if rec == nil {
+			continue
+		}
+		switch section {
+		case "MOLECULE":
+			//the name can be an empty line, so we count lines, not fields.
+			molline++
+			if molline == 1 {
+				rec.name = trimmed
+			} else if molline == 2 {
+				fields := strings.Fields(trimmed)
+				if len(fields) == 0 {
+					return nil, fmt.Errorf("line %d: missing atom number in MOLECULE section", lineno)
+				}
+				rec.natoms, err = strconv.Atoi(fields[0])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineno, err)
+				}
+				rec.atoms = make([]*Atom, 0, rec.natoms)
+				rec.coords = make([]float64, 0, 3*rec.natoms)
+			} else if molline == 4 {
+				rec.charged = trimmed != "NO_CHARGES"
+			}
+		case "ATOM":
+			if trimmed == "" {
+				continue
+			}
+			at, c, err := mol2ReadAtom(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineno, err)
+			}
+			rec.atoms = append(rec.atoms, at)
+			rec.coords = append(rec.coords, c[:]...)
+		case "BOND":
+			if trimmed == "" {
+				continue
+			}
+			fields := strings.Fields(trimmed)
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: ill-formed BOND line: %s", lineno, line)
+			}
+			i1, err1 := strconv.Atoi(fields[1])
+			i2, err2 := strconv.Atoi(fields[2])
+			if err1 != nil || err2 != nil || i1 < 1 || i2 < 1 || i1 > len(rec.atoms) || i2 > len(rec.atoms) {
+				return nil, fmt.Errorf("line %d: wrong atom indexes in BOND line: %s", lineno, line)
+			}
+			order, ok := mol2BondOrders[strings.ToLower(fields[3])]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown bond type %s", lineno, fields[3])
+			}
+			rec.bonds = append(rec.bonds, [2]int{i1 - 1, i2 - 1})
+			rec.orders = append(rec.orders, order)
+		case "SUBSTRUCTURE":
+			fields := strings.Fields(trimmed)
+			if len(fields) < 3 {
+				continue
+			}
+			id, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineno, err)
+			}
+			sub := &mol2Subst{name: fields[1]}
+			if len(fields) > 5 && fields[5] != "****" {
+				sub.chain = fields[5]
+			}
+			rec.substs[id] = sub
+		}
+	}
+	for _, r := range records {
+		if len(r.atoms) != r.natoms {
+			return nil, fmt.Errorf("molecule %s: expected %d atoms, found %d", r.name, r.natoms, len(r.atoms))
+		}
+	}
+	return records, nil
+}
+
+// mol2ReadAtom parses a line of the ATOM section.
+// The Tag field is temporarily used to store the substructure ID.
+func mol2ReadAtom(line string) (*Atom, [3]float64, error) {
+	var c [3]float64
+	var err error
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return nil, c, fmt.Errorf("ill-formed ATOM line: %s", line)
+	}
+	at := new(Atom)
+	at.ID, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, c, err
+	}
+	at.Name = fields[1]
+	for i := range c {
+		c[i], err = strconv.ParseFloat(fields[2+i], 64)
+		if err != nil {
+			return nil, c, err
+		}
+	}
+	at.Type = fields[5]
+	at.Symbol = sybyl2Symbol(at.Type)
+	at.Mass = symbolMass[at.Symbol]
+	at.MolID = 1
+	at.MolName = "UNK"
+	if len(fields) > 6 {
+		at.Tag, err = strconv.Atoi(fields[6])
+		if err != nil {
+			return nil, c, err
+		}
+	}
+	if len(fields) > 7 {
+		at.MolName = fields[7]
+	}
+	if len(fields) > 8 {
+		at.Charge, err = strconv.ParseFloat(fields[8], 64)
+		if err != nil {
+			return nil, c, err
+		}
+	}
+	return at, c, nil
+}
+
+// sybyl2Symbol returns the element symbol for a SYBYL atom type.
+// For dummy atoms, lone pairs and such, it returns the
+// type itself.
+func sybyl2Symbol(t string) string {
+	sym := strings.SplitN(t, ".", 2)[0]
+	if len(sym) > 1 {
+		sym = sym[:1] + strings.ToLower(sym[1:])
+	}
+	return sym
+}
+
+// splitResName splits residue names of the form "ALA12" into the name and the number.
+// If the name doesn't end in a number, ok is false.
+func splitResName(s string) (string, int, bool) {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	if i == 0 || i == len(s) {
+		return s, 0, false
+	}
+	n, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return s, 0, false
+	}
+	return s[:i], n, true
+}
+
+// mol2AssignResidues sets the residue names, numbers and chains of the atoms from the
+// substructure information.
+func mol2AssignResidues(rec *mol2Record) {
+	for _, at := range rec.atoms {
+		substid := at.Tag
+		at.Tag = 0
+		name := at.MolName
+		if sub, ok := rec.substs[substid]; ok {
+			name = sub.name
+			at.Chain = sub.chain
+		}
+		if substid > 0 {
+			at.MolID = substid
+		}
+		if n, num, ok := splitResName(name); ok {
+			name = n
+			at.MolID = num
+		}
+		at.MolName = name
+		at.MolName1 = three2OneLetter[at.MolName]
+	}
+}
+
+// MOL2FileWrite writes the molecule described by mol and Coords into a file with name outname
+// in the Tripos MOL2 format. If Coords has more than one element, one MOLECULE entry is written for each.
+func MOL2FileWrite(outname string, Coords []*v3.Matrix, mol Atomer) error {
+	out, err := createWrite(outname)
+	if err != nil {
+		return fmt.Errorf("MOL2FileWrite: %w", err)
+	}
+	err = MOL2Write(out, Coords, mol)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("MOL2FileWrite: %w", err)
+	}
+	//For compressed files, the last data is written only when the file is closed.
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("MOL2FileWrite: %w", err)
+	}
+	return nil
+}
+
+// MOL2Write writes the molecule described by mol and Coords to out, in the Tripos MOL2 format,
+// one MOLECULE entry for each element of Coords. The bonds are taken from the Bonds fields of the atoms.
+// Atoms without a Type are given their symbol as SYBYL type. An optional name for the molecule
+// can be given.
+func MOL2Write(out io.Writer, Coords []*v3.Matrix, mol Atomer, name ...string) error {
+	molname := "Written with goChem :-)"
+	if len(name) > 0 && name[0] != "" {
+		molname = name[0]
+	}
+	natoms := mol.Len()
+	for _, c := range Coords {
+		if c.NVecs() != natoms {
+			return fmt.Errorf("MOL2Write: Ref and Coords don't have the same number of atoms")
+		}
+	}
+	indexes, bonds := atomerBonds(mol)
+	charged := false
+	for i := 0; i < natoms; i++ {
+		if mol.Atom(i).Charge != 0 {
+			charged = true
+		}
+	}
+	//the residues, in order of appearance
+	substs := make([]int, 0, 1)
+	substids := make([]int, natoms)
+	for i := 0; i < natoms; i++ {
+		at := mol.Atom(i)
+		if i == 0 || at.MolID != mol.Atom(i-1).MolID || at.MolName != mol.Atom(i-1).MolName || at.Chain != mol.Atom(i-1).Chain {
+			substs = append(substs, i)
+		}
+		substids[i] = len(substs)
+	}
+	moltype := "SMALL"
+	if len(substs) > 1 {
+		moltype = "BIOPOLYMER"
+	}
+	chargetype := "NO_CHARGES"
+	if charged {
+		chargetype = "USER_CHARGES"
+	}
+	bw := bufio.NewWriter(out)
+	for _, coords := range Coords {
+		fmt.Fprintf(bw, "@<TRIPOS>MOLECULE\n%s\n%5d %5d %5d 0 0\n%s\n%s\n\n", molname, natoms, len(bonds), len(substs), moltype, chargetype)
+		fmt.Fprintf(bw, "@<TRIPOS>ATOM\n")
+		for i := 0; i < natoms; i++ {
+			at := mol.Atom(i)
+			t := at.Type
+			if t == "" {
+				t = at.Symbol
+			}
+			if t == "" {
+				t = "Du"
+			}
+			fmt.Fprintf(bw, "%7d %-6s %10.4f %10.4f %10.4f %-6s %5d %-8s %9.4f\n", i+1, at.Name, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2), t, substids[i], mol2SubstName(at), at.Charge)
+		}
+		if len(bonds) > 0 {
+			fmt.Fprintf(bw, "@<TRIPOS>BOND\n")
+		}
+		for i, b := range bonds {
+			fmt.Fprintf(bw, "%6d %5d %5d %s\n", i+1, indexes[b.At1]+1, indexes[b.At2]+1, mol2BondType(b.Order))
+		}
+		fmt.Fprintf(bw, "@<TRIPOS>SUBSTRUCTURE\n")
+		for i, root := range substs {
+			at := mol.Atom(root)
+			chain := strings.TrimSpace(at.Chain)
+			stype := "GROUP"
+			if _, ok := three2OneLetter[at.MolName]; ok {
+				stype = "RESIDUE"
+			}
+			if chain == "" {
+				chain = "****"
+			}
+			fmt.Fprintf(bw, "%6d %-8s %6d %-8s 1 %-4s %s\n", i+1, mol2SubstName(at), root+1, stype, chain, at.MolName)
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("MOL2Write: %w", err)
+	}
+	return nil
+}
+
+func mol2SubstName(at *Atom) string {
+	name := at.MolName
+	if name == "" {
+		name = "UNK"
+	}
+	return fmt.Sprintf("%s%d", name, at.MolID)
+}
+
+// mol2BondType returns the SYBYL bond type for a bond order.
+func mol2BondType(order float64) string {
+	switch order {
+	case 1:
+		return "1"
+	case 1.5:
+		return "ar"
+	case 2:
+		return "2"
+	case 3:
+		return "3"
+	case 0:
+		return "un"
+	}
+	return "1"
+}
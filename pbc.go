@@ -0,0 +1,210 @@
+/*
+ * pbc.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"math"
+
+	"github.com/rmera/gochem/spatial"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Box is a periodic simulation box, orthorhombic or triclinic, given by
+// its vectors a, b and c.
+type Box struct {
+	p *spatial.Periodic
+}
+
+// NewBox returns a box with the vectors a, b and c, given one after the other
+// in vecs, as in the box slices filled by the Next method of trajectories.
+// It returns an error if vecs has less than 9 elements or if the vectors
+// don't span a volume (as the all-zeros box of frames with no box information).
+func NewBox(vecs []float64) (*Box, error) {
+	p, err := spatial.NewPeriodic(vecs)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"NewBox"}}
+	}
+	return &Box{p: p}, nil
+}
+
+// NewOrthoBox returns an orthorhombic box with the given lengths along x, y and z.
+func NewOrthoBox(x, y, z float64) (*Box, error) {
+	return NewBox([]float64{x, 0, 0, 0, y, 0, 0, 0, z})
+}
+
+// Box returns the periodic box corresponding to the unit cell.
+func (U *UnitCell) Box() (*Box, error) {
+	return NewBox(U.Vectors())
+}
+
+// UnitCell returns the lengths and angles of the box, as a unit cell
+// with the P 1 space group.
+func (B *Box) UnitCell() *UnitCell {
+	v := B.p.Vectors()
+	a, b, c := v[0:3], v[3:6], v[6:9]
+	norm := func(x []float64) float64 { return math.Sqrt(x[0]*x[0] + x[1]*x[1] + x[2]*x[2]) }
+	angle := func(x, y []float64) float64 {
+		cos := (x[0]*y[0] + x[1]*y[1] + x[2]*y[2]) / (norm(x) * norm(y))
+		return math.Acos(math.Max(-1, math.Min(1, cos))) * 180 / math.Pi
+	}
+	return &UnitCell{
+		Lengths:    [3]float64{norm(a), norm(b), norm(c)},
+		Angles:     [3]float64{angle(b, c), angle(a, c), angle(a, b)},
+		SpaceGroup: "P 1",
+		Z:          1,
+	}
+}
+
+// Vectors returns the box vectors a, b and c, one after the other.
+func (B *Box) Vectors() []float64 {
+	return B.p.Vectors()
+}
+
+// Orthorhombic returns true if the box vectors are along the x, y and z axes.
+func (B *Box) Orthorhombic() bool {
+	return B.p.Orthorhombic()
+}
+
+// Volume returns the volume of the box.
+func (B *Box) Volume() float64 {
+	return B.p.Volume()
+}
+
+// MinImage replaces each vector (row) in d, which should be a difference between
+// two positions, with its shortest periodic image.
+func (B *Box) MinImage(d *v3.Matrix) {
+	var r [3]float64
+	for i := 0; i < d.NVecs(); i++ {
+		d.Row(r[:], i)
+		B.p.MinImage(r[:])
+		for j, v := range r {
+			d.Set(i, j, v)
+		}
+	}
+}
+
+// Distance returns the minimum-image distance between the first vectors (rows)
+// of a and b.
+func (B *Box) Distance(a, b *v3.Matrix) float64 {
+	var d [3]float64
+	for j := range d {
+		d[j] = b.At(0, j) - a.At(0, j)
+	}
+	B.p.MinImage(d[:])
+	return math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+}
+
+// Wrap translates each atom in coords by box vectors, so it is inside the box
+// (with fractional coordinates between 0 and 1). Molecules crossing the
+// box boundaries end up split. They can be put together again with MakeWhole.
+func (B *Box) Wrap(coords *v3.Matrix) {
+	var r, f [3]float64
+	for i := 0; i < coords.NVecs(); i++ {
+		coords.Row(r[:], i)
+		B.p.Fractional(r[:], f[:])
+		for j := range f {
+			f[j] -= math.Floor(f[j])
+		}
+		B.p.Cartesian(f[:], r[:])
+		for j, v := range r {
+			coords.Set(i, j, v)
+		}
+	}
+}
+
+// MakeWhole puts together the molecules that are split by the box boundaries,
+// by placing each atom at the periodic image closest to the atom bonded to it.
+// The bonds are taken from the Bonds field of the atoms in mol, so atoms with
+// no bonds are not moved. The first atom of each molecule stays in place.
+// coords is modified in place.
+func (B *Box) MakeWhole(coords *v3.Matrix, mol Atomer) error {
+	if coords.NVecs() != mol.Len() {
+		return CError{"Coordinates and topology have different number of atoms", []string{"Box.MakeWhole"}}
+	}
+	pos := make(map[*Atom]int, mol.Len())
+	for i := 0; i < mol.Len(); i++ {
+		pos[mol.Atom(i)] = i
+	}
+	done := make([]bool, mol.Len())
+	var d [3]float64
+	queue := make([]int, 0, 10)
+	for i := 0; i < mol.Len(); i++ {
+		if done[i] {
+			continue
+		}
+		done[i] = true
+		queue = append(queue[:0], i)
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			at := mol.Atom(cur)
+			for _, b := range at.Bonds {
+				other := b.At1
+				if other == at {
+					other = b.At2
+				}
+				j, ok := pos[other]
+				if !ok || done[j] {
+					continue
+				}
+				for k := range d {
+					d[k] = coords.At(j, k) - coords.At(cur, k)
+				}
+				B.p.MinImage(d[:])
+				for k, v := range d {
+					coords.Set(j, k, coords.At(cur, k)+v)
+				}
+				done[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+	return nil
+}
+
+// Unwrap removes from current, a frame of a trajectory, the jumps between
+// periodic images that happened since previous, which should be the unwrapped
+// preceding frame. Each atom is placed at the periodic image of its current position
+// that is closest to its previous one, so the atoms should not move more than
+// half a box length between the frames. Unwrapping a whole trajectory, each frame
+// with the unwrapped previous one, gives continuous trajectories, as needed
+// to obtain diffusion coefficients. current is modified in place.
+func (B *Box) Unwrap(current, previous *v3.Matrix) error {
+	if current.NVecs() != previous.NVecs() {
+		return CError{"Frames have different number of atoms", []string{"Box.Unwrap"}}
+	}
+	var d [3]float64
+	for i := 0; i < current.NVecs(); i++ {
+		for k := range d {
+			d[k] = current.At(i, k) - previous.At(i, k)
+		}
+		B.p.MinImage(d[:])
+		for k, v := range d {
+			current.Set(i, k, previous.At(i, k)+v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+/*
+ * pca.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package pca implements the principal component analysis (PCA) of trajectories, also known as
+// essential dynamics: The frames are superimposed on a reference, the covariance matrix
+// of the atomic coordinates is built and diagonalized, and its eigenvectors, the modes, are used
+// to describe the largest motions in the trajectory.
+//
+// A typical use, on the CA atoms, superimposing on the most rigid ones, found with LOVO:
+//
+//	l, _ := align.LOVO(mol, ref, "traj.xtc")
+//	o := pca.DefaultOptions()
+//	o.LOVO(l)
+//	p, _ := pca.New(mol, ref, t, cas, o)
+//	proj, _ := p.Project(t2, 2) //t2 is the same trajectory, opened again.
+//	cos := pca.CosineContent(proj, 0)
+//
+// Projections with a large cosine content (close to 1) are likely to reflect random diffusion,
+// rather than the actual motions of the system (B. Hess, Phys. Rev. E 65, 031910, 2002).
+package pca
+
+import (
+	"fmt"
+	"math"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/align"
+	"github.com/rmera/gochem/traj"
+	v3 "github.com/rmera/gochem/v3"
+	"gonum.org/v1/gonum/mat"
+)
+
+// batchSize is the number of frames added to the covariance matrix at the same time.
+const batchSize = 64
+
+// Options contains the options for the PCA.
+type Options struct {
+	fit          []int
+	massWeighted bool
+}
+
+// DefaultOptions returns options to superimpose the frames using all the atoms,
+// without mass-weighting.
+func DefaultOptions() *Options {
+	return new(Options)
+}
+
+// Fit returns the indexes of the atoms used to superimpose the frames on the reference
+// (nil for all the atoms), and sets them to new values, if given.
+func (O *Options) Fit(indexes ...[]int) []int {
+	if len(indexes) > 0 {
+		O.fit = indexes[0]
+	}
+	return O.fit
+}
+
+// LOVO sets the atoms used to superimpose the frames to the most rigid ones, as found by align.LOVO.
+func (O *Options) LOVO(l *align.LOVOReturn) {
+	O.fit = l.Natoms
+}
+
+// MassWeighted returns true if the coordinates are weighted by the square roots of
+// the atomic masses, and sets it to a new value, if given.
+func (O *Options) MassWeighted(m ...bool) bool {
+	if len(m) > 0 {
+		O.massWeighted = m[0]
+	}
+	return O.massWeighted
+}
+
+// PCA contains the principal components of the motions of a set of atoms in a trajectory.
+type PCA struct {
+	Atoms      []int         //The atoms considered, by index.
+	Frames     int           //The number of frames used.
+	Mean       *v3.Matrix    //The average structure of the atoms, after superposition.
+	Covariance *mat.SymDense //The covariance of the 3N (mass-weighted) coordinates, in A^2 (or amu A^2).
+	Values     []float64     //The eigenvalues of the covariance matrix, from the largest.
+	Vectors    *mat.Dense    //The eigenvectors, or modes, as columns, in the order of Values.
+	ref        *v3.Matrix
+	fit        []int
+	weights    []float64 //One per coordinate.
+}
+
+// New returns the PCA of the atoms with the given indexes (all the atoms, if atoms is nil) in the
+// remaining frames of the trajectory t. Each frame is superimposed on the reference ref, which must
+// contain all the atoms of mol, as t does, using the atoms in options.Fit(). If options.MassWeighted() is
+// true, the atoms of mol must have masses.
+func New(mol chem.Atomer, ref *v3.Matrix, t chem.Traj, atoms []int, options ...*Options) (*PCA, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	if mol.Len() != t.Len() || ref.NVecs() != t.Len() {
+		return nil, fmt.Errorf("New: %d atoms in the molecule, %d in the reference and %d in the trajectory", mol.Len(), ref.NVecs(), t.Len())
+	}
+	if atoms == nil {
+		atoms = make([]int, mol.Len())
+		for i := range atoms {
+			atoms[i] = i
+		}
+	}
+	for _, v := range append(append([]int{}, atoms...), o.fit...) {
+		if v < 0 || v >= mol.Len() {
+			return nil, fmt.Errorf("New: index %d out of range for a molecule with %d atoms", v, mol.Len())
+		}
+	}
+	n3 := 3 * len(atoms)
+	P := &PCA{Atoms: atoms, ref: ref, fit: o.fit, weights: make([]float64, n3)}
+	for i := range P.weights {
+		P.weights[i] = 1
+	}
+	if o.massWeighted {
+		for i, v := range atoms {
+			m := mol.Atom(v).Mass
+			if m <= 0 {
+				return nil, fmt.Errorf("New: atom %d has no mass", v)
+			}
+			for k := 0; k < 3; k++ {
+				P.weights[3*i+k] = math.Sqrt(m)
+			}
+		}
+	}
+	p := traj.NewPipeline(t, traj.Fit(ref, o.fit), traj.Atoms(atoms))
+	coords := v3.Zeros(len(atoms))
+	sum := make([]float64, n3)
+	cov := mat.NewSymDense(n3, nil)
+	batch := mat.NewDense(n3, batchSize, nil)
+	inBatch := 0
+	flush := func() {
+		if inBatch > 0 {
+			cov.SymRankK(cov, 1, batch.Slice(0, n3, 0, inBatch))
+			inBatch = 0
+		}
+	}
+	for {
+		if err := p.Next(coords); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				break
+			}
+			return nil, fmt.Errorf("New: reading frame %d: %w", P.Frames, err)
+		}
+		for i := 0; i < len(atoms); i++ {
+			for k := 0; k < 3; k++ {
+				x := coords.At(i, k) * P.weights[3*i+k]
+				batch.Set(3*i+k, inBatch, x)
+				sum[3*i+k] += x
+			}
+		}
+		inBatch++
+		P.Frames++
+		if inBatch == batchSize {
+			flush()
+		}
+	}
+	flush()
+	if P.Frames < 2 {
+		return nil, fmt.Errorf("New: at least 2 frames are needed, got %d", P.Frames)
+	}
+	//cov = <xx^T> - <x><x>^T
+	for i := range sum {
+		sum[i] /= float64(P.Frames)
+	}
+	cov.ScaleSym(1/float64(P.Frames), cov)
+	cov.SymRankOne(cov, -1, mat.NewVecDense(n3, sum))
+	P.Covariance = cov
+	P.Mean = v3.Zeros(len(atoms))
+	for i := 0; i < len(atoms); i++ {
+		for k := 0; k < 3; k++ {
+			P.Mean.Set(i, k, sum[3*i+k]/P.weights[3*i+k])
+		}
+	}
+	var eig mat.EigenSym
+	if !eig.Factorize(cov, true) {
+		return nil, fmt.Errorf("New: the diagonalization of the covariance matrix failed")
+	}
+	//gonum sorts the eigenvalues from the smallest, we want them from the largest.
+	vals := eig.Values(nil)
+	vecs := mat.NewDense(n3, n3, nil)
+	eig.VectorsTo(vecs)
+	P.Values = make([]float64, n3)
+	P.Vectors = mat.NewDense(n3, n3, nil)
+	for j := 0; j < n3; j++ {
+		P.Values[j] = vals[n3-1-j]
+		for i := 0; i < n3; i++ {
+			P.Vectors.Set(i, j, vecs.At(i, n3-1-j))
+		}
+	}
+	return P, nil
+}
+
+// Mode returns the eigenvector (mode) i, counting from 0, as a matrix with one row per atom.
+func (P *PCA) Mode(i int) *v3.Matrix {
+	ret := v3.Zeros(len(P.Atoms))
+	for j := range P.Atoms {
+		for k := 0; k < 3; k++ {
+			ret.Set(j, k, P.Vectors.At(3*j+k, i))
+		}
+	}
+	return ret
+}
+
+// Project returns the projections of the remaining frames of the trajectory t, which must have the same atoms
+// as the one used to obtain P, on the first k modes. Each frame is superimposed on the reference first.
+// The frames are processed concurrently (see traj.Parallel) with the options given. If a frame can't be read,
+// the projections for the previous frames are returned, together with the error.
+func (P *PCA) Project(t chem.Traj, k int, options ...*traj.ParallelOptions) ([][]float64, error) {
+	if t.Len() != P.ref.NVecs() {
+		return nil, fmt.Errorf("Project: %d atoms in the trajectory, but %d in the reference", t.Len(), P.ref.NVecs())
+	}
+	k = min(k, len(P.Values))
+	ret, err := traj.Parallel(t, func(frame int, coords *v3.Matrix) ([]float64, error) {
+		if _, err := chem.Super(coords, P.ref, P.fit, P.fit); err != nil {
+			return nil, err
+		}
+		proj := make([]float64, k)
+		for i, v := range P.Atoms {
+			for c := 0; c < 3; c++ {
+				d := (coords.At(v, c) - P.Mean.At(i, c)) * P.weights[3*i+c]
+				for j := range proj {
+					proj[j] += d * P.Vectors.At(3*i+c, j)
+				}
+			}
+		}
+		return proj, nil
+	}, options...)
+	if err != nil {
+		return ret, fmt.Errorf("Project: %w", err)
+	}
+	return ret, nil
+}
+
+// CosineContent returns the cosine content of the projections of a trajectory on the mode mode,
+// counting from 0, in projections, as returned by Project. The cosine content of the projection p(t)
+// on the mode i is (2/T)(integral of cos((i+1)*pi*t/T) p(t))^2/(integral of p(t)^2), where T is the length of
+// the trajectory. It is 1 for a cosine with i+1 half periods, which is how random diffusion looks like.
+func CosineContent(projections [][]float64, mode int) float64 {
+	n := len(projections)
+	var cp, pp float64
+	for t, v := range projections {
+		p := v[mode]
+		cp += math.Cos(float64(mode+1)*math.Pi*(float64(t)+0.5)/float64(n)) * p
+		pp += p * p
+	}
+	if pp == 0 {
+		return 0
+	}
+	return 2 * cp * cp / (float64(n) * pp)
+}
+
+// Extrapolate returns nframes structures of the atoms in P, along the mode mode, counting from 0,
+// with projections on it going from min to max, in equal steps, and 0 on the other modes.
+func (P *PCA) Extrapolate(mode int, min, max float64, nframes int) ([]*v3.Matrix, error) {
+	if mode < 0 || mode >= len(P.Values) {
+		return nil, fmt.Errorf("Extrapolate: mode %d out of range, there are %d", mode, len(P.Values))
+	}
+	if nframes < 2 {
+		return nil, fmt.Errorf("Extrapolate: at least 2 frames are needed, got %d", nframes)
+	}
+	ret := make([]*v3.Matrix, nframes)
+	for f := range ret {
+		a := min + (max-min)*float64(f)/float64(nframes-1)
+		ret[f] = v3.Zeros(len(P.Atoms))
+		for i := range P.Atoms {
+			for k := 0; k < 3; k++ {
+				ret[f].Set(i, k, P.Mean.At(i, k)+a*P.Vectors.At(3*i+k, mode)/P.weights[3*i+k])
+			}
+		}
+	}
+	return ret, nil
+}
+
+// WriteMode writes the structures obtained with Extrapolate to w, for instance, a DCD or STF
+// writer, to visualize the motion along the mode. The structures contain only the atoms in P.
+// w is not closed.
+func (P *PCA) WriteMode(w chem.TrajWriter, mode int, min, max float64, nframes int) error {
+	frames, err := P.Extrapolate(mode, min, max, nframes)
+	if err != nil {
+		return fmt.Errorf("WriteMode: %w", err)
+	}
+	for i, f := range frames {
+		if err := w.WNext(f); err != nil {
+			return fmt.Errorf("WriteMode: frame %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * pca_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package pca
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/traj/stf"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func TestPCA(Te *testing.T) {
+	//A rigid triangle, and an atom that moves along z as a cosine with one half period.
+	//Each frame is rotated and translated, so the frames must be superimposed on the triangle.
+	const nframes, amplitude = 100, 2.0
+	ref, _ := v3.NewMatrix([]float64{0, 0, 0, 3, 0, 0, 0, 4, 0, 1, 1, 0})
+	frames := make([]*v3.Matrix, nframes)
+	for f := range frames {
+		frames[f] = v3.Zeros(4)
+		frames[f].Copy(ref)
+		frames[f].Set(3, 2, amplitude*math.Cos(math.Pi*(float64(f)+0.5)/nframes))
+		rot, err := chem.RotatorAroundZ(0.1 * float64(f))
+		if err != nil {
+			Te.Fatal(err)
+		}
+		frames[f].Mul(frames[f], rot)
+		trans, _ := v3.NewMatrix([]float64{float64(f), -2, 0.5})
+		frames[f].AddVec(frames[f], trans)
+	}
+	top := chem.NewTopology(0, 1)
+	for i := 0; i < 4; i++ {
+		top.AppendAtom(&chem.Atom{Name: "C", Symbol: "C", MolName: "MOL", MolID: 1, ID: i + 1, Mass: 12})
+	}
+	mol, err := chem.NewMolecule(frames, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	o := DefaultOptions()
+	o.Fit([]int{0, 1, 2})
+	p, err := New(mol, ref, mol, nil, o)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	//The only motion is the z coordinate of the last atom, with variance amplitude^2/2.
+	if p.Frames != nframes || math.Abs(p.Values[0]-amplitude*amplitude/2) > 1e-6 || math.Abs(p.Values[1]) > 1e-6 {
+		Te.Errorf("Wrong eigenvalues: %v", p.Values[:3])
+	}
+	if mode := p.Mode(0); math.Abs(math.Abs(mode.At(3, 2))-1) > 1e-6 || math.Abs(p.Mean.At(3, 0)-1) > 1e-6 {
+		Te.Errorf("Wrong first mode or mean: %v %v", mode, p.Mean)
+	}
+	mol.InitRead()
+	proj, err := p.Project(mol, 2)
+	if err != nil || len(proj) != nframes || len(proj[0]) != 2 {
+		Te.Fatalf("Wrong projections: %d frames, error: %v", len(proj), err)
+	}
+	if d := math.Abs(proj[0][0]) - amplitude*math.Cos(math.Pi*0.5/nframes); math.Abs(d) > 1e-6 {
+		Te.Errorf("Wrong projection of the first frame: %v", proj[0])
+	}
+	if c := CosineContent(proj, 0); math.Abs(c-1) > 1e-6 {
+		Te.Errorf("The cosine content of the first projection is %f, expected 1", c)
+	}
+	//Mass-weighting scales the eigenvalues by the mass.
+	mol.InitRead()
+	o.MassWeighted(true)
+	if pw, err := New(mol, ref, mol, nil, o); err != nil || math.Abs(pw.Values[0]-12*p.Values[0]) > 1e-6 {
+		Te.Errorf("Wrong mass-weighted eigenvalues: %v, error %v", pw, err)
+	}
+	name := filepath.Join(Te.TempDir(), "mode.stf")
+	w, err := stf.NewWriter(name, 4, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if err := p.WriteMode(w, 0, -amplitude, amplitude, 5); err != nil {
+		Te.Fatal(err)
+	}
+	w.Close()
+	r, _, err := stf.New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer r.Close()
+	coords := v3.Zeros(4)
+	var zs []float64
+	for r.Next(coords) == nil {
+		zs = append(zs, coords.At(3, 2))
+	}
+	if len(zs) != 5 || math.Abs(zs[2]-p.Mean.At(3, 2)) > 1e-3 || math.Abs(math.Abs(zs[4]-zs[0])-2*amplitude) > 1e-3 {
+		Te.Errorf("Wrong extrapolated structures: %v", zs)
+	}
+}
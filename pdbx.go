@@ -26,11 +26,8 @@
 package chem
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"strconv"
 	"strings"
 
@@ -39,349 +36,624 @@ import (
 
 var tl func(string) string = strings.ToLower
 
-// PDBRRead reads a pdb file from an io.Reader. Returns a Molecule. If there is one frame in the PDB
-// the coordinates array will be of lenght 1. It also returns an error which is not
-// really well set up right now.
-// read_additional is now "deprecated", it will be set to true regardless. I have made it into
-func PDBxRead(pdb io.Reader) (*Molecule, error) {
-	bufiopdb := bufio.NewReader(pdb)
-	mol, err := pdbBufIORead(bufiopdb)
-	return mol, errDecorate(err, "PDBReaderREad")
+// PDBxInfo contains the information in a PDBx/mmCIF file, other than the atoms,
+// their bonds and their coordinates.
+type PDBxInfo struct {
+	Name        string //The name of the data block, usually the PDB ID.
+	Entities    []*PDBxEntity
+	ChemComps   map[string]*PDBxChemComp //By component ID (i.e. "HEM")
+	SSElements  []*SSElement
+	SS          []byte      //The secondary structure code (see SSElement) of the residue of each atom.
+	Occupancies [][]float64 //The occupancy of each atom, for each frame (model).
 }
 
-// PDBFileRead reads a pdb file from an io.Reader. Returns a Molecule. If there is one frame in the PDB
-// the coordinates array will be of lenght 1. It also returns an error which is not
-// really well set up right now. read_additional is now deprecated. The reader will just read
-func PDBxFileRead(pdbname string) (*Molecule, error) {
-	pdbxfile, err := os.Open(pdbname)
-	if err != nil {
-		//fmt.Println("Unable to open file!!")
-		return nil, err
-	}
-	defer pdbxfile.Close()
-	pdb := bufio.NewReader(pdbxfile)
-	mol, err := pdbxBufIORead(pdb)
-	return mol, err
+// PDBxEntity is a chemically distinct part of the structure, such as a polymer
+// or a ligand, which can be present in several chains.
+type PDBxEntity struct {
+	ID          string
+	Type        string //"polymer", "non-polymer", "water", etc.
+	Description string
+	PolymerType string //i.e. "polypeptide(L)". Empty for non-polymers.
+	Sequence    string //One-letter, canonical sequence, for polymers.
+	Chains      []string
 }
 
-func pdbxNextLoop(pdb *bufio.Reader) (*bufio.Reader, string, error) {
-	for {
-		line, err := pdb.ReadString('\n')
-		if err != nil {
-			return pdb, line, err
-		}
-		if strings.HasPrefix(tl(line), "loop_") {
-			return pdb, line, nil
-		}
-	}
-
+// PDBxChemComp is a chemical component (residue, ligand, ion) present in a PDBx/mmCIF file.
+type PDBxChemComp struct {
+	ID      string
+	Type    string
+	Name    string
+	Formula string
+	Weight  float64
 }
 
-type pdbxmap map[string]int
+// SSElement is a secondary structure element, from the residue Start to the residue End (both included) of a chain.
+type SSElement struct {
+	ID       string
+	Sheet    string //The ID of the sheet, for strands.
+	Code     byte   //As in DSSP: 'H' alpha helix, 'G' 3-10 helix, 'I' pi helix, 'E' strand, 'T' turn, 'S' bend.
+	Chain    string
+	Start    int //The residue numbers (MolID) of the first and last residues.
+	End      int
+	StartIns string //Insertion codes of the first and last residues.
+	EndIns   string
+}
 
-// adds i to the map[string] entry, if it exists. If not,
-// does nothing. Returns the map.
-func (m pdbxmap) add(s string, i int) pdbxmap {
-	s = strings.TrimSpace(s)
-	s = strings.Replace(s, "\n", "", -1)
-	if _, ok := m[s]; ok {
-		m[s] = i
+// PDBxRead reads a PDBx/mmCIF file from an io.Reader, and returns a Molecule, with one frame per model.
+// Bonds from the struct_conn category (disulfides, links to metals and ligands, etc.) are added to the molecule.
+// The optional options select alternate locations and models.
+func PDBxRead(pdb io.Reader, options ...*PDBOptions) (*Molecule, error) {
+	mol, _, err := PDBxReadInfo(pdb, options...)
+	if err != nil {
+		return nil, fmt.Errorf("PDBxRead: %w", err)
 	}
-	return m
+	return mol, nil
 }
 
-// returns the integaer corresponding to the given string in the map
-// or -1 if the string is not a key in the map.
-func (m pdbxmap) get(s string) int {
-	if i, ok := m[s]; ok {
-		return i
+// PDBxFileRead reads the PDBx/mmCIF file pdbname. See PDBxRead.
+func PDBxFileRead(pdbname string, options ...*PDBOptions) (*Molecule, error) {
+	mol, _, err := PDBxFileReadInfo(pdbname, options...)
+	if err != nil {
+		return nil, fmt.Errorf("PDBxFileRead: %w", err)
 	}
-	return -1
+	return mol, nil
 }
 
-func pdbxFillAtom(at *Atom, data []string, m pdbxmap) error {
-	do := func(at *Atom, s string, data []string, m pdbxmap, f func(*Atom, string) error) error {
-		s = strings.TrimSpace(s) //just in case I make a mistake
-		//	fmt.Println(data, m, s)
-		k := m.get(s)
-		if k >= 0 {
-			if k >= len(data) {
-				return fmt.Errorf("Index out of range: %d, %v", k, data)
-			}
-			//	println("DATA", k, data[k]) /////////////////////
-			err := f(at, data[k])
-			return err
-		}
-		return nil
-	}
-	//We start with the simpler string fields
-	//symbol
-	f := func(a *Atom, s string) error {
-		a.Symbol = s
-		return nil
+// PDBxFileReadInfo reads the PDBx/mmCIF file pdbname. See PDBxReadInfo.
+func PDBxFileReadInfo(pdbname string, options ...*PDBOptions) (*Molecule, *PDBxInfo, error) {
+	pdbxfile, err := openRead(pdbname)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PDBxFileReadInfo: %w", err)
 	}
-	do(at, "_atom_site.type_symbol", data, m, f)
-	//name
-	f = func(a *Atom, s string) error {
-		a.Name = s
-		return nil
+	defer pdbxfile.Close()
+	mol, info, err := PDBxReadInfo(pdbxfile, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PDBxFileReadInfo: file %s: %w", pdbname, err)
 	}
-	//println("NAME", at.Name) /////////////////////////
-	do(at, "_atom_site.auth_atom_id", data, m, f)
-	if at.Symbol == "" {
-		at.Symbol, _ = symbolFromName(at.Name)
+	return mol, info, nil
+}
+
+// PDBxReadInfo reads a PDBx/mmCIF file from an io.Reader, as PDBxRead, but it also returns
+// the secondary structure, entities, chemical components and per-model occupancies
+// in the file. The unit cell, if present, is put in the Cell field of the molecule.
+func PDBxReadInfo(pdb io.Reader, options ...*PDBOptions) (*Molecule, *PDBxInfo, error) {
+	opt := &PDBOptions{}
+	if len(options) > 0 && options[0] != nil {
+		opt = options[0]
 	}
-	//molname
-	f = func(a *Atom, s string) error {
-		a.MolName = s
-		return nil
+	block, err := readCIF(pdb)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PDBxReadInfo: %w", err)
 	}
-	do(at, "_atom_site.auth_comp_id", data, m, f)
-	at.MolName1 = three2OneLetter[at.MolName]
-
-	//char16
-	f = func(a *Atom, s string) error {
-		a.Char16 = s[0]
-		return nil
+	atomsite := block.category("_atom_site")
+	if atomsite == nil {
+		return nil, nil, fmt.Errorf("PDBxReadInfo: no atoms in file")
 	}
-	do(at, "_atom_site.label_alt_id", data, m, f)
-	//chain
-	f = func(a *Atom, s string) error {
-		a.Chain = s
-		return nil
+	models, err := pdbxReadAtoms(atomsite, opt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PDBxReadInfo: %w", err)
 	}
-	do(at, "_atom_site.auth_asym_id", data, m, f)
-
-	//Now the integer fields
-	//ID
-	f = func(a *Atom, s string) error {
-		n, err := strconv.Atoi(s)
+	top := NewTopology(0, 1, models.atoms)
+	top.FillIndexes()
+	coords := make([]*v3.Matrix, len(models.coords))
+	for i, c := range models.coords {
+		coords[i], err = v3.NewMatrix(c)
 		if err != nil {
-			return fmt.Errorf("pdbxFillAtom: Couldn't parse ID from %s: %w", s, err)
+			return nil, nil, fmt.Errorf("PDBxReadInfo: Couldn't transform coordinates from frame %d: %w", i, err)
 		}
-		a.ID = n
-		return nil
 	}
-	err := do(at, "_atom_site.id", data, m, f)
+	mol, err := NewMolecule(coords, top, models.bfactors)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("PDBxReadInfo: %w", err)
 	}
-	//molID
-	f = func(a *Atom, s string) error {
-		n, err := strconv.Atoi(s)
-		if err != nil {
-			return fmt.Errorf("pdbxFillAtom: Couldn't parse MolID from %s: %w", s, err)
+	res := newPDBResidues(models.atoms)
+	if conn := block.category("_struct_conn"); conn != nil {
+		if err := pdbxReadConn(conn, top, res); err != nil {
+			return nil, nil, fmt.Errorf("PDBxReadInfo: %w", err)
 		}
-		a.MolID = n
-		return nil
 	}
-	err = do(at, "_atom_site.auth_seq_id", data, m, f)
+	info := &PDBxInfo{Name: block.name, Occupancies: models.occupancies, ChemComps: make(map[string]*PDBxChemComp)}
+	info.SSElements, err = pdbxReadSS(block)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("PDBxReadInfo: %w", err)
 	}
-	//Now the floating point fields, except for the coordinates and b-factors
-	//occupancy
-	f = func(a *Atom, s string) error {
-		n, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			return fmt.Errorf("pdbxFillAtom: Couldn't parse Occupancy from %s: %w", s, err)
+	info.SS = res.ss(info.SSElements)
+	info.Entities = pdbxReadEntities(block)
+	if comp := block.category("_chem_comp"); comp != nil {
+		for i := range comp.rows {
+			c := &PDBxChemComp{ID: comp.get(i, "id"), Type: comp.get(i, "type"), Name: comp.get(i, "name"), Formula: comp.get(i, "formula")}
+			c.Weight, _ = comp.float(i, "formula_weight")
+			info.ChemComps[c.ID] = c
 		}
-		a.Occupancy = n
-		return nil
 	}
-	err = do(at, "_atom_site.occupancy", data, m, f)
+	mol.Cell, err = pdbxReadCell(block)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("PDBxReadInfo: %w", err)
 	}
-	//Charge, but we won't do anything if we somehow can't read it.
-	f = func(a *Atom, s string) error {
-		n, err := strconv.ParseFloat(s, 64)
+	return mol, info, nil
+}
+
+// pdbxModels contains the atoms, and the per-model data, read from the atom_site category.
+type pdbxModels struct {
+	atoms       []*Atom
+	coords      [][]float64
+	bfactors    [][]float64
+	occupancies [][]float64
+}
+
+// pdbxAtomKey returns a string that identifies the atom (but not its alternate location)
+// in the given row of the atom_site category. See atomKey.
+func pdbxAtomKey(c *cifCategory, row int) string {
+	molid, _ := strconv.Atoi(c.get(row, "auth_seq_id", "label_seq_id"))
+	return atomKey(c.get(row, "auth_asym_id", "label_asym_id"), molid, c.get(row, "pdbx_pdb_ins_code"), c.get(row, "auth_atom_id", "label_atom_id"))
+}
+
+// pdbxReadAtoms reads the atoms, with the alternate locations and models selected by opt,
+// from the atom_site category c. The atoms are taken from the first model read. For the following ones,
+// only the coordinates, b-factors and occupancies are read.
+func pdbxReadAtoms(c *cifCategory, opt *PDBOptions) (*pdbxModels, error) {
+	model := func(row int) (int, error) {
+		s := c.get(row, "pdbx_pdb_model_num")
+		if s == "" {
+			return 1, nil
+		}
+		m, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("Couldn't parse model number from %s: %w", s, err)
+		}
+		return m, nil
+	}
+	if len(c.rows) == 0 {
+		return nil, fmt.Errorf("no atoms in file")
+	}
+	first := opt.Model
+	if first == 0 {
+		var err error
+		if first, err = model(0); err != nil {
+			return nil, err
+		}
+	}
+	//We read all the atoms in the first model, and then choose among the alternate locations.
+	var all []*Atom
+	var rows []int
+	for i := range c.rows {
+		m, err := model(i)
+		if err != nil {
+			return nil, err
+		}
+		if m != first {
+			continue
+		}
+		a, err := pdbxFillAtom(c, i)
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("Couldn't read atom %d: %w", len(all)+1, err)
+		}
+		all = append(all, a)
+		rows = append(rows, i)
+	}
+	ret := &pdbxModels{}
+	index := make(map[string]int) //the index of each atom, by key and alternate location.
+	var firstrows []int
+	for i, keep := range selectAltLocs(all, opt.AltLoc) {
+		if keep {
+			a := all[i]
+			index[atomKey(a.Chain, a.MolID, a.InsCode, a.Name)+"|"+a.AltLoc] = len(ret.atoms)
+			ret.atoms = append(ret.atoms, a)
+			firstrows = append(firstrows, rows[i])
+		}
+	}
+	n := len(ret.atoms)
+	if n == 0 {
+		return nil, fmt.Errorf("no atoms read from file")
+	}
+	newFrame := func() int {
+		ret.coords = append(ret.coords, make([]float64, 3*n))
+		ret.bfactors = append(ret.bfactors, make([]float64, n))
+		ret.occupancies = append(ret.occupancies, make([]float64, n))
+		return len(ret.coords) - 1
+	}
+	hasbfac := c.col("b_iso_or_equiv") >= 0
+	fill := func(f, at, row int) error {
+		if err := pdbxFillFloats(c, row, ret.coords[f][3*at:3*at+3], "cartn_x", "cartn_y", "cartn_z"); err != nil {
+			return err
+		}
+		if hasbfac {
+			if err := pdbxFillFloats(c, row, ret.bfactors[f][at:at+1], "b_iso_or_equiv"); err != nil {
+				return err
+			}
+		}
+		ret.occupancies[f][at] = ret.atoms[at].Occupancy
+		if c.get(row, "occupancy") != "" {
+			return pdbxFillFloats(c, row, ret.occupancies[f][at:at+1], "occupancy")
 		}
-		a.Charge = n
 		return nil
 	}
-	do(at, "_atom_site.pdbx_formal_charge ", data, m, f)
-	//And, finally, the boolean field
-	f = func(a *Atom, s string) error {
-		if s != "ATOM" {
-			a.Het = true
+	newFrame()
+	for at, row := range firstrows {
+		if err := fill(0, at, row); err != nil {
+			return nil, err
+		}
+	}
+	frames := map[int]int{first: 0} //the frame of each model.
+	filled := []int{n}              //the number of atoms with coordinates in each frame.
+	for i := range c.rows {
+		m, _ := model(i) //errors were already checked.
+		if m == first || (opt.Model > 0 && m != opt.Model) {
+			continue
+		}
+		if _, ok := frames[m]; !ok {
+			frames[m] = newFrame()
+			filled = append(filled, 0)
+		}
+		f := frames[m]
+		var at int
+		var ok bool
+		if c.get(i, "auth_atom_id", "label_atom_id") == "" {
+			//Files written by PDBxCompactFileWrite have only the coordinates for models after the first.
+			at, ok = filled[f], filled[f] < n
 		} else {
-			a.Het = false
+			at, ok = index[pdbxAtomKey(c, i)+"|"+c.get(i, "label_alt_id")]
 		}
-		return nil
+		if !ok {
+			continue //an atom not present in the first model.
+		}
+		if err := fill(f, at, i); err != nil {
+			return nil, err
+		}
+		filled[f]++
 	}
-	do(at, "_atom_site.group_pdb", data, m, f)
-	return nil
+	for i, v := range filled {
+		if v != n {
+			return nil, fmt.Errorf("Model in frame %d has %d atoms, while the first one has %d", i, v, n)
+		}
+	}
+	return ret, nil
 }
 
-func pdbxFillBfac(data []string, bf []float64, m pdbxmap) ([]float64, error) {
-	v := "_atom_site.b_iso_or_equiv"
-	if m[v] >= 0 && m[v] < len(data) {
-		fl, err := strconv.ParseFloat(data[m[v]], 64)
+// pdbxFillFloats parses the values of the given items in the row of c, and puts them in dest.
+func pdbxFillFloats(c *cifCategory, row int, dest []float64, items ...string) error {
+	for j, it := range items {
+		s := c.get(row, it)
+		if s == "" {
+			return fmt.Errorf("Field %s not present for atom %s", it, c.get(row, "id"))
+		}
+		fl, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return bf, fmt.Errorf("pdbxFillBfac: Couldn't parse bfactor from %s: %w", data[m[v]], err)
+			return fmt.Errorf("Couldn't parse %s from %s: %w", it, s, err)
+		}
+		dest[j] = fl
+	}
+	return nil
+}
+
+// pdbxFillAtom returns an atom with the data in the row of the atom_site category c.
+func pdbxFillAtom(c *cifCategory, row int) (*Atom, error) {
+	at := new(Atom)
+	at.Name = c.get(row, "auth_atom_id", "label_atom_id")
+	at.MolName = c.get(row, "auth_comp_id", "label_comp_id")
+	at.MolName1 = three2OneLetter[at.MolName]
+	at.Chain = c.get(row, "auth_asym_id", "label_asym_id")
+	at.Symbol = strings.Title(tl(c.get(row, "type_symbol")))
+	if at.Symbol == "" && at.Name != "" {
+		at.Symbol, _ = symbolFromName(at.Name)
+	}
+	at.AltLoc = c.get(row, "label_alt_id")
+	if at.AltLoc != "" {
+		at.Char16 = at.AltLoc[0]
+	}
+	at.InsCode = c.get(row, "pdbx_pdb_ins_code")
+	at.Het = c.get(row, "group_pdb") != "ATOM"
+	var err error
+	if s := c.get(row, "id"); s != "" {
+		if at.ID, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("Couldn't parse ID from %s: %w", s, err)
 		}
-		bf = append(bf, fl)
-	} else {
-		return bf, fmt.Errorf("pdbxFillBfac: Field %s not present in data %v", v, data)
 	}
-	return bf, nil
+	if s := c.get(row, "auth_seq_id", "label_seq_id"); s != "" {
+		if at.MolID, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("Couldn't parse MolID from %s: %w", s, err)
+		}
+	}
+	if at.Occupancy, err = c.float(row, "occupancy"); err != nil {
+		return nil, fmt.Errorf("Couldn't parse Occupancy: %w", err)
+	}
+	//Charge, but we won't do anything if we somehow can't read it.
+	at.Charge, _ = c.float(row, "pdbx_formal_charge")
+	return at, nil
+}
+
+// atomKey returns a string that identifies an atom in a PDB or PDBx file, but not its alternate location.
+func atomKey(chain string, molid int, inscode, name string) string {
+	return strings.Join([]string{chain, strconv.Itoa(molid), inscode, name}, "|")
+}
+
+// selectAltLocs returns whether each of atoms is to be kept, given the alternate location policy altloc
+// (see PDBOptions). Atoms without alternate locations are always kept.
+func selectAltLocs(atoms []*Atom, altloc string) []bool {
+	keep := make([]bool, len(atoms))
+	chosen := make(map[string]int) //the index of the location chosen for each atom with several.
+	for i, at := range atoms {
+		if at.AltLoc == "" || altloc == "*" {
+			keep[i] = true
+			continue
+		}
+		k := atomKey(at.Chain, at.MolID, at.InsCode, at.Name)
+		j, ok := chosen[k]
+		switch {
+		case !ok:
+		case atoms[j].AltLoc == altloc:
+			continue
+		case at.AltLoc != altloc && at.Occupancy <= atoms[j].Occupancy:
+			continue
+		}
+		chosen[k] = i
+	}
+	for _, i := range chosen {
+		keep[i] = true
+	}
+	return keep
+}
+
+// pdbResidues allows finding residues and atoms in PDB and PDBx files by their identifiers.
+type pdbResidues struct {
+	atomlist []*Atom
+	first    []int          //the index of the first atom of each residue.
+	byKey    map[string]int //residue indexes, by chain, number and insertion code.
+	atoms    map[string][]int
 }
 
-func pdbxFillCoords(data []string, coord []float64, m pdbxmap) ([]float64, error) {
-	c := []string{"_atom_site.cartn_x", "_atom_site.cartn_y", "_atom_site.cartn_z"}
-	for j, v := range c {
-		if m[v] >= 0 && m[v] < len(data) {
-			fl, err := strconv.ParseFloat(data[m[v]], 64)
-			if err != nil {
-				return coord, fmt.Errorf("pdbxFillCoord: Couldn't parse %d cartesian coordinate from %s: %w", j, data[m[v]], err)
+func newPDBResidues(atoms []*Atom) *pdbResidues {
+	r := &pdbResidues{atomlist: atoms, byKey: make(map[string]int), atoms: make(map[string][]int)}
+	prev := ""
+	for i, at := range atoms {
+		k := atomKey(at.Chain, at.MolID, at.InsCode, "")
+		if k != prev || i == 0 {
+			if _, ok := r.byKey[k]; !ok {
+				r.byKey[k] = len(r.first)
 			}
-			coord = append(coord, fl)
-		} else {
-			return coord, fmt.Errorf("pdbxFillCoord: Field %s not present in data %v", v, data)
+			r.first = append(r.first, i)
+			prev = k
 		}
+		ak := atomKey(at.Chain, at.MolID, at.InsCode, at.Name)
+		r.atoms[ak] = append(r.atoms[ak], i)
 	}
-	return coord, nil
+	return r
 }
 
-func pdbxBufIORead(pdb *bufio.Reader) (*Molecule, error) {
-	m := pdbxmap(ma)
-	molecule := make([]*Atom, 0)
-	coords := make([][]float64, 1, 1)
-	coords[0] = make([]float64, 0, 3)
-	bfactors := make([][]float64, 1, 1)
-	bfactors[0] = make([]float64, 0)
-	currentmodel := 1
-	var reading bool
-	var field int = 0
-	var havebfactors bool = true
-	hp := strings.HasPrefix
-	trimall := func(s string) string { return strings.TrimSpace(strings.Replace(s, "\n", "", -1)) }
-
-	for {
-		line, err := pdb.ReadString('\n')
-		if err != nil {
-			break
+// atom returns the index of the atom with the given name in the given residue and, if possible,
+// alternate location. It returns -1 if there is no such atom.
+func (r *pdbResidues) atom(chain, resid, ins, name, alt string) int {
+	molid, err := strconv.Atoi(resid)
+	if err != nil {
+		return -1
+	}
+	indexes := r.atoms[atomKey(chain, molid, ins, name)]
+	if len(indexes) == 0 {
+		return -1
+	}
+	for _, i := range indexes {
+		if r.atomlist[i].AltLoc == alt {
+			return i
 		}
-		if hp(line, "#") || hp(line, ";") || trimall(line) == "" {
+	}
+	return indexes[0]
+}
+
+// ss returns the secondary structure code for each atom, given the elements.
+func (r *pdbResidues) ss(elements []*SSElement) []byte {
+	ret := make([]byte, len(r.atomlist))
+	for i := range ret {
+		ret[i] = ' '
+	}
+	for _, e := range elements {
+		b, ok1 := r.byKey[atomKey(e.Chain, e.Start, e.StartIns, "")]
+		end, ok2 := r.byKey[atomKey(e.Chain, e.End, e.EndIns, "")]
+		if !ok1 || !ok2 || b > end {
 			continue
 		}
-		if !reading && strings.HasPrefix(tl(line), "_atom_site") {
-			reading = true
-			field = 0
+		last := len(r.atomlist)
+		if end+1 < len(r.first) {
+			last = r.first[end+1]
 		}
-		if !reading {
-			pdb, line, err = pdbxNextLoop(pdb)
-			if err != nil {
-				break
-			}
+		for i := r.first[b]; i < last; i++ {
+			ret[i] = e.Code
+		}
+	}
+	return ret
+}
+
+// pdbxReadConn adds to top the covalent bonds and links to metals in the struct_conn category conn.
+// Hydrogen bonds and links between atoms in different asymmetric units are ignored, as are links
+// involving atoms that were not read.
+func pdbxReadConn(conn *cifCategory, top *Topology, res *pdbResidues) error {
+	orders := map[string]float64{"sing": 1, "doub": 2, "trip": 3, "quad": 4}
+	for i := range conn.rows {
+		if tl(conn.get(i, "conn_type_id")) == "hydrog" {
 			continue
 		}
-		if strings.HasPrefix(line, "loop_") { //new section
-			reading = false
+		sym1, sym2 := conn.get(i, "ptnr1_symmetry"), conn.get(i, "ptnr2_symmetry")
+		if sym1 != sym2 && sym1 != "" && sym2 != "" {
 			continue
 		}
-		// We shouldn't be here if reading is false
-		if strings.HasPrefix(line, "_") {
-			if !hp(line, "_atom_site") || hp(line, "_atom_site_anisotrop") { //a new section started
-				reading = false
-				continue
-			}
-			//	fmt.Println("This is reading!!", line, field) ///////////
+		var ats [2]int
+		for j, p := range []string{"ptnr1_", "ptnr2_"} {
+			ats[j] = res.atom(conn.get(i, p+"auth_asym_id", p+"label_asym_id"), conn.get(i, p+"auth_seq_id", p+"label_seq_id"),
+				conn.get(i, "pdbx_"+p+"pdb_ins_code"), conn.get(i, p+"auth_atom_id", p+"label_atom_id"), conn.get(i, "pdbx_"+p+"label_alt_id"))
+		}
+		if ats[0] < 0 || ats[1] < 0 || ats[0] == ats[1] {
+			continue
+		}
+		if atomsBonded(top.Atoms[ats[0]], top.Atoms[ats[1]]) {
+			continue
+		}
+		if err := topologyBond(top, ats[0], ats[1], orders[tl(conn.get(i, "pdbx_value_order"))]); err != nil {
+			return fmt.Errorf("struct_conn %s: %w", conn.get(i, "id"), err)
+		}
+		top.Bonds[len(top.Bonds)-1].Dist, _ = conn.float(i, "pdbx_dist_value")
+	}
+	return nil
+}
 
-			m.add(tl(line), field)
-			field++
-		} else {
-			//Here we should be reading the content lines.
-			//we first see if we have a model number, and whether
-			fields := strings.Fields(line)
-			modkey := m.get("_atom_site.pdbx_pdb_model_num")
-			if modkey >= 0 {
-				if modkey >= len(fields) {
-					return nil, fmt.Errorf("pdbxBufIORead: Model field out of range: %d (index) %d (len),  %v", modkey, len(fields), fields)
-				}
-				mod := fields[modkey]
-				model, err := strconv.Atoi(mod)
-				if err != nil {
-					return nil, fmt.Errorf("pdbxBufIORead: Couldn't parse model number from %s: %w", mod, err)
-				}
-				if model > currentmodel {
-					nats := len(coords[len(coords)-1])
-					coords = append(coords, make([]float64, 0, nats))
-					bfactors = append(bfactors, make([]float64, 0, nats))
-					currentmodel = model
-				}
-			}
-			//we don't read the atoms again for the next models.
-			if currentmodel == 1 {
-				at := new(Atom)
-				err := pdbxFillAtom(at, fields, m)
-				if err != nil {
-					return nil, fmt.Errorf("pdbxBufIORead: Couldn't read atom %d: %w", len(molecule)+1, err)
-				}
-				molecule = append(molecule, at)
+// pdbxSSCode returns the DSSP-like code for a secondary structure element of the given type and helix class.
+func pdbxSSCode(conftype, class string) byte {
+	t := strings.ToUpper(conftype)
+	switch {
+	case t == "HELX_RH_3T_P" || (t == "HELX_P" && class == "5"):
+		return 'G'
+	case t == "HELX_RH_PI_P" || (t == "HELX_P" && class == "3"):
+		return 'I'
+	case strings.HasPrefix(t, "HELX"):
+		return 'H'
+	case strings.HasPrefix(t, "STRN"):
+		return 'E'
+	case strings.HasPrefix(t, "TURN"):
+		return 'T'
+	case t == "BEND":
+		return 'S'
+	}
+	return ' '
+}
+
+// pdbxReadSS returns the secondary structure elements from the struct_conf (helices and turns) and
+// struct_sheet_range (strands) categories.
+func pdbxReadSS(block *cifBlock) ([]*SSElement, error) {
+	var ret []*SSElement
+	read := func(c *cifCategory, i int, e *SSElement) error {
+		e.Chain = c.get(i, "beg_auth_asym_id", "beg_label_asym_id")
+		e.StartIns = c.get(i, "pdbx_beg_pdb_ins_code")
+		e.EndIns = c.get(i, "pdbx_end_pdb_ins_code")
+		var err error
+		beg, end := c.get(i, "beg_auth_seq_id", "beg_label_seq_id"), c.get(i, "end_auth_seq_id", "end_label_seq_id")
+		if e.Start, err = strconv.Atoi(beg); err != nil {
+			return fmt.Errorf("wrong secondary structure element %s: %w", e.ID, err)
+		}
+		if e.End, err = strconv.Atoi(end); err != nil {
+			return fmt.Errorf("wrong secondary structure element %s: %w", e.ID, err)
+		}
+		ret = append(ret, e)
+		return nil
+	}
+	if conf := block.category("_struct_conf"); conf != nil {
+		for i := range conf.rows {
+			e := &SSElement{ID: conf.get(i, "id"), Code: pdbxSSCode(conf.get(i, "conf_type_id"), conf.get(i, "pdbx_pdb_helix_class"))}
+			if err := read(conf, i, e); err != nil {
+				return nil, err
 			}
-			//The following we always try to read.
-			c := len(coords) - 1
-			b := len(bfactors) - 1 //c and b should be equal, but not sure if its critical enough to check it.
-			coords[c], err = pdbxFillCoords(fields, coords[c], m)
-			if err != nil {
-				return nil, fmt.Errorf("pdbxBufIORead: Couldn't read %d th coordinates for frame %d: %w", len(coords[c])+1, currentmodel, err)
+		}
+	}
+	if sheet := block.category("_struct_sheet_range"); sheet != nil {
+		for i := range sheet.rows {
+			e := &SSElement{ID: sheet.get(i, "id"), Sheet: sheet.get(i, "sheet_id"), Code: 'E'}
+			if err := read(sheet, i, e); err != nil {
+				return nil, err
 			}
-			bfactors[b], err = pdbxFillBfac(fields, bfactors[b], m)
-			if err != nil && havebfactors {
-				//I might remove this, but not before more testing.
-				//It can very well be that the PDB just doesn't contain b-factors.
-				log.Printf("pdbxBufIORead: Couldn't read %d th bfactors for frame %d: %v", len(bfactors[b])+1, currentmodel, err)
-				havebfactors = false
+		}
+	}
+	return ret, nil
+}
 
+// pdbxReadEntities returns the entities in the entity and entity_poly categories.
+func pdbxReadEntities(block *cifBlock) []*PDBxEntity {
+	entity := block.category("_entity")
+	if entity == nil {
+		return nil
+	}
+	ret := make([]*PDBxEntity, 0, len(entity.rows))
+	byID := make(map[string]*PDBxEntity)
+	for i := range entity.rows {
+		e := &PDBxEntity{ID: entity.get(i, "id"), Type: entity.get(i, "type"), Description: entity.get(i, "pdbx_description")}
+		byID[e.ID] = e
+		ret = append(ret, e)
+	}
+	poly := block.category("_entity_poly")
+	if poly == nil {
+		return ret
+	}
+	for i := range poly.rows {
+		e, ok := byID[poly.get(i, "entity_id")]
+		if !ok {
+			continue
+		}
+		e.PolymerType = poly.get(i, "type")
+		e.Sequence = strings.Join(strings.Fields(poly.get(i, "pdbx_seq_one_letter_code_can", "pdbx_seq_one_letter_code")), "")
+		for _, ch := range strings.Split(poly.get(i, "pdbx_strand_id"), ",") {
+			if ch = strings.TrimSpace(ch); ch != "" {
+				e.Chains = append(e.Chains, ch)
 			}
 		}
+	}
+	return ret
+}
 
+// pdbxReadCell returns the unit cell in the cell and symmetry categories, or nil if there is none.
+// The 1 A cubic cell which the PDB uses for structures not determined by crystallography is
+// also taken as no cell.
+func pdbxReadCell(block *cifBlock) (*UnitCell, error) {
+	cell := block.category("_cell")
+	if cell == nil {
+		return nil, nil
 	}
-	top := NewTopology(0, 1, molecule)
-	var err error
-	frames := len(coords)
-	mcoords := make([]*v3.Matrix, frames, frames) //Final thing to return
-	for i := 0; i < frames; i++ {
-		mcoords[i], err = v3.NewMatrix(coords[i])
+	ret := &UnitCell{}
+	items := []string{"length_a", "length_b", "length_c", "angle_alpha", "angle_beta", "angle_gamma"}
+	for i, it := range items {
+		v, err := cell.float(0, it)
 		if err != nil {
-			return nil, fmt.Errorf("pdbBufIORead: Couldn't transfor coordinates from frame %d: %w", i, err)
+			return nil, fmt.Errorf("wrong cell %s: %w", it, err)
+		}
+		if i < 3 {
+			ret.Lengths[i] = v
+		} else {
+			ret.Angles[i-3] = v
 		}
 	}
-	//if something happened during the process
-	if err != nil {
-		return nil, fmt.Errorf("pdbBufIORead: %w", err)
-
+	if ret.Lengths == [3]float64{} || ret.Lengths == [3]float64{1, 1, 1} {
+		return nil, nil
 	}
-	if !havebfactors {
-		bfactors = nil
+	for i := range ret.Angles {
+		if ret.Angles[i] == 0 {
+			ret.Angles[i] = 90
+		}
 	}
-	returned, err := NewMolecule(mcoords, top, bfactors)
-	if err != nil {
-		return returned, fmt.Errorf("pdbBufIORead: %w", err)
+	ret.Z, _ = strconv.Atoi(cell.get(0, "z_pdb"))
+	if sym := block.category("_symmetry"); sym != nil {
+		ret.SpaceGroup = sym.get(0, "space_group_name_h-m")
+	}
+	if sg := block.category("_space_group"); sg != nil && ret.SpaceGroup == "" {
+		ret.SpaceGroup = sg.get(0, "name_h-m_alt")
 	}
-	return returned, nil
+	return ret, nil
 }
 
 func PDBxFileWrite(name string, coords []*v3.Matrix, mol Atomer, bfact [][]float64) error {
-	pdb, err := os.Create(name)
+	pdb, err := createWrite(name)
 	if err != nil {
 		return fmt.Errorf("PDBxFileWrite: %w", err)
 	}
-	defer pdb.Close()
-	return PDBxWrite(pdb, coords, mol, bfact, false, strings.Replace(name, ".pdb", "", -1))
+	if err := PDBxWrite(pdb, coords, mol, bfact, false, strings.Replace(name, ".pdb", "", -1)); err != nil {
+		pdb.Close()
+		return fmt.Errorf("PDBxFileWrite: %w", err)
+	}
+	//For compressed files, the last data is written only when the file is closed.
+	if err := pdb.Close(); err != nil {
+		return fmt.Errorf("PDBxFileWrite: %w", err)
+	}
+	return nil
 }
 
 func PDBxCompactFileWrite(name string, coords []*v3.Matrix, mol Atomer, bfact [][]float64) error {
-	pdb, err := os.Create(name)
+	pdb, err := createWrite(name)
 	if err != nil {
-		return fmt.Errorf("PDBxFileWrite: %w", err)
+		return fmt.Errorf("PDBxCompactFileWrite: %w", err)
+	}
+	if err := PDBxWrite(pdb, coords, mol, bfact, true, strings.Replace(name, ".pdb", "", -1)); err != nil {
+		pdb.Close()
+		return fmt.Errorf("PDBxCompactFileWrite: %w", err)
 	}
-	defer pdb.Close()
-	return PDBxWrite(pdb, coords, mol, bfact, true, strings.Replace(name, ".pdb", "", -1))
+	//For compressed files, the last data is written only when the file is closed.
+	if err := pdb.Close(); err != nil {
+		return fmt.Errorf("PDBxCompactFileWrite: %w", err)
+	}
+	return nil
 }
 
 func PDBxWrite(out io.Writer, coords []*v3.Matrix, mol Atomer, bfact [][]float64, save bool, name ...string) error {
@@ -390,6 +662,12 @@ func PDBxWrite(out io.Writer, coords []*v3.Matrix, mol Atomer, bfact [][]float64
 		n = name[0]
 	}
 	out.Write([]byte(fmt.Sprintf("data_%s\n#\n", n)))
+	if m, ok := mol.(*Molecule); ok && m.Cell != nil {
+		c := m.Cell
+		fmt.Fprintf(out, "_cell.length_a %.3f\n_cell.length_b %.3f\n_cell.length_c %.3f\n", c.Lengths[0], c.Lengths[1], c.Lengths[2])
+		fmt.Fprintf(out, "_cell.angle_alpha %.2f\n_cell.angle_beta %.2f\n_cell.angle_gamma %.2f\n", c.Angles[0], c.Angles[1], c.Angles[2])
+		fmt.Fprintf(out, "_cell.Z_PDB %d\n#\n_symmetry.space_group_name_H-M %s\n#\n", c.Z, cifQuote(c.SpaceGroup))
+	}
 	for i, v := range coords {
 		cr, _ := v.Dims()
 		if cr != mol.Len() {
@@ -414,7 +692,7 @@ func PDBxWrite(out io.Writer, coords []*v3.Matrix, mol Atomer, bfact [][]float64
 		if model == 1 || save {
 			out.Write([]byte("_atom_site.pdbx_PDB_model_num\n"))
 			out.Write([]byte("_atom_site.Cartn_x\n_atom_site.Cartn_y\n_atom_site.Cartn_z\n"))
-			if len(bfact) > i && len(bfact[i]) > mol.Len() {
+			if len(bfact) > i && len(bfact[i]) >= mol.Len() {
 				out.Write([]byte("_atom_site.B_iso_or_equiv\n"))
 			}
 		}
@@ -428,10 +706,10 @@ func PDBxWrite(out io.Writer, coords []*v3.Matrix, mol Atomer, bfact [][]float64
 					het = "HETATM"
 				}
 				c16 := checkChar16(a.Char16)
-				line = fmt.Sprintf("%s %s %s %s %s %d %d %4.2f %3.1f %s ", a.Symbol, a.Name, a.MolName, string(c16), a.Chain, a.ID, a.MolID, a.Occupancy, a.Charge, het)
+				line = fmt.Sprintf("%s %s %s %s %s %d %d %4.2f %3.1f %s ", cifQuote(a.Symbol), cifQuote(a.Name), cifQuote(a.MolName), string(c16), cifQuote(a.Chain), a.ID, a.MolID, a.Occupancy, a.Charge, het)
 			}
 			line += fmt.Sprintf("%d %5.3f %5.3f %5.3f ", model, v.At(j, 0), v.At(j, 1), v.At(j, 2))
-			if len(bfact) > i && len(bfact[i]) > mol.Len() {
+			if len(bfact) > i && len(bfact[i]) >= mol.Len() {
 				line += fmt.Sprintf("%5.3f\n", bfact[i][j])
 			} else {
 				line += fmt.Sprintf("\n")
@@ -457,27 +735,3 @@ func checkChar16(c byte) byte {
 	}
 	return '?'
 }
-
-var ma map[string]int = map[string]int{
-	"_atom_site.group_pdb":          -1,
-	"_atom_site.id":                 -1,
-	"_atom_site.type_symbol":        -1,
-	"_atom_site.label_atom_id":      -1,
-	"_atom_site.label_alt_id":       -1,
-	"_atom_site.label_comp_id":      -1,
-	"_atom_site.label_asym_id":      -1,
-	"_atom_site.label_entity_id":    -1,
-	"_atom_site.label_seq_id":       -1,
-	"_atom_site.pdbx_pdb_ins_code":  -1,
-	"_atom_site.cartn_x":            -1,
-	"_atom_site.cartn_y":            -1,
-	"_atom_site.cartn_z":            -1,
-	"_atom_site.occupancy":          -1,
-	"_atom_site.b_iso_or_equiv":     -1,
-	"_atom_site.pdbx_formal_charge": -1,
-	"_atom_site.auth_seq_id":        -1,
-	"_atom_site.auth_comp_id":       -1,
-	"_atom_site.auth_asym_id":       -1,
-	"_atom_site.auth_atom_id":       -1,
-	"_atom_site.pdbx_pdb_model_num": -1,
-}
@@ -0,0 +1,274 @@
+/*
+ * prmtop.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amber charges in prmtop files are multiplied by this factor (18.2223), so they
+// are in units that give the Coulomb energy in kcal/mol.
+const prmtopChargeFactor = 18.2223
+
+// prmtopSection is a %FLAG section of a prmtop file, with its fixed-width values
+// still as strings.
+type prmtopSection struct {
+	width int
+	vals  []string
+}
+
+var prmtopFormatRegexp = regexp.MustCompile(`\(\s*\d*([aAiIeEfF])(\d+)`)
+
+// PrmtopFileRead reads the Amber parameter/topology file name, and returns
+// the corresponding topology.
+func PrmtopFileRead(name string) (*Topology, error) {
+	f, err := openRead(name)
+	if err != nil {
+		return nil, fmt.Errorf("PrmtopFileRead: %w", err)
+	}
+	defer f.Close()
+	top, err := PrmtopRead(f)
+	if err != nil {
+		return nil, fmt.Errorf("PrmtopFileRead: %w", err)
+	}
+	return top, nil
+}
+
+// PrmtopRead reads an Amber parameter/topology (prmtop) file from r, and returns
+// a topology with the names, types, charges, masses, residues and bonds of the atoms.
+// The residue number is put in MolID, and the index of the molecule (if the file
+// defines them) in Tag. The total charge is set to the sum of the atomic charges, rounded.
+func PrmtopRead(r io.Reader) (*Topology, error) {
+	sections, err := readPrmtopSections(r)
+	if err != nil {
+		return nil, fmt.Errorf("PrmtopRead: %w", err)
+	}
+	pointers, err := sections.ints("POINTERS")
+	if err != nil || len(pointers) < 12 {
+		return nil, fmt.Errorf("PrmtopRead: missing or wrong POINTERS section")
+	}
+	natoms := pointers[0]
+	names := sections.strings("ATOM_NAME")
+	charges, err := sections.floats("CHARGE")
+	if err != nil {
+		return nil, fmt.Errorf("PrmtopRead: %w", err)
+	}
+	masses, err := sections.floats("MASS")
+	if err != nil {
+		return nil, fmt.Errorf("PrmtopRead: %w", err)
+	}
+	if len(names) != natoms || len(charges) != natoms || len(masses) != natoms {
+		return nil, fmt.Errorf("PrmtopRead: the number of names, charges or masses doesn't match the number of atoms (%d)", natoms)
+	}
+	types := sections.strings("AMBER_ATOM_TYPE")
+	atnums, err := sections.ints("ATOMIC_NUMBER")
+	if err != nil {
+		return nil, fmt.Errorf("PrmtopRead: %w", err)
+	}
+	reslabels := sections.strings("RESIDUE_LABEL")
+	respointers, err := sections.ints("RESIDUE_POINTER")
+	if err != nil || len(reslabels) != len(respointers) {
+		return nil, fmt.Errorf("PrmtopRead: wrong residue information")
+	}
+	atoms := make([]*Atom, natoms)
+	totalcharge := 0.0
+	for i := range atoms {
+		at := &Atom{Name: names[i], ID: i + 1, Charge: charges[i] / prmtopChargeFactor, Mass: masses[i]}
+		at.SetIndex(i)
+		if len(types) == natoms {
+			at.Type = types[i]
+		}
+		if len(atnums) == natoms && atnums[i] > 0 && atnums[i] < len(atomicNumberSymbol) {
+			at.Symbol = atomicNumberSymbol[atnums[i]]
+		} else {
+			at.Symbol = symbolFromMass(at.Mass, at.Name)
+		}
+		totalcharge += at.Charge
+		atoms[i] = at
+	}
+	for i, first := range respointers {
+		last := natoms
+		if i < len(respointers)-1 {
+			last = respointers[i+1] - 1
+		}
+		if first < 1 || last > natoms || first > last+1 {
+			return nil, fmt.Errorf("PrmtopRead: wrong residue pointer %d", first)
+		}
+		for _, at := range atoms[first-1 : last] {
+			at.MolID = i + 1
+			at.MolName = reslabels[i]
+			at.MolName1 = three2OneLetter[reslabels[i]]
+		}
+	}
+	//The molecules, if present (i.e. for periodic systems).
+	if permol, err := sections.ints("ATOMS_PER_MOLECULE"); err == nil && len(permol) > 0 {
+		i := 0
+		for mol, n := range permol {
+			for j := 0; j < n && i < natoms; j++ {
+				atoms[i].Tag = mol + 1
+				i++
+			}
+		}
+	}
+	top := NewTopology(int(math.Round(totalcharge)), 1, atoms)
+	for _, flag := range []string{"BONDS_INC_HYDROGEN", "BONDS_WITHOUT_HYDROGEN"} {
+		bonds, err := sections.ints(flag)
+		if err != nil || len(bonds)%3 != 0 {
+			return nil, fmt.Errorf("PrmtopRead: wrong %s section", flag)
+		}
+		for i := 0; i < len(bonds); i += 3 {
+			//The indexes refer to the coordinate array, so they are 3 times the atom index.
+			if err := topologyBond(top, bonds[i]/3, bonds[i+1]/3, 0); err != nil { //prmtop files have no bond orders.
+				return nil, fmt.Errorf("PrmtopRead: %w", err)
+			}
+		}
+	}
+	return top, nil
+}
+
+type prmtopSections map[string]*prmtopSection
+
+// readPrmtopSections reads all the %FLAG sections in a prmtop file.
+func readPrmtopSections(r io.Reader) (prmtopSections, error) {
+	sections := make(prmtopSections)
+	in := bufio.NewScanner(r)
+	in.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var current *prmtopSection
+	for in.Scan() {
+		line := in.Text()
+		switch {
+		case strings.HasPrefix(line, "%FLAG"):
+			current = &prmtopSection{}
+			sections[strings.TrimSpace(line[5:])] = current
+		case strings.HasPrefix(line, "%FORMAT"):
+			m := prmtopFormatRegexp.FindStringSubmatch(line)
+			if m == nil || current == nil {
+				return nil, fmt.Errorf("wrong format line: %s", line)
+			}
+			current.width, _ = strconv.Atoi(m[2])
+		case strings.HasPrefix(line, "%"): //%VERSION, %COMMENT
+		case current != nil && current.width > 0:
+			for i := 0; i < len(line); i += current.width {
+				current.vals = append(current.vals, line[i:min(i+current.width, len(line))])
+			}
+		}
+	}
+	return sections, in.Err()
+}
+
+// strings returns the values in the section flag, trimmed, or nil if the section doesn't exist.
+func (p prmtopSections) strings(flag string) []string {
+	s, ok := p[flag]
+	if !ok {
+		return nil
+	}
+	ret := make([]string, len(s.vals))
+	for i, v := range s.vals {
+		ret[i] = strings.TrimSpace(v)
+	}
+	return ret
+}
+
+// ints returns the values in the section flag as integers. A missing section is not an error,
+// and gives a nil slice.
+func (p prmtopSections) ints(flag string) ([]int, error) {
+	vals := p.strings(flag)
+	ret := make([]int, 0, len(vals))
+	for _, v := range vals {
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("wrong value in %s: %w", flag, err)
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+// floats returns the values in the section flag as floats. A missing section is an error.
+func (p prmtopSections) floats(flag string) ([]float64, error) {
+	vals := p.strings(flag)
+	if vals == nil {
+		return nil, fmt.Errorf("missing %s section", flag)
+	}
+	ret := make([]float64, 0, len(vals))
+	for _, v := range vals {
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wrong value in %s: %w", flag, err)
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// topologyBond creates a bond of order order between the atoms with 0-based indexes i and j
+// and adds it to the topology and the atoms.
+func topologyBond(top *Topology, i, j int, order float64) error {
+	if i < 0 || j < 0 || i >= top.Len() || j >= top.Len() || i == j {
+		return fmt.Errorf("wrong bond between atoms %d and %d", i, j)
+	}
+	at1 := top.Atoms[i]
+	at2 := top.Atoms[j]
+	b := &Bond{Index: len(top.Bonds), At1: at1, At2: at2, Order: order}
+	at1.Bonds = append(at1.Bonds, b)
+	at2.Bonds = append(at2.Bonds, b)
+	top.Bonds = append(top.Bonds, b)
+	return nil
+}
+
+// symbolFromMass guesses the element of an atom with the given mass. If no element
+// has a mass close enough, the symbol is guessed from the name.
+// Hydrogens with repartitioned masses are recognized by their names.
+func symbolFromMass(mass float64, name string) string {
+	best := ""
+	bestdiff := 0.5
+	for s, m := range symbolMass {
+		if d := math.Abs(m - mass); d < bestdiff {
+			best = s
+			bestdiff = d
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if name == "" {
+		return ""
+	}
+	s, _ := symbolFromName(name)
+	return s
+}
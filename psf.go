@@ -0,0 +1,253 @@
+/*
+ * psf.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PSFFileRead reads the CHARMM/NAMD PSF file name and returns the corresponding topology.
+func PSFFileRead(name string) (*Topology, error) {
+	f, err := openRead(name)
+	if err != nil {
+		return nil, fmt.Errorf("PSFFileRead: %w", err)
+	}
+	defer f.Close()
+	top, err := PSFRead(f)
+	if err != nil {
+		return nil, fmt.Errorf("PSFFileRead: %w", err)
+	}
+	return top, nil
+}
+
+// PSFRead reads a CHARMM/NAMD PSF file (standard, EXT or CHEQ, CHARMM or XPLOR atom types)
+// from r, and returns a topology with the segment IDs, residues, names, types, charges,
+// masses and bonds of the atoms. Only the atoms and bonds sections are read. The total
+// charge is set to the sum of the atomic charges, rounded.
+func PSFRead(r io.Reader) (*Topology, error) {
+	in := bufio.NewScanner(r)
+	in.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineno := 0
+	next := func() (string, bool) {
+		if !in.Scan() {
+			return "", false
+		}
+		lineno++
+		return in.Text(), true
+	}
+	if l, ok := next(); !ok || !strings.HasPrefix(strings.TrimSpace(l), "PSF") {
+		return nil, fmt.Errorf("PSFRead: not a PSF file")
+	}
+	var top *Topology
+	totalcharge := 0.0
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		count, section := psfSectionHeader(line)
+		switch section {
+		case "NATOM":
+			atoms := make([]*Atom, 0, count)
+			for i := 0; i < count; i++ {
+				l, ok := next()
+				if !ok {
+					return nil, fmt.Errorf("PSFRead: file ended in the atoms section")
+				}
+				at, err := psfReadAtom(l)
+				if err != nil {
+					return nil, fmt.Errorf("PSFRead: line %d: %w", lineno, err)
+				}
+				at.SetIndex(i)
+				totalcharge += at.Charge
+				atoms = append(atoms, at)
+			}
+			top = NewTopology(0, 1, atoms)
+		case "NBOND":
+			if top == nil {
+				return nil, fmt.Errorf("PSFRead: bonds section before the atoms section")
+			}
+			indexes := make([]int, 0, 2*count)
+			for len(indexes) < 2*count {
+				l, ok := next()
+				if !ok {
+					return nil, fmt.Errorf("PSFRead: file ended in the bonds section")
+				}
+				for _, f := range strings.Fields(l) {
+					i, err := strconv.Atoi(f)
+					if err != nil {
+						return nil, fmt.Errorf("PSFRead: line %d: %w", lineno, err)
+					}
+					indexes = append(indexes, i)
+				}
+			}
+			for i := 0; i < 2*count; i += 2 {
+				if err := topologyBond(top, indexes[i]-1, indexes[i+1]-1, 0); err != nil {
+					return nil, fmt.Errorf("PSFRead: %w", err)
+				}
+			}
+		}
+	}
+	if err := in.Err(); err != nil {
+		return nil, fmt.Errorf("PSFRead: %w", err)
+	}
+	if top == nil {
+		return nil, fmt.Errorf("PSFRead: no atoms section")
+	}
+	top.SetCharge(int(math.Round(totalcharge)))
+	return top, nil
+}
+
+// psfSectionHeader returns the number of elements and the name of the section (i.e. "NATOM") if line
+// is a section header (like "    1000 !NATOM"). Otherwise, it returns an empty name.
+func psfSectionHeader(line string) (int, string) {
+	i := strings.Index(line, "!")
+	if i < 0 {
+		return 0, ""
+	}
+	fields := strings.Fields(line[:i])
+	name := strings.Fields(line[i+1:] + " ")
+	if len(fields) == 0 || len(name) == 0 {
+		return 0, ""
+	}
+	//some sections (i.e. NGRP NST2) have several numbers, we need only the first.
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, ""
+	}
+	return n, strings.TrimSuffix(name[0], ":")
+}
+
+// psfReadAtom parses a line of the atoms section:
+// ID segment resid resname name type charge mass [imove] [CHEQ fields]
+func psfReadAtom(line string) (*Atom, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("wrong atom line %q", line)
+	}
+	at := &Atom{SegID: fields[1], MolName: fields[3], Name: fields[4], Type: fields[5]}
+	var err error
+	if at.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return nil, err
+	}
+	//The residue number can have an insertion code after the number.
+	resid := strings.TrimRightFunc(fields[2], func(r rune) bool { return r < '0' || r > '9' })
+	if at.MolID, err = strconv.Atoi(resid); err != nil {
+		return nil, err
+	}
+	at.InsCode = fields[2][len(resid):]
+	if at.Charge, err = strconv.ParseFloat(fields[6], 64); err != nil {
+		return nil, err
+	}
+	if at.Mass, err = strconv.ParseFloat(fields[7], 64); err != nil {
+		return nil, err
+	}
+	at.MolName1 = three2OneLetter[at.MolName]
+	at.Symbol = symbolFromMass(at.Mass, at.Name)
+	return at, nil
+}
+
+// PSFFileWrite writes the topology mol to the file name, in the PSF format.
+func PSFFileWrite(name string, mol Atomer) error {
+	f, err := createWrite(name)
+	if err != nil {
+		return fmt.Errorf("PSFFileWrite: %w", err)
+	}
+	if err := PSFWrite(f, mol); err != nil {
+		f.Close()
+		return fmt.Errorf("PSFFileWrite: %w", err)
+	}
+	//For compressed files, the last data is written only when the file is closed.
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("PSFFileWrite: %w", err)
+	}
+	return nil
+}
+
+// psfResid returns the residue number of at, followed by its insertion code, if any.
+func psfResid(at *Atom) string {
+	return strconv.Itoa(at.MolID) + at.InsCode
+}
+
+// PSFWrite writes the topology mol to out, in the XPLOR PSF format (atom types as names).
+// The EXT format is used if the system has 100000 or more atoms, or if any of the
+// names doesn't fit in the standard format. Only the atoms and bonds sections
+// are written with data, the others are written empty. Atoms with no segment ID
+// are written with their chain as segment ID.
+func PSFWrite(out io.Writer, mol Atomer) error {
+	natoms := mol.Len()
+	indexes, bonds := atomerBonds(mol)
+	ext := natoms >= 100000
+	for i := 0; i < natoms && !ext; i++ {
+		at := mol.Atom(i)
+		ext = len(at.SegID) > 4 || len(at.MolName) > 4 || len(at.Name) > 4 || len(at.Type) > 4 || len(psfResid(at)) > 4
+	}
+	atomfmt := "%8d %-4s %-4s %-4s %-4s %-4s %14.6f%14.4f%8d\n"
+	intfmt := "%8d"
+	header := "PSF"
+	if ext {
+		atomfmt = "%10d %-8s %-8s %-8s %-8s %-6s %14.6f%14.4f%8d\n"
+		intfmt = "%10d"
+		header = "PSF EXT"
+	}
+	bw := bufio.NewWriter(out)
+	fmt.Fprintf(bw, "%s\n\n"+intfmt+" !NTITLE\n REMARKS written by goChem\n\n", header, 1)
+	fmt.Fprintf(bw, intfmt+" !NATOM\n", natoms)
+	for i := 0; i < natoms; i++ {
+		at := mol.Atom(i)
+		seg := nonEmpty(at.SegID, at.Chain, "X")
+		//The file is read by splitting the lines in fields, so none can be empty.
+		fmt.Fprintf(bw, atomfmt, i+1, seg, psfResid(at), nonEmpty(at.MolName, "UNK"), nonEmpty(at.Name, at.Symbol, "X"),
+			nonEmpty(at.Type, at.Symbol, "X"), at.Charge, at.Mass, 0)
+	}
+	fmt.Fprintf(bw, "\n"+intfmt+" !NBOND: bonds\n", len(bonds))
+	for i, b := range bonds {
+		fmt.Fprintf(bw, intfmt+intfmt, indexes[b.At1]+1, indexes[b.At2]+1)
+		if i%4 == 3 || i == len(bonds)-1 {
+			fmt.Fprintf(bw, "\n")
+		}
+	}
+	for _, s := range []string{"NTHETA: angles", "NPHI: dihedrals", "NIMPHI: impropers", "NDON: donors", "NACC: acceptors"} {
+		fmt.Fprintf(bw, "\n"+intfmt+" !%s\n\n", 0, s)
+	}
+	return bw.Flush()
+}
+
+// nonEmpty returns the first non-empty string among strs.
+func nonEmpty(strs ...string) string {
+	for _, s := range strs {
+		//PDB files often have blank chains, for instance.
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	return ""
+}
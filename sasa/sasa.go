@@ -0,0 +1,447 @@
+/*
+ * sasa.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package sasa computes solvent accessible surface areas (SASA), per atom, per residue and
+// in total, for structures and trajectories.
+//
+// Two methods are available: The numerical method of Shrake and Rupley (J. Mol. Biol. 79, 351, 1973),
+// which uses the van der Waals radii of the atoms (see chem.Topology.FillVdw), and is exact up to the
+// number of points used, and the analytical LCPO approximation (J. Weiser, P. S. Shenkin and W. C. Still,
+// J. Comput. Chem. 20, 217, 1999), which is much faster, and uses its own radii, and the bonds of the
+// atoms (see chem.Topology.AssignBonds) to determine their types. Areas are in A^2.
+package sasa
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/spatial"
+	"github.com/rmera/gochem/traj"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Options contains the options for the SASA calculations.
+type Options struct {
+	probe   float64
+	points  int
+	workers int
+	lcpo    bool
+}
+
+// DefaultOptions returns options for the Shrake-Rupley method, with a probe radius of 1.4 A
+// (water), 960 points per atom, and as many gorutines as logical CPUs.
+func DefaultOptions() *Options {
+	return &Options{probe: 1.4, points: 960, workers: runtime.NumCPU()}
+}
+
+// Probe returns the radius of the probe, in A, and sets it to a new value, if a valid one is given.
+func (O *Options) Probe(r ...float64) float64 {
+	if len(r) > 0 && r[0] >= 0 {
+		O.probe = r[0]
+	}
+	return O.probe
+}
+
+// Points returns the number of points on the sphere of each atom, for the Shrake-Rupley method,
+// and sets it to a new value, if a valid one is given.
+func (O *Options) Points(n ...int) int {
+	if len(n) > 0 && n[0] > 0 {
+		O.points = n[0]
+	}
+	return O.points
+}
+
+// Workers returns the number of gorutines used, and sets it to a new value, if a valid one is given.
+func (O *Options) Workers(n ...int) int {
+	if len(n) > 0 && n[0] > 0 {
+		O.workers = n[0]
+	}
+	return O.workers
+}
+
+// LCPO returns true if the LCPO method is used by Traj, instead of Shrake-Rupley, and sets it
+// to a new value, if given.
+func (O *Options) LCPO(l ...bool) bool {
+	if len(l) > 0 {
+		O.lcpo = l[0]
+	}
+	return O.lcpo
+}
+
+// Residue is a residue (or any other molecule) with its SASA.
+type Residue struct {
+	Chain   string
+	MolID   int
+	InsCode string
+	MolName string
+	Area    float64
+}
+
+// SASA contains the solvent accessible surface area of a structure.
+type SASA struct {
+	Atoms    []float64  //The area of each atom.
+	Residues []*Residue //The area of each residue, in the order of the atoms.
+	Total    float64
+}
+
+// newSASA returns a SASA with the given per-atom areas, and the per-residue and total ones.
+// Residues are consecutive atoms with the same MolID, chain and insertion code.
+func newSASA(mol chem.Atomer, areas []float64) *SASA {
+	S := &SASA{Atoms: areas}
+	var current *Residue
+	for i, a := range areas {
+		at := mol.Atom(i)
+		if current == nil || at.MolID != current.MolID || at.Chain != current.Chain || at.InsCode != current.InsCode {
+			current = &Residue{Chain: at.Chain, MolID: at.MolID, InsCode: at.InsCode, MolName: at.MolName}
+			S.Residues = append(S.Residues, current)
+		}
+		current.Area += a
+		S.Total += a
+	}
+	return S
+}
+
+// spherePoints returns n points evenly distributed on the unit sphere, along
+// a golden-section spiral.
+func spherePoints(n int) [][3]float64 {
+	ret := make([][3]float64, n)
+	inc := math.Pi * (3 - math.Sqrt(5))
+	for i := range ret {
+		z := 1 - (2*float64(i)+1)/float64(n)
+		r := math.Sqrt(1 - z*z)
+		phi := float64(i) * inc
+		ret[i] = [3]float64{r * math.Cos(phi), r * math.Sin(phi), z}
+	}
+	return ret
+}
+
+// neighbors returns, for each atom, the atoms whose spheres, with the radii given, overlap with its own,
+// and the distances to them.
+func neighbors(coords *v3.Matrix, radii []float64) ([][]int, [][]float64, error) {
+	maxr := 0.0
+	for _, r := range radii {
+		maxr = math.Max(maxr, r)
+	}
+	n := coords.NVecs()
+	nb := make([][]int, n)
+	dists := make([][]float64, n)
+	if n < 2 || maxr == 0 {
+		return nb, dists, nil
+	}
+	cells, err := spatial.NewCellList(coords, 2*maxr)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range cells.Pairs(2 * maxr) {
+		if radii[p.I] == 0 || radii[p.J] == 0 || p.Dist >= radii[p.I]+radii[p.J] {
+			continue
+		}
+		nb[p.I] = append(nb[p.I], p.J)
+		nb[p.J] = append(nb[p.J], p.I)
+		dists[p.I] = append(dists[p.I], p.Dist)
+		dists[p.J] = append(dists[p.J], p.Dist)
+	}
+	return nb, dists, nil
+}
+
+// ShrakeRupley returns the SASA of mol, with the coordinates coords, obtained with the Shrake-Rupley method:
+// Points are distributed on a sphere around each atom, with the van der Waals radius of the atom plus the
+// probe radius, and the area of the sphere is scaled by the fraction of points not inside the sphere of other atoms.
+// The atoms are divided among options.Workers() gorutines.
+func ShrakeRupley(mol chem.Atomer, coords *v3.Matrix, options ...*Options) (*SASA, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	if mol.Len() != coords.NVecs() {
+		return nil, fmt.Errorf("ShrakeRupley: %d atoms in the molecule, but %d coordinates", mol.Len(), coords.NVecs())
+	}
+	n := mol.Len()
+	radii := make([]float64, n)
+	for i := range radii {
+		at := mol.Atom(i)
+		if at.Vdw <= 0 {
+			return nil, fmt.Errorf("ShrakeRupley: atom %d (%s %d) has no van der Waals radius", i, at.Name, at.ID)
+		}
+		radii[i] = at.Vdw + o.probe
+	}
+	nb, _, err := neighbors(coords, radii)
+	if err != nil {
+		return nil, fmt.Errorf("ShrakeRupley: %w", err)
+	}
+	points := spherePoints(max(o.points, 1))
+	areas := make([]float64, n)
+	workers := max(min(o.workers, n), 1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				var c [3]float64
+				for k := range c {
+					c[k] = coords.At(i, k)
+				}
+				exposed := 0
+				//The last neighbor that covered a point is tested first for the next point, as it often covers it too.
+				last := 0
+				for _, p := range points {
+					var q [3]float64
+					for k := range q {
+						q[k] = c[k] + radii[i]*p[k]
+					}
+					buried := false
+					for m := range nb[i] {
+						j := nb[i][(last+m)%len(nb[i])]
+						var d2 float64
+						for k := range q {
+							d := q[k] - coords.At(j, k)
+							d2 += d * d
+						}
+						if d2 < radii[j]*radii[j] {
+							buried = true
+							last = (last + m) % len(nb[i])
+							break
+						}
+					}
+					if !buried {
+						exposed++
+					}
+				}
+				areas[i] = 4 * math.Pi * radii[i] * radii[i] * float64(exposed) / float64(len(points))
+			}
+		}(w)
+	}
+	wg.Wait()
+	return newSASA(mol, areas), nil
+}
+
+// lcpoParams are the radius (without the probe) and the P1-P4 parameters of the LCPO method, for an atom type.
+type lcpoParams struct {
+	r, p1, p2, p3, p4 float64
+}
+
+// lcpoTable contains the LCPO parameters, by element, hybridization (3 for sp3, 2 for sp2,
+// 0 for carboxylate oxygens) and number of bonded heavy atoms, as given by Weiser et al.
+var lcpoTable = map[string]map[[2]int]lcpoParams{
+	"C": {
+		{3, 1}: {1.70, 0.77887, -0.28063, -0.0012968, 0.00039328},
+		{3, 2}: {1.70, 0.56482, -0.19608, -0.0010219, 0.0002658},
+		{3, 3}: {1.70, 0.23348, -0.072627, -0.00020079, 0.00007967},
+		{3, 4}: {1.70, 0.00000, 0.00000, 0.00000, 0.00000},
+		{2, 2}: {1.70, 0.51245, -0.15966, -0.00019781, 0.00016392},
+		{2, 3}: {1.70, 0.070344, -0.019015, -0.000022009, 0.000016875},
+	},
+	"O": {
+		{3, 1}: {1.60, 0.77914, -0.25262, -0.0016056, 0.00035071},
+		{3, 2}: {1.60, 0.49392, -0.16038, -0.00015512, 0.00016453},
+		{2, 1}: {1.60, 0.68563, -0.1868, -0.00135573, 0.00023743},
+		{0, 1}: {1.60, 0.88857, -0.33421, -0.0018683, 0.00049372},
+	},
+	"N": {
+		{3, 1}: {1.65, 0.78602, -0.29198, -0.0006537, 0.00036247},
+		{3, 2}: {1.65, 0.22599, -0.036648, -0.0012297, 0.000080038},
+		{3, 3}: {1.65, 0.051481, -0.012603, -0.00032006, 0.000024774},
+		{2, 1}: {1.65, 0.73511, -0.22116, -0.00089148, 0.0002523},
+		{2, 2}: {1.65, 0.41102, -0.12254, -0.000075448, 0.00011804},
+		{2, 3}: {1.65, 0.062577, -0.017874, -0.00008312, 0.000019849},
+	},
+	"S": {
+		{3, 1}: {1.90, 0.7722, -0.26393, 0.0010629, 0.0002179},
+		{3, 2}: {1.90, 0.54581, -0.19477, -0.0012873, 0.00029247},
+	},
+	"P": {
+		{3, 3}: {1.90, 0.3865, -0.18249, -0.0036598, 0.0004264},
+		{3, 4}: {1.90, 0.03873, -0.0089339, 0.0000083582, 0.0000030381},
+	},
+}
+
+// lcpoDefault is used for the atoms not in lcpoTable.
+var lcpoDefault = lcpoParams{1.70, 0.23348, -0.072627, -0.00020079, 0.00007967}
+
+// sp2 returns true if the atom at, not an hydrogen, is sp2, judging from its number of bonds.
+// Nitrogens with 3 bonds are sp2 if bonded to an sp2 carbon (amides, aromatic rings, etc.).
+func sp2(at *chem.Atom) bool {
+	switch at.Symbol {
+	case "C":
+		return len(at.Bonds) == 3
+	case "N":
+		if len(at.Bonds) < 3 {
+			return true
+		}
+		if len(at.Bonds) > 3 {
+			return false
+		}
+		for _, b := range at.Bonds {
+			if c := b.Cross(at); c.Symbol == "C" && sp2(c) {
+				return true
+			}
+		}
+	case "O":
+		if len(at.Bonds) == 1 {
+			return sp2(at.Bonds[0].Cross(at))
+		}
+	}
+	return false
+}
+
+// lcpoType returns the LCPO parameters for the atom at.
+func lcpoType(at *chem.Atom) lcpoParams {
+	table, ok := lcpoTable[at.Symbol]
+	if !ok {
+		return lcpoDefault
+	}
+	heavy := 0
+	for _, b := range at.Bonds {
+		if b.Cross(at).Symbol != "H" {
+			heavy++
+		}
+	}
+	hyb := 3
+	if sp2(at) {
+		hyb = 2
+	}
+	if at.Symbol == "O" && len(at.Bonds) == 1 {
+		//Carboxylate (and phosphate, etc.) oxygens: The atom they are bonded to has other oxygens bonded only to it.
+		c := at.Bonds[0].Cross(at)
+		for _, b := range c.Bonds {
+			if o := b.Cross(c); o != at && o.Symbol == "O" && len(o.Bonds) == 1 {
+				hyb = 0
+			}
+		}
+	}
+	//Atoms without bonded heavy atoms (water, ions) are taken as having one.
+	heavy = max(heavy, 1)
+	for ; heavy > 0; heavy-- {
+		if p, ok := table[[2]int{hyb, heavy}]; ok {
+			return p
+		}
+	}
+	return lcpoDefault
+}
+
+// overlap returns the area of the sphere of radius ri buried by a sphere of radius rj at a distance d.
+func overlap(ri, rj, d float64) float64 {
+	return 2 * math.Pi * ri * (ri - d/2 - (ri*ri-rj*rj)/(2*d))
+}
+
+// LCPO returns the SASA of mol, with the coordinates coords, obtained with the LCPO approximation.
+// Hydrogens are not considered by the method, and get 0 area. The types of the other atoms are
+// determined from their elements and bonds, so bonds must be assigned (see chem.Topology.AssignBonds).
+// Elements without LCPO parameters are treated as sp3 carbons bonded to 3 heavy atoms. Only the probe
+// radius is taken from options.
+func LCPO(mol chem.Atomer, coords *v3.Matrix, options ...*Options) (*SASA, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	if mol.Len() != coords.NVecs() {
+		return nil, fmt.Errorf("LCPO: %d atoms in the molecule, but %d coordinates", mol.Len(), coords.NVecs())
+	}
+	n := mol.Len()
+	params := make([]lcpoParams, n)
+	radii := make([]float64, n)
+	bonds := false
+	for i := range params {
+		at := mol.Atom(i)
+		bonds = bonds || len(at.Bonds) > 0
+		if at.Symbol == "H" {
+			continue
+		}
+		params[i] = lcpoType(at)
+		radii[i] = params[i].r + o.probe
+	}
+	if !bonds && n > 1 {
+		return nil, fmt.Errorf("LCPO: the atoms have no bonds")
+	}
+	nb, dists, err := neighbors(coords, radii)
+	if err != nil {
+		return nil, fmt.Errorf("LCPO: %w", err)
+	}
+	areas := make([]float64, n)
+	isNeighbor := make([]bool, n)
+	for i := range areas {
+		if radii[i] == 0 {
+			continue
+		}
+		for _, j := range nb[i] {
+			isNeighbor[j] = true
+		}
+		var sij, sjk, sijk float64
+		for m, j := range nb[i] {
+			aij := overlap(radii[i], radii[j], dists[i][m])
+			sij += aij
+			//The overlaps of j with the other neighbors of i.
+			var ajk float64
+			for l, k := range nb[j] {
+				if isNeighbor[k] {
+					ajk += overlap(radii[j], radii[k], dists[j][l])
+				}
+			}
+			sjk += ajk
+			sijk += aij * ajk
+		}
+		for _, j := range nb[i] {
+			isNeighbor[j] = false
+		}
+		p := params[i]
+		areas[i] = p.p1*4*math.Pi*radii[i]*radii[i] + p.p2*sij + p.p3*sjk + p.p4*sijk
+		areas[i] = math.Max(areas[i], 0)
+	}
+	return newSASA(mol, areas), nil
+}
+
+// Traj returns the SASA of mol for each of the remaining frames of the trajectory t, which must
+// have the same atoms as mol, obtained with the Shrake-Rupley method or, if options.LCPO() is
+// true, with LCPO. The frames are processed concurrently (see traj.Parallel), using
+// options.Workers() gorutines. If a frame can't be read, the areas for the previous
+// frames are returned, together with the error.
+func Traj(mol chem.Atomer, t chem.Traj, options ...*Options) ([]*SASA, error) {
+	o := DefaultOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	if mol.Len() != t.Len() {
+		return nil, fmt.Errorf("Traj: %d atoms in the molecule, but %d in the trajectory", mol.Len(), t.Len())
+	}
+	//Each frame is processed by a single gorutine.
+	frameOptions := *o
+	frameOptions.workers = 1
+	popt := traj.DefaultParallelOptions()
+	popt.Workers(o.workers)
+	ret, err := traj.Parallel(t, func(frame int, coords *v3.Matrix) (*SASA, error) {
+		if o.lcpo {
+			return LCPO(mol, coords, &frameOptions)
+		}
+		return ShrakeRupley(mol, coords, &frameOptions)
+	}, popt)
+	if err != nil {
+		return ret, fmt.Errorf("Traj: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,103 @@
+/*
+ * sasa_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package sasa
+
+import (
+	"math"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func TestSASA(Te *testing.T) {
+	//Ethane, with the carbon-carbon bond along x. Each methyl group is a residue.
+	c, _ := v3.NewMatrix([]float64{
+		0, 0, 0, -0.36, 1.03, 0, -0.36, -0.51, 0.89, -0.36, -0.51, -0.89,
+		1.53, 0, 0, 1.89, -1.03, 0, 1.89, 0.51, 0.89, 1.89, 0.51, -0.89,
+	})
+	top := chem.NewTopology(0, 1)
+	for i, s := range []string{"C", "H", "H", "H", "C", "H", "H", "H"} {
+		top.AppendAtom(&chem.Atom{Name: s, Symbol: s, MolName: "CH3", MolID: 1 + i/4, ID: i + 1})
+	}
+	top.FillVdw()
+	if err := top.AssignBonds(c); err != nil {
+		Te.Fatal(err)
+	}
+	//The two carbons, without the hydrogens, are two overlapping spheres, for which the area is known.
+	carbons := v3.Zeros(2)
+	carbons.SomeVecs(c, []int{0, 4})
+	o := DefaultOptions()
+	o.Workers(2)
+	cs, err := ShrakeRupley(chem.NewTopology(0, 1, []*chem.Atom{top.Atoms[0], top.Atoms[4]}), carbons, o)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	r := top.Atom(0).Vdw + o.Probe()
+	exact := 2 * (4*math.Pi*r*r - overlap(r, r, 1.53))
+	if math.Abs(cs.Total-exact)/exact > 0.005 || math.Abs(cs.Atoms[0]-cs.Atoms[1]) > 0.01*exact {
+		Te.Errorf("Shrake-Rupley area for two spheres: %f, expected %f", cs.Total, exact)
+	}
+	sr, err := ShrakeRupley(top, c, o)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	methyl := sr.Atoms[0] + sr.Atoms[1] + sr.Atoms[2] + sr.Atoms[3]
+	if len(sr.Residues) != 2 || math.Abs(sr.Residues[0].Area-methyl) > 1e-9 || math.Abs(sr.Residues[0].Area+sr.Residues[1].Area-sr.Total) > 1e-9 {
+		Te.Errorf("Wrong Shrake-Rupley areas: %v %f", sr.Atoms, sr.Total)
+	}
+	//Each carbon has one heavy neighbor, so only the first two LCPO terms are not zero.
+	lc, err := LCPO(top, c)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	p := lcpoTable["C"][[2]int{3, 1}]
+	r = p.r + 1.4
+	expected := p.p1*4*math.Pi*r*r + p.p2*overlap(r, r, 1.53)
+	if math.Abs(lc.Atoms[0]-expected) > 1e-9 || lc.Atoms[1] != 0 || math.Abs(lc.Total-2*expected) > 1e-9 {
+		Te.Errorf("Wrong LCPO areas: %v, expected %f per carbon", lc.Atoms, expected)
+	}
+	//LCPO implicitly includes the hydrogens in the area of the heavy atoms, so it should
+	//roughly agree with Shrake-Rupley for the whole methyl groups.
+	if d := math.Abs(lc.Atoms[0]-methyl) / methyl; d > 0.25 {
+		Te.Errorf("LCPO and Shrake-Rupley differ by %f%%: %f %f", d*100, lc.Atoms[0], methyl)
+	}
+	shifted := v3.Zeros(8)
+	shifted.Copy(c)
+	shifted.Set(0, 0, -10) //The first carbon, alone.
+	mol, err := chem.NewMolecule([]*v3.Matrix{c, shifted}, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	o.LCPO(true)
+	frames, err := Traj(mol, mol, o)
+	if err != nil || len(frames) != 2 {
+		Te.Fatalf("Expected 2 frames, got %d, error: %v", len(frames), err)
+	}
+	if frames[0].Total != lc.Total || frames[1].Atoms[0] <= lc.Atoms[0] {
+		Te.Errorf("Wrong LCPO areas for the trajectory: %f %f", frames[0].Total, frames[1].Atoms[0])
+	}
+}
@@ -0,0 +1,580 @@
+/*
+ * sdf.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// SDFNameKey is the key under which the name of each record (the first line of the molfile header)
+// is stored in the property map returned by SDFReader.Next. It is also used by SDFWrite.
+const SDFNameKey = "_Name"
+
+// SDFReader reads the records of an MDL SD file (or a single Molfile) one by one.
+// Both V2000 and V3000 connection tables are supported.
+type SDFReader struct {
+	sdf      *bufio.Reader
+	file     io.Closer
+	filename string
+	records  int
+	lineno   int
+}
+
+// SDFRead returns an SDFReader that reads records from sdf.
+func SDFRead(sdf io.Reader) *SDFReader {
+	return &SDFReader{sdf: bufio.NewReader(sdf)}
+}
+
+// SDFFileRead opens the SD file with name sdfname and returns an SDFReader for it.
+// The file is closed when the last record is read, or when Close is called.
+func SDFFileRead(sdfname string) (*SDFReader, error) {
+	f, err := openRead(sdfname)
+	if err != nil {
+		return nil, fmt.Errorf("SDFFileRead: %w", err)
+	}
+	S := SDFRead(f)
+	S.file = f
+	S.filename = sdfname
+	return S, nil
+}
+
+// Close closes the underlying file, if any.
+func (S *SDFReader) Close() {
+	if S.file != nil {
+		S.file.Close()
+		S.file = nil
+	}
+}
+
+// Records returns the number of records read so far.
+func (S *SDFReader) Records() int {
+	return S.records
+}
+
+func (S *SDFReader) readLine() (string, error) {
+	line, err := S.sdf.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	S.lineno++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Next reads the next record in the file. It returns the topology of the molecule, with
+// formal charges in the Charge field of the atoms, and with bonds (and their orders) assigned, the
+// coordinates, and a map with the data items of the record. The name of the record is stored
+// in the map with the key SDFNameKey.
+// After the last record has been read, it returns a LastFrameError.
+func (S *SDFReader) Next() (*Topology, *v3.Matrix, map[string]string, error) {
+	top, coords, props, err := S.next()
+	if err == io.EOF {
+		S.Close()
+		return nil, nil, nil, newlastFrameError(S.filename, S.records)
+	}
+	if err != nil {
+		S.Close()
+		return nil, nil, nil, fmt.Errorf("SDFReader.Next: record %d, line %d: %w", S.records+1, S.lineno, err)
+	}
+	S.records++
+	return top, coords, props, nil
+}
+
+func (S *SDFReader) next() (*Topology, *v3.Matrix, map[string]string, error) {
+	var name string
+	var err error
+	//we skip empty lines between records, if any.
+	for {
+		name, err = S.readLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if strings.TrimSpace(name) != "" {
+			break
+		}
+	}
+	props := map[string]string{SDFNameKey: strings.TrimSpace(name)}
+	for i := 0; i < 2; i++ {
+		if _, err = S.readLine(); err != nil {
+			return nil, nil, nil, unexpectedEOF(err)
+		}
+	}
+	counts, err := S.readLine()
+	if err != nil {
+		return nil, nil, nil, unexpectedEOF(err)
+	}
+	var top *Topology
+	var coords *v3.Matrix
+	if strings.Contains(counts, "V3000") {
+		top, coords, err = S.readV3000()
+	} else {
+		top, coords, err = S.readV2000(counts)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = S.readData(props)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	charge := 0.0
+	for _, at := range top.Atoms {
+		charge += at.Charge
+	}
+	top.SetCharge(int(math.Round(charge)))
+	top.FillIndexes()
+	tmp := v3.Zeros(1)
+	for _, b := range top.Bonds {
+		tmp.Sub(coords.VecView(b.At1.index), coords.VecView(b.At2.index))
+		b.Dist = tmp.Norm(2)
+	}
+	return top, coords, props, nil
+}
+
+// readData reads the data items of a record, up to the "$$$$" line (or EOF) into props.
+func (S *SDFReader) readData(props map[string]string) error {
+	key := ""
+	var value []string
+	for {
+		line, err := S.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "$$$$") {
+			return nil
+		}
+		if strings.HasPrefix(line, ">") {
+			key = ""
+			value = value[:0]
+			if i := strings.Index(line, "<"); i >= 0 {
+				if j := strings.Index(line[i:], ">"); j > 0 {
+					key = line[i+1 : i+j]
+				}
+			}
+			continue
+		}
+		if key == "" {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			props[key] = strings.Join(value, "\n")
+			key = ""
+			continue
+		}
+		value = append(value, line)
+		//in case the last item in the file has no ending blank line
+		props[key] = strings.Join(value, "\n")
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// sdfAtom returns a new atom with the given symbol and formal charge.
+func sdfAtom(symbol string, id int, charge float64) *Atom {
+	at := new(Atom)
+	at.Symbol = symbol
+	at.Name = symbol
+	at.ID = id
+	at.MolName = "UNK"
+	at.MolID = 1
+	at.Charge = charge
+	at.Mass = symbolMass[symbol]
+	return at
+}
+
+// sdfBond creates a bond between the 1-based indexes i and j, of type btype, and adds it to
+// the topology and the atoms.
+func sdfBond(top *Topology, i, j, btype int) error {
+	if i < 1 || j < 1 || i > top.Len() || j > top.Len() {
+		return fmt.Errorf("bond between atoms %d and %d out of range", i, j)
+	}
+	order := float64(btype)
+	switch btype {
+	case 4:
+		order = 1.5 //aromatic
+	case 1, 2, 3:
+	default:
+		order = 0 //query bonds, "any", etc.
+	}
+	at1 := top.Atoms[i-1]
+	at2 := top.Atoms[j-1]
+	b := &Bond{Index: len(top.Bonds), At1: at1, At2: at2, Order: order}
+	at1.Bonds = append(at1.Bonds, b)
+	at2.Bonds = append(at2.Bonds, b)
+	top.Bonds = append(top.Bonds, b)
+	return nil
+}
+
+// sdfField returns line[i:j], trimmed, and taking care of lines that are too short.
+func sdfField(line string, i, j int) string {
+	if len(line) <= i {
+		return ""
+	}
+	if len(line) < j {
+		j = len(line)
+	}
+	return strings.TrimSpace(line[i:j])
+}
+
+// readV2000 reads a V2000 connection table, given the counts line.
+func (S *SDFReader) readV2000(counts string) (*Topology, *v3.Matrix, error) {
+	natoms, err := strconv.Atoi(sdfField(counts, 0, 3))
+	if err != nil {
+		return nil, nil, err
+	}
+	nbonds, err := strconv.Atoi(sdfField(counts, 3, 6))
+	if err != nil {
+		return nil, nil, err
+	}
+	atoms := make([]*Atom, natoms)
+	c := make([]float64, 3*natoms)
+	for i := 0; i < natoms; i++ {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		for j := 0; j < 3; j++ {
+			c[3*i+j], err = strconv.ParseFloat(sdfField(line, 10*j, 10*j+10), 64)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		charge := 0.0
+		if ccc := sdfField(line, 36, 39); ccc != "" {
+			code, err := strconv.Atoi(ccc)
+			if err != nil {
+				return nil, nil, err
+			}
+			if code > 0 && code < 8 && code != 4 { //4 is a doublet radical, not a charge
+				charge = float64(4 - code)
+			}
+		}
+		atoms[i] = sdfAtom(sdfField(line, 31, 34), i+1, charge)
+	}
+	top := NewTopology(0, 1, atoms)
+	for i := 0; i < nbonds; i++ {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		var b [3]int
+		for j := range b {
+			b[j], err = strconv.Atoi(sdfField(line, 3*j, 3*j+3))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = sdfBond(top, b[0], b[1], b[2]); err != nil {
+			return nil, nil, err
+		}
+	}
+	//The properties block. If there are M  CHG lines, they supersede the charges in the atom block.
+	chgread := false
+	for {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		if strings.HasPrefix(line, "M  END") {
+			break
+		}
+		if !strings.HasPrefix(line, "M  CHG") {
+			continue
+		}
+		if !chgread {
+			for _, at := range atoms {
+				at.Charge = 0
+			}
+			chgread = true
+		}
+		fields := strings.Fields(line[6:])
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) < 1+2*n {
+			return nil, nil, fmt.Errorf("ill-formed charge line: %s", line)
+		}
+		for j := 0; j < n; j++ {
+			index, err1 := strconv.Atoi(fields[1+2*j])
+			charge, err2 := strconv.Atoi(fields[2+2*j])
+			if err1 != nil || err2 != nil || index < 1 || index > natoms {
+				return nil, nil, fmt.Errorf("ill-formed charge line: %s", line)
+			}
+			atoms[index-1].Charge = float64(charge)
+		}
+	}
+	coords, err := v3.NewMatrix(c)
+	return top, coords, err
+}
+
+// readV3000Line reads a "M  V30" line, joining continuation lines, and returns
+// it without the "M  V30 " prefix.
+func (S *SDFReader) readV3000Line() (string, error) {
+	ret := ""
+	for {
+		line, err := S.readLine()
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		if strings.HasPrefix(line, "M  END") {
+			return "", fmt.Errorf("unexpected end of V3000 connection table")
+		}
+		if !strings.HasPrefix(line, "M  V30") {
+			continue
+		}
+		line = strings.TrimSpace(line[6:])
+		if strings.HasSuffix(line, "-") {
+			ret += strings.TrimSuffix(line, "-")
+			continue
+		}
+		return ret + line, nil
+	}
+}
+
+// readV3000 reads a V3000 connection table. Only the atom and bond blocks are used.
+func (S *SDFReader) readV3000() (*Topology, *v3.Matrix, error) {
+	var atoms []*Atom
+	var c []float64
+	var bonds [][3]int
+	positions := make(map[int]int) //the 1-based position of the atom with each index in the file.
+	block := ""
+	for {
+		line, err := S.readV3000Line()
+		if err != nil {
+			return nil, nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "BEGIN" && len(fields) > 1 {
+			block = fields[1]
+			continue
+		}
+		if fields[0] == "END" && len(fields) > 1 {
+			if fields[1] == "CTAB" {
+				break
+			}
+			block = ""
+			continue
+		}
+		switch block {
+		case "ATOM":
+			if len(fields) < 6 {
+				return nil, nil, fmt.Errorf("ill-formed V3000 atom line: %s", line)
+			}
+			index, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := positions[index]; ok {
+				return nil, nil, fmt.Errorf("repeated V3000 atom index %d", index)
+			}
+			positions[index] = len(atoms) + 1
+			for j := 2; j < 5; j++ {
+				f, err := strconv.ParseFloat(fields[j], 64)
+				if err != nil {
+					return nil, nil, err
+				}
+				c = append(c, f)
+			}
+			charge := 0.0
+			for _, f := range fields[6:] {
+				if strings.HasPrefix(f, "CHG=") {
+					ch, err := strconv.Atoi(f[4:])
+					if err != nil {
+						return nil, nil, err
+					}
+					charge = float64(ch)
+				}
+			}
+			atoms = append(atoms, sdfAtom(fields[1], index, charge))
+		case "BOND":
+			if len(fields) < 4 {
+				return nil, nil, fmt.Errorf("ill-formed V3000 bond line: %s", line)
+			}
+			var b [3]int
+			for j := range b {
+				b[j], err = strconv.Atoi(fields[j+1])
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			//The bonds refer to the atoms by their indexes, which don't need to be consecutive.
+			i, ok1 := positions[b[1]]
+			j, ok2 := positions[b[2]]
+			if !ok1 || !ok2 {
+				return nil, nil, fmt.Errorf("V3000 bond between atoms %d and %d, which are not defined", b[1], b[2])
+			}
+			bonds = append(bonds, [3]int{i, j, b[0]})
+		}
+	}
+	//we still need to get to the M  END line.
+	for {
+		line, err := S.readLine()
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		if strings.HasPrefix(line, "M  END") {
+			break
+		}
+	}
+	top := NewTopology(0, 1, atoms)
+	for _, b := range bonds {
+		if err := sdfBond(top, b[0], b[1], b[2]); err != nil {
+			return nil, nil, err
+		}
+	}
+	coords, err := v3.NewMatrix(c)
+	return top, coords, err
+}
+
+// SDFFileWrite writes a single-record SD file with name sdfname, for the molecule mol
+// with coordinates coords and data items props. See SDFWrite.
+func SDFFileWrite(sdfname string, coords *v3.Matrix, mol Atomer, props map[string]string) error {
+	out, err := createWrite(sdfname)
+	if err != nil {
+		return fmt.Errorf("SDFFileWrite: %w", err)
+	}
+	err = SDFWrite(out, coords, mol, props)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("SDFFileWrite: %w", err)
+	}
+	//For compressed files, the last data is written only when the file is closed.
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("SDFFileWrite: %w", err)
+	}
+	return nil
+}
+
+// SDFWrite writes one SD record, terminated by "$$$$", for the molecule mol with coordinates coords, to out.
+// Bonds are taken from the Bonds field of the atoms, and the Charge field of the atoms is written as
+// the formal charge, when it has an integer value. The data items in props are written in alphabetical
+// order of their keys, except for the value of SDFNameKey, which is used as the record name.
+// The V2000 format is used unless the molecule has more than 999 atoms or bonds, in which case the
+// V3000 format is used. Several calls to SDFWrite on the same io.Writer produce a multi-record SD file.
+func SDFWrite(out io.Writer, coords *v3.Matrix, mol Atomer, props map[string]string) error {
+	natoms := mol.Len()
+	if coords.NVecs() != natoms {
+		return fmt.Errorf("SDFWrite: Ref and Coords don't have the same number of atoms")
+	}
+	indexes, bonds := atomerBonds(mol)
+	charges := make([]int, natoms)
+	for i := 0; i < natoms; i++ {
+		if q := mol.Atom(i).Charge; q == math.Trunc(q) {
+			charges[i] = int(q)
+		}
+	}
+	btype := func(order float64) int {
+		switch order {
+		case 1.5:
+			return 4
+		case 2:
+			return 2
+		case 3:
+			return 3
+		}
+		return 1
+	}
+	bw := bufio.NewWriter(out)
+	fmt.Fprintf(bw, "%s\n  goChem\n\n", props[SDFNameKey])
+	if natoms <= 999 && len(bonds) <= 999 {
+		fmt.Fprintf(bw, "%3d%3d  0  0  0  0  0  0  0  0999 V2000\n", natoms, len(bonds))
+		var chg []int
+		for i := 0; i < natoms; i++ {
+			code := 0
+			if q := charges[i]; q != 0 {
+				chg = append(chg, i)
+				if q >= -3 && q <= 3 {
+					code = 4 - q
+				}
+			}
+			fmt.Fprintf(bw, "%10.4f%10.4f%10.4f %-3s 0%3d  0  0  0  0  0  0  0  0  0  0\n", coords.At(i, 0), coords.At(i, 1), coords.At(i, 2), mol.Atom(i).Symbol, code)
+		}
+		for _, b := range bonds {
+			fmt.Fprintf(bw, "%3d%3d%3d  0\n", indexes[b.At1]+1, indexes[b.At2]+1, btype(b.Order))
+		}
+		for i := 0; i < len(chg); i += 8 {
+			end := i + 8
+			if end > len(chg) {
+				end = len(chg)
+			}
+			fmt.Fprintf(bw, "M  CHG%3d", end-i)
+			for _, j := range chg[i:end] {
+				fmt.Fprintf(bw, " %3d %3d", j+1, charges[j])
+			}
+			fmt.Fprintf(bw, "\n")
+		}
+	} else {
+		fmt.Fprintf(bw, "  0  0  0     0  0            999 V3000\n")
+		fmt.Fprintf(bw, "M  V30 BEGIN CTAB\nM  V30 COUNTS %d %d 0 0 0\nM  V30 BEGIN ATOM\n", natoms, len(bonds))
+		for i := 0; i < natoms; i++ {
+			chg := ""
+			if charges[i] != 0 {
+				chg = fmt.Sprintf(" CHG=%d", charges[i])
+			}
+			fmt.Fprintf(bw, "M  V30 %d %s %.4f %.4f %.4f 0%s\n", i+1, mol.Atom(i).Symbol, coords.At(i, 0), coords.At(i, 1), coords.At(i, 2), chg)
+		}
+		fmt.Fprintf(bw, "M  V30 END ATOM\nM  V30 BEGIN BOND\n")
+		for i, b := range bonds {
+			fmt.Fprintf(bw, "M  V30 %d %d %d %d\n", i+1, btype(b.Order), indexes[b.At1]+1, indexes[b.At2]+1)
+		}
+		fmt.Fprintf(bw, "M  V30 END BOND\nM  V30 END CTAB\n")
+	}
+	fmt.Fprintf(bw, "M  END\n")
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		if k != SDFNameKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(bw, "> <%s>\n%s\n\n", k, props[k])
+	}
+	fmt.Fprintf(bw, "$$$$\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("SDFWrite: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,629 @@
+/*
+ * selection.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package selection implements an atom selection language, similar to those of VMD, PyMOL
+// or MDAnalysis. A selection is compiled once, and can then be evaluated against
+// any chem.Atomer (and, for selections that depend on the geometry, a set of coordinates),
+// giving the indexes of the selected atoms, which is what most goChem functions take.
+//
+// The selection "chain A and resid 10 to 50 and name CA" gives the alpha carbons of
+// residues 10 to 50 of chain A. The keywords are:
+//
+//	name, resname, chain, segid, element, type   followed by one or more values
+//	resid, index, id                              followed by one or more numbers or ranges (10 to 50, or 10:50)
+//	all, none, protein, backbone, water, hydrogen, hetero
+//	within D of SEL                               atoms at D A or less from any atom in SEL
+//	same residue as SEL, same chain as SEL        whole residues (or chains) with atoms in SEL
+//	bonded to SEL                                 atoms bonded to any atom in SEL (but not in it)
+//	not SEL, SEL and SEL, SEL or SEL, (SEL)
+//
+// Values can contain the wildcards *, ? and [] as in path.Match (so "name H*" selects
+// all atoms with names starting with H) and can be quoted with ' or " if they contain
+// spaces or are keywords. index refers to the position of the atom in the Atomer,
+// starting from 0, and id to the ID field of the atom (normally, its serial number
+// in the file read).
+//
+// "not", "within", "same" and "bonded to" apply only to the selection immediately following,
+// and "and" takes precedence over "or". Thus, "within 5 of resname HEM and name FE" selects
+// iron atoms within 5 A of the heme groups. Use parentheses if in doubt.
+// The distances for "within" don't consider periodic boundary conditions.
+package selection
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/spatial"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Selection is a compiled atom selection.
+type Selection struct {
+	text string
+	root node
+}
+
+// Compile parses a selection and returns it, or an error, if the selection
+// is not valid.
+func Compile(selection string) (*Selection, error) {
+	toks, err := tokenize(selection)
+	if err != nil {
+		return nil, fmt.Errorf("Compile: %w", err)
+	}
+	p := &parser{toks: toks}
+	root, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].val)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Compile: %q: %w", selection, err)
+	}
+	return &Selection{text: selection, root: root}, nil
+}
+
+// MustCompile is like Compile, but panics if the selection is not valid.
+// It is meant for selections given as constants in programs.
+func MustCompile(selection string) *Selection {
+	S, err := Compile(selection)
+	if err != nil {
+		panic(err.Error())
+	}
+	return S
+}
+
+// String returns the text of the selection.
+func (S *Selection) String() string {
+	return S.text
+}
+
+// Indexes returns the indexes, in increasing order, of the atoms in mol that match
+// the selection. The coordinates for mol are required only for selections that
+// use "within". Otherwise, they are ignored.
+func (S *Selection) Indexes(mol chem.Atomer, coords ...*v3.Matrix) ([]int, error) {
+	e := &env{mol: mol}
+	if len(coords) > 0 && coords[0] != nil {
+		e.coords = coords[0]
+		if e.coords.NVecs() != mol.Len() {
+			return nil, fmt.Errorf("Indexes: %d atoms in the topology and %d in the coordinates", mol.Len(), e.coords.NVecs())
+		}
+	}
+	sel, err := S.root.eval(e)
+	if err != nil {
+		return nil, fmt.Errorf("Indexes: %q: %w", S.text, err)
+	}
+	ret := make([]int, 0, len(sel))
+	for i, v := range sel {
+		if v {
+			ret = append(ret, i)
+		}
+	}
+	return ret, nil
+}
+
+// Select compiles the selection and returns the indexes of the atoms in mol that match it.
+// The coordinates are only needed for selections that use "within".
+func Select(mol chem.Atomer, selection string, coords ...*v3.Matrix) ([]int, error) {
+	S, err := Compile(selection)
+	if err != nil {
+		return nil, err
+	}
+	return S.Indexes(mol, coords...)
+}
+
+// The residue names for the protein and water keywords.
+var (
+	proteinRes = []string{"ALA", "ARG", "ASN", "ASP", "CYS", "GLN", "GLU", "GLY", "HIS", "ILE",
+		"LEU", "LYS", "MET", "PHE", "PRO", "SER", "THR", "TRP", "TYR", "VAL",
+		"HID", "HIE", "HIP", "HSD", "HSE", "HSP", "CYX", "CYM", "ASH", "GLH", "LYN", "MSE", "SEC", "PYL"}
+	waterRes   = []string{"HOH", "WAT", "SOL", "TIP3", "TIP4", "TIP5", "SPC", "T3P", "T4P", "H2O"}
+	backbone   = []string{"N", "CA", "C", "O"}
+	keywordSel = map[string]func(at *chem.Atom) bool{
+		"all":  func(at *chem.Atom) bool { return true },
+		"none": func(at *chem.Atom) bool { return false },
+		"protein": func(at *chem.Atom) bool {
+			return isIn(at.MolName, proteinRes)
+		},
+		"backbone": func(at *chem.Atom) bool {
+			return isIn(at.MolName, proteinRes) && isIn(at.Name, backbone)
+		},
+		"water": func(at *chem.Atom) bool {
+			return isIn(at.MolName, waterRes)
+		},
+		"hydrogen": func(at *chem.Atom) bool {
+			return at.Symbol == "H" || at.Symbol == "D"
+		},
+		"hetero": func(at *chem.Atom) bool {
+			return at.Het
+		},
+	}
+	stringFields = map[string]func(at *chem.Atom) string{
+		"name":    func(at *chem.Atom) string { return at.Name },
+		"resname": func(at *chem.Atom) string { return at.MolName },
+		"chain":   func(at *chem.Atom) string { return at.Chain },
+		"segid":   func(at *chem.Atom) string { return at.SegID },
+		"element": func(at *chem.Atom) string { return at.Symbol },
+		"type":    func(at *chem.Atom) string { return at.Type },
+	}
+)
+
+func isIn(s string, container []string) bool {
+	for _, v := range container {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Lexer
+
+type token struct {
+	val    string
+	quoted bool
+	pos    int //in the original string, for error messages.
+}
+
+// is returns true if the token is the given (case insensitive) unquoted word.
+func (t token) is(word string) bool {
+	return !t.quoted && strings.EqualFold(t.val, word)
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, token{val: s[i : i+1], pos: i})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at position %d", i)
+			}
+			toks = append(toks, token{val: s[i+1 : i+1+end], quoted: true, pos: i})
+			i += end + 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, token{val: s[i:j], pos: i})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// Parser
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// peek returns the next token, or false if there are no tokens left.
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// accept consumes the next token if it is the given word, and reports whether it did.
+func (p *parser) accept(word string) bool {
+	if t, ok := p.peek(); ok && t.is(word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(word string) error {
+	if !p.accept(word) {
+		return p.errorf("expected %q", word)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if t, ok := p.peek(); ok {
+		return fmt.Errorf("%s at position %d", msg, t.pos)
+	}
+	return fmt.Errorf("%s at the end of the selection", msg)
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &binNode{left: left, right: right, or: true}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("expected a selection")
+	}
+	if t.quoted {
+		return nil, p.errorf("unexpected value %q", t.val)
+	}
+	p.pos++
+	word := strings.ToLower(t.val)
+	switch word {
+	case "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case "not":
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	case "within":
+		nt, ok := p.peek()
+		d, err := strconv.ParseFloat(nt.val, 64)
+		if !ok || err != nil || d < 0 {
+			return nil, p.errorf("expected a distance after within")
+		}
+		p.pos++
+		if err := p.expect("of"); err != nil {
+			return nil, err
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &withinNode{dist: d, sel: n}, nil
+	case "same":
+		var key func(at *chem.Atom) string
+		switch {
+		case p.accept("residue"):
+			key = func(at *chem.Atom) string {
+				return fmt.Sprintf("%s|%s|%d|%s", at.Chain, at.SegID, at.MolID, at.InsCode)
+			}
+		case p.accept("chain"):
+			key = func(at *chem.Atom) string { return at.Chain }
+		default:
+			return nil, p.errorf("expected residue or chain after same")
+		}
+		if err := p.expect("as"); err != nil {
+			return nil, err
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &sameNode{key: key, sel: n}, nil
+	case "bonded":
+		if err := p.expect("to"); err != nil {
+			return nil, err
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &bondedNode{n}, nil
+	case "resid", "index", "id":
+		return p.ranges(word)
+	}
+	if f, ok := keywordSel[word]; ok {
+		return atomNode(f), nil
+	}
+	if f, ok := stringFields[word]; ok {
+		vals := p.values()
+		if len(vals) == 0 {
+			return nil, p.errorf("expected values after %s", word)
+		}
+		for _, v := range vals {
+			if v == "" {
+				return nil, fmt.Errorf("empty value for %s", word)
+			}
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, fmt.Errorf("wrong pattern %q", v)
+			}
+		}
+		return atomNode(func(at *chem.Atom) bool {
+			s := f(at)
+			for _, v := range vals {
+				if m, _ := path.Match(v, s); m {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+	p.pos--
+	return nil, p.errorf("unknown keyword %q", t.val)
+}
+
+// values returns the values following a keyword, up to the next
+// and, or, or parenthesis, or the end of the selection.
+func (p *parser) values() []string {
+	var ret []string
+	for {
+		t, ok := p.peek()
+		if !ok || t.is("and") || t.is("or") || t.is("(") || t.is(")") {
+			return ret
+		}
+		ret = append(ret, t.val)
+		p.pos++
+	}
+}
+
+// ranges parses the numbers and ranges following the keyword field.
+func (p *parser) ranges(field string) (node, error) {
+	vals := p.values()
+	if len(vals) == 0 {
+		return nil, p.errorf("expected numbers after %s", field)
+	}
+	var rs [][2]int
+	for i := 0; i < len(vals); i++ {
+		v := vals[i]
+		if v == "" {
+			return nil, fmt.Errorf("empty number or range for %s", field)
+		}
+		var from, to int
+		var err, err2 error
+		if j := strings.Index(v[1:], ":"); j >= 0 { //v[1:] so we don't catch a leading minus sign.
+			from, err = strconv.Atoi(v[:j+1])
+			to, err2 = strconv.Atoi(v[j+2:])
+		} else if i+2 < len(vals) && strings.EqualFold(vals[i+1], "to") {
+			from, err = strconv.Atoi(v)
+			to, err2 = strconv.Atoi(vals[i+2])
+			i += 2
+		} else {
+			from, err = strconv.Atoi(v)
+			to = from
+		}
+		if err != nil || err2 != nil {
+			return nil, fmt.Errorf("wrong number or range for %s: %s", field, v)
+		}
+		rs = append(rs, [2]int{from, to})
+	}
+	return &rangeNode{field: field, ranges: rs}, nil
+}
+
+// Evaluation
+
+// env contains what is needed to evaluate a selection.
+type env struct {
+	mol    chem.Atomer
+	coords *v3.Matrix
+}
+
+// node is a part of a compiled selection. eval returns a slice with
+// the atoms of the Atomer that are selected set to true.
+type node interface {
+	eval(e *env) ([]bool, error)
+}
+
+// atomNode selects atoms based only on their own properties.
+type atomNode func(at *chem.Atom) bool
+
+func (n atomNode) eval(e *env) ([]bool, error) {
+	ret := make([]bool, e.mol.Len())
+	for i := range ret {
+		ret[i] = n(e.mol.Atom(i))
+	}
+	return ret, nil
+}
+
+type rangeNode struct {
+	field  string
+	ranges [][2]int
+}
+
+func (n *rangeNode) eval(e *env) ([]bool, error) {
+	ret := make([]bool, e.mol.Len())
+	for i := range ret {
+		var v int
+		switch n.field {
+		case "resid":
+			v = e.mol.Atom(i).MolID
+		case "id":
+			v = e.mol.Atom(i).ID
+		default:
+			v = i
+		}
+		for _, r := range n.ranges {
+			if v >= r[0] && v <= r[1] {
+				ret[i] = true
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+type binNode struct {
+	left, right node
+	or          bool
+}
+
+func (n *binNode) eval(e *env) ([]bool, error) {
+	l, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range r {
+		if n.or {
+			l[i] = l[i] || v
+		} else {
+			l[i] = l[i] && v
+		}
+	}
+	return l, nil
+}
+
+type notNode struct {
+	sel node
+}
+
+func (n *notNode) eval(e *env) ([]bool, error) {
+	s, err := n.sel.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range s {
+		s[i] = !v
+	}
+	return s, nil
+}
+
+type withinNode struct {
+	dist float64
+	sel  node
+}
+
+func (n *withinNode) eval(e *env) ([]bool, error) {
+	if e.coords == nil {
+		return nil, fmt.Errorf("within requires coordinates")
+	}
+	s, err := n.sel.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	//The atoms in the selection are selected. For the others (the candidates), we
+	//put them in a cell list and look for the ones close to each atom in the selection.
+	var ref, cand []int
+	for i, v := range s {
+		if v {
+			ref = append(ref, i)
+		} else {
+			cand = append(cand, i)
+		}
+	}
+	if len(ref) == 0 || len(cand) == 0 {
+		return s, nil
+	}
+	c := v3.Zeros(len(cand))
+	c.SomeVecs(e.coords, cand)
+	cutoff := n.dist
+	if cutoff == 0 {
+		cutoff = 1 //any positive value will do, the cell list only needs it to choose its cells.
+	}
+	cl, err := spatial.NewCellList(c, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range ref {
+		near, _ := cl.Within(e.coords.VecView(i), n.dist)
+		for _, j := range near {
+			s[cand[j]] = true
+		}
+	}
+	return s, nil
+}
+
+type sameNode struct {
+	key func(at *chem.Atom) string
+	sel node
+}
+
+func (n *sameNode) eval(e *env) ([]bool, error) {
+	s, err := n.sel.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for i, v := range s {
+		if v {
+			keys[n.key(e.mol.Atom(i))] = true
+		}
+	}
+	for i := range s {
+		s[i] = keys[n.key(e.mol.Atom(i))]
+	}
+	return s, nil
+}
+
+type bondedNode struct {
+	sel node
+}
+
+func (n *bondedNode) eval(e *env) ([]bool, error) {
+	s, err := n.sel.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	pos := make(map[*chem.Atom]int, e.mol.Len())
+	for i := 0; i < e.mol.Len(); i++ {
+		pos[e.mol.Atom(i)] = i
+	}
+	ret := make([]bool, len(s))
+	for i, v := range s {
+		if !v {
+			continue
+		}
+		at := e.mol.Atom(i)
+		for _, b := range at.Bonds {
+			other := b.At1
+			if other == at {
+				other = b.At2
+			}
+			if j, ok := pos[other]; ok && !s[j] {
+				ret[j] = true
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,150 @@
+/*
+ * selection_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package selection
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Two chains, a heme iron with one of its nitrogens and a water.
+const testPDB = `
+ATOM      1  N   ALA A   1       0.000   0.000   0.000  1.00  0.00           N
+ATOM      2  CA  ALA A   1       1.500   0.000   0.000  1.00  0.00           C
+ATOM      3  C   ALA A   1       2.500   1.000   0.000  1.00  0.00           C
+ATOM      4  O   ALA A   1       2.500   2.200   0.000  1.00  0.00           O
+ATOM      5  CB  ALA A   1       1.500  -1.500   0.000  1.00  0.00           C
+ATOM      6  HA  ALA A   1       1.500   0.000   1.000  1.00  0.00           H
+ATOM      7  N   GLY A   2       3.600   0.300   0.000  1.00  0.00           N
+ATOM      8  CA  GLY A   2       4.900   1.000   0.000  1.00  0.00           C
+ATOM      9  C   GLY A   2       6.100   0.000   0.000  1.00  0.00           C
+ATOM     10  O   GLY A   2       6.000  -1.200   0.000  1.00  0.00           O
+ATOM     11  N   ALA B   1      20.000   0.000   0.000  1.00  0.00           N
+ATOM     12  CA  ALA B   1      21.500   0.000   0.000  1.00  0.00           C
+HETATM   13  FE  HEM B 200       7.000   3.000   0.000  1.00  0.00          FE
+HETATM   14  NA  HEM B 200       8.500   3.000   0.000  1.00  0.00           N
+HETATM   15  O   HOH W 301      30.000  30.000  30.000  1.00  0.00           O
+CONECT   13   14
+END
+`
+
+func TestSelection(Te *testing.T) {
+	mol, err := chem.PDBRead(strings.NewReader(testPDB))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	cases := []struct {
+		sel  string
+		want []int
+	}{
+		{"chain A and resid 1 to 2 and name CA", []int{1, 7}},
+		{"name CA", []int{1, 7, 11}},
+		{"chain A and resid 1:1 and not hydrogen", []int{0, 1, 2, 3, 4}},
+		{"backbone and chain A", []int{0, 1, 2, 3, 6, 7, 8, 9}},
+		{"protein and not chain A", []int{10, 11}},
+		{"water", []int{14}},
+		{"hetero", []int{12, 13, 14}},
+		{"name C*", []int{1, 2, 4, 7, 8, 11}},
+		{"name 'HA' \"CB\"", []int{4, 5}},
+		{"NAME ca AND chain B", []int{}},
+		{"element N", []int{0, 6, 10, 13}},
+		{"within 3 of resname HEM", []int{7, 12, 13}},
+		{"within 3 of resname HEM and name CA", []int{7}},
+		{"same residue as index 7", []int{6, 7, 8, 9}},
+		{"same chain as name FE", []int{10, 11, 12, 13}},
+		{"bonded to name FE", []int{13}},
+		{"not (protein or water)", []int{12, 13}},
+		{"index 0 5:6 14 or id 12", []int{0, 5, 6, 11, 14}},
+		{"all", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}},
+		{"none", []int{}},
+	}
+	for _, c := range cases {
+		got, err := Select(mol, c.sel, mol.Coords[0])
+		if err != nil {
+			Te.Errorf("%s: %v", c.sel, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			Te.Errorf("%s: got %v, want %v", c.sel, got, c.want)
+		}
+	}
+	for _, s := range []string{"", "name", "resid a", "within of name CA", "chain A and", "(name CA", "foo", "name [", "name CA)", "same atom as name CA", "name 'CA", `resid ""`, `name ""`, "index ''"} {
+		if _, err := Compile(s); err == nil {
+			Te.Errorf("Invalid selection %q compiled", s)
+		}
+	}
+	//within requires coordinates.
+	if _, err := MustCompile("within 3 of name CA").Indexes(mol); err == nil {
+		Te.Errorf("within evaluated without coordinates")
+	}
+}
+
+// TestWithin compares within with the distances calculated for each pair of atoms.
+func TestWithin(Te *testing.T) {
+	const natoms = 300
+	r := rand.New(rand.NewSource(1))
+	mol := chem.NewTopology(0, 1)
+	coords := v3.Zeros(natoms)
+	for i := 0; i < natoms; i++ {
+		name := "C"
+		if i%10 == 0 {
+			name = "O"
+		}
+		mol.AppendAtom(&chem.Atom{Name: name, Symbol: name})
+		for j := 0; j < 3; j++ {
+			coords.Set(i, j, 20*r.Float64())
+		}
+		if i == 1 {
+			coords.SetVecs(coords.VecView(0), []int{1}) //so "within 0" selects something.
+		}
+	}
+	for _, d := range []float64{0, 1.5, 4, 100} {
+		got, err := Select(mol, fmt.Sprintf("within %g of name O", d), coords)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		var expected []int
+		for i := 0; i < natoms; i++ {
+			for j := 0; j < natoms; j += 10 {
+				diff := v3.Zeros(1)
+				diff.Sub(coords.VecView(i), coords.VecView(j))
+				if diff.Norm(2) <= d {
+					expected = append(expected, i)
+					break
+				}
+			}
+		}
+		if !slices.Equal(got, expected) {
+			Te.Errorf("within %g: got %v, expected %v", d, got, expected)
+		}
+	}
+}
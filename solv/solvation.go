@@ -32,6 +32,9 @@ import (
 	//	"sort"
 	//	"strconv"
 	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/selection"
+	"github.com/rmera/gochem/spatial"
+	"github.com/rmera/gochem/traj"
 	v3 "github.com/rmera/gochem/v3"
 	"gonum.org/v1/gonum/mat"
 )
@@ -65,11 +68,14 @@ func EllipsoidAxes(coords *v3.Matrix, epsilon float64, mol ...chem.Masser) ([]fl
 
 // Options contains options for the RDF/MDDF calculation
 type Options struct {
-	com  bool
-	cpus int
-	step float64
-	end  float64
-	skip int
+	com    bool
+	cpus   int
+	step   float64
+	end    float64
+	skip   int
+	box    *chem.Box
+	pbc    bool
+	solute string
 }
 
 // Returns a Options with the default options.
@@ -134,16 +140,69 @@ func (r *Options) End(end ...float64) float64 {
 	return ret
 }
 
+// Returns the periodic box used to obtain minimum-image distances, or nil, if
+// periodicity is not considered (the default). It sets the box, if one is given.
+func (r *Options) Box(box ...*chem.Box) *chem.Box {
+	ret := r.box
+	if len(box) > 0 {
+		r.box = box[0]
+	}
+	return ret
+}
+
+// Returns whether MolRDF reads the periodic box of each frame from the trajectory,
+// and sets the value to the one given, if any. Frames with no box information
+// use the box set with Box, if any. ConcMolRDF doesn't support this option.
+func (r *Options) PBC(pbc ...bool) bool {
+	ret := r.pbc
+	if len(pbc) > 0 {
+		r.pbc = pbc[0]
+	}
+	return ret
+}
+
+// Returns the atom selection (see the selection package) for the solute, and sets
+// it to a new value, if given. If the selection is not empty, MolRDF and ConcMolRDF
+// use it, evaluated on the first frame of the molecule, instead of the reference indexes given.
+func (r *Options) Solute(sel ...string) string {
+	ret := r.solute
+	if len(sel) > 0 {
+		r.solute = sel[0]
+	}
+	return ret
+}
+
+// soluteIndexes returns the indexes of the solute atoms, from the selection
+// in the options, if any, or refindexes, otherwise.
+func (r *Options) soluteIndexes(mol *chem.Molecule, refindexes []int) ([]int, error) {
+	if r.solute == "" {
+		return refindexes, nil
+	}
+	var coords *v3.Matrix
+	if len(mol.Coords) > 0 {
+		coords = mol.Coords[0]
+	}
+	return selection.Select(mol, r.solute, coords)
+}
+
 // ConcMolRDF calculates the RDF for a trajectory given the indexes of the solute atoms, the solvent molecule name, the step for the "layers" and the cutoff.
 // It processes several frames of the trajectory concurrently, depending on the logical CPUs available.
-// The code includes some extra comments, so it can be used as a template for concurrent trajectory processing.
-func ConcMolRDF(traj chem.ConcTraj, mol *chem.Molecule, refindexes []int, residues []string, options ...*Options) ([]float64, []float64, error) {
+// As the frames are read concurrently, the box is not read from the trajectory, so an error is returned
+// if the PBC option is set. To consider periodicity, set a box in the options with the Box method.
+func ConcMolRDF(t chem.ConcTraj, mol *chem.Molecule, refindexes []int, residues []string, options ...*Options) ([]float64, []float64, error) {
 	var o *Options
 	if len(options) > 0 {
 		o = options[0]
 	} else {
 		o = DefaultOptions()
 	}
+	if o.pbc {
+		return nil, nil, fmt.Errorf("ConcMolRDF: The box of each frame can't be read concurrently. Set a fixed box with the Box option instead of PBC")
+	}
+	refindexes, err := o.soluteIndexes(mol, refindexes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ConcMolRDF: %w", err)
+	}
 	A := 1.0
 	B := 1.0
 	if mol.Len() > 1 {
@@ -161,56 +220,29 @@ func ConcMolRDF(traj chem.ConcTraj, mol *chem.Molecule, refindexes []int, residu
 
 	}
 
+	//The frames are processed concurrently by traj.Parallel, which gives us the results for all the
+	//frames, in order (not that it matters here).
+	po := traj.DefaultParallelOptions()
+	po.Workers(o.cpus)
+	rdfs, err := traj.Parallel(t, func(frame int, coords *v3.Matrix) ([]float64, error) {
+		return FrameUMolCRDF(coords, mol, refindexes, residues, o), nil
+	}, po)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ConcMolRDF: %w", err)
+	}
 	var ret []float64
-	frames := make([]*v3.Matrix, o.cpus, o.cpus)
 	framesread := 0
-	for i, _ := range frames {
-		frames[i] = v3.Zeros(traj.Len())
-	}
-	results := make([]chan []float64, len(frames))
-	for i, _ := range results {
-		results[i] = make(chan []float64)
-	}
-	var err error
-	for i := 0; ; i++ {
-		if err != nil { //if we got a LastFrameError in the previous
-			break
-		}
-		coordchans, err := traj.NextConc(frames) //we get a slice of channels, each of which will receive a frame. They are sorted by the frame they receive.
-		if err != nil {
-			if err, ok := err.(chem.LastFrameError); ok {
-				if coordchans == nil {
-					break
-				}
-			} else {
-				if err, ok := err.(chem.Error); ok {
-					err.Decorate(fmt.Sprintf("ConcMolRDF, failed when reading the %d th frame", i))
-					return nil, nil, err
-				}
-				return nil, nil, err // somehow it wasn't a chem.Error.  This should never happen.
-			}
+	for _, rdf := range rdfs {
+		if len(rdf) == 0 {
+			continue
 		}
-		for key, channel := range coordchans {
-			go unitRDF(channel, results[key], mol, refindexes, residues, o) //we give each of the channels we got from NextConc to a gorutine "worker" that performs the analysis. We pass them a chan to transmit the results back.
+		if ret == nil {
+			ret = make([]float64, len(rdf))
 		}
-		//Here we go through the "results" channels, which are sorted by frame, so if we just iterate with a for, we'll get the results for the frames in the right order (not that it matters here).
-		for _, k := range results {
-			if k == nil {
-				break //shouldn't happen
-			}
-			rettemp := <-k
-			if len(rettemp) == 0 { //we ran out of frames
-				break
-			}
-			if ret == nil {
-				ret = make([]float64, len(rettemp))
-			}
-			for i, v := range ret {
-				ret[i] = v + rettemp[i]
-			}
-
-			framesread++
+		for i, v := range rdf {
+			ret[i] += v
 		}
+		framesread++
 	}
 	//fmt.Println(A, B) /////////////
 	ret, ret2, err := MDFFromCDF(ret, framesread, A, B, o.step)
@@ -218,18 +250,8 @@ func ConcMolRDF(traj chem.ConcTraj, mol *chem.Molecule, refindexes []int, residu
 }
 
 // The worker function for the RDF
-func unitRDF(channelin chan *v3.Matrix, channelout chan []float64, mol chem.Atomer, refindexes []int, residues []string, o *Options) {
-	if channelin != nil {
-		temp := <-channelin
-		rdf := FrameUMolCRDF(temp, mol, refindexes, residues, o)
-		channelout <- rdf
-	} else {
-		channelout <- nil
-	}
-	return
-}
-
 // MolRDF calculates the RDF for a trajectory given the indexes of the solute atoms, the solvent molecule name, the step for the "layers" and the cutoff.
+// If the PBC option is set, minimum-image distances are used, with the box of each frame.
 // API BREAK: mol used to be chem.Atomer.
 func MolRDF(traj chem.Traj, mol *chem.Molecule, refindexes []int, residues []string, options ...*Options) ([]float64, []float64, error) {
 	var o *Options
@@ -238,19 +260,32 @@ func MolRDF(traj chem.Traj, mol *chem.Molecule, refindexes []int, residues []str
 	} else {
 		o = DefaultOptions()
 	}
+	refindexes, err := o.soluteIndexes(mol, refindexes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("MolRDF: %w", err)
+	}
 	var ret []float64
 	coords := v3.Zeros(mol.Len())
 	framesread := 0
-	var err error
 	A := 1.0
 	B := 1.0
+	var boxv []float64
+	fo := *o //the options for each frame, which can have a different box.
+	if o.pbc {
+		boxv = make([]float64, 9)
+	}
 
 reading:
 	for i := 0; ; i++ {
 		if i > 0 && i%o.skip != 0 && err == nil {
 			err = traj.Next(nil) //if this err is not nil, the next traj.Next() will not be excecuted, whether it's a skip or a read. Instead, we'll go directly to error processing.
 			continue
-		} else if err == nil { //in case the frame we skipped before gave an error
+		} else if err == nil && boxv != nil { //in case the frame we skipped before gave an error
+			for j := range boxv {
+				boxv[j] = 0 //so a frame without box doesn't get the box of the previous one.
+			}
+			err = traj.Next(coords, boxv)
+		} else if err == nil {
 			err = traj.Next(coords)
 		}
 		if err != nil {
@@ -265,7 +300,13 @@ reading:
 
 			}
 		}
-		rdf := FrameUMolCRDF(coords, mol, refindexes, residues, o) ///only difference
+		if boxv != nil {
+			fo.box = o.box
+			if box, err := chem.NewBox(boxv); err == nil {
+				fo.box = box
+			}
+		}
+		rdf := FrameUMolCRDF(coords, mol, refindexes, residues, &fo) ///only difference
 		if ret == nil {
 			ret = make([]float64, len(rdf))
 		}
@@ -460,6 +501,8 @@ func repeated(id int, chain string, rac []*resAndChain) bool {
 // DistRank determines, for a reference set of coordinates and a set of residue names, the minimum distance between any atom from the reference
 // and any atom from each residue with one of the names given (or the centroid of each residue, if a variadic "com" bool is given)
 // returns a list with ID and distances, which satisfies the sort interface and has several other useful methods.
+// If a box is set in the options, minimum-image distances are used, and the solvent molecules split by the
+// box boundaries are put together before obtaining their centroids.
 func DistRank(coord *v3.Matrix, mol chem.Atomer, refindexes []int, residues []string, options ...*Options) MolDistList {
 	var o *Options
 	if len(options) > 0 {
@@ -481,9 +524,28 @@ func DistRank(coord *v3.Matrix, mol chem.Atomer, refindexes []int, residues []st
 	ref = v3.Zeros(len(refindexes))
 	ref.SomeVecs(coord, refindexes)
 	cutoff := o.end
-	cutoffplus := cutoff + 3
+	//The reference atoms go in a cell list, so we only compare each solvent atom with the reference
+	//atoms close to it. This replaced a pre-screening of waters by their distance to the first reference atom.
+	var boxv []float64
+	if o.box != nil {
+		boxv = o.box.Vectors()
+	}
+	cells, err := spatial.NewCellList(ref, cutoff, boxv)
+	if err != nil {
+		log.Printf("gochem/solv/DistRank Couldn't index the reference atoms: %s\n", err.Error())
+		return MolDistList(ranks)
+	}
+	//shortest returns the distance between the closest atoms of test and the reference, or +Inf if none is within the cutoff.
+	shortest := func(test *v3.Matrix) float64 {
+		d := math.Inf(1)
+		for k := 0; k < test.NVecs(); k++ {
+			if _, dists := cells.Within(test.VecView(k), cutoff); len(dists) > 0 && dists[0] < d {
+				d = dists[0]
+			}
+		}
+		return d
+	}
 	//	chunk := NewTopology(0, 1)
-	tmp := v3.Zeros(1)
 	//	fmt.Println("Start looking!") ///////////////////
 	for i := 0; i < mol.Len(); i++ {
 		at := mol.Atom(i)
@@ -491,11 +553,6 @@ func DistRank(coord *v3.Matrix, mol chem.Atomer, refindexes []int, residues []st
 		id = at.MolID
 		chain = at.Chain
 		var test *v3.Matrix
-		//a little pre-screening for waters
-		if isInString([]string{"SOL", "WAT", "HOH"}, molname) && dist(ref.VecView(0), coord.VecView(i), tmp) > cutoffplus {
-			continue
-
-		}
 		if isInString(residues, molname) && !repeated(id, chain, ownresIDs) && (id != molid_skip || chain != chain_skip) {
 			expectedreslen := 6
 			indexes := make([]int, 1, expectedreslen)
@@ -514,6 +571,9 @@ func DistRank(coord *v3.Matrix, mol chem.Atomer, refindexes []int, residues []st
 				test = v3.Zeros(len(indexes))
 			}
 			test.SomeVecs(coord, indexes)
+			if o.box != nil {
+				makeWhole(test, o.box)
+			}
 			//This is a bit ugly
 			if o.com == true {
 				var mass *mat.Dense
@@ -527,11 +587,11 @@ func DistRank(coord *v3.Matrix, mol chem.Atomer, refindexes []int, residues []st
 				if err != nil { //this really is very unlikely to fail. The ref matrix would have to be wrong. It could even deserve a panic.
 					o.com = false //if it fails, we don't try again, for consistency. Any previous successful COM use will not comparable with numbers used from now on.
 					log.Printf("gochem/solv/DistRank Couldn't obtain the COM/centroid: %s. Will work with all solvent atoms\n", err.Error())
-					distance = MolShortestDist(test, ref) //we don't panic, we just keep going with all atoms
+					distance = shortest(test) //we don't panic, we just keep going with all atoms
 				}
-				distance = MolShortestDist(c, ref)
+				distance = shortest(c)
 			} else {
-				distance = MolShortestDist(test, ref)
+				distance = shortest(test)
 			}
 			if distance <= cutoff {
 				ranks = append(ranks, &molDist{Distance: distance, MolID: id})
@@ -645,10 +705,10 @@ func (M MolDistList) Merge(list ...MolDistList) {
 }
 
 // MolShortestDistGiven two sets of coordinates, it obtains the distance between the two closest atoms
-// in the 2 sets.
+// in the 2 sets. If a box is given, minimum-image distances are used.
 // This is probably not a very efficient way to do it.
 // Note:This should probably be moved to the main gochem package, in geometric.go
-func MolShortestDist(test, ref *v3.Matrix) float64 {
+func MolShortestDist(test, ref *v3.Matrix, box ...*chem.Box) float64 {
 	temp := v3.Zeros(1)
 	var d1, dclosest float64
 	var vt1, vr1 *v3.Matrix // vtclosest,vr1, vrclosest *v3.Matrix
@@ -658,8 +718,12 @@ func MolShortestDist(test, ref *v3.Matrix) float64 {
 		vt1 = test.VecView(i)
 		for j := 0; j < ref.NVecs(); j++ {
 			vr1 = ref.VecView(j)
-			temp.Sub(vr1, vt1)
-			d1 = temp.Norm(2)
+			if len(box) > 0 && box[0] != nil {
+				d1 = box[0].Distance(vr1, vt1)
+			} else {
+				temp.Sub(vr1, vt1)
+				d1 = temp.Norm(2)
+			}
 			if d1 < dclosest {
 				dclosest = d1
 			}
@@ -673,6 +737,18 @@ func dist(r, t, temp *v3.Matrix) float64 {
 	return temp.Norm(2)
 }
 
+// makeWhole places each atom in test at the periodic image closest to the first atom.
+func makeWhole(test *v3.Matrix, box *chem.Box) {
+	first := test.VecView(0)
+	d := v3.Zeros(1)
+	for i := 1; i < test.NVecs(); i++ {
+		v := test.VecView(i)
+		d.Sub(v, first)
+		box.MinImage(d)
+		v.Add(first, d)
+	}
+}
+
 //NOTE: These will be replaced when the generic funcions
 //make it to Go's stdlib.
 
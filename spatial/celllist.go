@@ -0,0 +1,264 @@
+/*
+ * celllist.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package spatial
+
+import (
+	"fmt"
+	"math"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// CellList is a spatial index that divides space in cells with sides no shorter than
+// a cutoff, so the points within the cutoff of any given point are in the same
+// cell as the point or in the neighboring ones. Queries with distances larger than
+// the cutoff are supported, but slower. A CellList can consider periodic boundary conditions.
+type CellList struct {
+	coords []float64 //3 per point.
+	n      [3]int    //number of cells along each direction.
+	origin [3]float64
+	width  [3]float64 //the sides of the cells. In fractional coordinates for periodic systems.
+	height [3]float64 //the distances between opposite faces of a cell.
+	head   []int      //the first point in each cell, or -1.
+	next   []int      //the next point in the same cell as each point, or -1.
+	pbc    *Periodic
+}
+
+// maxCellsPerPoint limits the number of cells for sparse systems and small cutoffs.
+const maxCellsPerPoint = 8
+
+// NewCellList returns a cell list for the points in coords, with cells
+// appropriate for queries up to the distance cutoff. If box vectors are given
+// (9 numbers, the vectors a, b and c, one after the other, as in goChem trajectories),
+// periodic boundary conditions are used and all distances are minimum-image ones.
+// Points outside the box are put in it.
+func NewCellList(coords *v3.Matrix, cutoff float64, box ...[]float64) (*CellList, error) {
+	if cutoff <= 0 || math.IsNaN(cutoff) {
+		return nil, fmt.Errorf("NewCellList: the cutoff must be positive, got %f", cutoff)
+	}
+	C := &CellList{coords: flatCoords(coords)}
+	npoints := len(C.coords) / 3
+	maxcells := max(1, maxCellsPerPoint*npoints)
+	var extent [3]float64
+	if len(box) > 0 && box[0] != nil {
+		var err error
+		C.pbc, err = NewPeriodic(box[0])
+		if err != nil {
+			return nil, fmt.Errorf("NewCellList: %w", err)
+		}
+		extent = C.pbc.Heights()
+	} else {
+		for d := 0; d < 3; d++ {
+			lo, hi := math.Inf(1), math.Inf(-1)
+			for i := d; i < len(C.coords); i += 3 {
+				lo = math.Min(lo, C.coords[i])
+				hi = math.Max(hi, C.coords[i])
+			}
+			if npoints == 0 {
+				lo, hi = 0, 0
+			}
+			C.origin[d] = lo
+			extent[d] = hi - lo
+		}
+	}
+	for d := 0; d < 3; d++ {
+		C.n[d] = max(1, int(extent[d]/cutoff))
+	}
+	//For sparse systems we use fewer, larger cells.
+	for C.n[0]*C.n[1]*C.n[2] > maxcells {
+		for d := range C.n {
+			C.n[d] = max(1, C.n[d]/2)
+		}
+	}
+	for d := 0; d < 3; d++ {
+		C.height[d] = extent[d] / float64(C.n[d])
+		C.width[d] = C.height[d]
+		if C.pbc != nil {
+			C.width[d] = 1 / float64(C.n[d])
+		}
+		if C.width[d] == 0 {
+			C.width[d] = 1 //all points have the same coordinate along d, so there is only one cell.
+		}
+	}
+	C.head = make([]int, C.n[0]*C.n[1]*C.n[2])
+	for i := range C.head {
+		C.head[i] = -1
+	}
+	C.next = make([]int, npoints)
+	for i := 0; i < npoints; i++ {
+		c := C.cellCoords(C.coords[3*i : 3*i+3])
+		k := C.cellIndex(c)
+		C.next[i] = C.head[k]
+		C.head[k] = i
+	}
+	return C, nil
+}
+
+// Len returns the number of points in the cell list.
+func (C *CellList) Len() int {
+	return len(C.next)
+}
+
+// grid returns the position of the point p in the grid, in units of cells
+// (the integer part is the cell).
+func (C *CellList) grid(p []float64) [3]float64 {
+	var ret [3]float64
+	if C.pbc != nil {
+		C.pbc.Fractional(p, ret[:])
+		for d := range ret {
+			ret[d] = (ret[d] - math.Floor(ret[d])) / C.width[d]
+		}
+		return ret
+	}
+	for d := range ret {
+		ret[d] = (p[d] - C.origin[d]) / C.width[d]
+	}
+	return ret
+}
+
+// cellCoords returns the cell where the point p is (or the closest one, for
+// points outside the space covered by the cells).
+func (C *CellList) cellCoords(p []float64) [3]int {
+	g := C.grid(p)
+	var ret [3]int
+	for d := range ret {
+		ret[d] = min(max(0, int(math.Floor(g[d]))), C.n[d]-1)
+	}
+	return ret
+}
+
+func (C *CellList) cellIndex(c [3]int) int {
+	return (c[0]*C.n[1]+c[1])*C.n[2] + c[2]
+}
+
+// distance returns the distance between p and the point j in the list.
+func (C *CellList) distance(p []float64, j int) float64 {
+	q := C.coords[3*j : 3*j+3]
+	if C.pbc != nil {
+		return C.pbc.Distance(p, q)
+	}
+	dx, dy, dz := q[0]-p[0], q[1]-p[1], q[2]-p[2]
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// visit calls f for each point at a distance r or less from p. It returns true if
+// all the cells were searched.
+func (C *CellList) visit(p []float64, r float64, f func(j int, d float64)) bool {
+	var ranges [3][]int
+	all := true
+	g := C.grid(p)
+	for d := 0; d < 3; d++ {
+		reach := r / C.height[d] //in cells. The comparisons are done with floats, as r can be infinite.
+		if C.pbc != nil {
+			//If the 2k+1 cells around the home one wrap around the box, some of them
+			//would be visited twice, so we just visit each cell once.
+			k := math.Ceil(reach)
+			if 2*k+1 >= float64(C.n[d]) {
+				for c := 0; c < C.n[d]; c++ {
+					ranges[d] = append(ranges[d], c)
+				}
+				continue
+			}
+			all = false
+			home := min(int(g[d]), C.n[d]-1)
+			for c := home - int(k); c <= home+int(k); c++ {
+				ranges[d] = append(ranges[d], ((c%C.n[d])+C.n[d])%C.n[d])
+			}
+			continue
+		}
+		lo, hi := 0, C.n[d]-1
+		if l := g[d] - reach; l >= float64(hi) {
+			lo, all = hi, false
+		} else if l > 0 {
+			lo, all = int(l), false
+		}
+		if h := g[d] + reach; h < 0 {
+			hi, all = 0, false
+		} else if h < float64(hi) {
+			hi, all = int(h), false
+		}
+		for c := lo; c <= hi; c++ {
+			ranges[d] = append(ranges[d], c)
+		}
+	}
+	for _, a := range ranges[0] {
+		for _, b := range ranges[1] {
+			for _, c := range ranges[2] {
+				for j := C.head[C.cellIndex([3]int{a, b, c})]; j >= 0; j = C.next[j] {
+					if d := C.distance(p, j); d <= r {
+						f(j, d)
+					}
+				}
+			}
+		}
+	}
+	return all
+}
+
+// Within returns the indexes of the points at a distance r or less
+// from point, and the distances, sorted by distance.
+func (C *CellList) Within(point *v3.Matrix, r float64) ([]int, []float64) {
+	p := pointOf(point)
+	n := new(neighbors)
+	C.visit(p[:], r, n.add)
+	return n.sorted()
+}
+
+// Nearest returns the indexes of the k points closest to point, and their
+// distances, sorted by distance.
+func (C *CellList) Nearest(point *v3.Matrix, k int) ([]int, []float64) {
+	if k <= 0 || C.Len() == 0 {
+		return nil, nil
+	}
+	p := pointOf(point)
+	r := math.Max(C.height[0], math.Max(C.height[1], C.height[2]))
+	if r == 0 {
+		r = 1
+	}
+	for {
+		n := new(neighbors)
+		all := C.visit(p[:], r, n.add)
+		//The k closest points must be among those within r, if there are k of them.
+		if n.Len() >= k || math.IsInf(r, 1) {
+			idx, dists := n.sorted()
+			k = min(k, len(idx))
+			return idx[:k], dists[:k]
+		}
+		r *= 2
+		if all {
+			r = math.Inf(1) //all cells are searched anyway, so we just take every point.
+		}
+	}
+}
+
+// Pairs returns all the pairs of points at a distance cutoff or less from each other,
+// sorted by their first, then second, index. The cutoff can be larger than the one used
+// to build the cell list, but that makes the search slower.
+func (C *CellList) Pairs(cutoff float64) []Pair {
+	return pairsFrom(C.Len(), func(i int, f func(j int, d float64)) {
+		C.visit(C.coords[3*i:3*i+3], cutoff, f)
+	})
+}
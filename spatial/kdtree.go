@@ -0,0 +1,238 @@
+/*
+ * kdtree.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package spatial
+
+import (
+	"container/heap"
+	"math"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// leafSize is the number of points below which the tree is not split further.
+const leafSize = 8
+
+// KDTree is a k-d tree (with k=3) spatial index. It does not support periodic
+// boundary conditions.
+type KDTree struct {
+	coords []float64 //3 per point.
+	idx    []int     //the points, ordered so each subtree is a contiguous range.
+	axes   []int8    //the axis along which each range [lo,hi) is split, stored at its middle.
+}
+
+// NewKDTree returns a k-d tree for the points in coords.
+func NewKDTree(coords *v3.Matrix) *KDTree {
+	T := &KDTree{coords: flatCoords(coords)}
+	n := len(T.coords) / 3
+	T.idx = make([]int, n)
+	for i := range T.idx {
+		T.idx[i] = i
+	}
+	T.axes = make([]int8, n)
+	T.build(0, n)
+	return T
+}
+
+// Len returns the number of points in the tree.
+func (T *KDTree) Len() int {
+	return len(T.idx)
+}
+
+func (T *KDTree) coord(i, axis int) float64 {
+	return T.coords[3*T.idx[i]+axis]
+}
+
+// build splits the range [lo,hi) of points along the direction with the largest spread,
+// so the middle point is the median, and does the same with the two halves.
+func (T *KDTree) build(lo, hi int) {
+	if hi-lo <= leafSize {
+		return
+	}
+	axis, spread := 0, -1.0
+	for d := 0; d < 3; d++ {
+		mn, mx := math.Inf(1), math.Inf(-1)
+		for i := lo; i < hi; i++ {
+			mn = math.Min(mn, T.coord(i, d))
+			mx = math.Max(mx, T.coord(i, d))
+		}
+		if mx-mn > spread {
+			axis, spread = d, mx-mn
+		}
+	}
+	mid := (lo + hi) / 2
+	T.selectNth(lo, hi, mid, axis)
+	T.axes[mid] = int8(axis)
+	T.build(lo, mid)
+	T.build(mid+1, hi)
+}
+
+// selectNth reorders the points in [lo,hi) so the point n is the one that would be there
+// if the range were sorted along axis, with no larger points before it and no smaller ones after it.
+func (T *KDTree) selectNth(lo, hi, n, axis int) {
+	hi--
+	for lo < hi {
+		pivot := T.coord((lo+hi)/2, axis)
+		i, j := lo, hi
+		for i <= j {
+			for T.coord(i, axis) < pivot {
+				i++
+			}
+			for T.coord(j, axis) > pivot {
+				j--
+			}
+			if i <= j {
+				T.idx[i], T.idx[j] = T.idx[j], T.idx[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+func (T *KDTree) distance(p []float64, i int) float64 {
+	q := T.coords[3*T.idx[i] : 3*T.idx[i]+3]
+	dx, dy, dz := q[0]-p[0], q[1]-p[1], q[2]-p[2]
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// visit calls f for each point in [lo,hi) at a distance r or less from p.
+func (T *KDTree) visit(p []float64, r float64, lo, hi int, f func(j int, d float64)) {
+	if hi-lo <= leafSize {
+		for i := lo; i < hi; i++ {
+			if d := T.distance(p, i); d <= r {
+				f(T.idx[i], d)
+			}
+		}
+		return
+	}
+	mid := (lo + hi) / 2
+	axis := int(T.axes[mid])
+	if d := T.distance(p, mid); d <= r {
+		f(T.idx[mid], d)
+	}
+	diff := p[axis] - T.coord(mid, axis)
+	if diff <= r {
+		T.visit(p, r, lo, mid, f)
+	}
+	if diff >= -r {
+		T.visit(p, r, mid+1, hi, f)
+	}
+}
+
+// Within returns the indexes of the points at a distance r or less
+// from point, and the distances, sorted by distance.
+func (T *KDTree) Within(point *v3.Matrix, r float64) ([]int, []float64) {
+	p := pointOf(point)
+	n := new(neighbors)
+	T.visit(p[:], r, 0, T.Len(), n.add)
+	return n.sorted()
+}
+
+// Nearest returns the indexes of the k points closest to point, and their
+// distances, sorted by distance.
+func (T *KDTree) Nearest(point *v3.Matrix, k int) ([]int, []float64) {
+	if k <= 0 {
+		return nil, nil
+	}
+	p := pointOf(point)
+	h := &maxHeap{}
+	T.nearest(p[:], k, 0, T.Len(), h)
+	n := &neighbors{idx: h.idx, dists: h.dists}
+	return n.sorted()
+}
+
+func (T *KDTree) nearest(p []float64, k, lo, hi int, h *maxHeap) {
+	consider := func(i int) {
+		d := T.distance(p, i)
+		if h.Len() < k {
+			heap.Push(h, [2]float64{d, float64(T.idx[i])})
+		} else if d < h.dists[0] {
+			h.dists[0], h.idx[0] = d, T.idx[i]
+			heap.Fix(h, 0)
+		}
+	}
+	if hi-lo <= leafSize {
+		for i := lo; i < hi; i++ {
+			consider(i)
+		}
+		return
+	}
+	mid := (lo + hi) / 2
+	axis := int(T.axes[mid])
+	consider(mid)
+	diff := p[axis] - T.coord(mid, axis)
+	near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+	if diff > 0 {
+		near, far = far, near
+	}
+	T.nearest(p, k, near[0], near[1], h)
+	if h.Len() < k || math.Abs(diff) < h.dists[0] {
+		T.nearest(p, k, far[0], far[1], h)
+	}
+}
+
+// Pairs returns all the pairs of points at a distance cutoff or less from each other,
+// sorted by their first, then second, index.
+func (T *KDTree) Pairs(cutoff float64) []Pair {
+	return pairsFrom(T.Len(), func(i int, f func(j int, d float64)) {
+		T.visit(T.coords[3*i:3*i+3], cutoff, 0, T.Len(), f)
+	})
+}
+
+// maxHeap keeps the k nearest points found so far, with the farthest one on top.
+type maxHeap struct {
+	idx   []int
+	dists []float64
+}
+
+func (h *maxHeap) Len() int           { return len(h.idx) }
+func (h *maxHeap) Less(i, j int) bool { return h.dists[i] > h.dists[j] }
+func (h *maxHeap) Swap(i, j int) {
+	h.idx[i], h.idx[j] = h.idx[j], h.idx[i]
+	h.dists[i], h.dists[j] = h.dists[j], h.dists[i]
+}
+
+// Push takes a [2]float64 with the distance and the index of the point.
+func (h *maxHeap) Push(x interface{}) {
+	v := x.([2]float64)
+	h.dists = append(h.dists, v[0])
+	h.idx = append(h.idx, int(v[1]))
+}
+
+func (h *maxHeap) Pop() interface{} {
+	n := len(h.idx) - 1
+	v := [2]float64{h.dists[n], float64(h.idx[n])}
+	h.idx, h.dists = h.idx[:n], h.dists[:n]
+	return v
+}
@@ -0,0 +1,172 @@
+/*
+ * periodic.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package spatial
+
+import (
+	"fmt"
+	"math"
+)
+
+// Periodic contains the vectors of a periodic box, orthorhombic or triclinic,
+// and implements the basic operations needed for periodic boundary conditions.
+// Most users will want the Box type of the chem package, which builds on this one.
+type Periodic struct {
+	vecs  [3][3]float64 //the box vectors, as rows.
+	inv   [3][3]float64 //the inverse of vecs, to get fractional coordinates.
+	ortho bool
+}
+
+// NewPeriodic returns a Periodic with the box vectors a, b and c, given one after
+// the other in vecs, as in the box slices filled by goChem trajectories.
+// It returns an error if vecs has less than 9 elements or if the vectors
+// don't span a volume.
+func NewPeriodic(vecs []float64) (*Periodic, error) {
+	if len(vecs) < 9 {
+		return nil, fmt.Errorf("NewPeriodic: box vectors need 9 elements, got %d", len(vecs))
+	}
+	P := new(Periodic)
+	for i := 0; i < 3; i++ {
+		copy(P.vecs[i][:], vecs[3*i:3*i+3])
+	}
+	v := P.vecs
+	det := P.det()
+	if math.Abs(det) <= 1e-9 {
+		return nil, fmt.Errorf("NewPeriodic: box vectors don't span a volume")
+	}
+	//The inverse is the transposed cofactor matrix divided by the determinant.
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			i1, i2 := (j+1)%3, (j+2)%3
+			j1, j2 := (i+1)%3, (i+2)%3
+			P.inv[i][j] = (v[i1][j1]*v[i2][j2] - v[i1][j2]*v[i2][j1]) / det
+		}
+	}
+	P.ortho = v[0][1] == 0 && v[0][2] == 0 && v[1][0] == 0 && v[1][2] == 0 && v[2][0] == 0 && v[2][1] == 0
+	return P, nil
+}
+
+func (P *Periodic) det() float64 {
+	v := P.vecs
+	return v[0][0]*(v[1][1]*v[2][2]-v[1][2]*v[2][1]) -
+		v[0][1]*(v[1][0]*v[2][2]-v[1][2]*v[2][0]) +
+		v[0][2]*(v[1][0]*v[2][1]-v[1][1]*v[2][0])
+}
+
+// Vectors returns the box vectors a, b and c, one after the other.
+func (P *Periodic) Vectors() []float64 {
+	ret := make([]float64, 0, 9)
+	for _, v := range P.vecs {
+		ret = append(ret, v[:]...)
+	}
+	return ret
+}
+
+// Orthorhombic returns true if the box vectors are along the x, y and z axes.
+func (P *Periodic) Orthorhombic() bool {
+	return P.ortho
+}
+
+// Volume returns the volume of the box.
+func (P *Periodic) Volume() float64 {
+	return math.Abs(P.det())
+}
+
+// Heights returns the distances between the opposite faces of the box. The first one
+// is the distance between the faces spanned by b and c, and so on. For orthorhombic
+// boxes, these are just the lengths of the box vectors.
+func (P *Periodic) Heights() [3]float64 {
+	var ret [3]float64
+	for i := range ret {
+		//The rows of the inverse transposed are the reciprocal vectors,
+		//which are normal to the faces, with norms 1/height.
+		n := P.inv[0][i]*P.inv[0][i] + P.inv[1][i]*P.inv[1][i] + P.inv[2][i]*P.inv[2][i]
+		ret[i] = 1 / math.Sqrt(n)
+	}
+	return ret
+}
+
+// Fractional puts in f the fractional coordinates of the point r.
+func (P *Periodic) Fractional(r, f []float64) {
+	var t [3]float64
+	for j := 0; j < 3; j++ {
+		t[j] = r[0]*P.inv[0][j] + r[1]*P.inv[1][j] + r[2]*P.inv[2][j]
+	}
+	copy(f, t[:])
+}
+
+// Cartesian puts in r the cartesian coordinates of the point with fractional coordinates f.
+func (P *Periodic) Cartesian(f, r []float64) {
+	var t [3]float64
+	for j := 0; j < 3; j++ {
+		t[j] = f[0]*P.vecs[0][j] + f[1]*P.vecs[1][j] + f[2]*P.vecs[2][j]
+	}
+	copy(r, t[:])
+}
+
+// MinImage replaces the vector d, the difference between two positions, with its
+// shortest periodic image.
+func (P *Periodic) MinImage(d []float64) {
+	if P.ortho {
+		for j := 0; j < 3; j++ {
+			d[j] -= P.vecs[j][j] * math.Round(d[j]/P.vecs[j][j])
+		}
+		return
+	}
+	var f [3]float64
+	P.Fractional(d, f[:])
+	for j := range f {
+		f[j] -= math.Round(f[j])
+	}
+	P.Cartesian(f[:], d)
+	//For skewed boxes, the image closest in fractional coordinates is not always
+	//the shortest one, but the shortest one is among its neighbors.
+	best := d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+	var bestv [3]float64
+	copy(bestv[:], d)
+	for i := -1.0; i <= 1; i++ {
+		for j := -1.0; j <= 1; j++ {
+			for k := -1.0; k <= 1; k++ {
+				var t [3]float64
+				sq := 0.0
+				for l := 0; l < 3; l++ {
+					t[l] = d[l] + i*P.vecs[0][l] + j*P.vecs[1][l] + k*P.vecs[2][l]
+					sq += t[l] * t[l]
+				}
+				if sq < best {
+					best, bestv = sq, t
+				}
+			}
+		}
+	}
+	copy(d, bestv[:])
+}
+
+// Distance returns the minimum-image distance between the points a and b.
+func (P *Periodic) Distance(a, b []float64) float64 {
+	d := []float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}
+	P.MinImage(d)
+	return math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+}
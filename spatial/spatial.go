@@ -0,0 +1,126 @@
+/*
+ * spatial.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package spatial implements spatial indexes, which allow finding the atoms close
+// to a point, or all the pairs of atoms closer than a cutoff, without
+// going over all the pairs of atoms. This is what makes the analysis of large
+// systems possible.
+//
+// Two indexes are provided: cell lists (CellList), which support periodic boundary
+// conditions and work best for dense systems and short cutoffs, as in bond assignment,
+// and k-d trees (KDTree), which work for any distribution of points and cutoff, but
+// not for periodic systems.
+//
+// The package only depends on the v3 package, so it can be used by goChem itself.
+package spatial
+
+import (
+	"sort"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Pair is a pair of points, by index, and the distance between them.
+type Pair struct {
+	I, J int //I < J
+	Dist float64
+}
+
+// Index is a spatial index over a set of points, normally, the coordinates of a molecule.
+// Points are identified by their index in the set.
+type Index interface {
+	//Within returns the indexes of the points at a distance r or less
+	//from point, and the distances, sorted by distance.
+	Within(point *v3.Matrix, r float64) ([]int, []float64)
+
+	//Nearest returns the indexes of the k points closest to point, and their
+	//distances, sorted by distance. Fewer than k points are returned only if
+	//the set has fewer than k points.
+	Nearest(point *v3.Matrix, k int) ([]int, []float64)
+
+	//Pairs returns all the pairs of points at a distance cutoff or less from each other,
+	//sorted by their first, then second, index.
+	Pairs(cutoff float64) []Pair
+
+	//Len returns the number of points in the index.
+	Len() int
+}
+
+// flatCoords returns the coordinates in c as a slice, 3 elements per point.
+func flatCoords(c *v3.Matrix) []float64 {
+	n := c.NVecs()
+	ret := make([]float64, 0, 3*n)
+	for i := 0; i < n; i++ {
+		ret = append(ret, c.At(i, 0), c.At(i, 1), c.At(i, 2))
+	}
+	return ret
+}
+
+// pointOf returns the first vector in p as an array.
+func pointOf(p *v3.Matrix) [3]float64 {
+	return [3]float64{p.At(0, 0), p.At(0, 1), p.At(0, 2)}
+}
+
+// neighbors is a list of points and their distances to some reference, which
+// can be sorted by distance.
+type neighbors struct {
+	idx   []int
+	dists []float64
+}
+
+func (n *neighbors) add(i int, d float64) {
+	n.idx = append(n.idx, i)
+	n.dists = append(n.dists, d)
+}
+
+func (n *neighbors) Len() int           { return len(n.idx) }
+func (n *neighbors) Less(i, j int) bool { return n.dists[i] < n.dists[j] || (n.dists[i] == n.dists[j] && n.idx[i] < n.idx[j]) }
+func (n *neighbors) Swap(i, j int) {
+	n.idx[i], n.idx[j] = n.idx[j], n.idx[i]
+	n.dists[i], n.dists[j] = n.dists[j], n.dists[i]
+}
+
+// sorted sorts the neighbors by distance and returns them.
+func (n *neighbors) sorted() ([]int, []float64) {
+	sort.Sort(n)
+	return n.idx, n.dists
+}
+
+// pairsFrom returns the pairs of points in an index closer than cutoff, using the
+// function within, which must report each point within the cutoff of the point i.
+func pairsFrom(n int, within func(i int, f func(j int, d float64))) []Pair {
+	var ret []Pair
+	for i := 0; i < n; i++ {
+		first := len(ret)
+		within(i, func(j int, d float64) {
+			if j > i {
+				ret = append(ret, Pair{I: i, J: j, Dist: d})
+			}
+		})
+		p := ret[first:]
+		sort.Slice(p, func(a, b int) bool { return p[a].J < p[b].J })
+	}
+	return ret
+}
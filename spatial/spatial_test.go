@@ -0,0 +1,182 @@
+/*
+ * spatial_test.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package spatial
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// bruteWithin returns the points within r of p, sorted by distance, going over all the points.
+func bruteWithin(coords *v3.Matrix, p []float64, r float64, pbc *Periodic) ([]int, []float64) {
+	n := new(neighbors)
+	for j := 0; j < coords.NVecs(); j++ {
+		q := []float64{coords.At(j, 0), coords.At(j, 1), coords.At(j, 2)}
+		var d float64
+		if pbc != nil {
+			d = pbc.Distance(p, q)
+		} else {
+			d = math.Sqrt((q[0]-p[0])*(q[0]-p[0]) + (q[1]-p[1])*(q[1]-p[1]) + (q[2]-p[2])*(q[2]-p[2]))
+		}
+		if d <= r {
+			n.add(j, d)
+		}
+	}
+	return n.sorted()
+}
+
+func TestIndexes(Te *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const natoms = 2000
+	coords := v3.Zeros(natoms)
+	for i := 0; i < natoms; i++ {
+		for j := 0; j < 3; j++ {
+			coords.Set(i, j, rng.Float64()*30)
+		}
+	}
+	cl, err := NewCellList(coords, 3)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	//A triclinic box, with the points not always inside it.
+	tric := []float64{30, 0, 0, 5, 28, 0, -6, 4, 27}
+	pbc, _ := NewPeriodic(tric)
+	ptric, err := NewCellList(coords, 3, tric)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	portho, err := NewCellList(coords, 4, []float64{30, 0, 0, 0, 30, 0, 0, 0, 30})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	portho30, _ := NewPeriodic([]float64{30, 0, 0, 0, 30, 0, 0, 0, 30})
+	cases := []struct {
+		name  string
+		index Index
+		pbc   *Periodic
+	}{
+		{"cell list", cl, nil},
+		{"k-d tree", NewKDTree(coords), nil},
+		{"triclinic cell list", ptric, pbc},
+		{"orthorhombic cell list", portho, portho30},
+	}
+	point := v3.Zeros(1)
+	for _, c := range cases {
+		if c.index.Len() != natoms {
+			Te.Errorf("%s: wrong number of points: %d", c.name, c.index.Len())
+		}
+		for q := 0; q < 30; q++ {
+			p := []float64{rng.Float64()*40 - 5, rng.Float64()*40 - 5, rng.Float64()*40 - 5}
+			point.Set(0, 0, p[0])
+			point.Set(0, 1, p[1])
+			point.Set(0, 2, p[2])
+			r := []float64{1, 3, 7}[q%3]
+			got, gotd := c.index.Within(point, r)
+			want, wantd := bruteWithin(coords, p, r, c.pbc)
+			if fmt.Sprint(got) != fmt.Sprint(want) || fmt.Sprint(gotd) != fmt.Sprint(wantd) {
+				Te.Errorf("%s: Within(%v, %f) gave %v, want %v", c.name, p, r, got, want)
+			}
+			k := []int{1, 5, 20}[q%3]
+			got, gotd = c.index.Nearest(point, k)
+			want, wantd = bruteWithin(coords, p, math.Inf(1), c.pbc)
+			if fmt.Sprint(got) != fmt.Sprint(want[:k]) || fmt.Sprint(gotd) != fmt.Sprint(wantd[:k]) {
+				Te.Errorf("%s: Nearest(%v, %d) gave %v, want %v", c.name, p, k, got, want[:k])
+			}
+		}
+		pairs := c.index.Pairs(2)
+		var want []Pair
+		for i := 0; i < natoms; i++ {
+			idx, dists := bruteWithin(coords, []float64{coords.At(i, 0), coords.At(i, 1), coords.At(i, 2)}, 2, c.pbc)
+			for k, j := range idx {
+				if j > i {
+					want = append(want, Pair{I: i, J: j, Dist: dists[k]})
+				}
+			}
+		}
+		sort.Slice(want, func(a, b int) bool { return want[a].I < want[b].I || (want[a].I == want[b].I && want[a].J < want[b].J) })
+		if fmt.Sprint(pairs) != fmt.Sprint(want) {
+			Te.Errorf("%s: wrong pairs, got %d, want %d", c.name, len(pairs), len(want))
+		}
+	}
+	//Queries with cutoffs larger than the one used to build a periodic cell list, so the cells
+	//around a point wrap around the box.
+	box := []float64{15, 0, 0, 0, 15, 0, 0, 0, 15}
+	pbox, _ := NewPeriodic(box)
+	pcoords := v3.Zeros(300)
+	for i := 0; i < 300; i++ {
+		for j := 0; j < 3; j++ {
+			pcoords.Set(i, j, rng.Float64()*15)
+		}
+	}
+	pcl, err := NewCellList(pcoords, 2.5, box)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for _, r := range []float64{3, 3.2, 6} {
+		var want []Pair
+		for i := 0; i < pcoords.NVecs(); i++ {
+			p := []float64{pcoords.At(i, 0), pcoords.At(i, 1), pcoords.At(i, 2)}
+			point.Set(0, 0, p[0])
+			point.Set(0, 1, p[1])
+			point.Set(0, 2, p[2])
+			got, gotd := pcl.Within(point, r)
+			idx, dists := bruteWithin(pcoords, p, r, pbox)
+			if fmt.Sprint(got) != fmt.Sprint(idx) || fmt.Sprint(gotd) != fmt.Sprint(dists) {
+				Te.Errorf("periodic cell list: Within(%v, %f) gave %d points, want %d", p, r, len(got), len(idx))
+			}
+			for k, j := range idx {
+				if j > i {
+					want = append(want, Pair{I: i, J: j, Dist: dists[k]})
+				}
+			}
+		}
+		sort.Slice(want, func(a, b int) bool { return want[a].I < want[b].I || (want[a].I == want[b].I && want[a].J < want[b].J) })
+		if pairs := pcl.Pairs(r); fmt.Sprint(pairs) != fmt.Sprint(want) {
+			Te.Errorf("periodic cell list: wrong pairs for a cutoff of %f, got %d, want %d", r, len(pairs), len(want))
+		}
+	}
+	//Fewer points than requested.
+	small := v3.Zeros(3)
+	small.Set(1, 0, 100)
+	small.Set(2, 1, -50)
+	cls, _ := NewCellList(small, 1)
+	for _, idx := range []Index{cls, NewKDTree(small)} {
+		if got, _ := idx.Nearest(point, 5); len(got) != 3 {
+			Te.Errorf("Wrong number of neighbors for a small set: %v", got)
+		}
+	}
+	if _, err := NewCellList(small, 0); err == nil {
+		Te.Errorf("Cell list built with a zero cutoff")
+	}
+	if _, err := NewPeriodic([]float64{1, 0, 0, 2, 0, 0, 0, 0, 1}); err == nil {
+		Te.Errorf("Box with no volume accepted")
+	}
+}
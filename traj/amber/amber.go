@@ -0,0 +1,440 @@
+/*
+ * amber.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+// Package amber reads and writes Amber NetCDF trajectories (.nc) and restart files (.ncrst).
+// It includes its own reader and writer for the NetCDF-3 format, so the NetCDF C library
+// is not needed. Legacy ASCII trajectories are handled by the amberold package.
+// Coordinates and cell lengths are in A, velocities in A/ps and time in ps.
+package amber
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// velScale is the factor that converts Amber velocity units to A/ps. Amber stores
+// it in the scale_factor attribute of the velocities.
+const velScale = 20.455
+
+// NCObj is an Amber NetCDF trajectory or restart file opened for reading.
+// A restart file is read as a trajectory with a single frame.
+type NCObj struct {
+	readable   bool
+	restart    bool
+	natoms     int
+	nframes    int
+	frame      int //the next frame to be read
+	filename   string
+	fhandle    *os.File
+	nc         *ncFile
+	coords     *ncVar
+	vel        *ncVar
+	velScale   float64
+	raw        []byte
+	sraw       []byte //buffer for the time and cell
+	buffer     []float64
+	time       float64
+	lengths    [3]float64
+	angles     [3]float64
+	hasBox     bool
+	concBuffer [][]byte
+}
+
+// New opens the Amber NetCDF trajectory or restart file filename for reading.
+func New(filename string) (*NCObj, error) {
+	N := new(NCObj)
+	if err := N.initRead(filename); err != nil {
+		return nil, errDecorate(err, "New")
+	}
+	return N, nil
+}
+
+func (N *NCObj) initRead(name string) error {
+	var err error
+	N.filename = name
+	N.fhandle, err = os.Open(name)
+	if err != nil {
+		return Error{UnableToOpen + ": " + err.Error(), name, []string{"os.Open", "initRead"}, true}
+	}
+	var size int64
+	if info, err := N.fhandle.Stat(); err == nil {
+		size = info.Size()
+	}
+	N.nc, err = readNCHeader(bufio.NewReader(N.fhandle), size)
+	if err != nil {
+		N.fhandle.Close()
+		return Error{WrongFormat + ": " + err.Error(), name, []string{"readNCHeader", "initRead"}, true}
+	}
+	conv := attrStr(N.nc.attrs, "Conventions")
+	if !strings.Contains(conv, "AMBER") {
+		N.fhandle.Close()
+		return Error{WrongFormat + ": not an Amber NetCDF file", name, []string{"initRead"}, true}
+	}
+	N.coords = N.nc.variable("coordinates")
+	N.natoms = N.nc.dimLen("atom")
+	if N.coords == nil || N.natoms <= 0 || N.nc.nelems(N.coords) != 3*N.natoms {
+		N.fhandle.Close()
+		return Error{WrongFormat + ": no coordinates in file", name, []string{"initRead"}, true}
+	}
+	N.restart = !N.coords.record
+	N.nframes = N.nc.numrecs
+	if N.restart {
+		N.nframes = 1
+	}
+	if N.vel = N.nc.variable("velocities"); N.vel != nil {
+		N.velScale = 1
+		if a := findAttr(N.vel.attrs, "scale_factor"); a != nil && len(a.nums) > 0 {
+			N.velScale = a.nums[0]
+		}
+	}
+	N.buffer = make([]float64, 3*N.natoms)
+	N.readable = true
+	return nil
+}
+
+// Readable returns true if the object is ready to be read from
+// false otherwise. It doesnt guarantee that there is something
+// to read.
+func (N *NCObj) Readable() bool {
+	return N.readable
+}
+
+// Len returns the number of atoms per frame.
+func (N *NCObj) Len() int {
+	return N.natoms
+}
+
+// Close closes the underlying file.
+func (N *NCObj) Close() {
+	if N.fhandle != nil {
+		N.fhandle.Close()
+	}
+	N.fhandle = nil
+	N.readable = false
+	N.concBuffer = nil
+}
+
+// Restart returns true if the file is an Amber restart file.
+func (N *NCObj) Restart() bool {
+	return N.restart
+}
+
+// HasVelocities returns true if the file contains velocities.
+func (N *NCObj) HasVelocities() bool {
+	return N.vel != nil
+}
+
+// Time returns the time, in ps, of the last frame read.
+func (N *NCObj) Time() float64 {
+	return N.time
+}
+
+// Cell returns the cell lengths (A) and angles (degrees) of the last frame read, and
+// whether the frame contained cell information at all.
+func (N *NCObj) Cell() (lengths, angles [3]float64, ok bool) {
+	return N.lengths, N.angles, N.hasBox
+}
+
+// readScalars reads the time and the cell of the frame rec.
+func (N *NCObj) readScalars(rec int) error {
+	var err error
+	var vals [3]float64
+	read := func(name string, out []float64) bool {
+		v := N.nc.variable(name)
+		if v == nil || err != nil || N.nc.nelems(v) != len(out) {
+			return false
+		}
+		N.sraw, err = N.nc.readRaw(N.fhandle, v, rec, N.sraw)
+		if err == nil {
+			decodeVals(v.typ, N.sraw, out)
+		}
+		return err == nil
+	}
+	N.time = 0
+	if read("time", vals[:1]) {
+		N.time = vals[0]
+	}
+	N.hasBox = read("cell_lengths", N.lengths[:]) && read("cell_angles", N.angles[:])
+	//A frame with a zero cell has no box.
+	N.hasBox = N.hasBox && N.lengths != [3]float64{} && N.angles != [3]float64{}
+	return err
+}
+
+// readFrame reads the raw coordinates of the next frame into buf, which is returned.
+func (N *NCObj) readFrame(buf []byte) ([]byte, error) {
+	if !N.readable {
+		return buf, Error{TrajUnIni, N.filename, []string{"readFrame"}, true}
+	}
+	if N.frame >= N.nframes {
+		N.Close()
+		return buf, newlastFrameError(N.filename, "readFrame")
+	}
+	buf, err := N.nc.readRaw(N.fhandle, N.coords, N.frame, buf)
+	if err == nil {
+		err = N.readScalars(N.frame)
+	}
+	if err != nil {
+		N.Close()
+		return buf, Error{ReadError + ": " + err.Error(), N.filename, []string{"readFrame"}, true}
+	}
+	N.frame++
+	return buf, nil
+}
+
+// Next reads the next frame of the trajectory and puts the coordinates in coords, if not nil.
+// If a slice of at least 9 elements is given, the box vectors of the frame are put there
+// (zeros if the frame has no box).
+func (N *NCObj) Next(coords *v3.Matrix, box ...[]float64) error {
+	return errDecorate(N.NextFull(coords, nil, box...), "Next")
+}
+
+// NextFull reads the next frame of the trajectory. Coordinates and velocities are put in coords and vel,
+// if not nil. If velocities are requested and the file contains none, an error is returned after
+// the coordinates are read. The box vectors are returned as in Next.
+func (N *NCObj) NextFull(coords, vel *v3.Matrix, box ...[]float64) error {
+	var err error
+	N.raw, err = N.readFrame(N.raw)
+	if err != nil {
+		return errDecorate(err, "NextFull")
+	}
+	if len(box) > 0 && len(box[0]) >= 9 {
+		for i := range box[0][:9] {
+			box[0][i] = 0
+		}
+		if N.hasBox {
+			lensAngs2Vecs(N.lengths, N.angles, box[0])
+		}
+	}
+	if coords != nil {
+		if err := N.decode(N.coords, N.raw, 1, coords); err != nil {
+			return err
+		}
+	}
+	if vel == nil {
+		return nil
+	}
+	if N.vel == nil {
+		return Error{"No velocities in file", N.filename, []string{"NextFull"}, false}
+	}
+	N.raw, err = N.nc.readRaw(N.fhandle, N.vel, N.frame-1, N.raw)
+	if err != nil {
+		N.Close()
+		return Error{ReadError + ": " + err.Error(), N.filename, []string{"NextFull"}, true}
+	}
+	return N.decode(N.vel, N.raw, N.velScale, vel)
+}
+
+// decode puts the raw data of the variable v, multiplied by scale, in out.
+func (N *NCObj) decode(v *ncVar, raw []byte, scale float64, out *v3.Matrix) error {
+	if out.NVecs() < N.natoms {
+		return Error{NotEnoughSpace, N.filename, []string{"decode"}, true}
+	}
+	decodeVals(v.typ, raw, N.buffer)
+	for i := 0; i < N.natoms; i++ {
+		for j := 0; j < 3; j++ {
+			out.Set(i, j, scale*N.buffer[3*i+j])
+		}
+	}
+	return nil
+}
+
+// NextConc takes a slice of *v3.Matrix and reads as many frames as elements the list has
+// form the trajectory. The frames are discarted if the corresponding element of the slice
+// is nil. The function returns a slice of channels through each of each of which
+// a *v3.Matrix will be transmited.
+func (N *NCObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	if !N.readable {
+		return nil, Error{TrajUnIni, N.filename, []string{"NextConc"}, true}
+	}
+	for len(N.concBuffer) < len(frames) {
+		N.concBuffer = append(N.concBuffer, nil)
+	}
+	framechans := make([]chan *v3.Matrix, len(frames))
+	used := false
+	for key, val := range frames {
+		if val == nil {
+			//we still need to move to the next frame.
+			if _, err := N.readFrame(nil); err != nil {
+				if _, ok := err.(chem.LastFrameError); ok && used {
+					return framechans[:key], errDecorate(err, "NextConc")
+				}
+				return nil, errDecorate(err, "NextConc")
+			}
+			continue
+		}
+		buf, err := N.readFrame(N.concBuffer[key])
+		if err != nil {
+			if _, ok := err.(chem.LastFrameError); ok && used {
+				return framechans[:key], errDecorate(err, "NextConc")
+			}
+			return nil, errDecorate(err, "NextConc")
+		}
+		N.concBuffer[key] = buf
+		used = true
+		framechans[key] = make(chan *v3.Matrix)
+		go func(raw []byte, typ ncType, natoms int, keep *v3.Matrix, pipe chan *v3.Matrix) {
+			vals := make([]float64, 3*natoms)
+			decodeVals(typ, raw, vals)
+			for i := 0; i < natoms; i++ {
+				keep.Set(i, 0, vals[3*i])
+				keep.Set(i, 1, vals[3*i+1])
+				keep.Set(i, 2, vals[3*i+2])
+			}
+			pipe <- keep
+		}(buf, N.coords.typ, N.natoms, val, framechans[key])
+	}
+	return framechans, nil
+}
+
+// lensAngs2Vecs puts in vecs the box vectors corresponding to the given cell lengths and
+// angles (in degrees). The first vector is along x and the second in the xy plane.
+func lensAngs2Vecs(lens, angs [3]float64, vecs []float64) {
+	d2r := math.Pi / 180
+	cosa, cosb, cosg := math.Cos(angs[0]*d2r), math.Cos(angs[1]*d2r), math.Cos(angs[2]*d2r)
+	sing := math.Sin(angs[2] * d2r)
+	vecs[0] = lens[0]
+	vecs[1], vecs[2] = 0, 0
+	vecs[3] = lens[1] * cosg
+	vecs[4] = lens[1] * sing
+	vecs[5] = 0
+	vecs[6] = lens[2] * cosb
+	cy := (cosa - cosb*cosg) / sing
+	vecs[7] = lens[2] * cy
+	vecs[8] = lens[2] * math.Sqrt(math.Max(0, 1-cosb*cosb-cy*cy))
+}
+
+// vecs2LensAngs returns the cell lengths and angles (in degrees) of the box vectors in vecs.
+func vecs2LensAngs(vecs []float64) (lens, angs [3]float64) {
+	var v [3][]float64
+	for i := range v {
+		v[i] = vecs[3*i : 3*i+3]
+		lens[i] = math.Sqrt(dot(v[i], v[i]))
+	}
+	angle := func(a, b int) float64 {
+		if lens[a] == 0 || lens[b] == 0 {
+			return 90
+		}
+		return math.Acos(dot(v[a], v[b])/(lens[a]*lens[b])) * 180 / math.Pi
+	}
+	angs[0] = angle(1, 2)
+	angs[1] = angle(0, 2)
+	angs[2] = angle(0, 1)
+	return lens, angs
+}
+
+func dot(a, b []float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+//Errors
+
+// errDecorate is a helper function that asserts that the error is
+// implements chem.Error and decorates the error with the caller's name before returning it.
+// if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	if err == nil {
+		return nil
+	}
+	err2 := err.(chem.Error)
+	err2.Decorate(caller)
+	return err2
+}
+
+// Error is the general structure for Amber NetCDF errors. It fullfills  chem.Error and chem.TrajError
+type Error struct {
+	message  string
+	filename string //the input file that has problems, or empty string if none.
+	deco     []string
+	critical bool
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("amber netcdf file %s error: %s", err.filename, err.message)
+}
+
+// Decorate Adds new information to the error
+func (E Error) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+// Filename returns the file to which the failing trajectory was associated
+func (err Error) FileName() string { return err.filename }
+
+// Format returns the format of the file (always "amber netcdf") associated to the error
+func (err Error) Format() string { return "amber netcdf" }
+
+// Critical returns true if the error is critical, false otherwise
+func (err Error) Critical() bool { return err.critical }
+
+const (
+	TrajUnIni      = "Traj object uninitialized to read"
+	TrajUnIniWrite = "Traj object uninitialized to write"
+	ReadError      = "Error reading frame"
+	WriteError     = "Error writing frame"
+	UnableToOpen   = "Unable to open file"
+	WrongFormat    = "Wrong format in the Amber NetCDF file"
+	NotEnoughSpace = "Not enough space in passed matrices"
+	EOF            = "EOF"
+)
+
+// lastFrameError implements chem.LastFrameError
+type lastFrameError struct {
+	deco     []string
+	fileName string
+}
+
+// lastFrameError does nothing
+func (E lastFrameError) NormalLastFrameTermination() {}
+
+func (E lastFrameError) FileName() string { return E.fileName }
+
+func (E lastFrameError) Error() string { return "EOF" }
+
+func (E lastFrameError) Critical() bool { return false }
+
+func (E lastFrameError) Format() string { return "amber netcdf" }
+
+func (E lastFrameError) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+func newlastFrameError(filename string, caller string) *lastFrameError {
+	e := new(lastFrameError)
+	e.fileName = filename
+	e.deco = []string{caller}
+	return e
+}
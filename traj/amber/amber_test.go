@@ -0,0 +1,188 @@
+/*
+ * amber_test.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ */
+
+package amber
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func testFrame(natoms, frame int, scale float64) *v3.Matrix {
+	m := v3.Zeros(natoms)
+	for i := 0; i < natoms; i++ {
+		for j := 0; j < 3; j++ {
+			m.Set(i, j, scale*float64(frame+i+j))
+		}
+	}
+	return m
+}
+
+// a triclinic box, with a=30, b=32, c=34, alpha=80, beta=85 and gamma=95 degrees.
+func testBox() []float64 {
+	box := make([]float64, 9)
+	lensAngs2Vecs([3]float64{30, 32, 34}, [3]float64{80, 85, 95}, box)
+	return box
+}
+
+// TestAmberNC writes a trajectory with box and velocities and reads it back.
+// It also checks that files with an unknown number of frames (as those still being written) can be read.
+func TestAmberNC(Te *testing.T) {
+	natoms := 6
+	name := filepath.Join(Te.TempDir(), "test.nc")
+	w, err := NewWriter(name, natoms, true, true)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	w.SetTimeStep(0.5)
+	for i := 0; i < 4; i++ {
+		if err := w.WNextFull(testFrame(natoms, i, 1), testFrame(natoms, i, 0.1), 0.5*float64(i), testBox()); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.WNext(testFrame(natoms, 4, 1)) //no box or velocities
+	w.Close()
+	for _, streaming := range []bool{false, true} {
+		if streaming {
+			f, err := os.OpenFile(name, os.O_RDWR, 0644)
+			if err != nil {
+				Te.Fatal(err)
+			}
+			f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 4)
+			f.Close()
+		}
+		traj, err := New(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if traj.Len() != natoms || !traj.HasVelocities() || traj.Restart() {
+			Te.Fatalf("Wrong trajectory properties")
+		}
+		coords := v3.Zeros(natoms)
+		vel := v3.Zeros(natoms)
+		box := make([]float64, 9)
+		ref := testBox()
+		for i := 0; i < 4; i++ {
+			if err := traj.NextFull(coords, vel, box); err != nil {
+				Te.Fatal(err)
+			}
+			lens, angs, ok := traj.Cell()
+			if !ok || math.Abs(lens[1]-32) > 1e-6 || math.Abs(angs[2]-95) > 1e-6 || math.Abs(traj.Time()-0.5*float64(i)) > 1e-6 {
+				Te.Errorf("Wrong cell or time in frame %d: %v %v %f", i, lens, angs, traj.Time())
+			}
+			for j := range box {
+				if math.Abs(box[j]-ref[j]) > 1e-4 {
+					Te.Errorf("Wrong box in frame %d: %v %v", i, box, ref)
+					break
+				}
+			}
+			if math.Abs(coords.At(3, 2)-float64(i+5)) > 1e-4 || math.Abs(vel.At(3, 2)-0.1*float64(i+5)) > 1e-4 {
+				Te.Errorf("Wrong data in frame %d: %v %v", i, coords.VecView(3), vel.VecView(3))
+			}
+		}
+		if err := traj.Next(coords, box); err != nil {
+			Te.Fatal(err)
+		}
+		if _, _, ok := traj.Cell(); ok || box[0] != 0 || coords.At(0, 0) != 4 {
+			Te.Errorf("Wrong last frame %v %v", box, coords.VecView(0))
+		}
+		if err := traj.Next(coords); err == nil {
+			Te.Errorf("Expected the end of the trajectory")
+		} else if _, ok := err.(chem.LastFrameError); !ok {
+			Te.Error(err)
+		}
+	}
+}
+
+func TestAmberNCConc(Te *testing.T) {
+	natoms := 3
+	name := filepath.Join(Te.TempDir(), "testconc.nc")
+	w, err := NewWriter(name, natoms, false, false)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		w.WNext(testFrame(natoms, i, 1))
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frames := []*v3.Matrix{v3.Zeros(natoms), nil, v3.Zeros(natoms)}
+	read := 0
+	for {
+		chans, err := traj.NextConc(frames)
+		for i, c := range chans {
+			if c != nil {
+				m := <-c
+				if math.Abs(m.At(0, 0)-float64(read)) > 1e-5 {
+					Te.Errorf("Frame %d (%d in batch) has wrong coordinates: %v", read, i, m.VecView(0))
+				}
+			}
+			read++
+		}
+		if err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok {
+				Te.Fatal(err)
+			}
+			break
+		}
+	}
+	if read != 7 {
+		Te.Errorf("Read %d frames, expected 7", read)
+	}
+}
+
+func TestAmberRestart(Te *testing.T) {
+	natoms := 4
+	name := filepath.Join(Te.TempDir(), "test.ncrst")
+	coords := testFrame(natoms, 0, 1.123456789)
+	if err := WriteRestart(name, coords, testFrame(natoms, 1, 0.01), 1500, testBox()); err != nil {
+		Te.Fatal(err)
+	}
+	rst, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if !rst.Restart() {
+		Te.Errorf("File not recognized as a restart")
+	}
+	c := v3.Zeros(natoms)
+	vel := v3.Zeros(natoms)
+	if err := rst.NextFull(c, vel); err != nil {
+		Te.Fatal(err)
+	}
+	//restarts are in double precision.
+	if math.Abs(c.At(3, 1)-coords.At(3, 1)) > 1e-12 || math.Abs(vel.At(3, 1)-0.05) > 1e-12 || rst.Time() != 1500 {
+		Te.Errorf("Wrong data in restart: %v %v %f", c.VecView(3), vel.VecView(3), rst.Time())
+	}
+	if lens, _, ok := rst.Cell(); !ok || math.Abs(lens[2]-34) > 1e-9 {
+		Te.Errorf("Wrong cell in restart: %v", lens)
+	}
+	if err := rst.Next(c); err == nil {
+		Te.Errorf("Expected only one frame in the restart")
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * amber_write.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// newAmberHeader returns the header for an Amber NetCDF file with natoms atoms.
+// For restarts, the variables have no frame dimension and are stored in double precision.
+func newAmberHeader(natoms int, restart, box, velocities bool) *ncFile {
+	str := func(name, val string) ncAttr { return ncAttr{name: name, typ: ncChar, str: val} }
+	f := &ncFile{
+		attrs: []ncAttr{str("title", "goChem"), str("application", "AMBER"), str("program", "goChem"),
+			str("programVersion", "1.0"), str("Conventions", "AMBER"), str("ConventionVersion", "1.0")},
+	}
+	dim := func(name string, length int) int {
+		f.dims = append(f.dims, ncDim{name, length})
+		return len(f.dims) - 1
+	}
+	ctype := ncFloat
+	var rec []int //the record dimension, if any.
+	if restart {
+		f.attrs[4].str = "AMBERRESTART"
+		ctype = ncDouble
+	} else {
+		rec = []int{dim("frame", 0)}
+	}
+	//dims returns the given dimensions, preceded by the record one, for trajectories.
+	dims := func(d ...int) []int {
+		return append(append([]int{}, rec...), d...)
+	}
+	spatial := dim("spatial", 3)
+	atom := dim("atom", natoms)
+	f.vars = []*ncVar{
+		{name: "spatial", dims: []int{spatial}, typ: ncChar},
+		{name: "time", dims: dims(), typ: ctype, attrs: []ncAttr{str("units", "picosecond")}},
+		{name: "coordinates", dims: dims(atom, spatial), typ: ctype, attrs: []ncAttr{str("units", "angstrom")}},
+	}
+	if velocities {
+		f.vars = append(f.vars, &ncVar{name: "velocities", dims: dims(atom, spatial), typ: ctype,
+			attrs: []ncAttr{str("units", "angstrom/picosecond"), {name: "scale_factor", typ: ncDouble, nums: []float64{velScale}}}})
+	}
+	if box {
+		cellSpatial := dim("cell_spatial", 3)
+		cellAngular := dim("cell_angular", 3)
+		label := dim("label", 5)
+		f.vars = append(f.vars,
+			&ncVar{name: "cell_spatial", dims: []int{cellSpatial}, typ: ncChar},
+			&ncVar{name: "cell_angular", dims: []int{cellAngular, label}, typ: ncChar},
+			&ncVar{name: "cell_lengths", dims: dims(cellSpatial), typ: ncDouble, attrs: []ncAttr{str("units", "angstrom")}},
+			&ncVar{name: "cell_angles", dims: dims(cellAngular), typ: ncDouble, attrs: []ncAttr{str("units", "degree")}})
+	}
+	return f
+}
+
+// charVals returns the characters of s as numbers, as needed to write them.
+func charVals(s string) []float64 {
+	ret := make([]float64, len(s))
+	for i := range s {
+		ret[i] = float64(s[i])
+	}
+	return ret
+}
+
+// encodeNonRecord returns the data of the non-record variables of f. The labels
+// are filled in here, and the values of the rest are taken from vals.
+func (f *ncFile) encodeNonRecord(vals map[string][]float64) []byte {
+	labels := map[string][]float64{
+		"spatial":      charVals("xyz"),
+		"cell_spatial": charVals("abc"),
+		"cell_angular": charVals("alphabeta gamma"),
+	}
+	var buf []byte
+	for _, v := range f.vars {
+		if v.record {
+			continue
+		}
+		d, ok := labels[v.name]
+		if !ok {
+			d = vals[v.name]
+		}
+		buf = f.encodeVar(buf, v, d)
+	}
+	return buf
+}
+
+// matrixVals returns the elements of the first natoms rows of m, multiplied by scale.
+func matrixVals(m *v3.Matrix, natoms int, scale float64) []float64 {
+	ret := make([]float64, 0, 3*natoms)
+	for i := 0; i < natoms; i++ {
+		ret = append(ret, scale*m.At(i, 0), scale*m.At(i, 1), scale*m.At(i, 2))
+	}
+	return ret
+}
+
+// NCWObj is an Amber NetCDF trajectory opened for writing.
+type NCWObj struct {
+	natoms   int
+	filename string
+	writable bool
+	box      bool
+	vel      bool
+	frames   int
+	dt       float64
+	nc       *ncFile
+	fhandle  *os.File
+	w        *bufio.Writer
+	buf      []byte
+}
+
+// NewWriter creates an Amber NetCDF trajectory with name filename, for natoms atoms.
+// If box is true, the cell of each frame is stored, and if velocities is true, space
+// for velocities is reserved in each frame.
+func NewWriter(filename string, natoms int, box, velocities bool) (*NCWObj, error) {
+	if natoms <= 0 {
+		return nil, Error{"The number of atoms must be positive", filename, []string{"NewWriter"}, true}
+	}
+	N := &NCWObj{natoms: natoms, filename: filename, box: box, vel: velocities, dt: 1}
+	var err error
+	N.fhandle, err = os.Create(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen + ": " + err.Error(), filename, []string{"os.Create", "NewWriter"}, true}
+	}
+	N.nc = newAmberHeader(natoms, false, box, velocities)
+	N.w = bufio.NewWriter(N.fhandle)
+	N.buf = N.nc.encodeHeader()
+	N.buf = append(N.buf, N.nc.encodeNonRecord(nil)...)
+	if _, err := N.w.Write(N.buf); err != nil {
+		N.fhandle.Close()
+		return nil, Error{err.Error(), filename, []string{"bufio.Writer.Write", "NewWriter"}, true}
+	}
+	N.writable = true
+	return N, nil
+}
+
+// Len returns the number of atoms per frame in the trajectory.
+func (N *NCWObj) Len() int {
+	return N.natoms
+}
+
+// SetTimeStep sets the time, in ps, between frames written with WNext. The default is 1 ps.
+func (N *NCWObj) SetTimeStep(dt float64) {
+	N.dt = dt
+}
+
+// Close writes the number of frames to the file, and closes it.
+func (N *NCWObj) Close() {
+	if !N.writable {
+		return
+	}
+	N.w.Flush()
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(N.frames))
+	N.fhandle.WriteAt(n[:], 4) //numrecs goes right after the magic number.
+	N.fhandle.Close()
+	N.writable = false
+}
+
+// WNext writes coords, and the box, if given, as the next frame of the trajectory.
+// The time of the frame will be the number of frames written before it, times
+// the time step set with SetTimeStep.
+func (N *NCWObj) WNext(coords *v3.Matrix, box ...[]float64) error {
+	return errDecorate(N.WNextFull(coords, nil, float64(N.frames)*N.dt, box...), "WNext")
+}
+
+// WNextFull writes a frame with the given coordinates, velocities (in A/ps) and time (in ps).
+// vel is ignored if the trajectory was not created with velocities, and, if nil, zero velocities
+// are written. The box vectors are written if a slice of at least 9 elements is given, and the trajectory
+// was created with box information.
+func (N *NCWObj) WNextFull(coords, vel *v3.Matrix, time float64, box ...[]float64) error {
+	if !N.writable {
+		return Error{TrajUnIniWrite, N.filename, []string{"WNextFull"}, true}
+	}
+	if coords == nil || coords.NVecs() != N.natoms || (vel != nil && vel.NVecs() != N.natoms) {
+		return Error{"Coordinates don't match the trajectory size", N.filename, []string{"WNextFull"}, true}
+	}
+	vals := map[string][]float64{
+		"time":        {time},
+		"coordinates": matrixVals(coords, N.natoms, 1),
+	}
+	if N.vel && vel != nil {
+		vals["velocities"] = matrixVals(vel, N.natoms, 1/velScale)
+	}
+	if N.box && len(box) > 0 && len(box[0]) >= 9 {
+		lens, angs := vecs2LensAngs(box[0])
+		vals["cell_lengths"] = lens[:]
+		vals["cell_angles"] = angs[:]
+	}
+	N.buf = N.buf[:0]
+	for _, v := range N.nc.vars {
+		if !v.record {
+			continue
+		}
+		d := vals[v.name]
+		if d == nil {
+			d = make([]float64, N.nc.nelems(v))
+		}
+		N.buf = N.nc.encodeVar(N.buf, v, d)
+	}
+	if _, err := N.w.Write(N.buf); err != nil {
+		return Error{WriteError + ": " + err.Error(), N.filename, []string{"WNextFull"}, true}
+	}
+	N.frames++
+	return nil
+}
+
+// WriteRestart writes an Amber NetCDF restart file with name filename, with the given
+// coordinates, velocities (in A/ps) and time (in ps). vel can be nil, and the box
+// vectors are written if box is not nil.
+func WriteRestart(filename string, coords, vel *v3.Matrix, time float64, box []float64) error {
+	natoms := coords.NVecs()
+	if vel != nil && vel.NVecs() != natoms {
+		return Error{"Velocities don't match the coordinates", filename, []string{"WriteRestart"}, true}
+	}
+	hasbox := len(box) >= 9
+	f := newAmberHeader(natoms, true, hasbox, vel != nil)
+	vals := map[string][]float64{
+		"time":        {time},
+		"coordinates": matrixVals(coords, natoms, 1),
+	}
+	if vel != nil {
+		vals["velocities"] = matrixVals(vel, natoms, 1/velScale)
+	}
+	if hasbox {
+		lens, angs := vecs2LensAngs(box)
+		vals["cell_lengths"] = lens[:]
+		vals["cell_angles"] = angs[:]
+	}
+	buf := f.encodeHeader()
+	buf = append(buf, f.encodeNonRecord(vals)...)
+	if err := os.WriteFile(filename, buf, 0644); err != nil {
+		return Error{err.Error(), filename, []string{"os.WriteFile", "WriteRestart"}, true}
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+/*
+ * netcdf.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package amber
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// This file contains a minimal implementation of the NetCDF-3 format (classic and
+// 64-bit offset), enough to read and write Amber trajectories and restarts.
+// NetCDF-4 (HDF5-based) files are not supported.
+
+type ncType int32
+
+const (
+	ncByte   ncType = 1
+	ncChar   ncType = 2
+	ncShort  ncType = 3
+	ncInt    ncType = 4
+	ncFloat  ncType = 5
+	ncDouble ncType = 6
+)
+
+const (
+	ncDimensionTag = 0x0A
+	ncVariableTag  = 0x0B
+	ncAttributeTag = 0x0C
+	ncStreaming    = -1 //numrecs value for files where the number of records is not known.
+	ncMaxElems     = 1 << 28
+)
+
+// size returns the size in bytes of an element of type t, or 0 if the type is not supported.
+func (t ncType) size() int {
+	switch t {
+	case ncByte, ncChar:
+		return 1
+	case ncShort:
+		return 2
+	case ncInt, ncFloat:
+		return 4
+	case ncDouble:
+		return 8
+	}
+	return 0
+}
+
+type ncDim struct {
+	name   string
+	length int //0 means the unlimited (record) dimension.
+}
+
+// ncAttr is a NetCDF attribute. Character attributes are stored in str, all numerical
+// types in nums.
+type ncAttr struct {
+	name string
+	typ  ncType
+	str  string
+	nums []float64
+}
+
+type ncVar struct {
+	name   string
+	dims   []int
+	attrs  []ncAttr
+	typ    ncType
+	vsize  int64 //size of the variable, or of one record of it, for record variables.
+	begin  int64
+	record bool
+}
+
+// ncFile is the header of a NetCDF-3 file.
+type ncFile struct {
+	version byte //1 for classic, 2 for 64-bit offset.
+	numrecs int
+	dims    []ncDim
+	attrs   []ncAttr
+	vars    []*ncVar
+	recsize int64
+}
+
+func findAttr(attrs []ncAttr, name string) *ncAttr {
+	for i := range attrs {
+		if attrs[i].name == name {
+			return &attrs[i]
+		}
+	}
+	return nil
+}
+
+// attrStr returns the value of the character attribute name, or an empty string.
+func attrStr(attrs []ncAttr, name string) string {
+	if a := findAttr(attrs, name); a != nil {
+		return a.str
+	}
+	return ""
+}
+
+// variable returns the variable with the given name, or nil if there is none.
+func (f *ncFile) variable(name string) *ncVar {
+	for _, v := range f.vars {
+		if v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// dimLen returns the length of the dimension name, or -1 if it is not present.
+func (f *ncFile) dimLen(name string) int {
+	for _, d := range f.dims {
+		if d.name == name {
+			return d.length
+		}
+	}
+	return -1
+}
+
+// nelems returns the number of elements of the variable (of one record, for record variables).
+func (f *ncFile) nelems(v *ncVar) int {
+	n := 1
+	for _, d := range v.dims {
+		if f.dims[d].length == 0 {
+			continue
+		}
+		n *= f.dims[d].length
+	}
+	return n
+}
+
+func pad4(n int64) int64 {
+	return (n + 3) / 4 * 4
+}
+
+// ncReader reads the header of a NetCDF file, keeping the first error found.
+type ncReader struct {
+	r   io.Reader
+	err error
+	buf [8]byte
+}
+
+func (n *ncReader) int32() int32 {
+	if n.err != nil {
+		return 0
+	}
+	_, n.err = io.ReadFull(n.r, n.buf[:4])
+	return int32(binary.BigEndian.Uint32(n.buf[:4]))
+}
+
+func (n *ncReader) int64() int64 {
+	if n.err != nil {
+		return 0
+	}
+	_, n.err = io.ReadFull(n.r, n.buf[:8])
+	return int64(binary.BigEndian.Uint64(n.buf[:8]))
+}
+
+// count reads a number of elements, checking that it is reasonable.
+func (n *ncReader) count() int {
+	c := n.int32()
+	if n.err == nil && (c < 0 || c > ncMaxElems) {
+		n.err = fmt.Errorf("wrong number of elements in header: %d", c)
+	}
+	return int(c)
+}
+
+// bytes reads k bytes, and the padding up to a multiple of 4.
+func (n *ncReader) bytes(k int) []byte {
+	if n.err != nil {
+		return nil
+	}
+	b := make([]byte, pad4(int64(k)))
+	_, n.err = io.ReadFull(n.r, b)
+	return b[:k]
+}
+
+func (n *ncReader) name() string {
+	return string(n.bytes(n.count()))
+}
+
+// list reads the tag and number of elements of a list. It returns 0 for absent lists.
+func (n *ncReader) list(tag int32) int {
+	t := n.int32()
+	c := n.count()
+	if n.err == nil && t != tag && (t != 0 || c != 0) {
+		n.err = fmt.Errorf("wrong tag in header: %d", t)
+	}
+	return c
+}
+
+func (n *ncReader) attrs() []ncAttr {
+	natts := n.list(ncAttributeTag)
+	var attrs []ncAttr
+	for i := 0; i < natts && n.err == nil; i++ {
+		a := ncAttr{name: n.name(), typ: ncType(n.int32())}
+		nelems := n.count()
+		if n.err == nil && a.typ.size() == 0 {
+			n.err = fmt.Errorf("unsupported type %d for attribute %s", a.typ, a.name)
+		}
+		raw := n.bytes(nelems * a.typ.size())
+		if a.typ == ncChar {
+			a.str = string(trimNull(raw))
+		} else if n.err == nil {
+			a.nums = make([]float64, nelems)
+			decodeVals(a.typ, raw, a.nums)
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+// trimNull removes trailing null characters, which some programs add to strings.
+func trimNull(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// readNCHeader reads the header of a NetCDF-3 file from r. size is the size of the file,
+// used to determine the number of records in files being written.
+func readNCHeader(r io.Reader, size int64) (*ncFile, error) {
+	n := &ncReader{r: r}
+	m := n.bytes(4)
+	if n.err != nil {
+		return nil, n.err
+	}
+	if string(m[:3]) != "CDF" {
+		if string(m[1:4]) == "HDF" {
+			return nil, fmt.Errorf("NetCDF-4/HDF5 files are not supported")
+		}
+		return nil, fmt.Errorf("not a NetCDF file")
+	}
+	f := &ncFile{version: m[3]}
+	if f.version != 1 && f.version != 2 {
+		return nil, fmt.Errorf("unsupported NetCDF version %d", f.version)
+	}
+	f.numrecs = int(n.int32())
+	ndims := n.list(ncDimensionTag)
+	for i := 0; i < ndims && n.err == nil; i++ {
+		d := ncDim{name: n.name(), length: n.count()}
+		f.dims = append(f.dims, d)
+	}
+	f.attrs = n.attrs()
+	nvars := n.list(ncVariableTag)
+	var nrecvars int
+	for i := 0; i < nvars && n.err == nil; i++ {
+		v := &ncVar{name: n.name()}
+		nd := n.count()
+		for j := 0; j < nd && n.err == nil; j++ {
+			d := int(n.int32())
+			if d < 0 || d >= len(f.dims) {
+				return nil, fmt.Errorf("wrong dimension %d for variable %s", d, v.name)
+			}
+			if f.dims[d].length == 0 {
+				if j != 0 {
+					return nil, fmt.Errorf("record dimension not first for variable %s", v.name)
+				}
+				v.record = true
+			}
+			v.dims = append(v.dims, d)
+		}
+		v.attrs = n.attrs()
+		v.typ = ncType(n.int32())
+		if n.err == nil && v.typ.size() == 0 {
+			return nil, fmt.Errorf("unsupported type %d for variable %s", v.typ, v.name)
+		}
+		n.int32() //vsize. We calculate it ourselves, as it can overflow for large variables.
+		if f.version == 1 {
+			v.begin = int64(n.int32())
+		} else {
+			v.begin = n.int64()
+		}
+		v.vsize = pad4(int64(f.nelems(v) * v.typ.size()))
+		if v.record {
+			nrecvars++
+			f.recsize += v.vsize
+		}
+		f.vars = append(f.vars, v)
+	}
+	if n.err != nil {
+		return nil, n.err
+	}
+	//With only one record variable, records are not padded.
+	if nrecvars == 1 {
+		for _, v := range f.vars {
+			if v.record {
+				v.vsize = int64(f.nelems(v) * v.typ.size())
+				f.recsize = v.vsize
+			}
+		}
+	}
+	if f.numrecs == ncStreaming && f.recsize > 0 {
+		f.numrecs = 0
+		for _, v := range f.vars {
+			if v.record {
+				f.numrecs = int((size - v.begin) / f.recsize)
+				break
+			}
+		}
+	}
+	if f.numrecs < 0 {
+		return nil, fmt.Errorf("wrong number of records %d", f.numrecs)
+	}
+	return f, nil
+}
+
+// readRaw reads the raw data of the variable v (the record rec, for record variables) from r
+// into buf, which is returned, resized as needed.
+func (f *ncFile) readRaw(r io.ReaderAt, v *ncVar, rec int, buf []byte) ([]byte, error) {
+	size := f.nelems(v) * v.typ.size()
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	offset := v.begin
+	if v.record {
+		offset += int64(rec) * f.recsize
+	}
+	_, err := r.ReadAt(buf, offset)
+	return buf, err
+}
+
+// decodeVals decodes the big-endian values of type t in raw into out.
+func decodeVals(t ncType, raw []byte, out []float64) {
+	s := t.size()
+	for i := range out {
+		b := raw[i*s:]
+		switch t {
+		case ncByte, ncChar:
+			out[i] = float64(int8(b[0]))
+		case ncShort:
+			out[i] = float64(int16(binary.BigEndian.Uint16(b)))
+		case ncInt:
+			out[i] = float64(int32(binary.BigEndian.Uint32(b)))
+		case ncFloat:
+			out[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case ncDouble:
+			out[i] = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+	}
+}
+
+// appendVals appends the big-endian encoding of vals, as type t, to buf.
+func appendVals(t ncType, buf []byte, vals []float64) []byte {
+	for _, v := range vals {
+		switch t {
+		case ncByte, ncChar:
+			buf = append(buf, byte(int8(v)))
+		case ncShort:
+			buf = binary.BigEndian.AppendUint16(buf, uint16(int16(v)))
+		case ncInt:
+			buf = binary.BigEndian.AppendUint32(buf, uint32(int32(v)))
+		case ncFloat:
+			buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(float32(v)))
+		case ncDouble:
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+		}
+	}
+	return buf
+}
+
+func appendPad(buf []byte) []byte {
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func appendName(buf []byte, name string) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(name)))
+	return appendPad(append(buf, name...))
+}
+
+func appendAttrs(buf []byte, attrs []ncAttr) []byte {
+	if len(attrs) == 0 {
+		return append(buf, make([]byte, 8)...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, ncAttributeTag)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(attrs)))
+	for _, a := range attrs {
+		buf = appendName(buf, a.name)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(a.typ))
+		if a.typ == ncChar {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(a.str)))
+			buf = append(buf, a.str...)
+		} else {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(a.nums)))
+			buf = appendVals(a.typ, buf, a.nums)
+		}
+		buf = appendPad(buf)
+	}
+	return buf
+}
+
+// encodeHeader returns the header of f, in the 64-bit offset format. The offsets of the
+// variables, and the record size, are set so the data follows the header: first
+// the non-record variables, then the records.
+func (f *ncFile) encodeHeader() []byte {
+	f.version = 2
+	var buf []byte
+	for pass := 0; pass < 2; pass++ {
+		//The size of the header doesn't depend on the offsets, so, in the second
+		//pass, we know where the data starts.
+		offset := int64(len(buf))
+		buf = append(buf[:0], 'C', 'D', 'F', f.version)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(f.numrecs))
+		buf = binary.BigEndian.AppendUint32(buf, ncDimensionTag)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(f.dims)))
+		for _, d := range f.dims {
+			buf = appendName(buf, d.name)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(d.length))
+		}
+		buf = appendAttrs(buf, f.attrs)
+		f.layout(offset)
+		buf = binary.BigEndian.AppendUint32(buf, ncVariableTag)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(f.vars)))
+		for _, v := range f.vars {
+			buf = appendName(buf, v.name)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(v.dims)))
+			for _, d := range v.dims {
+				buf = binary.BigEndian.AppendUint32(buf, uint32(d))
+			}
+			buf = appendAttrs(buf, v.attrs)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(v.typ))
+			buf = binary.BigEndian.AppendUint32(buf, uint32(min(v.vsize, math.MaxUint32)))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(v.begin))
+		}
+	}
+	return buf
+}
+
+// layout sets the size and offset of each variable, given the offset where the data starts.
+func (f *ncFile) layout(offset int64) {
+	f.recsize = 0
+	var recvars []*ncVar
+	for _, v := range f.vars {
+		v.record = len(v.dims) > 0 && f.dims[v.dims[0]].length == 0
+		v.vsize = pad4(int64(f.nelems(v) * v.typ.size()))
+		if v.record {
+			recvars = append(recvars, v)
+			continue
+		}
+		v.begin = offset
+		offset += v.vsize
+	}
+	if len(recvars) == 1 {
+		recvars[0].vsize = int64(f.nelems(recvars[0]) * recvars[0].typ.size())
+	}
+	for _, v := range recvars {
+		v.begin = offset + f.recsize
+		f.recsize += v.vsize
+	}
+}
+
+// encodeVar returns the encoded data for v (or one record of it), padded as needed.
+func (f *ncFile) encodeVar(buf []byte, v *ncVar, vals []float64) []byte {
+	start := len(buf)
+	buf = appendVals(v.typ, buf, vals)
+	for int64(len(buf)-start) < v.vsize {
+		buf = append(buf, 0)
+	}
+	return buf
+}
@@ -38,14 +38,15 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"strings"
 
 	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
-	"gonum.org/v1/gonum/floats"
 )
 
 const mAXTITLE int32 = 80
 const rSCAL32BITS int32 = 1
+const aKMA2ps float64 = 0.04888821 //The time unit of CHARMM, AKMA, in ps.
 
 //Container for an Charmm/NAMD binary trajectory file.
 type DCDObj struct {
@@ -58,8 +59,18 @@ type DCDObj struct {
 	extrablock bool //simulation box!
 	fourdim    bool
 	new        bool  //Still no frame read from it?
-	fixed      int32 //Fixed atoms (not supported)
-	box        [6]float32
+	fixed      int32 //Fixed atoms, only present in the first frame.
+	free       []int32 //The indexes, from 0, of the non-fixed atoms, if there are fixed atoms.
+	firstFrame [][]float32 //The first frame, which contains the fixed atoms, if there are fixed atoms.
+	freeBuffer []float32 //For reading the free atoms in the frames after the first.
+	delta      float64 //The time step, in AKMA units.
+	istart     int32 //The step of the first frame.
+	nsavc      int32 //The steps between frames.
+	titles     []string
+	box        [6]float64
+	boxWarned  bool //Have we warned that the trajectory has no box?
+	current    int   //The next frame to be read.
+	headerSize int64 //Where the first frame starts, in bytes.
 	fhandle    *os.File
 	dcd        io.ReadCloser //The DCD file
 	dcdFields  [][]float32
@@ -67,49 +78,23 @@ type DCDObj struct {
 	endian     binary.ByteOrder
 }
 
-//Angles in radians, lens in A
-func vectors2LenAngles(vecs []float64) ([]float64, []float64) {
-	lens := make([]float64, 3)
-	angs := make([]float64, 3)
-	lens[0] = floats.Norm(vecs[0:3], 2)
-	lens[2] = floats.Norm(vecs[3:6], 2)
-	lens[3] = floats.Norm(vecs[6:9], 2)
-	angs[0] = math.Acos(floats.Dot(vecs[3:6], vecs[6:9]) / (lens[1] * lens[2]))
-	angs[1] = math.Acos(floats.Dot(vecs[6:9], vecs[0:3]) / (lens[0] * lens[2]))
-	angs[1] = math.Acos(floats.Dot(vecs[0:3], vecs[3:6]) / (lens[0] * lens[1]))
-
-	return lens, angs
-}
-
-//Lens in A, angles in Radians
-func lensAngs2Vecs(lens, angs, vecs []float64) []float64 {
-	if len(vecs) < 0 {
-		vecs = make([]float64, 9)
-	}
-	vecs[0] = lens[0]
-	vecs[1] = 0
-	vecs[2] = 0
-	vecs[3] = lens[1] * math.Cos(angs[2])
-	vecs[4] = lens[1] * math.Sin(angs[2])
-	vecs[5] = 0
-	vecs[6] = lens[2] * math.Cos(angs[1])
-	vecs[6] = lens[2] * (math.Cos(angs[0]) - math.Cos(angs[1])*math.Cos(angs[2])) / math.Sin(angs[2])
-	//Deal with floating-point fun
-	cutoff := 1e-5
-	for i, v := range vecs {
-		if v < cutoff {
-			vecs[i] = 0.0
+//cell returns the unit cell in the CHARMM box block b, which contains A, gamma, B, beta,
+//alpha and C. The angles are given as cosines in recent CHARMM versions, and in degrees
+//in older ones and in NAMD.
+func cell(b [6]float64) *chem.UnitCell {
+	angs := [3]float64{b[4], b[3], b[1]}
+	cosines := true
+	for _, v := range angs {
+		if v < -1 || v > 1 {
+			cosines = false
 		}
 	}
-	//If anything fails, I'm not crashing the calling program over a stupid box xD
-	defer func() {
-		if r := recover(); r != nil {
-			for i, _ := range vecs {
-				vecs[i] = 0.0
-			}
+	if cosines {
+		for i, v := range angs {
+			angs[i] = math.Acos(v) * 180 / math.Pi
 		}
-	}()
-	return vecs
+	}
+	return &chem.UnitCell{Lengths: [3]float64{b[0], b[2], b[5]}, Angles: angs}
 }
 
 //New builds a new DCDObj object from a DCD trajectory file
@@ -143,32 +128,38 @@ func (D *DCDObj) Close() {
 	D.readable = false
 }
 
-//initRead initializes a XtcObj for reading.
+//initRead initializes a DCDObj for reading.
 //It requires only the filename, which must be valid.
-//It support big and little endianness, charmm or (namd>=2.1) and no
-//fixed atoms.
+//It supports big and little endianness, charmm, namd>=2.1 and X-PLOR files,
+//fixed atoms, unit cells and the 4th dimension of charmm.
 func (D *DCDObj) initRead(name string) error {
 	wrapbinerr := func(err error) error {
 		return Error{err.Error(), D.filename, []string{"binary.Read", "initRead"}, true}
 	}
 
 	rec_scale := rSCAL32BITS //At least for now we will not support anything else.
-	D.endian = binary.LittleEndian
 	_ = rec_scale
 	NB := bytes.NewBuffer //shortness sake
 	var err error
+	var check int32
+	D.filename = name
 	D.dcd, err = os.Open(name)
 	if err != nil {
 		return Error{err.Error(), D.filename, []string{"os.Open", "initRead"}, true}
 	}
-	var check int32
-	if err := binary.Read(D.dcd, D.endian, &check); err != nil {
+	//For some reason the first thing we should read is an 84.
+	//We use it to find out whether the file is little or big endian.
+	first := make([]byte, 4, 4)
+	if _, err := io.ReadFull(D.dcd, first); err != nil {
 		return wrapbinerr(err)
 	}
-	//For some reason the first thing we should read is an 84.
-	//If this fails it means that the file is big endian.
-	if check != 84 {
-		D.endian = binary.BigEndian //
+	switch {
+	case binary.LittleEndian.Uint32(first) == 84:
+		D.endian = binary.LittleEndian
+	case binary.BigEndian.Uint32(first) == 84:
+		D.endian = binary.BigEndian
+	default:
+		return Error{WrongFormat + ": Wrong size of the first block (files with 64-bit block sizes are not supported)", D.filename, []string{"initRead"}, true}
 	}
 	//Then the magic number "CORD", also for some unknown reason.
 	magic := make([]byte, 4, 4)
@@ -184,42 +175,30 @@ func (D *DCDObj) initRead(name string) error {
 	if err := binary.Read(D.dcd, D.endian, buf); err != nil {
 		return wrapbinerr(err)
 	}
-	//X-plor sets this last int to zero, charmm sets it to its version number.
-	//if we have a charmm file we get some additional flags.
-	if err := binary.Read(NB(buf[76:]), D.endian, &check); err != nil {
+	var icntrl [20]int32
+	if err := binary.Read(NB(buf), D.endian, &icntrl); err != nil {
 		return wrapbinerr(err)
 	}
-	if check != 0 {
-		//		fmt.Println("CHARMM!!!") //////77
+	D.istart = icntrl[1]
+	D.nsavc = icntrl[2]
+	D.fixed = icntrl[8]
+	//X-plor sets this last int to zero, charmm sets it to its version number.
+	//if we have a charmm file we get some additional flags.
+	if icntrl[19] != 0 {
 		D.charmm = true
-		if err := binary.Read(NB(buf[40:]), D.endian, &check); err != nil {
+		D.extrablock = icntrl[10] != 0
+		D.fourdim = icntrl[11] != 0
+		var delta float32 //This should work only on Charmm and namd >=2.1
+		if err := binary.Read(NB(buf[36:]), D.endian, &delta); err != nil {
 			return wrapbinerr(err)
 		}
-		if check != 0 {
-			//			fmt.Println("block", check) ///////////
-			D.extrablock = true
-		}
-		if err := binary.Read(NB(buf[40:]), D.endian, &check); err != nil {
+		D.delta = float64(delta)
+	} else {
+		//X-plor uses a double for the time step, and has no additional flags.
+		if err := binary.Read(NB(buf[36:]), D.endian, &D.delta); err != nil {
 			return wrapbinerr(err)
 		}
-		if check == 1 {
-			//			fmt.Println("4-dim", check) ///////////
-			D.fourdim = true
-		}
-
-	} else {
-		return Error{WrongFormat + ": X-plor DCD not supported", D.filename, []string{"initRead"}, true}
-	}
-	if err := binary.Read(NB(buf[32:]), D.endian, &D.fixed); err != nil {
-		return Error{err.Error(), D.filename, []string{"initRead"}, true}
-
 	}
-	//	fmt.Println("fixed", D.fixed)
-	var delta float32 //This should work only on Charmm and namd >=2.1
-	if err := binary.Read(NB(buf[36:]), D.endian, &delta); err != nil {
-		return wrapbinerr(err)
-	}
-	//	fmt.Println("delta:", delta)///////////////////////////////////////
 
 	if err := binary.Read(D.dcd, D.endian, &check); err != nil {
 		return Error{err.Error(), D.filename, []string{"initRead"}, true}
@@ -227,8 +206,8 @@ func (D *DCDObj) initRead(name string) error {
 	if check != 84 {
 		return Error{WrongFormat, D.filename, []string{"initRead"}, true}
 	}
-	var input_int int32
-	if err := binary.Read(D.dcd, D.endian, &input_int); err != nil {
+	var titlesize int32
+	if err := binary.Read(D.dcd, D.endian, &titlesize); err != nil {
 		return wrapbinerr(err)
 	}
 	//how many units of mAXTITLE does the title have?
@@ -236,15 +215,27 @@ func (D *DCDObj) initRead(name string) error {
 	if err := binary.Read(D.dcd, D.endian, &ntitle); err != nil {
 		return wrapbinerr(err)
 	}
+	//Older versions of goChem wrote a wrong size for this block, so we only check that
+	//the titles fit in it.
+	if ntitle < 0 || titlesize < 4+ntitle*mAXTITLE {
+		return Error{WrongFormat + ": Wrong title block", D.filename, []string{"initRead"}, true}
+	}
 	title := make([]byte, mAXTITLE*ntitle, mAXTITLE*ntitle)
 	if err := binary.Read(D.dcd, D.endian, title); err != nil {
 		return wrapbinerr(err)
 	}
-	//	fmt.Println("Title:", string(title))///////////////////////////////////////
-	if err := binary.Read(D.dcd, D.endian, &input_int); err != nil {
+	D.titles = make([]string, 0, ntitle)
+	for i := 0; i < int(ntitle); i++ {
+		t := title[i*int(mAXTITLE) : (i+1)*int(mAXTITLE)]
+		D.titles = append(D.titles, strings.TrimRight(string(t), " \x00"))
+	}
+	if err := binary.Read(D.dcd, D.endian, &check); err != nil {
 		return wrapbinerr(err)
 
 	}
+	if check != titlesize {
+		return Error{WrongFormat + ": Wrong title block", D.filename, []string{"initRead"}, true}
+	}
 	if err := binary.Read(D.dcd, D.endian, &check); err != nil {
 		return wrapbinerr(err)
 
@@ -262,16 +253,74 @@ func (D *DCDObj) initRead(name string) error {
 	if check != 4 { //and one more 4
 		return Error{WrongFormat, D.filename, []string{"initRead"}, true}
 	}
-	if D.fixed == 0 {
-		runtime.SetFinalizer(D, func(D *DCDObj) {
-			D.dcd.Close()
-		})
-		D.readable = true
-		return nil //nothing else to do
+	if D.fixed < 0 || D.fixed >= D.natoms {
+		return Error{fmt.Sprintf("%s: %d fixed atoms, out of %d", WrongFormat, D.fixed, D.natoms), D.filename, []string{"initRead"}, true}
 	}
+	//If there are fixed atoms, the indexes (from 1) of the free atoms come next.
+	//The frames after the first contain only the free atoms.
+	if D.fixed > 0 {
+		nfree := D.natoms - D.fixed
+		if err := binary.Read(D.dcd, D.endian, &check); err != nil {
+			return wrapbinerr(err)
+		}
+		if check != 4*nfree {
+			return Error{WrongFormat + ": Wrong free atoms block", D.filename, []string{"initRead"}, true}
+		}
+		D.free = make([]int32, nfree)
+		if err := binary.Read(D.dcd, D.endian, D.free); err != nil {
+			return wrapbinerr(err)
+		}
+		for i, v := range D.free {
+			if v < 1 || v > D.natoms {
+				return Error{fmt.Sprintf("%s: Free atom index %d out of range", WrongFormat, v), D.filename, []string{"initRead"}, true}
+			}
+			D.free[i] = v - 1
+		}
+		if err := binary.Read(D.dcd, D.endian, &check); err != nil {
+			return wrapbinerr(err)
+		}
+		if check != 4*nfree {
+			return Error{WrongFormat + ": Wrong free atoms block", D.filename, []string{"initRead"}, true}
+		}
+		D.freeBuffer = make([]float32, nfree)
+	}
+	D.headerSize, err = D.dcd.(io.Seeker).Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Error{err.Error(), D.filename, []string{"os.File.Seek", "initRead"}, true}
+	}
+	runtime.SetFinalizer(D, func(D *DCDObj) {
+		D.dcd.Close()
+	})
 	D.new = true //nothing read yet
-	return Error{"Fixed atoms not supported", D.filename, []string{"initRead"}, true}
+	D.readable = true
+	return nil
+}
+
+//Timestep returns the time step of the simulation, in ps, as given in the header of the file.
+//The time between frames is Timestep()*SaveInterval().
+func (D *DCDObj) Timestep() float64 {
+	return D.delta * aKMA2ps
+}
+
+//SaveInterval returns the number of simulation steps between frames.
+func (D *DCDObj) SaveInterval() int {
+	return int(D.nsavc)
+}
 
+//FirstStep returns the simulation step of the first frame.
+func (D *DCDObj) FirstStep() int {
+	return int(D.istart)
+}
+
+//Fixed returns the number of fixed atoms in the trajectory. The coordinates of these
+//atoms are stored only for the first frame, and the same values are returned for all frames.
+func (D *DCDObj) Fixed() int {
+	return int(D.fixed)
+}
+
+//Titles returns the title records in the header of the file, without trailing spaces.
+func (D *DCDObj) Titles() []string {
+	return D.titles
 }
 
 //Next Reads the next frame in a DcDObj that has been initialized for read
@@ -307,32 +356,31 @@ func (D *DCDObj) Next(keep *v3.Matrix, box ...[]float64) error {
 	}
 	//	final := NewVecs(outBlock, int(D.natoms), 3)
 	//	fmt.Print(final)/////////7
-	if len(box) > 0 && D.extrablock && !allZeros(D.box) {
-		lens := []float64{float64(D.box[0]), float64(D.box[2]), float64(D.box[5])}
-		angs := []float64{float64(D.box[1]), float64(D.box[3]), float64(D.box[4])}
-		box[0] = lensAngs2Vecs(lens, angs, box[0]) //No error here, if it doesn't work,
-		//you just get a bunch of zeros as a box.
-
-		//Here we reset the "box buffer" to zeros, so, if the next call fails, you get zeros
-		//and not something weird.
-		for i, _ := range D.box {
-			D.box[i] = 0
-		}
-	}
 	//If we couldn't get box info, you'll at least get a heads-up.
 	if len(box) > 0 {
-		if !D.extrablock {
+		if !D.extrablock && !D.boxWarned {
 			log.Printf("The trajectory %s does not contain box info\n", D.filename)
+			D.boxWarned = true //once is enough.
 		}
 		if D.extrablock && allZeros(D.box) {
 			log.Printf("The trajectory %s contains box info, but", D.filename)
 			log.Printf("It's either absent from this frame, or it could not be read\n")
 		}
 	}
+	if len(box) > 0 && len(box[0]) >= 9 && D.extrablock && !allZeros(D.box) {
+		copy(box[0], cell(D.box).Vectors())
+	}
+	if D.extrablock {
+		//Here we reset the "box buffer" to zeros, so, if the next call fails, you get zeros
+		//and not something weird.
+		for i, _ := range D.box {
+			D.box[i] = 0
+		}
+	}
 	return nil
 }
 
-func allZeros(test [6]float32) bool {
+func allZeros(test [6]float64) bool {
 	for _, v := range test {
 		if v != 0.0 {
 			return false
@@ -355,63 +403,72 @@ func (D *DCDObj) nextRaw(blocks [][]float32) error {
 		D.Close()
 		return newlastFrameError(D.filename, "nextRaw")
 	}
-	//if there is an extra block we just skip it.
-	//Sadly, even when there is an extra block, it is not present in all
-	//snapshots for some trajectories, so we must use the block size to see if
-	//there is an extra block or if the X block starts inmediately
+	//After the first frame, only the free atoms are stored, if there are fixed atoms.
+	n := D.natoms
+	onlyfree := D.fixed > 0 && D.current > 0
+	if onlyfree {
+		n -= D.fixed
+		if D.firstFrame == nil {
+			return Error{"The first frame, with the fixed atoms, has not been read", D.filename, []string{"nextRaw"}, true}
+		}
+	}
+	//if there is an extra block we read the box from it.
 	var blocksize int32
-	if D.extrablock {
+	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
+		D.Close()
+		return newlastFrameError(D.filename, "nextRaw")
+	}
+	//The header says whether there is an extra block. Sadly, for some trajectories, it is
+	//not present in all the snapshots, or it is there even if the header says it isn't, so,
+	//if the size of the block (the box is 6 float64s) contradicts the header, we trust the size.
+	//When the X block has the same size as the box, we can only trust the header.
+	box := D.extrablock
+	if blocksize != 6*8 {
+		box = false
+	} else if blocksize != 4*n {
+		box = true
+		D.extrablock = true
+	}
+	if box {
+		if err := D.readFloat64Block(blocksize, D.box[:]); err != nil {
+			return errDecorate(err, "nextRaw")
+		}
+		//now get the coords, each as a slice of float32
 		if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
 			D.Close()
 			return newlastFrameError(D.filename, "nextRaw")
 		}
-		//If the blocksize is 4*natoms it means that the block is not an
-		//extra block, but the X coordinates, and thus we must skip the following.
-		//We will use the weaker condition blocksize<natoms*4, because the last
-		//frame doesn't seem to carry the 4th D.
-		if blocksize < D.natoms*3 {
-			//We'll try to read the box vectors. Good luck, I guess :-D
-			if err := D.readFloat32Block(blocksize, D.box[:]); err != nil {
-				return err
+	}
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
+				return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
 			}
-			blocksize = 0
 		}
-	}
-	//now get the coords, each as a slice of float32
-	//X
-	//we collect the X block size again only if it has not been collected before
-	if blocksize == 0 {
-		if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
-			D.Close()
-			return newlastFrameError(D.filename, "nextRaw")
-
+		if blocksize != 4*n {
+			return Error{fmt.Sprintf("%s: block of %d bytes for %d atoms", WrongFormat, blocksize, n), D.filename, []string{"nextRaw"}, true}
+		}
+		if !onlyfree {
+			if err := D.readFloat32Block(blocksize, blocks[i]); err != nil {
+				return errDecorate(err, "nextRaw")
+			}
+			continue
+		}
+		if err := D.readFloat32Block(blocksize, D.freeBuffer); err != nil {
+			return errDecorate(err, "nextRaw")
+		}
+		copy(blocks[i], D.firstFrame[i])
+		for j, v := range D.free {
+			blocks[i][v] = D.freeBuffer[j]
 		}
 	}
-	err := D.readFloat32Block(blocksize, blocks[0])
-	if err != nil {
-		return errDecorate(err, "nextRaw")
-	}
-	//	fmt.Println("X", len(xblock)) //, xblock)
-	//	fmt.Println("X", blocks[0])  ///////////////////////////////
-	//Y
-	//Collect the size first, then the rest
-	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
-		return err
-	}
-	err = D.readFloat32Block(blocksize, blocks[1])
-	if err != nil {
-		return errDecorate(err, "nextRaw")
-	}
-	//	fmt.Println("Y", blocks[1])
-	//Z
-	if err := binary.Read(D.dcd, D.endian, &blocksize); err != nil {
-		return Error{err.Error(), D.filename, []string{"binary.Read", "nextRaw"}, true}
-	}
-	err = D.readFloat32Block(blocksize, blocks[2])
-	if err != nil {
-		return errDecorate(err, "nextRaw")
+	if D.fixed > 0 && D.current == 0 {
+		D.firstFrame = make([][]float32, 3)
+		for i := range D.firstFrame {
+			D.firstFrame[i] = append([]float32(nil), blocks[i]...)
+		}
 	}
-	//	fmt.Println("Z", blocks[2])
+	D.current++
 	//we skip the 4-D values if they exist. Apparently this is not present in the
 	//last snapshot, so we use an EOF here to signal that we have read the last snapshot.
 	if D.charmm && D.fourdim {
@@ -451,6 +508,23 @@ func (D *DCDObj) readFloat32Block(blocksize int32, block []float32) error {
 	return nil
 }
 
+//Reads the contents of a block into block, which must have the
+//appropiate size, and checks the size of the block at its end.
+func (D *DCDObj) readFloat64Block(blocksize int32, block []float64) error {
+	var check int32
+	if err := binary.Read(D.dcd, D.endian, block); err != nil {
+		return Error{err.Error(), D.filename, []string{"binary.Read", "readFloat64Block"}, true}
+
+	}
+	if err := binary.Read(D.dcd, D.endian, &check); err != nil {
+		return Error{err.Error(), D.filename, []string{"binary.Read", "readFloat64Block"}, true}
+	}
+	if check != blocksize {
+		return Error{WrongFormat, D.filename, []string{"readFloat64Block"}, true}
+	}
+	return nil
+}
+
 //Queries the size of a block, make a slice of a quarter of that size
 //and reads that ammount of float32. This function is used for the
 //
@@ -478,6 +552,101 @@ func (D *DCDObj) Len() int {
 	return int(D.natoms)
 }
 
+//frameSize returns the size, in bytes, of the given frame in the trajectory. All the frames
+//have the same size, except for the first one, if there are fixed atoms.
+func (D *DCDObj) frameSize(frame int) int64 {
+	n := int64(D.natoms)
+	if frame > 0 {
+		n -= int64(D.fixed)
+	}
+	block := 4*n + 8 //the block, plus its size before and after it.
+	size := 3 * block
+	if D.extrablock {
+		size += 6*8 + 8 //the box: 6 float64s.
+	}
+	if D.fourdim {
+		size += block
+	}
+	return size
+}
+
+//frameOffset returns the position in the file, in bytes, where the given frame starts.
+func (D *DCDObj) frameOffset(frame int) int64 {
+	if frame == 0 {
+		return D.headerSize
+	}
+	return D.headerSize + D.frameSize(0) + int64(frame-1)*D.frameSize(1)
+}
+
+//NFrames returns the number of frames in the trajectory, obtained from the size of the file,
+//or -1 if the file can't be accessed.
+func (D *DCDObj) NFrames() int {
+	n, err := D.nFrames()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func (D *DCDObj) nFrames() (int, error) {
+	info, err := os.Stat(D.filename)
+	if err != nil {
+		return -1, Error{err.Error(), D.filename, []string{"os.Stat", "nFrames"}, true}
+	}
+	size := info.Size() - D.headerSize
+	if D.fourdim {
+		size += 4*int64(D.natoms-D.fixed) + 8 //the last frame may not have the 4th dimension.
+	}
+	if size < D.frameSize(0) {
+		return 0, nil
+	}
+	return 1 + int((size-D.frameSize(0))/D.frameSize(1)), nil
+}
+
+//Tell returns the index of the next frame to be read, counting from 0.
+func (D *DCDObj) Tell() int {
+	return D.current
+}
+
+//Seek sets the trajectory so the next frame read is frame (counting from 0).
+//It assumes that all the frames have the same size (except for the first one, if there are
+//fixed atoms), as in the DCDs produced by
+//CHARMM, NAMD or OpenMM, and goChem itself. If the trajectory was
+//closed, for instance, because its last frame was read, it is opened again.
+func (D *DCDObj) Seek(frame int) error {
+	n, err := D.nFrames()
+	if err != nil {
+		return errDecorate(err, "Seek")
+	}
+	if frame < 0 || frame >= n {
+		return Error{fmt.Sprintf("Frame %d out of range, the trajectory has %d frames", frame, n), D.filename, []string{"Seek"}, true}
+	}
+	if !D.readable {
+		D.readLast = false
+		runtime.SetFinalizer(D, nil) //initRead sets it again.
+		if err := D.initRead(D.filename); err != nil {
+			return errDecorate(err, "Seek")
+		}
+	}
+	//The first frame is needed to get the coordinates of the fixed atoms.
+	if frame > 0 && D.fixed > 0 && D.firstFrame == nil {
+		if _, err := D.dcd.(io.Seeker).Seek(D.headerSize, io.SeekStart); err != nil {
+			return Error{err.Error(), D.filename, []string{"os.File.Seek", "Seek"}, true}
+		}
+		D.current = 0
+		first := [][]float32{make([]float32, D.natoms), make([]float32, D.natoms), make([]float32, D.natoms)}
+		if err := D.nextRaw(first); err != nil {
+			return errDecorate(err, "Seek")
+		}
+	}
+	if _, err := D.dcd.(io.Seeker).Seek(D.frameOffset(frame), io.SeekStart); err != nil {
+		return Error{err.Error(), D.filename, []string{"os.File.Seek", "Seek"}, true}
+	}
+	D.current = frame
+	D.readLast = false
+	return nil
+}
+
 //This function never actually returns error. Still, it is an internal function
 //and I may modify it some days so I won't change the signature.
 func (D *DCDObj) setConcBuffer(batchsize int) error {
@@ -518,9 +687,14 @@ func (D *DCDObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 	if D.buffSize < len(frames) {
 		D.setConcBuffer(len(frames))
 	}
+	used := false
 	for key, _ := range frames {
 		DFields := D.concBuffer[key]
 		if err := D.nextRaw(DFields); err != nil {
+			//If some frames were read, they are returned with the error.
+			if _, ok := err.(chem.LastFrameError); ok && used {
+				return framechans[:key], errDecorate(err, "NextConc")
+			}
 			return nil, errDecorate(err, "NextConc")
 		}
 		//We have to test for used twice to allow allocating for goCoords
@@ -529,6 +703,7 @@ func (D *DCDObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 			framechans[key] = nil //ignored frame
 			continue
 		}
+		used = true
 		framechans[key] = make(chan *v3.Matrix)
 		//Now the parallel part
 		go func(natoms int, DFields [][]float32, keep *v3.Matrix, pipe chan *v3.Matrix) {
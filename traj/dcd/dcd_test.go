@@ -25,7 +25,12 @@
 package dcd
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 
 	chem "github.com/rmera/gochem"
@@ -249,3 +254,329 @@ func SecondRow(channelin, channelout chan *VecMatrix, current, other int) {
 	return
 }
 */
+
+// TestPSFDCD writes a topology to a PSF file, and a trajectory for it to a DCD file,
+// and reads back the pair, as one would for a CHARMM or NAMD simulation.
+func TestPSFDCD(Te *testing.T) {
+	dir := Te.TempDir()
+	var atoms []*chem.Atom
+	for i := 0; i < 3; i++ {
+		atoms = append(atoms,
+			&chem.Atom{Name: "OH2", MolName: "TIP3", MolID: i + 1, SegID: "SOLV", Type: "OT", Charge: -0.834, Mass: 15.9994, Symbol: "O"},
+			&chem.Atom{Name: "H1", MolName: "TIP3", MolID: i + 1, SegID: "SOLV", Type: "HT", Charge: 0.417, Mass: 1.008, Symbol: "H"},
+			&chem.Atom{Name: "H2", MolName: "TIP3", MolID: i + 1, SegID: "SOLV", Type: "HT", Charge: 0.417, Mass: 1.008, Symbol: "H"})
+	}
+	top := chem.NewTopology(0, 1, atoms)
+	psfname := filepath.Join(dir, "test.psf")
+	if err := chem.PSFFileWrite(psfname, top); err != nil {
+		Te.Fatal(err)
+	}
+	dcdname := filepath.Join(dir, "test.dcd")
+	w, err := NewWriter(dcdname, top.Len())
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(top.Len())
+	for f := 0; f < 3; f++ {
+		for i := 0; i < top.Len(); i++ {
+			coords.Set(i, 0, float64(f+i))
+		}
+		if err := w.WNext(coords); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	rtop, err := chem.PSFFileRead(psfname)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	traj, err := New(dcdname)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if rtop.Len() != traj.Len() {
+		Te.Fatalf("PSF and DCD atom numbers don't match: %d %d", rtop.Len(), traj.Len())
+	}
+	if at := rtop.Atom(4); at.SegID != "SOLV" || at.MolID != 2 || at.Name != "H1" || at.Type != "HT" {
+		Te.Errorf("Wrong atom read from PSF: %+v", at)
+	}
+	for f := 0; ; f++ {
+		if err := traj.Next(coords); err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok || f != 3 {
+				Te.Fatal(f, err)
+			}
+			break
+		}
+		if coords.At(4, 0) != float64(f+4) {
+			Te.Errorf("Wrong coordinates in frame %d: %v", f, coords.VecView(4))
+		}
+	}
+}
+
+func TestDCDSeek(Te *testing.T) {
+	const natoms, nframes = 12, 7
+	name := filepath.Join(Te.TempDir(), "seek.dcd")
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(natoms)
+	for f := 0; f < nframes; f++ {
+		for i := 0; i < natoms; i++ {
+			coords.Set(i, 0, float64(10*f+i))
+		}
+		if err := w.WNext(coords); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer traj.Close()
+	var _ chem.Seeker = traj
+	if n := traj.NFrames(); n != nframes {
+		Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+	}
+	//forward, backwards, and after the end of the trajectory was reached.
+	for _, f := range []int{4, 1, 6, 0} {
+		if err := traj.Seek(f); err != nil {
+			Te.Fatal(err)
+		}
+		for ; ; f++ {
+			if traj.Tell() != f {
+				Te.Fatalf("Tell returned %d, expected %d", traj.Tell(), f)
+			}
+			if err := traj.Next(coords); err != nil {
+				if _, ok := err.(chem.LastFrameError); !ok || f != nframes {
+					Te.Fatal(f, err)
+				}
+				break
+			}
+			if coords.At(3, 0) != float64(10*f+3) {
+				Te.Fatalf("Wrong coordinates in frame %d: %v", f, coords.VecView(3))
+			}
+		}
+	}
+	if err := traj.Seek(nframes); err == nil {
+		Te.Error("Seek beyond the last frame didn't fail")
+	}
+}
+
+// rawDCD writes a CHARMM DCD file with the given byte order, where only the atoms with
+// indexes (from 0) in free are stored after the first frame (all, if free is nil), with
+// the box block cell in each frame, if not nil, and with a 4th dimension block, if fourdim is true.
+func rawDCD(name string, order binary.ByteOrder, frames []*v3.Matrix, free []int32, cell []float64, fourdim bool) error {
+	var b bytes.Buffer
+	w := func(data ...interface{}) {
+		for _, d := range data {
+			binary.Write(&b, order, d)
+		}
+	}
+	natoms := frames[0].NVecs()
+	var icntrl [20]uint32
+	icntrl[0] = uint32(len(frames))
+	icntrl[1], icntrl[2] = 100, 10 //first step and steps between frames
+	if free != nil {
+		icntrl[8] = uint32(natoms - len(free))
+	}
+	icntrl[9] = math.Float32bits(2) //the time step, in AKMA units.
+	if cell != nil {
+		icntrl[10] = 1
+	}
+	if fourdim {
+		icntrl[11] = 1
+	}
+	icntrl[19] = 24
+	w(int32(84), []byte("CORD"), icntrl, int32(84))
+	title := []byte(fmt.Sprintf("%-80s%-80s", "* FIRST TITLE", "* SECOND TITLE"))
+	w(int32(164), int32(2), title, int32(164))
+	w(int32(4), int32(natoms), int32(4))
+	if free != nil {
+		w(int32(4*len(free)))
+		for _, v := range free {
+			w(v + 1)
+		}
+		w(int32(4 * len(free)))
+	}
+	for f, m := range frames {
+		if cell != nil {
+			w(int32(48), cell, int32(48))
+		}
+		atoms := make([]int, 0, natoms)
+		for i := 0; i < natoms; i++ {
+			atoms = append(atoms, i)
+		}
+		if f > 0 && free != nil {
+			atoms = atoms[:0]
+			for _, v := range free {
+				atoms = append(atoms, int(v))
+			}
+		}
+		size := int32(4 * len(atoms))
+		for k := 0; k < 3; k++ {
+			w(size)
+			for _, i := range atoms {
+				w(float32(m.At(i, k)))
+			}
+			w(size)
+		}
+		if fourdim {
+			w(size, make([]float32, len(atoms)), size)
+		}
+	}
+	return os.WriteFile(name, b.Bytes(), 0644)
+}
+
+func TestDCDVariants(Te *testing.T) {
+	const natoms, nframes = 4, 3
+	frames := make([]*v3.Matrix, nframes)
+	for f := range frames {
+		frames[f] = v3.Zeros(natoms)
+		for i := 0; i < natoms; i++ {
+			for k := 0; k < 3; k++ {
+				frames[f].Set(i, k, float64(10*f+i+k))
+			}
+		}
+	}
+	dir := Te.TempDir()
+	//Big endian, with fixed atoms (0 and 2), a box with angles in degrees, and a 4th dimension.
+	//Then little endian, with a box with the angles as cosines.
+	for n, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		name := filepath.Join(dir, fmt.Sprintf("variant%d.dcd", n))
+		cell, free := []float64{10, 90, 12, 90, 90, 14}, []int32{1, 3}
+		if n == 1 {
+			cell, free = []float64{10, 0, 12, 0, 0, 14}, nil
+		}
+		if err := rawDCD(name, order, frames, free, cell, n == 0); err != nil {
+			Te.Fatal(err)
+		}
+		traj, err := New(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		if traj.Fixed() != 2*(1-n) || traj.SaveInterval() != 10 || traj.FirstStep() != 100 || math.Abs(traj.Timestep()-2*0.04888821) > 1e-6 {
+			Te.Errorf("Wrong header information: %d fixed atoms, %d %d %f", traj.Fixed(), traj.SaveInterval(), traj.FirstStep(), traj.Timestep())
+		}
+		if t := traj.Titles(); len(t) != 2 || t[1] != "* SECOND TITLE" {
+			Te.Errorf("Wrong titles: %q", t)
+		}
+		if traj.NFrames() != nframes {
+			Te.Errorf("NFrames returned %d, expected %d", traj.NFrames(), nframes)
+		}
+		//We go first to the last frame, so the first one has to be read from the Seek call.
+		coords := v3.Zeros(natoms)
+		box := make([]float64, 9)
+		if err := traj.Seek(2); err != nil {
+			Te.Fatal(err)
+		}
+		if err := traj.Next(coords, box); err != nil {
+			Te.Fatal(err)
+		}
+		//The fixed atoms keep the coordinates of the first frame.
+		if coords.At(1, 2) != 23 || (n == 0 && coords.At(2, 0) != 2) || (n == 1 && coords.At(2, 0) != 22) {
+			Te.Errorf("Wrong coordinates in the last frame: %v", coords)
+		}
+		for i, v := range []float64{10, 0, 0, 0, 12, 0, 0, 0, 14} {
+			if math.Abs(box[i]-v) > 1e-6 {
+				Te.Errorf("Wrong box: %v", box)
+				break
+			}
+		}
+		if err := traj.Next(coords); err == nil {
+			Te.Error("Expected the end of the trajectory")
+		}
+		if err := traj.Seek(0); err != nil {
+			Te.Fatal(err)
+		}
+		read := 0
+		for ; traj.Next(coords) == nil; read++ {
+		}
+		if read != nframes {
+			Te.Errorf("Read %d frames, expected %d", read, nframes)
+		}
+	}
+}
+
+// TestDCDUnflaggedBox reads a trajectory with a box in each frame, but where the header
+// says there is none.
+func TestDCDUnflaggedBox(Te *testing.T) {
+	frames := []*v3.Matrix{v3.Zeros(4), v3.Zeros(4)}
+	frames[1].Set(3, 2, 5)
+	name := filepath.Join(Te.TempDir(), "unflagged.dcd")
+	if err := rawDCD(name, binary.LittleEndian, frames, nil, []float64{10, 90, 12, 90, 90, 14}, false); err != nil {
+		Te.Fatal(err)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0, 0, 0, 0}, 48) //the extra block flag.
+	f.Close()
+	if err != nil {
+		Te.Fatal(err)
+	}
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(4)
+	box := make([]float64, 9)
+	for i := range frames {
+		if err := traj.Next(coords, box); err != nil {
+			Te.Fatal(err)
+		}
+		if box[0] != 10 || box[4] != 12 || box[8] != 14 {
+			Te.Errorf("Wrong box in frame %d: %v", i, box)
+		}
+	}
+	if coords.At(3, 2) != 5 {
+		Te.Errorf("Wrong coordinates in the last frame: %v", coords)
+	}
+}
+
+// TestDCDWriteBox writes a trajectory with a box in each frame, for 12 atoms, so the blocks
+// with the coordinates have the same size as the one with the box.
+func TestDCDWriteBox(Te *testing.T) {
+	const natoms, nframes = 12, 3
+	name := filepath.Join(Te.TempDir(), "box.dcd")
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(natoms)
+	boxes := make([][]float64, nframes)
+	for f := 0; f < nframes; f++ {
+		for i := 0; i < natoms; i++ {
+			coords.Set(i, 1, float64(10*f+i))
+		}
+		boxes[f] = []float64{30 + float64(f), 0, 0, 5, 40, 0, 0, 0, 50}
+		if err := w.WNext(coords, boxes[f]); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer traj.Close()
+	box := make([]float64, 9)
+	for _, f := range []int{0, 2, 1} {
+		if err := traj.Seek(f); err != nil {
+			Te.Fatal(err)
+		}
+		if err := traj.Next(coords, box); err != nil {
+			Te.Fatal(f, err)
+		}
+		if coords.At(11, 1) != float64(10*f+11) {
+			Te.Errorf("Wrong coordinates in frame %d: %v", f, coords.VecView(11))
+		}
+		for i, v := range boxes[f] {
+			if math.Abs(box[i]-v) > 1e-4 {
+				Te.Fatalf("Wrong box in frame %d: %v, expected %v", f, box, boxes[f])
+			}
+		}
+	}
+}
@@ -27,9 +27,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"runtime"
 
+	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
 )
 
@@ -59,7 +61,8 @@ type DCDWObj struct {
 	writable   bool //Is it ready to be written on
 	filename   string
 	charmm     bool //Charmm traj?
-	extrablock bool
+	extrablock bool //Is the unit cell written in each frame?
+	boxWarned  bool //Have we warned that a box can't be written?
 	fourdim    bool
 	frames     int32
 	new        bool     //Still no frame written to it it?
@@ -160,13 +163,13 @@ func (D *DCDWObj) initWrite(name string) error {
 	if err := binary.Write(D.dcd, D.endian, int32(84)); err != nil {
 		return wrapbinerr(err)
 	}
-	//same as above
-	if err := binary.Write(D.dcd, D.endian, int32(244)); err != nil {
+	//how many units of mAXTITLE does the title have?
+	var ntitle int32 = 2 //just a dummy title.
+	//The size of the title block
+	if err := binary.Write(D.dcd, D.endian, 4+ntitle*mAXTITLE); err != nil {
 		return wrapbinerr(err)
 	}
 
-	//how many units of mAXTITLE does the title have?
-	var ntitle int32 = 2 //just a dummy title.
 	if err := binary.Write(D.dcd, D.endian, ntitle); err != nil {
 		return wrapbinerr(err)
 	}
@@ -179,8 +182,8 @@ func (D *DCDWObj) initWrite(name string) error {
 	if err := binary.Write(D.dcd, D.endian, title); err != nil {
 		return wrapbinerr(err)
 	}
-	//no idea
-	if err := binary.Write(D.dcd, D.endian, int32(244)); err != nil {
+	//The size of the title block, again.
+	if err := binary.Write(D.dcd, D.endian, 4+ntitle*mAXTITLE); err != nil {
 		return wrapbinerr(err)
 	}
 	//no idea
@@ -208,8 +211,11 @@ func (D *DCDWObj) initWrite(name string) error {
 	return nil   //nothing else to do
 }
 
-//WNext rites the next frame to the trajectory.
-func (D *DCDWObj) WNext(towrite *v3.Matrix) error {
+//WNext writes the next frame to the trajectory. If a box is given for the first frame, the file
+//will contain a unit cell, in the CHARMM format, for each frame. The unit cell is then written for
+//all the frames where a box is given, and all zeros are written for frames without box.
+//If no box is given for the first frame, no box is written for any frame.
+func (D *DCDWObj) WNext(towrite *v3.Matrix, box ...[]float64) error {
 	if !D.writable {
 		return Error{TrajUnIni, D.filename, []string{"WNext"}, true}
 	}
@@ -233,6 +239,26 @@ func (D *DCDWObj) WNext(towrite *v3.Matrix) error {
 		D.dcdFields[1][k] = float32(towrite.At(k, 1))
 		D.dcdFields[2][k] = float32(towrite.At(k, 2))
 	}
+	var cell [6]float64 //A, gamma, B, beta, alpha, C, with the angles in degrees, as NAMD does.
+	if len(box) > 0 && len(box[0]) >= 9 {
+		if b, err := chem.NewBox(box[0]); err == nil {
+			u := b.UnitCell()
+			cell = [6]float64{u.Lengths[0], u.Angles[2], u.Lengths[1], u.Angles[1], u.Angles[0], u.Lengths[2]}
+			if D.frames == 0 && !D.extrablock {
+				if err := D.setExtraBlock(); err != nil {
+					return errDecorate(err, "WNext")
+				}
+			} else if !D.extrablock && !D.boxWarned {
+				log.Printf("The trajectory %s has no box in its first frame, so no box will be written\n", D.filename)
+				D.boxWarned = true //once is enough.
+			}
+		}
+	}
+	if D.extrablock {
+		if err := D.writeFloat64Block(cell[:]); err != nil {
+			return errDecorate(err, "WNext")
+		}
+	}
 	D.wnextRaw(D.dcdFields)
 	D.frames++
 	D.updateFrames()
@@ -283,6 +309,30 @@ func (D *DCDWObj) wnextRaw(blocks [][]float32) error {
 
 }
 
+//Writes a block of float64s to the file, with its size before and after it.
+func (D *DCDWObj) writeFloat64Block(block []float64) error {
+	var blocksize int32 = int32(len(block)) * 8
+	for _, v := range []interface{}{blocksize, block, blocksize} {
+		if err := binary.Write(D.dcd, D.endian, v); err != nil {
+			return Error{err.Error(), D.filename, []string{"binary.Write", "writeFloat64Block"}, true}
+		}
+	}
+	return nil
+}
+
+//setExtraBlock sets the flag in the header that indicates that the frames contain a unit cell
+//(the 11th control integer).
+func (D *DCDWObj) setExtraBlock() error {
+	const offset = 4 + 4 + 10*4 //The block size, "CORD" and the first 10 control integers.
+	one := make([]byte, 4)
+	D.endian.PutUint32(one, 1)
+	if _, err := D.dcd.WriteAt(one, offset); err != nil {
+		return Error{err.Error(), D.filename, []string{"dcd.WriteAt", "setExtraBlock"}, true}
+	}
+	D.extrablock = true
+	return nil
+}
+
 //Writes a block of float32s to the file, adding its size
 func (D *DCDWObj) writeFloat32Block(block []float32) error {
 	var blocksize int32 = int32(len(block)) * 4
@@ -0,0 +1,190 @@
+/*
+ * parallel.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package traj
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// ParallelOptions contains the options for Parallel.
+type ParallelOptions struct {
+	workers int
+	begin   int
+	skip    int
+}
+
+// DefaultParallelOptions returns options to process all the frames, using as many
+// gorutines as logical CPUs.
+func DefaultParallelOptions() *ParallelOptions {
+	return &ParallelOptions{workers: runtime.NumCPU()}
+}
+
+// Workers returns the number of frames processed at the same time, and sets
+// it to a new value, if a valid one is given.
+func (O *ParallelOptions) Workers(n ...int) int {
+	if len(n) > 0 && n[0] > 0 {
+		O.workers = n[0]
+	}
+	return O.workers
+}
+
+// Begin returns the first frame to be processed, counting from 0, and sets
+// it to a new value, if a valid one is given.
+func (O *ParallelOptions) Begin(n ...int) int {
+	if len(n) > 0 && n[0] >= 0 {
+		O.begin = n[0]
+	}
+	return O.begin
+}
+
+// Skip returns the number of frames skipped after each processed frame, and sets
+// it to a new value, if a valid one is given.
+func (O *ParallelOptions) Skip(n ...int) int {
+	if len(n) > 0 && n[0] >= 0 {
+		O.skip = n[0]
+	}
+	return O.skip
+}
+
+// Parallel calls f on the frames begin, begin+skip+1, begin+2*(skip+1)... of t, which must be a
+// chem.Traj, a chem.ConcTraj or both (counting from the next frame to be read) until the trajectory ends, processing up to Workers frames at the
+// same time, and returns the results, in the order of the frames. f receives the index of the frame
+// and its coordinates. The coordinates are reused after f returns, so f must copy them if they are
+// needed later. f must be safe to call from several gorutines at once.
+// If t is a chem.ConcTraj, the frames are read with NextConc, otherwise, with Next. If t is
+// a chem.Seeker, the skipped frames are jumped over, instead of read.
+// If f, or the reading of a frame, fails, the results for the frames before the failing
+// one are returned, together with the error.
+func Parallel[T any](t interface{ Len() int }, f func(frame int, coords *v3.Matrix) (T, error), options ...*ParallelOptions) ([]T, error) {
+	o := DefaultParallelOptions()
+	if len(options) > 0 && options[0] != nil {
+		o = options[0]
+	}
+	workers := max(o.workers, 1)
+	buffers := make([]*v3.Matrix, workers)
+	for i := range buffers {
+		buffers[i] = v3.Zeros(t.Len())
+	}
+	conc, _ := t.(chem.ConcTraj)
+	plain, _ := t.(chem.Traj)
+	if conc == nil && plain == nil {
+		return nil, fmt.Errorf("Parallel: %T is not a trajectory", t)
+	}
+	seeker, _ := t.(chem.Seeker)
+	if seeker != nil && o.skip > 0 && plain != nil {
+		conc = nil //Seeking over each skipped frame is better than reading them with NextConc.
+	}
+	seekerStart := 0
+	if seeker != nil {
+		seekerStart = seeker.Tell()
+	}
+	var ret []T
+	pos := 0 //the next frame to be read from t.
+	next := o.begin
+	batch := make([]int, 0, workers) //the frames in the current batch
+	results := make([]T, workers)    //and their results.
+	errs := make([]error, workers)
+	for ended := false; !ended; {
+		batch = batch[:0]
+		for len(batch) < workers {
+			batch = append(batch, next)
+			next += o.skip + 1
+		}
+		//The frames are read, and each is given to a gorutine, as soon as it is available.
+		var readErr error
+		var wg sync.WaitGroup
+		process := func(j int, coords *v3.Matrix) {
+			defer wg.Done()
+			results[j], errs[j] = f(batch[j], coords)
+		}
+		if seeker != nil && batch[0] > pos && seeker.Seek(seekerStart+batch[0]) == nil {
+			pos = batch[0]
+		}
+		read := 0     //frames in the batch read.
+		failed := pos //the frame that couldn't be read, if readErr is not nil.
+		if conc != nil {
+			frames := make([]*v3.Matrix, batch[workers-1]-pos+1)
+			for j, v := range batch {
+				frames[v-pos] = buffers[j]
+			}
+			var chans []chan *v3.Matrix
+			chans, readErr = conc.NextConc(frames)
+			failed = pos + len(chans)
+			for j, v := range batch {
+				if v-pos >= len(chans) || chans[v-pos] == nil {
+					break
+				}
+				wg.Add(1)
+				go func(j int, c chan *v3.Matrix) {
+					process(j, <-c)
+				}(j, chans[v-pos])
+				read++
+			}
+			pos += len(frames)
+		} else {
+			for j, v := range batch {
+				if seeker != nil && v > pos && seeker.Seek(seekerStart+v) == nil {
+					pos = v
+				}
+				for ; pos < v && readErr == nil; pos++ {
+					if readErr = plain.Next(nil); readErr != nil {
+						failed = pos
+					}
+				}
+				if readErr == nil {
+					readErr = plain.Next(buffers[j])
+					failed = pos
+				}
+				if readErr != nil {
+					break
+				}
+				pos++
+				wg.Add(1)
+				go process(j, buffers[j])
+				read++
+			}
+		}
+		wg.Wait()
+		for j := 0; j < read; j++ {
+			if errs[j] != nil {
+				return ret, fmt.Errorf("Parallel: frame %d: %w", batch[j], errs[j])
+			}
+			ret = append(ret, results[j])
+		}
+		if readErr != nil {
+			if !isLastFrame(readErr) {
+				return ret, fmt.Errorf("Parallel: reading frame %d: %w", failed, readErr)
+			}
+			ended = true
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,294 @@
+/*
+ * pipeline.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package traj
+
+import (
+	"fmt"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Transform is a change applied to each frame of a trajectory, as it is read.
+type Transform interface {
+	//Apply returns the transformed coordinates of a frame. coords can be modified and returned.
+	//box contains the box vectors of the frame, or is nil, if the frame has no box information.
+	Apply(coords *v3.Matrix, box []float64) (*v3.Matrix, error)
+
+	//Len returns the number of atoms in the transformed frames, for frames with natoms atoms.
+	Len(natoms int) int
+}
+
+// TransformFunc is a function that modifies a frame in place, with the box vectors box (or nil,
+// for frames without box information). It implements Transform.
+type TransformFunc func(coords *v3.Matrix, box []float64) error
+
+// Apply calls F on coords and box, and returns coords.
+func (F TransformFunc) Apply(coords *v3.Matrix, box []float64) (*v3.Matrix, error) {
+	return coords, F(coords, box)
+}
+
+// Len returns natoms, as F doesn't change the number of atoms.
+func (F TransformFunc) Len(natoms int) int {
+	return natoms
+}
+
+// Translate returns a Transform that adds the vector v to every atom.
+func Translate(v *v3.Matrix) Transform {
+	return TransformFunc(func(coords *v3.Matrix, box []float64) error {
+		coords.AddVec(coords, v)
+		return nil
+	})
+}
+
+// Center returns a Transform that translates each frame so the center of the atoms with the
+// given indexes (all the atoms, if indexes is nil) is at the center of the box, for frames
+// with box information, or at the origin, otherwise. If masses are given, one per atom in the
+// frame, the center of mass is used instead of the geometric center.
+func Center(indexes []int, masses ...[]float64) Transform {
+	return TransformFunc(func(coords *v3.Matrix, box []float64) error {
+		idx := indexes
+		if idx == nil {
+			idx = make([]int, coords.NVecs())
+			for i := range idx {
+				idx[i] = i
+			}
+		}
+		center := v3.Zeros(1)
+		var total float64
+		for _, i := range idx {
+			if i < 0 || i >= coords.NVecs() {
+				return fmt.Errorf("Center: index %d out of range for a frame with %d atoms", i, coords.NVecs())
+			}
+			m := 1.0
+			if len(masses) > 0 && masses[0] != nil {
+				m = masses[0][i]
+			}
+			for k := 0; k < 3; k++ {
+				center.Set(0, k, center.At(0, k)+m*coords.At(i, k))
+			}
+			total += m
+		}
+		if total == 0 {
+			return fmt.Errorf("Center: no atoms, or zero total mass, to center")
+		}
+		for k := 0; k < 3; k++ {
+			c := -center.At(0, k) / total
+			if box != nil {
+				c += (box[k] + box[3+k] + box[6+k]) / 2
+			}
+			center.Set(0, k, c)
+		}
+		coords.AddVec(coords, center)
+		return nil
+	})
+}
+
+// Fit returns a Transform that superimposes each frame on ref, which must have the same atoms,
+// using only the atoms with the given indexes, or all of them, if indexes is nil.
+func Fit(ref *v3.Matrix, indexes []int) Transform {
+	return TransformFunc(func(coords *v3.Matrix, box []float64) error {
+		var err error
+		if indexes == nil {
+			_, err = chem.Super(coords, ref)
+		} else {
+			_, err = chem.Super(coords, ref, indexes, indexes)
+		}
+		if err != nil {
+			return fmt.Errorf("Fit: %w", err)
+		}
+		return nil
+	})
+}
+
+// Wrap returns a Transform that puts all the atoms of each frame in the box. If mol is given,
+// the molecules split by the wrapping are then put together again, using the bonds in mol (see
+// chem.Box.MakeWhole). Frames without box information are not touched.
+func Wrap(mol ...chem.Atomer) Transform {
+	return TransformFunc(func(coords *v3.Matrix, box []float64) error {
+		if box == nil {
+			return nil
+		}
+		b, err := chem.NewBox(box)
+		if err != nil {
+			return fmt.Errorf("Wrap: %w", err)
+		}
+		b.Wrap(coords)
+		if len(mol) > 0 && mol[0] != nil {
+			if err := b.MakeWhole(coords, mol[0]); err != nil {
+				return fmt.Errorf("Wrap: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// unwrap keeps the previous frame, needed to unwrap the next one.
+type unwrap struct {
+	previous *v3.Matrix
+}
+
+// Unwrap returns a Transform that removes the jumps of atoms between periodic images,
+// so the atoms move continuously, as needed, for instance, to obtain diffusion coefficients
+// (see chem.Box.Unwrap). The first frame is taken as it is. Frames without box information
+// are not touched. The Transform keeps the last frame it processed, so it should
+// not be shared by different pipelines.
+func Unwrap() Transform {
+	return &unwrap{}
+}
+
+// Apply unwraps coords with respect to the previous frame, and returns it.
+func (U *unwrap) Apply(coords *v3.Matrix, box []float64) (*v3.Matrix, error) {
+	if U.previous != nil && box != nil {
+		b, err := chem.NewBox(box)
+		if err != nil {
+			return nil, fmt.Errorf("Unwrap: %w", err)
+		}
+		if err := b.Unwrap(coords, U.previous); err != nil {
+			return nil, fmt.Errorf("Unwrap: %w", err)
+		}
+	}
+	if U.previous == nil || U.previous.NVecs() != coords.NVecs() {
+		U.previous = v3.Zeros(coords.NVecs())
+	}
+	U.previous.Copy(coords)
+	return coords, nil
+}
+
+// Len returns natoms, as unwrapping doesn't change the number of atoms.
+func (U *unwrap) Len(natoms int) int {
+	return natoms
+}
+
+// atoms keeps only some atoms of the frames.
+type atoms struct {
+	indexes []int
+	out     *v3.Matrix
+}
+
+// Atoms returns a Transform that keeps only the atoms with the given indexes, in that order.
+// Transforms after it in a pipeline see only those atoms.
+func Atoms(indexes []int) Transform {
+	return &atoms{indexes: indexes, out: v3.Zeros(len(indexes))}
+}
+
+// Apply returns a matrix with the coordinates of the atoms of the subset.
+func (A *atoms) Apply(coords *v3.Matrix, box []float64) (*v3.Matrix, error) {
+	for _, i := range A.indexes {
+		if i < 0 || i >= coords.NVecs() {
+			return nil, fmt.Errorf("Atoms: index %d out of range for a frame with %d atoms", i, coords.NVecs())
+		}
+	}
+	A.out.SomeVecs(coords, A.indexes)
+	return A.out, nil
+}
+
+// Len returns the number of atoms in the subset.
+func (A *atoms) Len(natoms int) int {
+	return len(A.indexes)
+}
+
+// Pipeline is a trajectory that applies a list of transformations, in order, to each frame
+// of another trajectory, as the frames are read. For instance, to put the protein in the center
+// of the box, with the solvent around it, and then superimpose everything on a reference:
+//
+//	p := traj.NewPipeline(t, traj.Center(protein), traj.Wrap(mol), traj.Fit(ref, backbone))
+//
+// The order matters: fitting before wrapping would leave the solvent in a rotated box.
+type Pipeline struct {
+	traj       chem.Traj
+	transforms []Transform
+	buffer     *v3.Matrix
+	box        []float64
+}
+
+// NewPipeline returns a trajectory that applies transforms, in order, to each frame of traj.
+func NewPipeline(traj chem.Traj, transforms ...Transform) *Pipeline {
+	return &Pipeline{traj: traj, transforms: transforms, buffer: v3.Zeros(traj.Len()), box: make([]float64, 9)}
+}
+
+// Readable returns true if there may be frames left to read.
+func (P *Pipeline) Readable() bool {
+	return P.traj.Readable()
+}
+
+// Len returns the number of atoms in the transformed frames.
+func (P *Pipeline) Len() int {
+	n := P.traj.Len()
+	for _, t := range P.transforms {
+		n = t.Len(n)
+	}
+	return n
+}
+
+// Next reads the next frame, applies the transformations, and puts the result in coords, if
+// it is not nil. The box vectors are put in box, if given. Frames are transformed even when
+// coords is nil, as some transformations need to see every frame.
+func (P *Pipeline) Next(coords *v3.Matrix, box ...[]float64) error {
+	if coords != nil && coords.NVecs() != P.Len() {
+		return fmt.Errorf("Pipeline.Next: room for %d atoms given, but the frames have %d", coords.NVecs(), P.Len())
+	}
+	for i := range P.box {
+		P.box[i] = 0
+	}
+	if err := P.traj.Next(P.buffer, P.box); err != nil {
+		if isLastFrame(err) {
+			return err
+		}
+		return fmt.Errorf("Pipeline.Next: %w", err)
+	}
+	var b []float64
+	if _, err := chem.NewBox(P.box); err == nil {
+		b = P.box
+	}
+	c := P.buffer
+	for i, t := range P.transforms {
+		var err error
+		c, err = t.Apply(c, b)
+		if err != nil {
+			return fmt.Errorf("Pipeline.Next: transformation %d: %w", i, err)
+		}
+	}
+	if coords != nil {
+		coords.Copy(c)
+	}
+	if len(box) > 0 && len(box[0]) >= 9 {
+		copy(box[0], P.box)
+	}
+	return nil
+}
+
+// NextConc reads as many frames as elements frames has, and puts each, transformed, in the
+// corresponding element of frames, which is sent through the returned channel. Frames for which
+// the element is nil are transformed and discarded.
+func (P *Pipeline) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	return nextConc(P, frames)
+}
+
+// Close closes the wrapped trajectory, if it can be closed.
+func (P *Pipeline) Close() {
+	closeTraj(P.traj)
+}
@@ -34,10 +34,14 @@ An STF file has the extension stf, and it is compressed with z-standard (zstd).
 A STF file may only contain ASCII symbols.
 
 A STF file has a "header" starting in the first line, and ending with a line that starts with the characters "**" followed by one or more spaces, and the number of atoms per frame.
-Each line of the header must be a pair key=value. It a trajectory includes topology, this may be included in the header with the key "topology" and a jsons string, describing the topology as an array of atoms, as defined in github.com/rmera/gochem/chemjson, as a value. Implementations are not required to support reading/writing of topologies. The precision (an integer greater than 0, see nex paragraph) must be included in the topology, unless the default value, 2, is employed. It must be include as a value with the corresponding key "prec".
+Each line of the header must be a pair key=value. Only the first "=" in the line separates the key from the value. It a trajectory includes topology, this may be included in the header with the key "topology" and a jsons string, describing the topology as an array of atoms, as defined in github.com/rmera/gochem/chemjson, as a value. The JSON string must take only one line, and can't contain non-ASCII characters, which need to be escaped. The total charge and the multiplicity of the system may be given with the keys "charge" and "multi", respectively. Implementations are not required to support reading/writing of topologies. The precision (an integer greater than 0, see nex paragraph) must be included in the topology, unless the default value, 2, is employed. It must be include as a value with the corresponding key "prec".
+The version of the format is given with the key "version". Files without this key are version 1. The current version is 2, and its only differences with version 1 are the optional metadata and velocities in each frame (see below). Implementations writing version 2 files must include the key.
 Note that the header can have 0 lines, in which case the file simply has the line starting with "**" as a first line.
 
-After the header, the file has one line per atom, per frame. Each line contains  3 numbers, corresponding to the x y and z cartesian coordinates, respectively, and nothing more. Each of these 3 number contains the respective coordinate in Angstrom, multiplied by 10 to the power of (precision), where precision is 2, or whatever positive integer given in the header (see the previous paragraph) and rounded to make it an integer. Since the default precision is 2, the numbers go multiplied by 100, unless a different value is given in the header, and rounded to an integer.
+After the header, the file has one line per atom, per frame.
+In version 2 files, each frame can start with any number of lines with key=value pairs with information on the frame (metadata). The keys can't contain whitespaces, nor the characters "=" and "*". The keys "time" (in ps), "step" (an integer) and "energy" are standard. The metadata lines are the only ones with the character "=" after the header, so they can't be mistaken for coordinates. Each line contains  3 numbers, corresponding to the x y and z cartesian coordinates, respectively, and nothing more. Each of these 3 number contains the respective coordinate in Angstrom, multiplied by 10 to the power of (precision), where precision is 2, or whatever positive integer given in the header (see the previous paragraph) and rounded to make it an integer. Since the default precision is 2, the numbers go multiplied by 100, unless a different value is given in the header, and rounded to an integer.
+
+In version 2 files, the coordinates can be followed by a line with only the character "V", and then one line per atom with its velocity, in A/ps, written in the same way as the coordinates, with the same precision.
 
 Each frame ends with a line starting with the character "*" (no whitespaces before) , optionally followed by: one or more whitespace and 9 floating-point numbers separated by spaces (precision unspecified). If present, these number correspond to the vectors defining the simulation box, in Angstrom
 
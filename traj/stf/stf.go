@@ -10,20 +10,62 @@ import (
 	"log"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/klauspost/compress/zstd"
 	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/chemjson"
 	v3 "github.com/rmera/gochem/v3"
 	"gonum.org/v1/gonum/mat"
 )
 
 const (
 	lzwLitwidth int = 8
+	version     int = 2 //the latest version of the format, see doc.go
 )
 
+//FrameInfo contains the optional information of a frame, besides the coordinates and the box.
+type FrameInfo struct {
+	//Key-value pairs with information on the frame. The keys "time" (in ps), "step" and "energy"
+	//are standard, but any key without "=" or whitespaces can be used.
+	Meta map[string]string
+
+	//The velocities of the atoms, in A/ps, or nil. When reading, the matrix must be allocated
+	//by the caller, otherwise, the velocities are discarded.
+	Velocities *v3.Matrix
+
+	//Set by the reader to true if the frame contained velocities, and to false otherwise.
+	//Ignored by the writer.
+	HasVelocities bool
+}
+
+//Float returns the value for key in the metadata, as a float64, and true, or 0 and false,
+//if the key is not present or its value is not a number.
+func (F *FrameInfo) Float(key string) (float64, bool) {
+	v, ok := F.Meta[key]
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+//SetFloat sets the value v for key in the metadata.
+func (F *FrameInfo) SetFloat(key string, v float64) {
+	if F.Meta == nil {
+		F.Meta = make(map[string]string)
+	}
+	F.Meta[key] = strconv.FormatFloat(v, 'g', -1, 64)
+}
+
 //Write!
 type StfW struct {
 	f           *os.File
@@ -62,21 +104,48 @@ func (S *StfW) WNextDense(dcoord *mat.Dense) error {
 	return err
 }
 
+//WNext writes coord, and the box, if given, as the next frame.
 func (S *StfW) WNext(coord *v3.Matrix, box ...[]float64) error {
+	err := S.WNextInfo(coord, nil, box...)
+	if err != nil {
+		err = errDecorate(err, "WNext")
+	}
+	return err
+}
+
+//WNextInfo writes coord, the metadata and velocities in info, if not nil, and the box, if
+//given, as the next frame.
+func (S *StfW) WNextInfo(coord *v3.Matrix, info *FrameInfo, box ...[]float64) error {
 	//centroid, err := chem.MassCenterMem(coord, coord, S.framebuffer) //not actually the CoM, but the geometric center.
 	//if err == nil {
 	//	coord = S.framebuffer //we won't say anything in case of error, sorry.
 	//}
 
 	if !S.writeable {
-		return Error{TrajUnIniWrite, S.filename, []string{"WNext"}, true}
+		return Error{TrajUnIniWrite, S.filename, []string{"WNextInfo"}, true}
 	}
 	if coord == nil {
-		return Error{NilCoordinates, S.filename, []string{"WNext"}, true}
+		return Error{NilCoordinates, S.filename, []string{"WNextInfo"}, true}
 	}
 	v := coord.NVecs()
 	if v != S.natoms {
-		return Error{fmt.Sprintf("%d coordinates given, but %d expected", v, S.natoms), S.filename, []string{"WNext"}, true}
+		return Error{fmt.Sprintf("%d coordinates given, but %d expected", v, S.natoms), S.filename, []string{"WNextInfo"}, true}
+	}
+	if info != nil && info.Velocities != nil && info.Velocities.NVecs() != v {
+		return Error{fmt.Sprintf("%d velocities given, but %d expected", info.Velocities.NVecs(), v), S.filename, []string{"WNextInfo"}, true}
+	}
+	if info != nil && len(info.Meta) > 0 {
+		keys := make([]string, 0, len(info.Meta))
+		for k, val := range info.Meta {
+			if k == "" || strings.ContainsAny(k, "=* \t\n") || strings.Contains(val, "\n") {
+				return Error{fmt.Sprintf("Invalid metadata %q=%q", k, val), S.filename, []string{"WNextInfo"}, true}
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			S.h.Write([]byte(fmt.Sprintf("%s=%s\n", k, info.Meta[k])))
+		}
 	}
 	//	strs := make([]string, 4) //old code
 	var temp [3]int
@@ -92,6 +161,15 @@ func (S *StfW) WNext(coord *v3.Matrix, box ...[]float64) error {
 
 		S.h.Write([]byte(str))
 	}
+	if info != nil && info.Velocities != nil {
+		S.h.Write([]byte("V\n"))
+		for i := 0; i < v; i++ {
+			floats[0] = info.Velocities.At(i, 0)
+			floats[1] = info.Velocities.At(i, 1)
+			floats[2] = info.Velocities.At(i, 2)
+			S.h.Write([]byte(coordsEncode(floats, temp, S.prec)))
+		}
+	}
 	if len(box) > 0 && len(box[0]) >= 9 {
 		b := box[0]
 		//if we did do the centroid thing, we should also displace the box vectors.
@@ -113,7 +191,8 @@ func (S *StfW) WNext(coord *v3.Matrix, box ...[]float64) error {
 	return nil
 }
 
-//Only the first map will be read!
+//NewWriter creates the file name and returns a writer for a STF trajectory with natoms atoms
+//per frame, and the key-value pairs in header. Only the first map will be read!
 func NewWriter(name string, natoms int, header map[string]string, compressionLevel ...int) (*StfW, error) {
 	var level int = 11 //For python compatibility
 	if len(compressionLevel) > 0 {
@@ -167,24 +246,98 @@ func NewWriter(name string, natoms int, header map[string]string, compressionLev
 	S.writeable = true
 	S.prec = 2 //the default
 	if header != nil && len(header) != 0 {
+		badprec := false
 		if p, ok := header["prec"]; ok && p != "2" {
 			prec, err := strconv.Atoi(p)
-			if err != nil {
+			if err == nil && prec > 0 {
 				S.prec = prec
 			} else {
 				log.Printf("Invalid precision for trajectory %s. Will use the default", S.filename)
+				badprec = true
 			}
 		}
 		headerstr := ""
 		for k, v := range header {
+			if k == "version" || (k == "prec" && badprec) {
+				continue
+			}
 			headerstr += fmt.Sprintf("%s=%v\n", k, v)
 		}
 		S.h.Write([]byte(headerstr))
 	}
+	S.h.Write([]byte(fmt.Sprintf("version=%d\n", version)))
 	S.h.Write([]byte(fmt.Sprintf("** %d\n", S.natoms)))
 	return S, nil
 }
 
+//NewTopologyWriter creates the file name and returns a writer for a STF trajectory with
+//the atoms in mol, which are included in the header, with the key "topology", together with
+//the charge and multiplicity, if mol has them, and the key-value pairs in header.
+func NewTopologyWriter(name string, mol chem.Atomer, header map[string]string, compressionLevel ...int) (*StfW, error) {
+	h := make(map[string]string, len(header)+3)
+	for k, v := range header {
+		h[k] = v
+	}
+	top, err := chemjson.EncodeTopology(mol)
+	if err != nil {
+		return nil, Error{err.Error(), name, []string{"chemjson.EncodeTopology", "NewTopologyWriter"}, true}
+	}
+	h["topology"] = asciiJSON(top)
+	if m, ok := mol.(interface {
+		Charge() int
+		Multi() int
+	}); ok {
+		h["charge"] = strconv.Itoa(m.Charge())
+		h["multi"] = strconv.Itoa(m.Multi())
+	}
+	return NewWriter(name, mol.Len(), h, compressionLevel...)
+}
+
+//asciiJSON returns the JSON data j as a string, with the non-ASCII characters replaced
+//by escape sequences, as STF files can only contain ASCII symbols.
+func asciiJSON(j []byte) string {
+	var b strings.Builder
+	for _, r := range string(j) {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			fmt.Fprintf(&b, "\\u%04x\\u%04x", r1, r2)
+		} else {
+			fmt.Fprintf(&b, "\\u%04x", r)
+		}
+	}
+	return b.String()
+}
+
+//Topology returns the topology in the header of a STF file, as returned by New, or an error if the
+//header has no topology. The charge and multiplicity are also taken from the header, if present,
+//otherwise, they are set to 0 and 1, respectively.
+func Topology(header map[string]string) (*chem.Topology, error) {
+	t, ok := header["topology"]
+	if !ok {
+		return nil, fmt.Errorf("Topology: no topology in the header")
+	}
+	charge, multi := 0, 1
+	var err error
+	if c, ok := header["charge"]; ok {
+		if charge, err = strconv.Atoi(c); err != nil {
+			return nil, fmt.Errorf("Topology: invalid charge %q: %w", c, err)
+		}
+	}
+	if m, ok := header["multi"]; ok {
+		if multi, err = strconv.Atoi(m); err != nil {
+			return nil, fmt.Errorf("Topology: invalid multiplicity %q: %w", m, err)
+		}
+	}
+	top, jerr := chemjson.DecodeTopology([]byte(t), charge, multi)
+	if jerr != nil {
+		return nil, fmt.Errorf("Topology: %w", jerr)
+	}
+	return top, nil
+}
+
 //Read!
 type StfR struct {
 	f            *os.File
@@ -192,10 +345,14 @@ type StfR struct {
 	h            *bufio.Reader
 	intermediate *bufio.Reader
 	//	framebuffer  *v3.Matrix
-	natoms   int
-	filename string
-	prec     int
-	readable bool
+	natoms    int
+	filename  string
+	prec      int
+	version   int
+	readable  bool
+	next      int     //the next frame to be read
+	offsets   []int64 //where each frame starts in the uncompressed data. Built when first needed.
+	boxWarned bool    //Have we warned that a frame has no box?
 }
 
 //This will cause additional indirections
@@ -231,11 +388,68 @@ func New(name string) (*StfR, map[string]string, error) {
 	S := new(StfR)
 	S.natoms = -1 //just so we know if things don't work
 	m := make(map[string]string)
-	var err error
 	S.filename = name
+	if err := S.open(); err != nil {
+		return nil, nil, err
+	}
+	for {
+		str, err := S.h.ReadString('\n')
+		if err != nil {
+			return nil, nil, Error{"Can't read header " + err.Error(), S.filename, []string{"NewStfR"}, true}
+		}
+		str = strings.TrimSuffix(str, "\n")
+		//	str = string([]byte(str)[0 : len(str)-1]) //This should work for ASCII, which is fine for Stf. It removes the last '\n'
+		if strings.HasPrefix(str, "**") {
+			nat := strings.Fields(str)
+			if len(nat) < 2 {
+				return nil, nil, Error{fmt.Sprintf("Can't read atom number from '%s': %s", str, err.Error()), S.filename, []string{"NewStfR"}, true}
+			}
+			nat[1] = strings.TrimSuffix(nat[1], "\n")
+			S.natoms, err = strconv.Atoi(nat[1])
+			if err != nil {
+				return nil, nil, Error{fmt.Sprintf("Can't read atom number from '%s': %s", nat[1], err.Error()), S.filename, []string{"NewStfR"}, true}
+
+			}
+			break
+		}
+		k, v, ok := strings.Cut(str, "=")
+		if !ok {
+			return nil, nil, Error{"Malformed header line: " + str, S.filename, []string{"NewStfR"}, true}
+		}
+		m[k] = v
+	}
+	//	S.framebuffer = v3.Zeros(S.natoms)
+	S.version = 1 //files without version are from before the key was introduced.
+	if v, ok := m["version"]; ok {
+		var err error
+		S.version, err = strconv.Atoi(v)
+		if err != nil || S.version < 1 || S.version > version {
+			S.lzw.Close()
+			S.f.Close()
+			return nil, nil, Error{"Unsupported format version " + v, S.filename, []string{"NewStfR"}, true}
+		}
+	}
+	S.readable = true
+	if len(m) != 0 {
+		if p, ok := m["prec"]; ok && p != "2" {
+			prec, err := strconv.Atoi(p)
+			if err == nil && prec > 0 {
+				S.prec = prec
+			} else {
+				log.Printf("Invalid precision for trajectory %s. Will assume the default", S.filename)
+			}
+		}
+
+	}
+	return S, m, nil
+}
+
+//open opens the file and sets the reader for the uncompressed data, but doesn't read anything.
+func (S *StfR) open() error {
+	var err error
 	S.f, err = os.Open(S.filename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 	var AnyNewReader func(io.Reader) (io.ReadCloser, error)
 	zreader := func(a io.Reader) (io.ReadCloser, error) {
@@ -251,7 +465,7 @@ func New(name string) (*StfR, map[string]string, error) {
 
 	}
 	gzreader := func(a io.Reader) (io.ReadCloser, error) { return gzip.NewReader(a) }
-	switch strings.ToLower(name)[len(name)-1] {
+	switch strings.ToLower(S.filename)[len(S.filename)-1] {
 	case 'l':
 		AnyNewReader = func(a io.Reader) (io.ReadCloser, error) { return lzw.NewReader(a, lzw.MSB, 8), nil }
 	case 'f':
@@ -271,49 +485,11 @@ func New(name string) (*StfR, map[string]string, error) {
 	S.intermediate = bufio.NewReader(S.f)
 	S.lzw, err = AnyNewReader(S.intermediate)
 	if err != nil {
-		return nil, nil, Error{"Can't read header " + err.Error(), S.filename, []string{"NewStfR"}, true}
+		S.f.Close()
+		return Error{"Can't read header " + err.Error(), S.filename, []string{"NewStfR"}, true}
 	}
 	S.h = bufio.NewReader(S.lzw)
-	for {
-		str, err := S.h.ReadString('\n')
-		if err != nil {
-			return nil, nil, Error{"Can't read header " + err.Error(), S.filename, []string{"NewStfR"}, true}
-		}
-		str = strings.TrimSuffix(str, "\n")
-		//	str = string([]byte(str)[0 : len(str)-1]) //This should work for ASCII, which is fine for Stf. It removes the last '\n'
-		if strings.Contains(str, "**") {
-			nat := strings.Fields(str)
-			if len(nat) < 2 {
-				return nil, nil, Error{fmt.Sprintf("Can't read atom number from '%s': %s", str, err.Error()), S.filename, []string{"NewStfR"}, true}
-			}
-			nat[1] = strings.TrimSuffix(nat[1], "\n")
-			S.natoms, err = strconv.Atoi(nat[1])
-			if err != nil {
-				return nil, nil, Error{fmt.Sprintf("Can't read atom number from '%s': %s", nat[1], err.Error()), S.filename, []string{"NewStfR"}, true}
-
-			}
-			break
-		}
-		kv := strings.Split(str, "=")
-		if len(kv) != 2 {
-			return nil, nil, Error{"Malformed header" + err.Error(), S.filename, []string{"NewStfR"}, true}
-		}
-		m[kv[0]] = kv[1]
-	}
-	//	S.framebuffer = v3.Zeros(S.natoms)
-	S.readable = true
-	if len(m) != 0 {
-		if p, ok := m["prec"]; ok && p != "2" {
-			prec, err := strconv.Atoi(p)
-			if err != nil {
-				S.prec = prec
-			} else {
-				log.Printf("Invalid precision for trajectory %s. Will assume the default", S.filename)
-			}
-		}
-
-	}
-	return S, m, nil
+	return nil
 }
 
 //Readabe returns true if the handle is readable (if it is possible to call Next on it)
@@ -349,44 +525,71 @@ func coordsDecode(str string, temp *[3]float64, prec int) error {
 //Returns error if the operation is not successful. If the error is EOF, the end of the
 //trajectory has been reached, not an actual error.
 func (S *StfR) Next(c *v3.Matrix, box ...[]float64) error {
-	//	var tmpstr string
-	//	var prec int = 2
-	//	var pointplace int
-	var temp [3]float64
-	var err error
-	for i := 0; i < S.natoms; i++ {
+	err := S.NextInfo(c, nil, box...)
+	if err != nil {
+		err = errDecorate(err, "Next")
+	}
+	return err
+}
+
+//NextInfo is like Next, but it also puts the metadata and velocities of the frame in info,
+//if not nil. The previous content of info.Meta is removed.
+func (S *StfR) NextInfo(c *v3.Matrix, info *FrameInfo, box ...[]float64) error {
+	if info != nil {
+		info.HasVelocities = false
+		if info.Meta == nil {
+			info.Meta = make(map[string]string)
+		}
+		for k := range info.Meta {
+			delete(info.Meta, k)
+		}
+	}
+	var first []byte
+	for lines := 0; ; lines++ {
 		b, err := S.h.ReadBytes('\n')
 		if err != nil {
-			// EOF should only happen when reading the first atom
-			if err.Error() == "EOF" && (strings.Contains(err.Error(), "EOF")) && i == 0 {
+			// EOF should only happen when reading the first line of the frame
+			if err == io.EOF && lines == 0 && len(b) == 0 {
 				//nothing bad happened here, the trajectory just ended.
 				S.Close()
-				return newlastFrameError(S.filename, "Next")
+				return newlastFrameError(S.filename, "NextInfo")
 			} else {
-				return Error{message: err.Error(), filename: S.filename, critical: true}
+				return Error{message: err.Error(), filename: S.filename, deco: []string{"NextInfo"}, critical: true}
 			}
-
-		}
-		err = coordsDecode(string(b[:len(b)-1]), &temp, S.prec)
-		if err != nil {
-			return Error{message: err.Error(), filename: S.filename, critical: true}
 		}
-
-		if c == nil {
-			continue //We ignore this whole frame, reading the content but not saving it.
-			//Note that we still check the frame for correctness.
+		//Metadata lines, the only ones with a "=", can only be present from version 2.
+		k, v, ismeta := strings.Cut(string(b[:len(b)-1]), "=")
+		if S.version < 2 || !ismeta {
+			first = b
+			break
 		}
-		for j, v := range temp {
-			c.Set(i, j, v)
+		if info != nil {
+			info.Meta[k] = v
 		}
-
+	}
+	if err := S.readBlock(c, first); err != nil {
+		return errDecorate(err, "NextInfo")
 	}
 	s, err := S.h.ReadString('\n')
 	if err != nil {
-		return Error{"Can't read the frame termination mark" + err.Error(), S.filename, []string{"Next"}, true}
+		return Error{"Can't read the frame termination mark " + err.Error(), S.filename, []string{"NextInfo"}, true}
+	}
+	if S.version >= 2 && s[0] == 'V' {
+		var vel *v3.Matrix
+		if info != nil {
+			info.HasVelocities = true
+			vel = info.Velocities
+		}
+		if err := S.readBlock(vel, nil); err != nil {
+			return errDecorate(err, "NextInfo")
+		}
+		s, err = S.h.ReadString('\n')
+		if err != nil {
+			return Error{"Can't read the frame termination mark " + err.Error(), S.filename, []string{"NextInfo"}, true}
+		}
 	}
 	if s[0] != '*' {
-		return Error{"Wrong number of atoms in frame" + err.Error(), S.filename, []string{"Next"}, true}
+		return Error{"Wrong number of atoms in frame", S.filename, []string{"NextInfo"}, true}
 	}
 
 	//This part reads the
@@ -410,11 +613,45 @@ func (S *StfR) Next(c *v3.Matrix, box ...[]float64) error {
 				}
 			}
 
-		} else {
+		} else if !S.boxWarned {
 			log.Printf("Trajectory file %s does not contain (correct) box information: %s", S.filename, fields) //just a head-up
+			S.boxWarned = true
 		}
 	}
+	S.next++
+	return nil
+}
 
+//readBlock reads one line per atom, each with the 3 components of a vector (coordinates or velocities),
+//and puts the vectors in m, unless m is nil. If first is not nil, it is taken as the first line.
+func (S *StfR) readBlock(m *v3.Matrix, first []byte) error {
+	if m != nil && m.NVecs() != S.natoms {
+		return Error{fmt.Sprintf("Room for %d atoms given, but the frames have %d", m.NVecs(), S.natoms), S.filename, []string{"readBlock"}, true}
+	}
+	var temp [3]float64
+	for i := 0; i < S.natoms; i++ {
+		b := first
+		if i > 0 || b == nil {
+			var err error
+			b, err = S.h.ReadBytes('\n')
+			if err != nil {
+				return Error{message: err.Error(), filename: S.filename, deco: []string{"readBlock"}, critical: true}
+			}
+		}
+		err := coordsDecode(string(b[:len(b)-1]), &temp, S.prec)
+		if err != nil {
+			return Error{message: err.Error(), filename: S.filename, deco: []string{"readBlock"}, critical: true}
+		}
+
+		if m == nil {
+			continue //We ignore this whole block, reading the content but not saving it.
+			//Note that we still check the block for correctness.
+		}
+		for j, v := range temp {
+			m.Set(i, j, v)
+		}
+
+	}
 	return nil
 }
 
@@ -424,10 +661,166 @@ func (S *StfR) Close() {
 		return
 	}
 	S.lzw.Close()
+	S.f.Close()
 	S.readable = false
 	return
 }
 
+//NFrames returns the number of frames in the trajectory, or -1 if the file can't be read.
+//As STF files are compressed, finding where each frame starts requires going over the whole file.
+//This is done the first time NFrames or Seek are called, and the result is saved in an index
+//file, with the name of the trajectory plus the extension .idx, which is used in the following
+//calls, even from other programs, as long as the trajectory is not modified.
+//If the index file can't be written, the index is just kept in memory.
+func (S *StfR) NFrames() int {
+	if err := S.index(); err != nil {
+		return -1
+	}
+	return len(S.offsets)
+}
+
+//index finds where each frame starts, if that hasn't been done before.
+func (S *StfR) index() error {
+	if S.offsets != nil {
+		return nil
+	}
+	var err error
+	S.offsets, err = stfIndex(S.filename)
+	if err != nil {
+		return errDecorate(err, "index")
+	}
+	return nil
+}
+
+//Tell returns the index of the next frame to be read, counting from 0.
+func (S *StfR) Tell() int {
+	return S.next
+}
+
+//Seek sets the trajectory so the next frame read is frame (counting from 0).
+//The data before the frame still needs to be decompressed, but it is not parsed.
+//If the trajectory was closed, for instance, because its last frame was read,
+//it is opened again. The first call builds the index of the frames, and saves it to
+//the file name.idx, where name is the name of the trajectory (see NFrames). If that
+//file can't be written, the index is only kept in memory, and no error is returned.
+func (S *StfR) Seek(frame int) error {
+	if err := S.index(); err != nil {
+		return errDecorate(err, "Seek")
+	}
+	n := len(S.offsets)
+	if frame < 0 || frame >= n {
+		return Error{fmt.Sprintf("Frame %d out of range, the trajectory has %d frames", frame, n), S.filename, []string{"Seek"}, true}
+	}
+	skip := S.offsets[frame]
+	if S.readable && frame >= S.next {
+		skip -= S.offsets[S.next] //we just go forward from the current frame.
+	} else {
+		S.Close()
+		if err := S.open(); err != nil {
+			return Error{err.Error(), S.filename, []string{"open", "Seek"}, true}
+		}
+		S.readable = true
+	}
+	if _, err := S.h.Discard(int(skip)); err != nil {
+		S.Close()
+		return Error{err.Error(), S.filename, []string{"bufio.Reader.Discard", "Seek"}, true}
+	}
+	S.next = frame
+	return nil
+}
+
+//stfIndex returns the positions in the uncompressed data of the STF file name, where each frame starts.
+//The positions are read from the index file, if there is an up-to-date one, or obtained from
+//the trajectory, and saved in the index file, otherwise. An incomplete last frame is not included.
+func stfIndex(name string) ([]int64, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, Error{err.Error(), name, []string{"os.Stat", "stfIndex"}, true}
+	}
+	//The first line of the index file identifies the trajectory it belongs to.
+	id := fmt.Sprintf("stfidx %d %d", info.Size(), info.ModTime().UnixNano())
+	if ret, err := readStfIndex(name+".idx", id); err == nil {
+		return ret, nil
+	}
+	S := &StfR{filename: name}
+	if err := S.open(); err != nil {
+		return nil, Error{err.Error(), name, []string{"open", "stfIndex"}, true}
+	}
+	defer S.lzw.Close()
+	defer S.f.Close()
+	var ret []int64
+	var pos, start int64
+	header := true
+	for {
+		l, err := S.h.ReadSlice('\n')
+		pos += int64(len(l))
+		long := false
+		for err == bufio.ErrBufferFull { //long lines, such as the topology in the header, are read in pieces.
+			long = true
+			var more []byte
+			more, err = S.h.ReadSlice('\n')
+			pos += int64(len(more))
+		}
+		if err == io.EOF {
+			break //an incomplete last frame is just ignored.
+		}
+		if err != nil {
+			return nil, Error{err.Error(), name, []string{"bufio.Reader.ReadSlice", "stfIndex"}, true}
+		}
+		if header {
+			if !long && strings.HasPrefix(string(l), "**") {
+				header = false
+				start = pos
+			}
+			continue
+		}
+		//Only the line that ends a frame starts with "*".
+		if l[0] == '*' {
+			ret = append(ret, start)
+			start = pos
+		}
+	}
+	//Not being able to write the index is not a problem, it will be just built again next time.
+	if out, err := os.Create(name + ".idx"); err == nil {
+		w := bufio.NewWriter(out)
+		fmt.Fprintln(w, id)
+		for _, v := range ret {
+			fmt.Fprintln(w, v)
+		}
+		err = w.Flush()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name + ".idx") //so an incomplete index is not read next time.
+		}
+	}
+	return ret, nil
+}
+
+//readStfIndex reads the positions of the frames from the index file name, and returns
+//an error if the file can't be read, or if its first line is not id.
+func readStfIndex(name, id string) ([]int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() || sc.Text() != id {
+		return nil, fmt.Errorf("Index %s doesn't match the trajectory", name)
+	}
+	var ret []int64
+	for sc.Scan() {
+		v, err := strconv.ParseInt(sc.Text(), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, sc.Err()
+}
+
 //Len returns the number of atoms in each frame of the trajectory.
 func (S *StfR) Len() int {
 	return S.natoms
@@ -442,10 +835,19 @@ func (D *StfR) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 		return nil, Error{TrajUnIniRead, D.filename, []string{"NextConc"}, true}
 	}
 	framechans := make([]chan *v3.Matrix, len(frames)) //the slice of chans that will be returned
+	used := false
 	for key, v := range frames {
 		if err := D.Next(v); err != nil {
+			//If some frames were read, they are returned with the error.
+			if _, ok := err.(chem.LastFrameError); ok && used {
+				return framechans[:key], errDecorate(err, "NextConc")
+			}
 			return nil, errDecorate(err, "NextConc")
 		}
+		if v == nil {
+			continue //ignored frame
+		}
+		used = true
 		framechans[key] = make(chan *v3.Matrix)
 		go func(keep *v3.Matrix, pipe chan *v3.Matrix) {
 			pipe <- keep
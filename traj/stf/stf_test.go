@@ -25,8 +25,11 @@
 package stf
 
 import (
+	"bytes"
 	"fmt"
-
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	chem "github.com/rmera/gochem"
@@ -230,3 +233,197 @@ func BenchmarkWriteSTF(B *testing.B) {
 		wtraj.WNext(mat)
 	}
 }
+
+func TestSTFSeek(Te *testing.T) {
+	const natoms, nframes = 6, 7
+	name := filepath.Join(Te.TempDir(), "seek.stf")
+	w, err := NewWriter(name, natoms, map[string]string{"title": "seek"})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(natoms)
+	for f := 0; f < nframes; f++ {
+		for i := 0; i < natoms; i++ {
+			coords.Set(i, 0, float64(10*f+i))
+		}
+		if err := w.WNext(coords); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	//The second time, the index is read from the file written the first time.
+	for try := 0; try < 2; try++ {
+		traj, _, err := New(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		var _ chem.Seeker = traj
+		traj.Next(coords)
+		if n := traj.NFrames(); n != nframes {
+			Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+		}
+		if _, err := os.Stat(name + ".idx"); err != nil {
+			Te.Errorf("No index file written: %v", err)
+		}
+		//forward, backwards, and after the end of the trajectory was reached.
+		for _, f := range []int{4, 1, 6, 0} {
+			if err := traj.Seek(f); err != nil {
+				Te.Fatal(err)
+			}
+			for ; ; f++ {
+				if traj.Tell() != f {
+					Te.Fatalf("Tell returned %d, expected %d", traj.Tell(), f)
+				}
+				if err := traj.Next(coords); err != nil {
+					if _, ok := err.(chem.LastFrameError); !ok || f != nframes {
+						Te.Fatal(f, err)
+					}
+					break
+				}
+				if coords.At(3, 0) != float64(10*f+3) {
+					Te.Fatalf("Wrong coordinates in frame %d: %v", f, coords.VecView(3))
+				}
+			}
+		}
+		traj.Close()
+	}
+}
+
+// TestSTFSeekNoIndex checks that Seek works when the index file can't be written.
+func TestSTFSeekNoIndex(Te *testing.T) {
+	const natoms, nframes = 2, 3
+	name := filepath.Join(Te.TempDir(), "noindex.stf")
+	w, err := NewWriter(name, natoms, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(natoms)
+	for f := 0; f < nframes; f++ {
+		coords.Set(0, 0, float64(f))
+		if err := w.WNext(coords); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	//A directory with the name of the index file can't be replaced by it.
+	if err := os.Mkdir(name+".idx", 0755); err != nil {
+		Te.Fatal(err)
+	}
+	traj, _, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer traj.Close()
+	if err := traj.Seek(2); err != nil {
+		Te.Fatal(err)
+	}
+	if err := traj.Next(coords); err != nil || coords.At(0, 0) != 2 {
+		Te.Errorf("Wrong frame read after Seek: %v %v", coords, err)
+	}
+}
+
+// TestSTFNoBox checks that reading the box from a trajectory without one warns only once.
+func TestSTFNoBox(Te *testing.T) {
+	const natoms, nframes = 3, 4
+	name := filepath.Join(Te.TempDir(), "nobox.stf")
+	w, err := NewWriter(name, natoms, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(natoms)
+	for f := 0; f < nframes; f++ {
+		if err := w.WNext(coords); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	traj, _, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer traj.Close()
+	box := make([]float64, 9)
+	for f := 0; f < nframes; f++ {
+		if err := traj.Next(coords, box); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	if n := bytes.Count(logged.Bytes(), []byte("box information")); n != 1 {
+		Te.Errorf("Warned %d times about the missing box, expected once:\n%s", n, logged.String())
+	}
+}
+
+func TestSTFTopology(Te *testing.T) {
+	const natoms = 3
+	name := filepath.Join(Te.TempDir(), "top.stf")
+	top := chem.NewTopology(-1, 2)
+	for i, s := range []string{"O", "H", "H"} {
+		top.AppendAtom(&chem.Atom{Name: s + fmt.Sprint(i), Symbol: s, MolName: "HÖH", MolID: 1, ID: i + 1, Charge: 0.1 * float64(i)})
+	}
+	w, err := NewTopologyWriter(name, top, map[string]string{"prec": "3", "title": "a=b"})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords, _ := v3.NewMatrix([]float64{0, 0, 0, 0.957, 0, 0, -0.24, 0.927, 0})
+	vel := v3.Zeros(natoms)
+	vel.Set(1, 2, 1.234)
+	info := &FrameInfo{Velocities: vel}
+	info.SetFloat("time", 2.5)
+	info.Meta["step"] = "1250"
+	//A frame with metadata and velocities, one with only coordinates, and one with metadata.
+	if err := w.WNextInfo(coords, info); err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.WNext(coords, []float64{10, 0, 0, 0, 10, 0, 0, 0, 10}); err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.WNextInfo(coords, &FrameInfo{Meta: map[string]string{"energy": "-76.4"}}); err != nil {
+		Te.Fatal(err)
+	}
+	if err := w.WNextInfo(coords, &FrameInfo{Meta: map[string]string{"a b": "1"}}); err == nil {
+		Te.Error("Invalid metadata key accepted")
+	}
+	w.Close()
+	r, header, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer r.Close()
+	if header["version"] != "2" || header["title"] != "a=b" || r.prec != 3 {
+		Te.Errorf("Wrong header: %v", header)
+	}
+	rtop, err := Topology(header)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if rtop.Len() != natoms || rtop.Charge() != -1 || rtop.Multi() != 2 || rtop.Atom(2).Name != "H2" || rtop.Atom(1).MolName != "HÖH" || rtop.Atom(2).Charge != 0.2 || rtop.Atom(2).Index() != 2 {
+		Te.Errorf("Wrong topology read: %v", rtop.Atom(2))
+	}
+	if n := r.NFrames(); n != 3 {
+		Te.Errorf("NFrames returned %d, expected 3", n)
+	}
+	rinfo := &FrameInfo{Velocities: v3.Zeros(natoms)}
+	c := v3.Zeros(natoms)
+	if err := r.NextInfo(c, rinfo); err != nil {
+		Te.Fatal(err)
+	}
+	if t, ok := rinfo.Float("time"); !ok || t != 2.5 || rinfo.Meta["step"] != "1250" || !rinfo.HasVelocities || rinfo.Velocities.At(1, 2) != 1.234 || c.At(1, 0) != 0.957 {
+		Te.Errorf("Wrong first frame: %v %v %v", rinfo.Meta, rinfo.Velocities, c)
+	}
+	box := make([]float64, 9)
+	if err := r.NextInfo(c, rinfo, box); err != nil {
+		Te.Fatal(err)
+	}
+	if len(rinfo.Meta) != 0 || rinfo.HasVelocities || box[4] != 10 {
+		Te.Errorf("Wrong second frame: %v %v", rinfo.Meta, box)
+	}
+	if err := r.Next(c); err != nil {
+		Te.Fatal(err)
+	}
+	if err := r.Next(c); err == nil {
+		Te.Error("Expected the end of the trajectory")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * traj.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+// Package traj contains tools that work on any goChem trajectory, regardless of its
+// format. The readers and writers for each format are in the subpackages (dcd, xtc, stf, etc.),
+// and implement chem.Traj and chem.TrajWriter, respectively. Converting between formats
+// takes only a few lines:
+//
+//	r, _ := xtc.New("traj.xtc")
+//	w, _ := dcd.NewWriter("traj.dcd", r.Len())
+//	defer w.Close()
+//	n, err := traj.Copy(w, r)
+//
+// The package also has views of trajectories, which are trajectories themselves: Chain,
+// to read several trajectories as one, Slice, to read only some frames, and Subset, to read
+// only some atoms. They can be combined, for instance, to read every 10th frame of the
+// protein atoms of several consecutive simulations:
+//
+//	c, _ := traj.NewChain(t1, t2, t3)
+//	s, _ := traj.NewSlice(c, 0, -1, 10)
+//	p, _ := traj.NewSubset(s, proteinIndexes)
+//
+// Finally, a Pipeline transforms each frame of a trajectory as it is read (centering, fitting,
+// wrapping or unwrapping it, see Transform), without writing an intermediate file.
+//
+// To analyze several frames at the same time, Parallel calls a function on each frame
+// of any trajectory, concurrently, and returns the results in the order of the frames.
+package traj
+
+import (
+	"fmt"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// Copy reads all the remaining frames in src and writes them to dst, with their box vectors,
+// for the frames that have them. It returns the number of frames copied.
+// Neither src nor dst are closed.
+func Copy(dst chem.TrajWriter, src chem.Traj) (int, error) {
+	coords := v3.Zeros(src.Len())
+	box := make([]float64, 9)
+	for n := 0; ; n++ {
+		for i := range box {
+			box[i] = 0
+		}
+		if err := src.Next(coords, box); err != nil {
+			if _, ok := err.(chem.LastFrameError); ok {
+				return n, nil
+			}
+			return n, fmt.Errorf("Copy: reading frame %d: %w", n, err)
+		}
+		var err error
+		if _, berr := chem.NewBox(box); berr == nil {
+			err = dst.WNext(coords, box)
+		} else {
+			err = dst.WNext(coords)
+		}
+		if err != nil {
+			return n, fmt.Errorf("Copy: writing frame %d: %w", n, err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package traj
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/traj/dcd"
+	"github.com/rmera/gochem/traj/stf"
+	"github.com/rmera/gochem/traj/xtc"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// The writers in the format subpackages, and those in the chem package, are all TrajWriters.
+var _ = []chem.TrajWriter{&dcd.DCDWObj{}, &xtc.XTCWObj{}, &stf.StfW{}, &chem.XYZWriter{}, &chem.GroWriter{}, &chem.PDBWriter{}}
+
+func TestCopy(Te *testing.T) {
+	const natoms, nframes = 30, 5
+	r := rand.New(rand.NewSource(1))
+	top := chem.NewTopology(0, 1)
+	for i := 0; i < natoms; i++ {
+		top.AppendAtom(&chem.Atom{Name: "O", Symbol: "O", MolName: "HOH", MolID: i + 1, ID: i + 1})
+	}
+	frames := make([]*v3.Matrix, nframes)
+	for i := range frames {
+		frames[i] = v3.Zeros(natoms)
+		for j := 0; j < natoms; j++ {
+			for k := 0; k < 3; k++ {
+				frames[i].Set(j, k, 30*r.Float64())
+			}
+		}
+	}
+	mol, err := chem.NewMolecule(frames, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	mol.Cell = &chem.UnitCell{Lengths: [3]float64{30, 31, 32}, Angles: [3]float64{90, 90, 90}}
+	dir := Te.TempDir()
+	//Molecule -> XTC -> STF -> DCD, and each file is read back.
+	xw, err := xtc.NewWriter(filepath.Join(dir, "t.xtc"), natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if n, err := Copy(xw, mol); err != nil || n != nframes {
+		Te.Fatalf("Copied %d frames to XTC, expected %d: %v", n, nframes, err)
+	}
+	xw.Close()
+	xr, err := xtc.New(filepath.Join(dir, "t.xtc"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	sw, err := stf.NewWriter(filepath.Join(dir, "t.stf"), natoms, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if n, err := Copy(sw, xr); err != nil || n != nframes {
+		Te.Fatalf("Copied %d frames to STF, expected %d: %v", n, nframes, err)
+	}
+	sw.Close()
+	xr.Close()
+	sr, _, err := stf.New(filepath.Join(dir, "t.stf"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(natoms)
+	box := make([]float64, 9)
+	for i, f := range frames {
+		if err := sr.Next(coords, box); err != nil {
+			Te.Fatal(err)
+		}
+		if math.Abs(box[0]-30) > 0.01 || math.Abs(box[4]-31) > 0.01 || math.Abs(box[8]-32) > 0.01 {
+			Te.Errorf("Wrong box in frame %d: %v", i, box)
+		}
+		d := v3.Zeros(natoms)
+		d.Sub(f, coords)
+		if d.Norm(2) > 0.01*natoms {
+			Te.Errorf("Frame %d differs from the original", i)
+		}
+	}
+	sr.Close()
+	sr, _, err = stf.New(filepath.Join(dir, "t.stf"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	dw, err := dcd.NewWriter(filepath.Join(dir, "t.dcd"), natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if n, err := Copy(dw, sr); err != nil || n != nframes {
+		Te.Fatalf("Copied %d frames to DCD, expected %d: %v", n, nframes, err)
+	}
+	dw.Close()
+	sr.Close()
+	dr, err := dcd.New(filepath.Join(dir, "t.dcd"))
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer dr.Close()
+	var read int
+	for ; ; read++ {
+		if err := dr.Next(coords); err != nil {
+			break
+		}
+	}
+	if read != nframes {
+		Te.Errorf("Read %d frames from the DCD, expected %d", read, nframes)
+	}
+}
+
+// framesMol returns a molecule with natoms atoms and nframes frames, where all
+// the coordinates of the atom i in the frame f are first+f+i/100.
+func framesMol(natoms, nframes, first int) *chem.Molecule {
+	top := chem.NewTopology(0, 1)
+	for i := 0; i < natoms; i++ {
+		top.AppendAtom(&chem.Atom{Name: "C", Symbol: "C", MolName: "MET", MolID: 1, ID: i + 1})
+	}
+	frames := make([]*v3.Matrix, nframes)
+	for f := range frames {
+		frames[f] = v3.Zeros(natoms)
+		for i := 0; i < natoms; i++ {
+			for k := 0; k < 3; k++ {
+				frames[f].Set(i, k, float64(first+f)+float64(i)/100)
+			}
+		}
+	}
+	mol, _ := chem.NewMolecule(frames, top, nil)
+	return mol
+}
+
+func TestViews(Te *testing.T) {
+	var _ = []chem.ConcTraj{&Chain{}, &Slice{}, &Subset{}}
+	m1, m2 := framesMol(5, 10, 0), framesMol(5, 8, 10)
+	c, err := NewChain(m1, m2)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	//Chains are not Seekers, so here the frames not in the slice are read and discarded.
+	s, err := NewSlice(c, 2, 17, 3)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(5)
+	var got []float64
+	for {
+		if err := s.Next(coords); err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok {
+				Te.Fatal(err)
+			}
+			break
+		}
+		got = append(got, coords.At(0, 0))
+	}
+	if fmt.Sprint(got) != "[2 5 8 11 14]" {
+		Te.Errorf("Wrong frames read from the slice of the chain: %v", got)
+	}
+	//Molecules are Seekers, so here Seek is used.
+	m1.InitRead()
+	s, _ = NewSlice(m1, 1, -1, 5)
+	sub, err := NewSubset(s, []int{3, 1})
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords = v3.Zeros(2)
+	chans, err := sub.NextConc([]*v3.Matrix{coords, nil, v3.Zeros(2)})
+	if _, ok := err.(chem.LastFrameError); !ok || len(chans) != 2 {
+		Te.Fatalf("Expected the end of the trajectory after 2 frames, got %d frames and error %v", len(chans), err)
+	}
+	first := <-chans[0]
+	if chans[1] != nil || first.At(0, 1) != 1.03 || first.At(1, 1) != 1.01 || m1.Tell() != 7 {
+		Te.Errorf("Wrong frames read from the subset of the slice: %v", first)
+	}
+	if _, err := NewChain(m1, framesMol(4, 1, 0)); err == nil {
+		Te.Error("Chained trajectories with different number of atoms")
+	}
+}
+
+func TestPipeline(Te *testing.T) {
+	//3 atoms, in a 10 A cubic box. The first one crosses the box boundary in the x direction.
+	xs := []float64{8.5, 9.5, 0.5, 1.5}
+	frames := make([]*v3.Matrix, len(xs))
+	for f, x := range xs {
+		frames[f], _ = v3.NewMatrix([]float64{x, 5, 5, 4, 4, 4, 6, 6, 6})
+	}
+	top := chem.NewTopology(0, 1)
+	for i := 0; i < 3; i++ {
+		top.AppendAtom(&chem.Atom{Name: "AR", Symbol: "Ar", MolName: "AR", MolID: i + 1, ID: i + 1})
+	}
+	mol, err := chem.NewMolecule(frames, top, nil)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	mol.Cell = &chem.UnitCell{Lengths: [3]float64{10, 10, 10}, Angles: [3]float64{90, 90, 90}}
+	moved := false
+	shift := TransformFunc(func(coords *v3.Matrix, box []float64) error {
+		moved = box != nil && box[4] == 10
+		return nil
+	})
+	p := NewPipeline(mol, Unwrap(), Atoms([]int{0, 2}), shift)
+	if p.Len() != 2 {
+		Te.Fatalf("The pipeline should have 2 atoms, has %d", p.Len())
+	}
+	coords := v3.Zeros(2)
+	var got []float64
+	for {
+		if err := p.Next(coords); err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok {
+				Te.Fatal(err)
+			}
+			break
+		}
+		got = append(got, coords.At(0, 0))
+	}
+	if fmt.Sprint(got) != "[8.5 9.5 10.5 11.5]" || !moved {
+		Te.Errorf("Wrong unwrapped coordinates: %v", got)
+	}
+	//Centering the first (unwrapped) atom puts it in the center of the box, and wrapping
+	//puts the others back in the box.
+	mol.InitRead()
+	p = NewPipeline(mol, Unwrap(), Center([]int{0}), Wrap())
+	coords = v3.Zeros(3)
+	for i := 0; i < 4; i++ {
+		if err := p.Next(coords); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	expected, _ := v3.NewMatrix([]float64{5, 5, 5, 7.5, 4, 4, 9.5, 6, 6})
+	expected.Sub(expected, coords)
+	if expected.Norm(2) > 1e-9 {
+		Te.Errorf("Wrong centered and wrapped coordinates: %v", coords)
+	}
+	//A rotated frame is superimposed on the reference.
+	ref := frames[0]
+	rot, _ := v3.NewMatrix([]float64{0, 1, 0, -1, 0, 0, 0, 0, 1})
+	rotated := v3.Zeros(3)
+	rotated.Mul(ref, rot)
+	rmol, _ := chem.NewMolecule([]*v3.Matrix{rotated}, top, nil)
+	p = NewPipeline(rmol, Fit(ref, nil))
+	if err := p.Next(coords); err != nil {
+		Te.Fatal(err)
+	}
+	if rmsd, _ := chem.RMSD(coords, ref); rmsd > 1e-6 {
+		Te.Errorf("Frame not superimposed on the reference, RMSD: %f", rmsd)
+	}
+}
+
+func TestParallel(Te *testing.T) {
+	first := func(frame int, coords *v3.Matrix) (float64, error) {
+		if coords.At(1, 0) != float64(frame)+0.01 {
+			return 0, fmt.Errorf("frame %d has the coordinates of another frame", frame)
+		}
+		return coords.At(0, 0), nil
+	}
+	o := DefaultParallelOptions()
+	o.Workers(3)
+	o.Begin(2)
+	//With a seeker, a ConcTraj without Seek (a Chain) and a trajectory that is neither.
+	for _, skip := range []int{0, 2} {
+		o.Skip(skip)
+		trajs := []chem.Traj{framesMol(3, 10, 0), &Chain{trajs: []chem.Traj{framesMol(3, 10, 0)}}, struct{ chem.Traj }{framesMol(3, 10, 0)}}
+		for i, t := range trajs {
+			got, err := Parallel(t, first, o)
+			if err != nil {
+				Te.Fatal(i, err)
+			}
+			expected := "[2 3 4 5 6 7 8 9]"
+			if skip == 2 {
+				expected = "[2 5 8]"
+			}
+			if fmt.Sprint(got) != expected {
+				Te.Errorf("Trajectory %d, skip %d: got %v, expected %s", i, skip, got, expected)
+			}
+		}
+	}
+	m := framesMol(3, 10, 0)
+	got, err := Parallel(m, func(frame int, coords *v3.Matrix) (int, error) {
+		if frame == 5 {
+			return 0, fmt.Errorf("can't process frame 5")
+		}
+		return frame, nil
+	})
+	if err == nil || len(got) != 5 {
+		Te.Errorf("Expected an error, and the results for the first 5 frames, got %v %v", got, err)
+	}
+	//A read error right after a whole batch.
+	o = DefaultParallelOptions()
+	o.Workers(3)
+	e := &failingConc{framesMol(3, 10, 0), 3}
+	if got, err = Parallel(e, func(frame int, coords *v3.Matrix) (int, error) { return frame, nil }, o); err == nil || len(got) != 3 || !strings.Contains(err.Error(), "frame 3") {
+		Te.Errorf("Expected an error reading frame 3, and the results for the first 3 frames, got %v %v", got, err)
+	}
+}
+
+// failingConc is a trajectory where NextConc fails once the frame fail has been read.
+type failingConc struct {
+	*chem.Molecule
+	fail int
+}
+
+func (F *failingConc) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	chans, err := F.Molecule.NextConc(frames)
+	if err == nil && F.Tell() >= F.fail {
+		err = fmt.Errorf("corrupted frame")
+	}
+	return chans, err
+}
@@ -0,0 +1,487 @@
+/*
+ * trr.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+// Package trr reads and writes GROMACS TRR trajectories. TRR files are plain XDR (big endian)
+// files, so this package doesn't need libxdrfile. In addition to coordinates, the velocities,
+// forces, time, step and lambda of each frame can be obtained.
+// goChem units are used: coordinates and box vectors are in A, velocities in A/ps and forces in kJ/(mol A).
+package trr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+const (
+	magic   int32  = 1993
+	version string = "GMX_trn_file"
+	nm2A           = 10.0
+)
+
+// header is the header of each TRR frame.
+type header struct {
+	irSize, eSize, boxSize, virSize, presSize int32
+	topSize, symSize, xSize, vSize, fSize     int32
+	natoms, step, nre                         int32
+	t, lambda                                 float64
+	double                                    bool //are reals in double precision?
+}
+
+// realSize returns the size in bytes of the real numbers in the frame.
+func (h *header) realSize() int {
+	if h.double {
+		return 8
+	}
+	return 4
+}
+
+// dataSize returns the size in bytes of the data following the header.
+func (h *header) dataSize() int {
+	return int(h.boxSize + h.virSize + h.presSize + h.xSize + h.vSize + h.fSize)
+}
+
+// frame contains the raw, still undecoded, data of a frame.
+type frame struct {
+	h    header
+	data []byte
+}
+
+// TRRObj is a GROMACS TRR trajectory opened for reading.
+type TRRObj struct {
+	readable   bool
+	natoms     int
+	filename   string
+	fhandle    *os.File
+	trr        *bufio.Reader
+	current    frame
+	concBuffer []frame
+}
+
+// New opens the TRR trajectory filename for reading.
+func New(filename string) (*TRRObj, error) {
+	traj := new(TRRObj)
+	if err := traj.initRead(filename); err != nil {
+		return nil, errDecorate(err, "New")
+	}
+	return traj, nil
+}
+
+// initRead opens the file and reads the header of the first frame to get the number of atoms.
+// the file is then rewound.
+func (T *TRRObj) initRead(name string) error {
+	var err error
+	T.filename = name
+	T.fhandle, err = os.Open(name)
+	if err != nil {
+		return Error{err.Error(), T.filename, []string{"os.Open", "initRead"}, true}
+	}
+	T.trr = bufio.NewReader(T.fhandle)
+	var h header
+	if err := readHeader(T.trr, &h); err != nil {
+		T.fhandle.Close()
+		if err == io.EOF {
+			return Error{"Empty file", T.filename, []string{"initRead"}, true}
+		}
+		return Error{err.Error(), T.filename, []string{"initRead"}, true}
+	}
+	T.natoms = int(h.natoms)
+	if _, err := T.fhandle.Seek(0, io.SeekStart); err != nil {
+		T.fhandle.Close()
+		return Error{err.Error(), T.filename, []string{"os.File.Seek", "initRead"}, true}
+	}
+	T.trr.Reset(T.fhandle)
+	T.readable = true
+	return nil
+}
+
+// Readable returns true if the object is ready to be read from
+// false otherwise. It doesn't guarantee that there is something
+// to read.
+func (T *TRRObj) Readable() bool {
+	return T.readable
+}
+
+// Len returns the number of atoms per frame in the trajectory.
+func (T *TRRObj) Len() int {
+	return T.natoms
+}
+
+// Close closes the underlying file.
+func (T *TRRObj) Close() {
+	if !T.readable {
+		return
+	}
+	T.fhandle.Close()
+	T.readable = false
+}
+
+// Step returns the MD step of the last frame read.
+func (T *TRRObj) Step() int {
+	return int(T.current.h.step)
+}
+
+// Time returns the time, in ps, of the last frame read.
+func (T *TRRObj) Time() float64 {
+	return T.current.h.t
+}
+
+// Lambda returns the free-energy lambda value of the last frame read.
+func (T *TRRObj) Lambda() float64 {
+	return T.current.h.lambda
+}
+
+// HasCoords returns true if the last frame read contained coordinates.
+func (T *TRRObj) HasCoords() bool {
+	return T.current.h.xSize > 0
+}
+
+// HasVelocities returns true if the last frame read contained velocities.
+func (T *TRRObj) HasVelocities() bool {
+	return T.current.h.vSize > 0
+}
+
+// HasForces returns true if the last frame read contained forces.
+func (T *TRRObj) HasForces() bool {
+	return T.current.h.fSize > 0
+}
+
+// readFrame reads the next frame into f.
+func (T *TRRObj) readFrame(f *frame) error {
+	if !T.readable {
+		return Error{TrajUnIni, T.filename, []string{"readFrame"}, true}
+	}
+	if err := readHeader(T.trr, &f.h); err != nil {
+		T.Close()
+		if err == io.EOF {
+			return newlastFrameError(T.filename, "readFrame")
+		}
+		return Error{err.Error(), T.filename, []string{"readFrame"}, true}
+	}
+	if int(f.h.natoms) != T.natoms {
+		T.Close()
+		return Error{fmt.Sprintf("%s: frame with %d atoms in a trajectory with %d", WrongFormat, f.h.natoms, T.natoms), T.filename, []string{"readFrame"}, true}
+	}
+	size := f.h.dataSize()
+	if cap(f.data) < size {
+		f.data = make([]byte, size)
+	}
+	f.data = f.data[:size]
+	if _, err := io.ReadFull(T.trr, f.data); err != nil {
+		T.Close()
+		return Error{ReadError + ": " + err.Error(), T.filename, []string{"io.ReadFull", "readFrame"}, true}
+	}
+	return nil
+}
+
+// Next reads the next frame containing coordinates into coords, which can be nil, in which case the
+// frame is discarded. If a slice of at least 9 elements is given as box, the box vectors are
+// put there. Frames that contain only velocities and/or forces are skipped.
+func (T *TRRObj) Next(coords *v3.Matrix, box ...[]float64) error {
+	for {
+		if err := T.readFrame(&T.current); err != nil {
+			return errDecorate(err, "Next")
+		}
+		if T.current.h.xSize > 0 {
+			break
+		}
+	}
+	if coords == nil {
+		return nil
+	}
+	var b []float64
+	if len(box) > 0 {
+		b = box[0]
+	}
+	return T.current.decode(coords, nil, nil, b)
+}
+
+// NextFull reads the next frame of the trajectory, putting the coordinates, velocities, forces and box
+// vectors in coords, vel, force and box, respectively. Any of these can be nil, in which case the
+// corresponding data is discarded. Matrices for data absent in the frame are not modified, so the
+// HasCoords, HasVelocities and HasForces methods should be used to check what was read.
+func (T *TRRObj) NextFull(coords, vel, force *v3.Matrix, box ...[]float64) error {
+	if err := T.readFrame(&T.current); err != nil {
+		return errDecorate(err, "NextFull")
+	}
+	var b []float64
+	if len(box) > 0 {
+		b = box[0]
+	}
+	return T.current.decode(coords, vel, force, b)
+}
+
+// NextConc takes a slice of *v3.Matrix and reads as many frames (containing coordinates) as elements
+// the slice has from the trajectory. The frames are discarded if the corresponding element of the slice
+// is nil. The function returns a slice of channels through each of each of which
+// a *v3.Matrix will be transmitted.
+func (T *TRRObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	if !T.readable {
+		return nil, Error{TrajUnIni, T.filename, []string{"NextConc"}, true}
+	}
+	for len(T.concBuffer) < len(frames) {
+		T.concBuffer = append(T.concBuffer, frame{})
+	}
+	framechans := make([]chan *v3.Matrix, len(frames))
+	used := false
+	for key, val := range frames {
+		f := &T.concBuffer[key]
+		for {
+			if err := T.readFrame(f); err != nil {
+				if _, ok := err.(chem.LastFrameError); ok && used {
+					return framechans[:key], errDecorate(err, "NextConc")
+				}
+				return nil, errDecorate(err, "NextConc")
+			}
+			if f.h.xSize > 0 {
+				break
+			}
+		}
+		T.current.h = f.h
+		if val == nil {
+			framechans[key] = nil
+			continue
+		}
+		used = true
+		framechans[key] = make(chan *v3.Matrix)
+		go func(f *frame, keep *v3.Matrix, pipe chan *v3.Matrix) {
+			f.decode(keep, nil, nil, nil) //the data has already been checked by readFrame
+			pipe <- keep
+		}(f, val, framechans[key])
+	}
+	return framechans, nil
+}
+
+// decode puts the data in the frame in the given matrices/slices, if they are not nil.
+func (f *frame) decode(coords, vel, force *v3.Matrix, box []float64) error {
+	rs := f.h.realSize()
+	offset := 0
+	get := func(i int) float64 {
+		if rs == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(f.data[offset+8*i:]))
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(f.data[offset+4*i:])))
+	}
+	if f.h.boxSize > 0 && len(box) >= 9 {
+		for i := 0; i < 9; i++ {
+			box[i] = nm2A * get(i)
+		}
+	}
+	offset += int(f.h.boxSize + f.h.virSize + f.h.presSize)
+	natoms := int(f.h.natoms)
+	blocks := []struct {
+		size  int32
+		m     *v3.Matrix
+		scale float64
+	}{
+		{f.h.xSize, coords, nm2A},
+		{f.h.vSize, vel, nm2A},
+		{f.h.fSize, force, 1 / nm2A},
+	}
+	for _, b := range blocks {
+		if b.size > 0 && b.m != nil {
+			if b.m.NVecs() < natoms {
+				return Error{NotEnoughSpace, "", []string{"decode"}, true}
+			}
+			for i := 0; i < natoms; i++ {
+				for j := 0; j < 3; j++ {
+					b.m.Set(i, j, b.scale*get(3*i+j))
+				}
+			}
+		}
+		offset += int(b.size)
+	}
+	return nil
+}
+
+// readHeader reads a TRR frame header. It returns io.EOF only if there was nothing
+// left to read.
+func readHeader(r io.Reader, h *header) error {
+	var buf [4]byte
+	readInt := func() (int32, error) {
+		_, err := io.ReadFull(r, buf[:])
+		return int32(binary.BigEndian.Uint32(buf[:])), err
+	}
+	m, err := readInt()
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated header")
+		}
+		return err
+	}
+	if m != magic {
+		return fmt.Errorf("%s: wrong magic number %d", WrongFormat, m)
+	}
+	//the version string, as an int with strlen+1 and a XDR string (length + padded chars)
+	if _, err = readInt(); err != nil {
+		return unexpected(err)
+	}
+	slen, err := readInt()
+	if err != nil {
+		return unexpected(err)
+	}
+	if slen < 0 || slen > 128 {
+		return fmt.Errorf("%s: wrong version string length %d", WrongFormat, slen)
+	}
+	vers := make([]byte, (slen+3)/4*4)
+	if _, err = io.ReadFull(r, vers); err != nil {
+		return unexpected(err)
+	}
+	ints := []*int32{&h.irSize, &h.eSize, &h.boxSize, &h.virSize, &h.presSize, &h.topSize, &h.symSize,
+		&h.xSize, &h.vSize, &h.fSize, &h.natoms, &h.step, &h.nre}
+	for _, v := range ints {
+		if *v, err = readInt(); err != nil {
+			return unexpected(err)
+		}
+	}
+	var size int32
+	switch {
+	case h.boxSize > 0:
+		size = h.boxSize / 9
+	case h.virSize > 0:
+		size = h.virSize / 9
+	case h.presSize > 0:
+		size = h.presSize / 9
+	case h.xSize > 0 && h.natoms > 0:
+		size = h.xSize / (3 * h.natoms)
+	case h.vSize > 0 && h.natoms > 0:
+		size = h.vSize / (3 * h.natoms)
+	case h.fSize > 0 && h.natoms > 0:
+		size = h.fSize / (3 * h.natoms)
+	}
+	if size != 4 && size != 8 {
+		return fmt.Errorf("%s: can't determine the precision of the frame", WrongFormat)
+	}
+	h.double = size == 8
+	reals := make([]byte, 2*size)
+	if _, err = io.ReadFull(r, reals); err != nil {
+		return unexpected(err)
+	}
+	if h.double {
+		h.t = math.Float64frombits(binary.BigEndian.Uint64(reals))
+		h.lambda = math.Float64frombits(binary.BigEndian.Uint64(reals[8:]))
+	} else {
+		h.t = float64(math.Float32frombits(binary.BigEndian.Uint32(reals)))
+		h.lambda = float64(math.Float32frombits(binary.BigEndian.Uint32(reals[4:])))
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("truncated header")
+	}
+	return err
+}
+
+//Errors
+
+// errDecorate is a helper function that asserts that the error is
+// implements chem.Error and decorates the error with the caller's name before returning it.
+// if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	if err == nil {
+		return nil
+	}
+	err2 := err.(chem.Error)
+	err2.Decorate(caller)
+	return err2
+}
+
+// Error is the general structure for TRR trajectory errors. It fullfills  chem.Error and chem.TrajError
+type Error struct {
+	message  string
+	filename string //the input file that has problems, or empty string if none.
+	deco     []string
+	critical bool
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("trr file %s error: %s", err.filename, err.message)
+}
+
+// Decorate Adds new information to the error
+func (E Error) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+// Filename returns the file to which the failing trajectory was associated
+func (err Error) FileName() string { return err.filename }
+
+// Format returns the format of the file (always "trr") associated to the error
+func (err Error) Format() string { return "trr" }
+
+// Critical returns true if the error is critical, false otherwise
+func (err Error) Critical() bool { return err.critical }
+
+const (
+	TrajUnIni      = "Traj object uninitialized to read"
+	TrajUnIniWrite = "Traj object uninitialized to write"
+	ReadError      = "Error reading frame"
+	UnableToOpen   = "Unable to open file"
+	WrongFormat    = "Wrong format in the TRR file or frame"
+	NotEnoughSpace = "Not enough space in passed matrices"
+	EOF            = "EOF"
+)
+
+// lastFrameError implements chem.LastFrameError
+type lastFrameError struct {
+	deco     []string
+	fileName string
+}
+
+// lastFrameError does nothing
+func (E lastFrameError) NormalLastFrameTermination() {}
+
+func (E lastFrameError) FileName() string { return E.fileName }
+
+func (E lastFrameError) Error() string { return "EOF" }
+
+func (E lastFrameError) Critical() bool { return false }
+
+func (E lastFrameError) Format() string { return "trr" }
+
+func (E lastFrameError) Decorate(deco string) []string {
+	//Even thought this method does not use a pointer as a receiver, and tries to alter the received,
+	//it should work, since E.deco is a slice, and hence a pointer itself.
+	if deco != "" {
+		E.deco = append(E.deco, deco)
+	}
+	return E.deco
+}
+
+func newlastFrameError(filename string, caller string) *lastFrameError {
+	e := new(lastFrameError)
+	e.fileName = filename
+	e.deco = []string{caller}
+	return e
+}
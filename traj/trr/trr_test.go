@@ -0,0 +1,136 @@
+/*
+ * trr_test.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ */
+
+package trr
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+func testFrame(natoms, frame int, scale float64) *v3.Matrix {
+	m := v3.Zeros(natoms)
+	for i := 0; i < natoms; i++ {
+		for j := 0; j < 3; j++ {
+			m.Set(i, j, scale*float64(frame+i+j))
+		}
+	}
+	return m
+}
+
+// TestTRR writes a trajectory with coordinates, velocities and forces, and reads it back, both with Next and NextFull.
+func TestTRR(Te *testing.T) {
+	natoms := 5
+	name := filepath.Join(Te.TempDir(), "test.trr")
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	box := []float64{30, 0, 0, 0, 30, 0, 0, 0, 30}
+	for i := 0; i < 4; i++ {
+		err = w.WNextFull(testFrame(natoms, i, 1), testFrame(natoms, i, 0.5), testFrame(natoms, i, 2), 100*i, 0.2*float64(i), 0.1, box)
+		if err != nil {
+			Te.Fatal(err)
+		}
+	}
+	//a frame with only forces, which Next should skip.
+	w.WNextFull(nil, nil, testFrame(natoms, 4, 2), 400, 0.8, 0.1)
+	w.WNext(testFrame(natoms, 5, 1))
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(traj.Len())
+	vel := v3.Zeros(traj.Len())
+	force := v3.Zeros(traj.Len())
+	rbox := make([]float64, 9)
+	for i := 0; i < 4; i++ {
+		if err = traj.NextFull(coords, vel, force, rbox); err != nil {
+			Te.Fatal(err)
+		}
+		if traj.Step() != 100*i || math.Abs(traj.Time()-0.2*float64(i)) > 1e-6 || math.Abs(traj.Lambda()-0.1) > 1e-6 {
+			Te.Errorf("Wrong step, time or lambda in frame %d: %d %f %f", i, traj.Step(), traj.Time(), traj.Lambda())
+		}
+		if !traj.HasVelocities() || !traj.HasForces() || rbox[4] != 30 {
+			Te.Errorf("Missing data in frame %d", i)
+		}
+		if math.Abs(coords.At(2, 1)-float64(i+3)) > 1e-4 || math.Abs(vel.At(2, 1)-0.5*float64(i+3)) > 1e-4 || math.Abs(force.At(2, 1)-2*float64(i+3)) > 1e-4 {
+			Te.Errorf("Wrong data in frame %d: %v %v %v", i, coords.VecView(2), vel.VecView(2), force.VecView(2))
+		}
+	}
+	if err = traj.Next(coords); err != nil {
+		Te.Fatal(err)
+	}
+	if traj.Step() != 5 || math.Abs(coords.At(0, 0)-5) > 1e-5 {
+		Te.Errorf("Next didn't skip the frame without coordinates")
+	}
+	if err = traj.Next(coords); err == nil {
+		Te.Errorf("Expected the end of the trajectory")
+	} else if _, ok := err.(chem.LastFrameError); !ok {
+		Te.Error(err)
+	}
+}
+
+func TestTRRConc(Te *testing.T) {
+	natoms := 3
+	name := filepath.Join(Te.TempDir(), "testconc.trr")
+	w, err := NewWriter(name, natoms, true)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		w.WNext(testFrame(natoms, i, 1))
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frames := []*v3.Matrix{v3.Zeros(natoms), nil, v3.Zeros(natoms)}
+	read := 0
+	for {
+		chans, err := traj.NextConc(frames)
+		for i, c := range chans {
+			if c == nil {
+				read++
+				continue
+			}
+			m := <-c
+			if math.Abs(m.At(0, 0)-float64(read)) > 1e-5 {
+				Te.Errorf("Frame %d (%d in batch) has wrong coordinates: %v", read, i, m.VecView(0))
+			}
+			read++
+		}
+		if err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok {
+				Te.Fatal(err)
+			}
+			break
+		}
+	}
+	if read != 7 {
+		Te.Errorf("Read %d frames, expected 7", read)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * trr_write.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package trr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// TRRWObj is a GROMACS TRR trajectory opened for writing.
+type TRRWObj struct {
+	natoms   int
+	filename string
+	writable bool
+	double   bool
+	frames   int
+	dt       float64
+	fhandle  *os.File
+	trr      *bufio.Writer
+	buf      []byte
+}
+
+// NewWriter creates a TRR trajectory with name filename, for natoms atoms, for writing.
+// If double is given and true, the data is written in double precision.
+func NewWriter(filename string, natoms int, double ...bool) (*TRRWObj, error) {
+	T := new(TRRWObj)
+	T.natoms = natoms
+	T.filename = filename
+	T.dt = 1
+	if len(double) > 0 {
+		T.double = double[0]
+	}
+	if natoms <= 0 {
+		return nil, Error{"The number of atoms must be positive", filename, []string{"NewWriter"}, true}
+	}
+	var err error
+	T.fhandle, err = os.Create(filename)
+	if err != nil {
+		return nil, Error{err.Error(), filename, []string{"os.Create", "NewWriter"}, true}
+	}
+	T.trr = bufio.NewWriter(T.fhandle)
+	T.writable = true
+	return T, nil
+}
+
+// Len returns the number of atoms per frame in the trajectory.
+func (T *TRRWObj) Len() int {
+	return T.natoms
+}
+
+// SetTimeStep sets the time, in ps, between frames written with WNext. The default is 1 ps.
+func (T *TRRWObj) SetTimeStep(dt float64) {
+	T.dt = dt
+}
+
+// Close flushes and closes the underlying file.
+func (T *TRRWObj) Close() {
+	if !T.writable {
+		return
+	}
+	T.trr.Flush()
+	T.fhandle.Close()
+	T.writable = false
+}
+
+// WNext writes coords, and the box, if given, as the next frame of the trajectory.
+// The step of the frame will be the number of frames written before it, and the time, the step times
+// the time step set with SetTimeStep.
+func (T *TRRWObj) WNext(coords *v3.Matrix, box ...[]float64) error {
+	err := T.WNextFull(coords, nil, nil, T.frames, float64(T.frames)*T.dt, 0, box...)
+	return errDecorate(err, "WNext")
+}
+
+// WNextFull writes a frame with the given coordinates, velocities, forces, step, time (in ps) and lambda.
+// Any of the matrices can be nil, in which case the corresponding block is not written, but at least
+// one of them must be given. The box is written if a slice with at least 9 elements is given.
+func (T *TRRWObj) WNextFull(coords, vel, force *v3.Matrix, step int, time, lambda float64, box ...[]float64) error {
+	if !T.writable {
+		return Error{TrajUnIniWrite, T.filename, []string{"WNextFull"}, true}
+	}
+	if coords == nil && vel == nil && force == nil {
+		return Error{"No data given to write", T.filename, []string{"WNextFull"}, true}
+	}
+	for _, m := range []*v3.Matrix{coords, vel, force} {
+		if m != nil && m.NVecs() != T.natoms {
+			return Error{"Coordinates don't match the trajectory size", T.filename, []string{"WNextFull"}, true}
+		}
+	}
+	var h header
+	h.double = T.double
+	rs := int32(h.realSize())
+	blocksize := rs * 3 * int32(T.natoms)
+	if len(box) > 0 && len(box[0]) >= 9 {
+		h.boxSize = 9 * rs
+	}
+	if coords != nil {
+		h.xSize = blocksize
+	}
+	if vel != nil {
+		h.vSize = blocksize
+	}
+	if force != nil {
+		h.fSize = blocksize
+	}
+	h.natoms = int32(T.natoms)
+	h.step = int32(step)
+	h.t = time
+	h.lambda = lambda
+	T.buf = T.buf[:0]
+	T.putHeader(&h)
+	if h.boxSize > 0 {
+		for _, v := range box[0][:9] {
+			T.putReal(v / nm2A)
+		}
+	}
+	blocks := []struct {
+		m     *v3.Matrix
+		scale float64
+	}{
+		{coords, 1 / nm2A},
+		{vel, 1 / nm2A},
+		{force, nm2A},
+	}
+	for _, b := range blocks {
+		if b.m == nil {
+			continue
+		}
+		for i := 0; i < T.natoms; i++ {
+			for j := 0; j < 3; j++ {
+				T.putReal(b.scale * b.m.At(i, j))
+			}
+		}
+	}
+	if _, err := T.trr.Write(T.buf); err != nil {
+		return Error{err.Error(), T.filename, []string{"bufio.Writer.Write", "WNextFull"}, true}
+	}
+	T.frames++
+	return nil
+}
+
+func (T *TRRWObj) putInt(i int32) {
+	T.buf = binary.BigEndian.AppendUint32(T.buf, uint32(i))
+}
+
+func (T *TRRWObj) putReal(f float64) {
+	if T.double {
+		T.buf = binary.BigEndian.AppendUint64(T.buf, math.Float64bits(f))
+		return
+	}
+	T.buf = binary.BigEndian.AppendUint32(T.buf, math.Float32bits(float32(f)))
+}
+
+func (T *TRRWObj) putHeader(h *header) {
+	T.putInt(magic)
+	T.putInt(int32(len(version) + 1))
+	T.putInt(int32(len(version)))
+	T.buf = append(T.buf, version...) //12 characters, so no padding needed.
+	for _, v := range []int32{h.irSize, h.eSize, h.boxSize, h.virSize, h.presSize, h.topSize, h.symSize,
+		h.xSize, h.vSize, h.fSize, h.natoms, h.step, h.nre} {
+		T.putInt(v)
+	}
+	T.putReal(h.t)
+	T.putReal(h.lambda)
+}
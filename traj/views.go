@@ -0,0 +1,313 @@
+/*
+ * views.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package traj
+
+import (
+	"fmt"
+
+	chem "github.com/rmera/gochem"
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// This file contains "views" of trajectories: Types that wrap one or more trajectories
+// and are trajectories themselves (they implement chem.Traj and chem.ConcTraj) so
+// they can be given to any function taking a trajectory, and combined with each other.
+
+// lastFrameError signals the end of a view that is not the end of the wrapped trajectory.
+// It implements chem.LastFrameError.
+type lastFrameError struct {
+	deco []string
+}
+
+func (E *lastFrameError) Error() string               { return "EOF" }
+func (E *lastFrameError) Format() string              { return "view" }
+func (E *lastFrameError) Critical() bool              { return false }
+func (E *lastFrameError) FileName() string            { return "" }
+func (E *lastFrameError) NormalLastFrameTermination() {}
+
+// Decorate will add the dec string to the decoration slice of strings of the error,
+// and return the resulting slice.
+func (E *lastFrameError) Decorate(dec string) []string {
+	if dec != "" {
+		E.deco = append(E.deco, dec)
+	}
+	return E.deco
+}
+
+func isLastFrame(err error) bool {
+	_, ok := err.(chem.LastFrameError)
+	return ok
+}
+
+// nextConc reads as many frames from t as elements frames has, with Next, and puts each
+// in the corresponding element of frames, which is sent through the returned channel.
+// The frames for which the element is nil are read and discarded. If the trajectory
+// ends, the channels for the frames read are returned together with the error.
+func nextConc(t chem.Traj, frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	framechans := make([]chan *v3.Matrix, len(frames))
+	for key, v := range frames {
+		if err := t.Next(v); err != nil {
+			if key == 0 || !isLastFrame(err) {
+				return nil, err
+			}
+			return framechans[:key], err
+		}
+		if v == nil {
+			continue
+		}
+		framechans[key] = make(chan *v3.Matrix, 1)
+		framechans[key] <- v
+	}
+	return framechans, nil
+}
+
+// closeTraj closes t, if it can be closed.
+func closeTraj(t chem.Traj) {
+	if c, ok := t.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// Chain is a trajectory made by concatenating several trajectories with the
+// same number of atoms, as the segments of a simulation.
+type Chain struct {
+	trajs   []chem.Traj
+	current int
+}
+
+// NewChain returns a Chain with the given trajectories, which must all
+// have the same number of atoms.
+func NewChain(trajs ...chem.Traj) (*Chain, error) {
+	if len(trajs) == 0 {
+		return nil, fmt.Errorf("NewChain: no trajectories given")
+	}
+	for i, t := range trajs {
+		if t.Len() != trajs[0].Len() {
+			return nil, fmt.Errorf("NewChain: trajectory %d has %d atoms, but trajectory 0 has %d", i, t.Len(), trajs[0].Len())
+		}
+	}
+	return &Chain{trajs: trajs}, nil
+}
+
+// Readable returns true if there may be frames left to read.
+func (C *Chain) Readable() bool {
+	return C.current < len(C.trajs)
+}
+
+// Len returns the number of atoms per frame.
+func (C *Chain) Len() int {
+	return C.trajs[0].Len()
+}
+
+// Next reads the next frame into coords (or discards it, if coords is nil), and the
+// box vectors into box, if given. When a trajectory ends, the frame is read from the next one.
+func (C *Chain) Next(coords *v3.Matrix, box ...[]float64) error {
+	for C.current < len(C.trajs) {
+		err := C.trajs[C.current].Next(coords, box...)
+		if err == nil {
+			return nil
+		}
+		if !isLastFrame(err) {
+			return fmt.Errorf("Chain.Next: trajectory %d: %w", C.current, err)
+		}
+		C.current++
+	}
+	return &lastFrameError{}
+}
+
+// NextConc reads as many frames as elements frames has, and puts each in the corresponding
+// element of frames, which is sent through the returned channel. Frames for which the element
+// is nil are discarded.
+func (C *Chain) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	return nextConc(C, frames)
+}
+
+// Close closes all the trajectories in the chain that can be closed.
+func (C *Chain) Close() {
+	for _, t := range C.trajs {
+		closeTraj(t)
+	}
+	C.current = len(C.trajs)
+}
+
+// Slice is a view of the frames begin, begin+stride, begin+2*stride... up to, but not
+// including, end, of a trajectory. The frames not in the view are skipped with Seek, if the
+// trajectory implements chem.Seeker, or read and discarded, otherwise.
+type Slice struct {
+	traj        chem.Traj
+	begin, end  int
+	stride      int
+	pos         int //the next frame to be read from traj, relative to where the slice was created.
+	seeker      chem.Seeker
+	seekerStart int //the frame of the seeker where the slice starts.
+	started     bool
+}
+
+// NewSlice returns a view of the frames begin, begin+stride... before end of traj, counting
+// from the next frame to be read. If end is negative, the view goes to the end of the trajectory.
+func NewSlice(traj chem.Traj, begin, end, stride int) (*Slice, error) {
+	if begin < 0 || stride < 1 {
+		return nil, fmt.Errorf("NewSlice: begin must not be negative and stride must be positive, got %d and %d", begin, stride)
+	}
+	if end >= 0 && end < begin {
+		return nil, fmt.Errorf("NewSlice: end (%d) before begin (%d)", end, begin)
+	}
+	S := &Slice{traj: traj, begin: begin, end: end, stride: stride}
+	if s, ok := traj.(chem.Seeker); ok {
+		S.seeker = s
+		S.seekerStart = s.Tell()
+	}
+	return S, nil
+}
+
+// Readable returns true if there may be frames left to read.
+func (S *Slice) Readable() bool {
+	return S.traj.Readable() && (S.end < 0 || S.pos < S.end)
+}
+
+// Len returns the number of atoms per frame.
+func (S *Slice) Len() int {
+	return S.traj.Len()
+}
+
+// skipTo gets the wrapped trajectory to the frame target, relative to the
+// beginning of the slice.
+func (S *Slice) skipTo(target int) error {
+	if S.seeker != nil && target > S.pos {
+		if n := S.seeker.NFrames(); n >= 0 && S.seekerStart+target >= n {
+			return &lastFrameError{}
+		}
+		if err := S.seeker.Seek(S.seekerStart + target); err == nil {
+			S.pos = target
+			return nil
+		}
+		//If we couldn't seek, we will read the frames, and find out what the problem is.
+	}
+	for S.pos < target {
+		if err := S.traj.Next(nil); err != nil {
+			return err
+		}
+		S.pos++
+	}
+	return nil
+}
+
+// Next reads the next frame in the slice into coords (or discards it, if coords is nil),
+// and the box vectors into box, if given.
+func (S *Slice) Next(coords *v3.Matrix, box ...[]float64) error {
+	target := S.begin
+	if S.started {
+		target = S.pos + S.stride - 1
+	}
+	if S.end >= 0 && target >= S.end {
+		return &lastFrameError{}
+	}
+	if err := S.skipTo(target); err != nil {
+		if isLastFrame(err) {
+			return err
+		}
+		return fmt.Errorf("Slice.Next: %w", err)
+	}
+	if err := S.traj.Next(coords, box...); err != nil {
+		if isLastFrame(err) {
+			return err
+		}
+		return fmt.Errorf("Slice.Next: %w", err)
+	}
+	S.pos++
+	S.started = true
+	return nil
+}
+
+// NextConc reads as many frames of the slice as elements frames has, and puts each in the
+// corresponding element of frames, which is sent through the returned channel. Frames for which
+// the element is nil are discarded.
+func (S *Slice) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	return nextConc(S, frames)
+}
+
+// Close closes the wrapped trajectory, if it can be closed.
+func (S *Slice) Close() {
+	closeTraj(S.traj)
+}
+
+// Subset is a view of a trajectory with only some of its atoms.
+type Subset struct {
+	traj    chem.Traj
+	indexes []int
+	buffer  *v3.Matrix
+}
+
+// NewSubset returns a view of traj with only the atoms with the given indexes, in that order.
+func NewSubset(traj chem.Traj, indexes []int) (*Subset, error) {
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("NewSubset: no atoms given")
+	}
+	for _, v := range indexes {
+		if v < 0 || v >= traj.Len() {
+			return nil, fmt.Errorf("NewSubset: index %d out of range for a trajectory with %d atoms", v, traj.Len())
+		}
+	}
+	return &Subset{traj: traj, indexes: indexes, buffer: v3.Zeros(traj.Len())}, nil
+}
+
+// Readable returns true if there may be frames left to read.
+func (S *Subset) Readable() bool {
+	return S.traj.Readable()
+}
+
+// Len returns the number of atoms in the subset.
+func (S *Subset) Len() int {
+	return len(S.indexes)
+}
+
+// Next reads the next frame, and puts the coordinates of the atoms in the subset in coords (or
+// discards it, if coords is nil), and the box vectors in box, if given.
+func (S *Subset) Next(coords *v3.Matrix, box ...[]float64) error {
+	if coords == nil {
+		return S.traj.Next(nil, box...)
+	}
+	if coords.NVecs() != len(S.indexes) {
+		return fmt.Errorf("Subset.Next: room for %d atoms given, but the subset has %d", coords.NVecs(), len(S.indexes))
+	}
+	if err := S.traj.Next(S.buffer, box...); err != nil {
+		return err
+	}
+	coords.SomeVecs(S.buffer, S.indexes)
+	return nil
+}
+
+// NextConc reads as many frames as elements frames has, and puts the coordinates of the atoms in
+// the subset in the corresponding element of frames, which is sent through the returned channel.
+// Frames for which the element is nil are discarded.
+func (S *Subset) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	return nextConc(S, frames)
+}
+
+// Close closes the wrapped trajectory, if it can be closed.
+func (S *Subset) Close() {
+	closeTraj(S.traj)
+}
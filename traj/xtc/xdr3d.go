@@ -0,0 +1,515 @@
+/*
+ * xdr3d.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package xtc
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file implements the coordinate compression algorithm used in XTC files
+// (xdr3dfcoord). It follows closely the C implementation in the GROMACS xdrfile library,
+// but the indexes in magicints used for the run-length encoding are kept inside the table
+// (the C code can go one past its end), so the files produced may differ from those written by
+// GROMACS for systems where the coordinates of consecutive atoms are very far apart.
+
+var magicints = [...]int32{
+	0, 0, 0, 0, 0, 0, 0, 0, 0,
+	8, 10, 12, 16, 20, 25, 32, 40, 50, 64,
+	80, 101, 128, 161, 203, 256, 322, 406, 512, 645,
+	812, 1024, 1290, 1625, 2048, 2580, 3250, 4096, 5060, 6501,
+	8192, 10321, 13003, 16384, 20642, 26007, 32768, 41285, 52015, 65536,
+	82570, 104031, 131072, 165140, 208063, 262144, 330280, 416127, 524287, 660561,
+	832255, 1048576, 1321122, 1664510, 2097152, 2642245, 3329021, 4194304, 5284491, 6658042,
+	8388607, 10568983, 13316085, 16777216,
+}
+
+const (
+	firstidx = 9
+	lastidx  = len(magicints)
+)
+
+// sizeofint returns the number of bits needed to store size.
+func sizeofint(size uint32) int {
+	num := uint64(1)
+	nbits := 0
+	for uint64(size) >= num && nbits < 32 {
+		nbits++
+		num <<= 1
+	}
+	return nbits
+}
+
+// sizeofints returns the number of bits needed to store the product of the sizes.
+func sizeofints(sizes [3]uint32) int {
+	var bytes [32]uint32
+	nbytes := 1
+	bytes[0] = 1
+	nbits := 0
+	for i := 0; i < 3; i++ {
+		var tmp uint32
+		bytecnt := 0
+		for ; bytecnt < nbytes; bytecnt++ {
+			tmp = bytes[bytecnt]*sizes[i] + tmp
+			bytes[bytecnt] = tmp & 0xff
+			tmp >>= 8
+		}
+		for tmp != 0 {
+			bytes[bytecnt] = tmp & 0xff
+			bytecnt++
+			tmp >>= 8
+		}
+		nbytes = bytecnt
+	}
+	num := uint32(1)
+	nbytes--
+	for bytes[nbytes] >= num {
+		nbits++
+		num *= 2
+	}
+	return nbits + nbytes*8
+}
+
+// bitReader reads bit-packed integers from the compressed data of a frame.
+type bitReader struct {
+	data     []byte
+	cnt      int
+	lastbits uint32
+	lastbyte uint32
+	overflow bool //set if we tried to read past the end of the data.
+}
+
+func (b *bitReader) nextByte() uint32 {
+	if b.cnt >= len(b.data) {
+		b.overflow = true
+		return 0
+	}
+	c := b.data[b.cnt]
+	b.cnt++
+	return uint32(c)
+}
+
+func (b *bitReader) receiveBits(nbits int) int32 {
+	mask := uint32((uint64(1) << uint(nbits)) - 1)
+	var num uint32
+	for nbits >= 8 {
+		b.lastbyte = (b.lastbyte << 8) | b.nextByte()
+		num |= (b.lastbyte >> b.lastbits) << uint(nbits-8)
+		nbits -= 8
+	}
+	if nbits > 0 {
+		if int(b.lastbits) < nbits {
+			b.lastbits += 8
+			b.lastbyte = (b.lastbyte << 8) | b.nextByte()
+		}
+		b.lastbits -= uint32(nbits)
+		num |= (b.lastbyte >> b.lastbits) & ((1 << uint(nbits)) - 1)
+	}
+	return int32(num & mask)
+}
+
+func (b *bitReader) receiveInts(nbits int, sizes [3]uint32) [3]int32 {
+	var bytes [32]uint32
+	var nums [3]int32
+	nbytes := 0
+	for nbits > 8 {
+		bytes[nbytes] = uint32(b.receiveBits(8))
+		nbytes++
+		nbits -= 8
+	}
+	if nbits > 0 {
+		bytes[nbytes] = uint32(b.receiveBits(nbits))
+		nbytes++
+	}
+	for i := 2; i > 0; i-- {
+		var num uint32
+		for j := nbytes - 1; j >= 0; j-- {
+			num = (num << 8) | bytes[j]
+			p := num / sizes[i]
+			bytes[j] = p
+			num = num - p*sizes[i]
+		}
+		nums[i] = int32(num)
+	}
+	nums[0] = int32(bytes[0] | (bytes[1] << 8) | (bytes[2] << 16) | (bytes[3] << 24))
+	return nums
+}
+
+// bitWriter packs integers in a stream of bits.
+type bitWriter struct {
+	data     []byte
+	lastbits uint32
+	lastbyte uint32
+}
+
+func (b *bitWriter) sendBits(nbits int, num uint32) {
+	for nbits >= 8 {
+		b.lastbyte = (b.lastbyte << 8) | (num >> uint(nbits-8))
+		b.data = append(b.data, byte(b.lastbyte>>b.lastbits))
+		nbits -= 8
+	}
+	if nbits > 0 {
+		b.lastbyte = (b.lastbyte << uint(nbits)) | num
+		b.lastbits += uint32(nbits)
+		if b.lastbits >= 8 {
+			b.lastbits -= 8
+			b.data = append(b.data, byte(b.lastbyte>>b.lastbits))
+		}
+	}
+}
+
+func (b *bitWriter) sendInts(nbits int, sizes [3]uint32, nums [3]uint32) error {
+	var bytes [32]uint32
+	nbytes := 0
+	tmp := nums[0]
+	for {
+		bytes[nbytes] = tmp & 0xff
+		nbytes++
+		tmp >>= 8
+		if tmp == 0 {
+			break
+		}
+	}
+	for i := 1; i < 3; i++ {
+		if nums[i] >= sizes[i] {
+			return fmt.Errorf("number %d doesn't match size %d", nums[i], sizes[i])
+		}
+		tmp = nums[i]
+		bytecnt := 0
+		for ; bytecnt < nbytes; bytecnt++ {
+			tmp = bytes[bytecnt]*sizes[i] + tmp
+			bytes[bytecnt] = tmp & 0xff
+			tmp >>= 8
+		}
+		for tmp != 0 {
+			bytes[bytecnt] = tmp & 0xff
+			bytecnt++
+			tmp >>= 8
+		}
+		nbytes = bytecnt
+	}
+	if nbits >= nbytes*8 {
+		for i := 0; i < nbytes; i++ {
+			b.sendBits(8, bytes[i])
+		}
+		b.sendBits(nbits-nbytes*8, 0)
+	} else {
+		i := 0
+		for ; i < nbytes-1; i++ {
+			b.sendBits(8, bytes[i])
+		}
+		b.sendBits(nbits-(nbytes-1)*8, bytes[i])
+	}
+	return nil
+}
+
+// finish returns the packed data, including the last, incomplete, byte, if any.
+func (b *bitWriter) finish() []byte {
+	if b.lastbits > 0 {
+		b.data = append(b.data, byte(b.lastbyte<<(8-b.lastbits)))
+	}
+	return b.data
+}
+
+// compressed contains a set of compressed coordinates, as stored in a XTC frame.
+type compressed struct {
+	natoms   int
+	prec     float32
+	minint   [3]int32
+	maxint   [3]int32
+	smallidx int32
+	data     []byte
+}
+
+func abs32(i int32) int32 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// decompress puts the coordinates encoded in c into out, which must have space for
+// at least 3*c.natoms numbers.
+func (c *compressed) decompress(out []float32) error {
+	natoms := c.natoms
+	if len(out) < 3*natoms {
+		return fmt.Errorf("not enough space for the decompressed coordinates")
+	}
+	var sizeint [3]uint32
+	var bitsizeint [3]int
+	bitsize := 0
+	for i := range sizeint {
+		sizeint[i] = uint32(c.maxint[i] - c.minint[i] + 1)
+	}
+	if (sizeint[0] | sizeint[1] | sizeint[2]) > 0xffffff {
+		for i := range sizeint {
+			bitsizeint[i] = sizeofint(sizeint[i])
+		}
+	} else {
+		bitsize = sizeofints(sizeint)
+	}
+	smallidx := int(c.smallidx)
+	if smallidx < firstidx || smallidx >= lastidx {
+		return fmt.Errorf("wrong small index %d", smallidx)
+	}
+	smaller := magicints[max(firstidx, smallidx-1)] / 2
+	smallnum := magicints[smallidx] / 2
+	s := uint32(magicints[smallidx])
+	sizesmall := [3]uint32{s, s, s}
+	invp := 1 / c.prec
+	br := &bitReader{data: c.data}
+	var thiscoord, prevcoord [3]int32
+	run := 0 //the run length is kept from one iteration to the next unless it is changed.
+	lfp := 0
+	write := func(coord [3]int32) {
+		for j := 0; j < 3; j++ {
+			out[lfp+j] = float32(coord[j]) * invp
+		}
+		lfp += 3
+	}
+	for i := 0; i < natoms; {
+		if bitsize == 0 {
+			for j := 0; j < 3; j++ {
+				thiscoord[j] = br.receiveBits(bitsizeint[j])
+			}
+		} else {
+			thiscoord = br.receiveInts(bitsize, sizeint)
+		}
+		i++
+		for j := 0; j < 3; j++ {
+			thiscoord[j] += c.minint[j]
+		}
+		prevcoord = thiscoord
+		isSmaller := 0
+		if br.receiveBits(1) == 1 {
+			run = int(br.receiveBits(5))
+			isSmaller = run % 3
+			run -= isSmaller
+			isSmaller--
+		}
+		if run > 0 {
+			if i+run/3 > natoms {
+				return fmt.Errorf("more coordinates than atoms in compressed frame")
+			}
+			for k := 0; k < run; k += 3 {
+				thiscoord = br.receiveInts(smallidx, sizesmall)
+				i++
+				for j := 0; j < 3; j++ {
+					thiscoord[j] += prevcoord[j] - smallnum
+				}
+				if k == 0 {
+					//first and second atoms are interchanged for better compression of water molecules.
+					thiscoord, prevcoord = prevcoord, thiscoord
+					write(prevcoord)
+				} else {
+					prevcoord = thiscoord
+				}
+				write(thiscoord)
+			}
+		} else {
+			write(thiscoord)
+		}
+		smallidx += isSmaller
+		if smallidx < firstidx || smallidx >= lastidx {
+			return fmt.Errorf("wrong small index %d", smallidx)
+		}
+		if isSmaller < 0 {
+			smallnum = smaller
+			if smallidx > firstidx {
+				smaller = magicints[smallidx-1] / 2
+			} else {
+				smaller = 0
+			}
+		} else if isSmaller > 0 {
+			smaller = smallnum
+			smallnum = magicints[smallidx] / 2
+		}
+		s := uint32(magicints[smallidx])
+		sizesmall = [3]uint32{s, s, s}
+	}
+	if br.overflow {
+		return fmt.Errorf("compressed data ended unexpectedly")
+	}
+	return nil
+}
+
+// compress compresses natoms coordinates (3*natoms numbers) from coords, with precision prec,
+// into c. natoms should be larger than 9, as smaller sets are not compressed in XTC files.
+func (c *compressed) compress(coords []float32, natoms int, prec float32) error {
+	if prec <= 0 {
+		prec = 1000
+	}
+	c.natoms = natoms
+	c.prec = prec
+	ints := make([]int32, 3*natoms)
+	minint := [3]int32{math.MaxInt32, math.MaxInt32, math.MaxInt32}
+	maxint := [3]int32{math.MinInt32, math.MinInt32, math.MinInt32}
+	var mindiff int32 = math.MaxInt32
+	var old [3]int32
+	for i := 0; i < natoms; i++ {
+		var diff int32
+		for j := 0; j < 3; j++ {
+			var lf float32
+			f := float32(coords[3*i+j] * prec) //the conversion avoids fused operations
+			if f >= 0 {
+				lf = f + 0.5
+			} else {
+				lf = f - 0.5
+			}
+			if math.Abs(float64(lf)) > math.MaxInt32-2 {
+				return fmt.Errorf("internal overflow compressing coordinates")
+			}
+			l := int32(lf)
+			minint[j] = min(minint[j], l)
+			maxint[j] = max(maxint[j], l)
+			ints[3*i+j] = l
+			diff += abs32(old[j] - l)
+			old[j] = l
+		}
+		if diff < mindiff && i > 0 {
+			mindiff = diff
+		}
+	}
+	var sizeint [3]uint32
+	var bitsizeint [3]int
+	bitsize := 0
+	for j := 0; j < 3; j++ {
+		if float64(maxint[j])-float64(minint[j]) >= math.MaxInt32-2 {
+			return fmt.Errorf("internal overflow compressing coordinates")
+		}
+		sizeint[j] = uint32(maxint[j] - minint[j] + 1)
+	}
+	if (sizeint[0] | sizeint[1] | sizeint[2]) > 0xffffff {
+		for j := range sizeint {
+			bitsizeint[j] = sizeofint(sizeint[j])
+		}
+	} else {
+		bitsize = sizeofints(sizeint)
+	}
+	smallidx := firstidx
+	for smallidx < lastidx-1 && magicints[smallidx] < mindiff {
+		smallidx++
+	}
+	c.smallidx = int32(smallidx)
+	c.minint = minint
+	c.maxint = maxint
+	maxidx := min(lastidx-1, smallidx+8)
+	minidx := maxidx - 8 //often this is equal to smallidx
+	smaller := magicints[max(firstidx, smallidx-1)] / 2
+	smallnum := magicints[smallidx] / 2
+	s := uint32(magicints[smallidx])
+	sizesmall := [3]uint32{s, s, s}
+	larger := magicints[maxidx] / 2
+	bw := &bitWriter{data: make([]byte, 0, 3*natoms)}
+	var prevcoord [3]int32
+	var tmpcoord [30]uint32
+	prevrun := -1
+	for i := 0; i < natoms; {
+		isSmall := false
+		isSmaller := 0
+		thiscoord := ints[3*i : 3*i+3]
+		if smallidx < maxidx && i >= 1 && abs32(thiscoord[0]-prevcoord[0]) < larger &&
+			abs32(thiscoord[1]-prevcoord[1]) < larger && abs32(thiscoord[2]-prevcoord[2]) < larger {
+			isSmaller = 1
+		} else if smallidx > minidx {
+			isSmaller = -1
+		}
+		if i+1 < natoms {
+			next := ints[3*i+3 : 3*i+6]
+			if abs32(thiscoord[0]-next[0]) < smallnum && abs32(thiscoord[1]-next[1]) < smallnum &&
+				abs32(thiscoord[2]-next[2]) < smallnum {
+				//interchange first with second atom for better compression of water molecules
+				for j := 0; j < 3; j++ {
+					thiscoord[j], next[j] = next[j], thiscoord[j]
+				}
+				isSmall = true
+			}
+		}
+		if bitsize == 0 {
+			for j := 0; j < 3; j++ {
+				bw.sendBits(bitsizeint[j], uint32(thiscoord[j]-minint[j]))
+			}
+		} else {
+			t := [3]uint32{uint32(thiscoord[0] - minint[0]), uint32(thiscoord[1] - minint[1]), uint32(thiscoord[2] - minint[2])}
+			if err := bw.sendInts(bitsize, sizeint, t); err != nil {
+				return err
+			}
+		}
+		copy(prevcoord[:], thiscoord)
+		i++
+		run := 0
+		if !isSmall && isSmaller == -1 {
+			isSmaller = 0
+		}
+		for isSmall && run < 8*3 {
+			thiscoord = ints[3*i : 3*i+3]
+			var tmpsum int32
+			for j := 0; j < 3; j++ {
+				tmp := thiscoord[j] - prevcoord[j]
+				tmpsum += tmp * tmp
+			}
+			if isSmaller == -1 && tmpsum >= smaller*smaller {
+				isSmaller = 0
+			}
+			for j := 0; j < 3; j++ {
+				tmpcoord[run] = uint32(thiscoord[j] - prevcoord[j] + smallnum)
+				run++
+			}
+			copy(prevcoord[:], thiscoord)
+			i++
+			isSmall = false
+			if i < natoms {
+				thiscoord = ints[3*i : 3*i+3]
+				if abs32(thiscoord[0]-prevcoord[0]) < smallnum && abs32(thiscoord[1]-prevcoord[1]) < smallnum &&
+					abs32(thiscoord[2]-prevcoord[2]) < smallnum {
+					isSmall = true
+				}
+			}
+		}
+		if run != prevrun || isSmaller != 0 {
+			prevrun = run
+			bw.sendBits(1, 1) //flag the change in run-length
+			bw.sendBits(5, uint32(run+isSmaller+1))
+		} else {
+			bw.sendBits(1, 0) //flag the fact that runlength did not change
+		}
+		for k := 0; k < run; k += 3 {
+			if err := bw.sendInts(smallidx, sizesmall, [3]uint32{tmpcoord[k], tmpcoord[k+1], tmpcoord[k+2]}); err != nil {
+				return err
+			}
+		}
+		if isSmaller != 0 {
+			smallidx += isSmaller
+			if isSmaller < 0 {
+				smallnum = smaller
+				smaller = magicints[smallidx-1] / 2
+			} else {
+				smaller = smallnum
+				smallnum = magicints[smallidx] / 2
+			}
+			s := uint32(magicints[smallidx])
+			sizesmall = [3]uint32{s, s, s}
+		}
+	}
+	c.data = bw.finish()
+	return nil
+}
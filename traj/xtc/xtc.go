@@ -25,196 +25,223 @@
  *
  * ***/
 
+// Package xtc reads and writes GROMACS XTC trajectories. It is written in pure Go,
+// and does not require the GROMACS xdrfile library.
 package xtc
 
-// #cgo CFLAGS:  -I.
-// #cgo LDFLAGS:  -L.  -lnsl -lm   -lxdrfile
-//#include <xtc.h>
-//#include <stdio.h>
-//#include <stdlib.h>
-//#include <xdrfile_xtc.h>
-//#include <xdrfile_trr.h>
-//#include <xdrfile.h>
-import "C"
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"os"
 
+	chem "github.com/rmera/gochem"
 	v3 "github.com/rmera/gochem/v3"
 )
 
-//XTCObj is a container for an GROMACS XTC binary trajectory file.
+const (
+	magic = 1995
+	nm2A  = 10.0
+)
+
+// frame contains a XTC frame, with its coordinates still compressed.
+type frame struct {
+	step   int32
+	time   float32
+	box    [9]float32
+	coords compressed
+	raw    []float32 //uncompressed coordinates, used for systems with 9 atoms or less.
+}
+
+// decode puts the coordinates of the frame, in A, in output.
+func (f *frame) decode(output *v3.Matrix, buffer []float32) error {
+	c := f.raw
+	if f.coords.natoms > 9 {
+		if err := f.coords.decompress(buffer); err != nil {
+			return err
+		}
+		c = buffer
+	}
+	r, _ := output.Dims()
+	if r < f.coords.natoms {
+		panic("Buffer v3.Matrix too small to hold trajectory frame")
+	}
+	for j := 0; j < f.coords.natoms; j++ {
+		for k := 0; k < 3; k++ {
+			output.Set(j, k, nm2A*float64(c[3*j+k])) //nm to Angstroms
+		}
+	}
+	return nil
+}
+
+// XTCObj is a container for an GROMACS XTC binary trajectory file.
 type XTCObj struct {
-	readable      bool
-	natoms        int
-	filename      string
-	fp            *C.XDRFILE //pointer to the XDRFILE
-	goCoords      []float64
-	goBox         []float64
-	concBuffer    [][]C.float
-	concBoxBuffer [][]C.float
-	cCoords       []C.float
-	cBox          []C.float
-	buffSize      int
+	readable   bool
+	natoms     int
+	filename   string
+	fhandle    *os.File
+	xtc        *bufio.Reader
+	current    frame
+	buffer     []float32
+	concBuffer []frame
+	buffSize   int
+	next       int     //the next frame to be read.
+	offsets    []int64 //where each frame starts in the file, built when first needed.
 }
 
-//New returns an xtb object from a xtb-formated trajectory file
+// New returns an xtc object from a xtc-formated trajectory file
 func New(filename string) (*XTCObj, error) {
 	traj := new(XTCObj)
 	if err := traj.initRead(filename); err != nil {
-		err := err.(Error) //I know that is the type returned byt initRead
-		err.Decorate("New")
-		return nil, err
+		return nil, errDecorate(err, "New")
 	}
 	return traj, nil
 
 }
 
-//Readable returns true if the object is ready to be read from
-//false otherwise. IT doesnt guarantee that there is something
-//to read.
+// Readable returns true if the object is ready to be read from
+// false otherwise. IT doesnt guarantee that there is something
+// to read.
 func (X *XTCObj) Readable() bool {
-	if X.readable {
-		return true
-	}
-	return false
+	return X.readable
 }
 
-//InitRead initializes a XTCObj for reading.
-//It requires only the filename, which must be valid
+// InitRead initializes a XTCObj for reading.
+// It requires only the filename, which must be valid
 func (X *XTCObj) initRead(name string) error {
-	Cfilename := C.CString(name)
-	Cnatoms := C.read_natoms(Cfilename)
-	X.natoms = int(Cnatoms)
-	totalcoords := X.natoms * 3
-	X.fp = C.openfile(Cfilename)
-	if X.fp == nil {
-		return Error{UnableToOpen, X.filename, []string{"initRead"}, true}
+	var err error
+	X.filename = name
+	X.fhandle, err = os.Open(name)
+	if err != nil {
+		return Error{UnableToOpen + ": " + err.Error(), X.filename, []string{"os.Open", "initRead"}, true}
+	}
+	X.xtc = bufio.NewReader(X.fhandle)
+	var h [2]int32
+	if err := binary.Read(X.xtc, binary.BigEndian, h[:]); err != nil {
+		X.fhandle.Close()
+		return Error{ReadError + ": " + err.Error(), X.filename, []string{"binary.Read", "initRead"}, true}
+	}
+	if h[0] != magic {
+		X.fhandle.Close()
+		return Error{WrongFormat + ": wrong magic number", X.filename, []string{"initRead"}, true}
 	}
+	X.natoms = int(h[1])
+	if _, err := X.fhandle.Seek(0, io.SeekStart); err != nil {
+		X.fhandle.Close()
+		return Error{err.Error(), X.filename, []string{"os.File.Seek", "initRead"}, true}
+	}
+	X.xtc.Reset(X.fhandle)
 	//The idea is to reserve less memory, using the same buffer many times.
-	//X.goCoords = make([]float64, totalcoords, totalcoords)
-	X.cCoords = make([]C.float, totalcoords, totalcoords)
-	X.cBox = make([]C.float, 9)
-	X.concBuffer = append(X.concBuffer, X.cCoords)
-	X.concBoxBuffer = append(X.concBoxBuffer, X.cBox)
+	X.buffer = make([]float32, 3*X.natoms)
 	X.buffSize = 1
 	X.readable = true
 	return nil
 }
 
-//Next Reads the next frame in a XTCObj that has been initialized for read
-//With initread. If keep is true, returns a pointer to matrix.DenseMatrix
-//With the coordinates read, otherwiser, it discards the coordinates and
-//returns nil.
-func (X *XTCObj) Next(output *v3.Matrix, box ...[]float64) error {
-	if !X.Readable() {
-		return Error{TrajUnIni, X.filename, []string{"Next"}, true}
+// Step returns the MD step of the last frame read.
+func (X *XTCObj) Step() int {
+	return int(X.current.step)
+}
+
+// Time returns the time, in ps, of the last frame read.
+func (X *XTCObj) Time() float64 {
+	return float64(X.current.time)
+}
+
+// readFrame reads the next frame, without decompressing it, into f.
+func (X *XTCObj) readFrame(f *frame) error {
+	if !X.readable {
+		return Error{TrajUnIni, X.filename, []string{"readFrame"}, true}
 	}
-	cnatoms := C.int(X.natoms)
-	worked := C.get_coords(X.fp, &X.cCoords[0], &X.cBox[0], cnatoms)
-	if worked == 11 {
+	err := readFrame(X.xtc, f, X.natoms)
+	if err == io.EOF {
 		X.Close()
-		return newlastFrameError(X.filename, "Next") //This is not really an error and should be catched in the calling function
+		return newlastFrameError(X.filename, "readFrame") //This is not really an error and should be catched in the calling function
 	}
-	if worked != 0 {
+	if err != nil {
 		X.Close()
-		return Error{ReadError, X.filename, []string{"Next"}, true}
-	}
-	if output == nil {
-		return nil //just drop the frame and box
-	}
-	r, c := output.Dims()
-	if r < (X.natoms) {
-		panic("Buffer v3.Matrix too small to hold trajectory frame")
+		return Error{ReadError + ": " + err.Error(), X.filename, []string{"readFrame"}, true}
 	}
-	for j := 0; j < r; j++ {
-		for k := 0; k < c; k++ {
-			l := k + (3 * j)
-			output.Set(j, k, (10 * float64(X.cCoords[l]))) //nm to Angstroms
-		}
+	X.next++
+	return nil
+}
+
+// Next Reads the next frame in a XTCObj that has been initialized for read
+// With initread. If output is not nil, the coordinates read are put there,
+// otherwise, they are discarded. If a slice of at least 9 elements is given as box,
+// the box vectors are put there.
+func (X *XTCObj) Next(output *v3.Matrix, box ...[]float64) error {
+	if err := X.readFrame(&X.current); err != nil {
+		return errDecorate(err, "Next")
 	}
 	//We won't return an error here, as you most commonly don't want the vectors.
 	//If you give aything that won't fit the box vectors you just won't get the vectors back.
 	if len(box) > 0 && len(box[0]) >= 9 {
 		for k := 0; k < 9; k++ {
-			box[0][k] = 10 * float64(X.cBox[k])
+			box[0][k] = nm2A * float64(X.current.box[k])
 		}
 	}
-
-	return nil //Just drop the frame
+	if output == nil {
+		return nil //just drop the frame
+	}
+	if err := X.current.decode(output, X.buffer); err != nil {
+		X.Close()
+		return Error{ReadError + ": " + err.Error(), X.filename, []string{"Next"}, true}
+	}
+	return nil
 }
 
-//SetConcBuffer
+// SetConcBuffer
 func (X *XTCObj) setConcBuffer(batchsize int) error {
-	l := X.buffSize
-	if l == batchsize {
-		return nil
-		//I won't do anything about the boxes if we have too many. It's little memory anyway.
-	} else if l > batchsize {
-		//I don't think it's worth it to free that memory. If the user wan't to read more frames again
-		//we'll have to re-aquire memory. Better just leave it until we free the object.
-		//I suspect the user rarely starts reading less frames concurrently half way the traj, anyway.
-		//	for i := batchsize; i < l; i++ {
-		//		X.concBuffer[i] = nil //no idea if this actually works
-		//		X.concBoxBuffer[i]=nil
-		//	}
-		X.concBuffer = X.concBuffer[:batchsize-1] //not sure if this frees the remaining []float32 slices
-		X.buffSize = batchsize
-		return nil
-	}
-	for i := 0; i < batchsize-l; i++ {
-		tmp := make([]C.float, X.Len()*3)
-		X.concBuffer = append(X.concBuffer, tmp)
+	for len(X.concBuffer) < batchsize {
+		X.concBuffer = append(X.concBuffer, frame{})
 	}
 	X.buffSize = batchsize
 	return nil
 }
 
-//Close closes the underlying file and sets all fields in the object to their zero-value
+// Close closes the underlying file and sets all fields in the object to their zero-value
 func (X *XTCObj) Close() {
-	if X.fp != nil {
-		C.xtc_close(X.fp) //we avoid closing it if it was closed already
+	if X.fhandle != nil {
+		X.fhandle.Close() //we avoid closing it if it was closed already
 	}
 	X.readable = false
-	X.cCoords = nil
-	X.cBox = nil
+	X.buffer = nil
 	X.concBuffer = nil
-	X.concBoxBuffer = nil
-	X.fp = nil
+	X.fhandle = nil
 }
 
-//NextConc takes a slice of bools and reads as many frames as elements the list has
-//form the trajectory. The frames are discarted if the corresponding elemetn of the slice
-//is false. The function returns a slice of channels through each of each of which
-// a *matrix.DenseMatrix will be transmited
+// NextConc takes a slice of *v3.Matrix and reads as many frames as elements the list has
+// form the trajectory. The frames are discarted if the corresponding element of the slice
+// is nil. The function returns a slice of channels through each of each of which
+// a *v3.Matrix will be transmited. The decompression of each frame is done concurrently.
 func (X *XTCObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
+	if X.natoms == 0 || !X.readable {
+		return nil, Error{TrajUnIni, X.filename, []string{"NextConc"}, true}
+	}
 	if X.buffSize < len(frames) {
 		X.setConcBuffer(len(frames))
 	}
-	if X.natoms == 0 {
-		return nil, Error{TrajUnIni, X.filename, []string{"NextConc"}, true}
-	}
 	framechans := make([]chan *v3.Matrix, len(frames)) //the slice of chans that will be returned
-	cnatoms := C.int(X.natoms)
 	used := false
 	for key, val := range frames {
-		//cCoords:=X.concBuffer[key]
-		//	fmt.Println(len(X.concBuffer), len(X.cBox), key, val.NVecs()) ///////////////////////////////
-		worked := C.get_coords(X.fp, &X.concBuffer[key][0], &X.cBox[0], cnatoms)
+		f := &X.concBuffer[key]
+		err := X.readFrame(f)
 		//Error handling
-		if worked == 11 {
-			if used == false {
-				//X.readable = false
-				X.Close()
-				return nil, newlastFrameError(X.filename, "NextConc") //This is not really an error and
+		if _, ok := err.(chem.LastFrameError); ok {
+			if !used {
+				return nil, errDecorate(err, "NextConc") //This is not really an error and
 			} else { //should be catched in the calling function
-				X.readable = false
-				return framechans, newlastFrameError(X.filename, "NextConc") //same
+				return framechans[:key], errDecorate(err, "NextConc") //same
 			}
 		}
-		if worked != 0 {
-			X.Close()
-			return nil, Error{ReadError, X.filename, []string{"NextConc"}, true}
+		if err != nil {
+			return nil, errDecorate(err, "NextConc")
 		}
+		X.current.step = f.step
+		X.current.time = f.time
 		if val == nil {
 			framechans[key] = nil //ignored frame
 			continue
@@ -222,30 +249,243 @@ func (X *XTCObj) NextConc(frames []*v3.Matrix) ([]chan *v3.Matrix, error) {
 		used = true
 		framechans[key] = make(chan *v3.Matrix)
 		//Now the parallel part
-		go func(natoms int, cCoords []C.float, goCoords *v3.Matrix, pipe chan *v3.Matrix) {
-			r, c := goCoords.Dims()
-			for j := 0; j < r; j++ {
-				for k := 0; k < c; k++ {
-					l := k + (3 * j)
-					goCoords.Set(j, k, (10 * float64(cCoords[l]))) //nm to Angstroms
-				}
-			}
+		go func(f *frame, goCoords *v3.Matrix, pipe chan *v3.Matrix) {
+			//the data has been read already, so we don't expect errors here.
+			f.decode(goCoords, make([]float32, 3*f.coords.natoms))
 			pipe <- goCoords
-		}(X.natoms, X.concBuffer[key], val, framechans[key])
+		}(f, val, framechans[key])
 	}
 
 	return framechans, nil
 }
 
-//Len returns the number of atoms per frame in the XTCObj.
-//XTCObj must be initialized. 0 means an uninitialized object.
+// Len returns the number of atoms per frame in the XTCObj.
+// XTCObj must be initialized. 0 means an uninitialized object.
 func (X *XTCObj) Len() int {
 	return X.natoms
 }
 
+// NFrames returns the number of frames in the trajectory, or -1 if the file can't be read.
+// The first call (to NFrames or Seek) goes over the whole file to find where each frame starts,
+// as the frames in XTC files have different sizes.
+func (X *XTCObj) NFrames() int {
+	if err := X.index(); err != nil {
+		return -1
+	}
+	return len(X.offsets)
+}
+
+// index finds where each frame starts, if that hasn't been done before.
+func (X *XTCObj) index() error {
+	if X.offsets != nil {
+		return nil
+	}
+	var err error
+	X.offsets, err = frameOffsets(X.filename, X.natoms)
+	if err != nil {
+		X.offsets = nil
+		return Error{ReadError + ": " + err.Error(), X.filename, []string{"frameOffsets", "index"}, true}
+	}
+	return nil
+}
+
+// Tell returns the index of the next frame to be read, counting from 0.
+func (X *XTCObj) Tell() int {
+	return X.next
+}
+
+// Seek sets the trajectory so the next frame read is frame (counting from 0).
+// If the trajectory was closed, for instance, because its last frame was read,
+// it is opened again.
+func (X *XTCObj) Seek(frame int) error {
+	if err := X.index(); err != nil {
+		return errDecorate(err, "Seek")
+	}
+	n := len(X.offsets)
+	if frame < 0 || frame >= n {
+		return Error{fmt.Sprintf("Frame %d out of range, the trajectory has %d frames", frame, n), X.filename, []string{"Seek"}, true}
+	}
+	if !X.readable {
+		if err := X.initRead(X.filename); err != nil {
+			return errDecorate(err, "Seek")
+		}
+	}
+	if _, err := X.fhandle.Seek(X.offsets[frame], io.SeekStart); err != nil {
+		return Error{err.Error(), X.filename, []string{"os.File.Seek", "Seek"}, true}
+	}
+	X.xtc.Reset(X.fhandle)
+	X.next = frame
+	return nil
+}
+
+// frameOffsets returns the positions in the file name, with a trajectory of natoms atoms,
+// where each frame starts. Only the headers of the frames are read. An incomplete
+// last frame is not included.
+func frameOffsets(name string, natoms int) ([]int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	ret := make([]int64, 0, 100)
+	var h [92]byte //the header, up to the number of bytes of compressed data.
+	for pos := int64(0); pos < size; {
+		hsize := 56 //magic, natoms, step, time, box and natoms again.
+		if natoms > 9 {
+			hsize = 92 //plus the precision, minint, maxint, smallidx and the number of bytes.
+		}
+		if _, err := f.ReadAt(h[:hsize], pos); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if m := int32(binary.BigEndian.Uint32(h[0:])); m != magic {
+			return nil, fmt.Errorf("%s: wrong magic number %d at byte %d", WrongFormat, m, pos)
+		}
+		fsize := int64(hsize) + 12*int64(natoms)
+		if natoms > 9 {
+			nbytes := int64(int32(binary.BigEndian.Uint32(h[88:])))
+			if nbytes < 0 {
+				return nil, fmt.Errorf("%s: negative size for compressed data at byte %d", WrongFormat, pos)
+			}
+			fsize = int64(hsize) + (nbytes+3)/4*4
+		}
+		if pos+fsize > size {
+			break
+		}
+		ret = append(ret, pos)
+		pos += fsize
+	}
+	return ret, nil
+}
+
+// readFrame reads a frame, for a system with natoms atoms, from r, into f.
+// It returns io.EOF only if there was nothing left to read.
+func readFrame(r io.Reader, f *frame, natoms int) error {
+	var h [3]int32
+	if err := binary.Read(r, binary.BigEndian, h[:]); err != nil {
+		return err
+	}
+	if h[0] != magic {
+		return fmt.Errorf("%s: wrong magic number %d", WrongFormat, h[0])
+	}
+	if int(h[1]) != natoms {
+		return fmt.Errorf("%s: frame with %d atoms in a trajectory with %d", WrongFormat, h[1], natoms)
+	}
+	f.step = h[2]
+	//From here on, EOF means a truncated frame.
+	wrap := func(err error) error {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &f.time); err != nil {
+		return wrap(err)
+	}
+	if err := binary.Read(r, binary.BigEndian, f.box[:]); err != nil {
+		return wrap(err)
+	}
+	var lsize int32
+	if err := binary.Read(r, binary.BigEndian, &lsize); err != nil {
+		return wrap(err)
+	}
+	if int(lsize) != natoms {
+		return fmt.Errorf("%s: wrong number of atoms in coordinates %d", WrongFormat, lsize)
+	}
+	c := &f.coords
+	c.natoms = natoms
+	if natoms <= 9 {
+		if len(f.raw) != 3*natoms {
+			f.raw = make([]float32, 3*natoms)
+		}
+		return wrap(binary.Read(r, binary.BigEndian, f.raw))
+	}
+	if err := binary.Read(r, binary.BigEndian, &c.prec); err != nil {
+		return wrap(err)
+	}
+	var ints [8]int32 //minint, maxint, smallidx and byte count
+	if err := binary.Read(r, binary.BigEndian, ints[:]); err != nil {
+		return wrap(err)
+	}
+	copy(c.minint[:], ints[0:3])
+	copy(c.maxint[:], ints[3:6])
+	c.smallidx = ints[6]
+	nbytes := int(ints[7])
+	if nbytes < 0 {
+		return fmt.Errorf("%s: negative size for compressed data", WrongFormat)
+	}
+	padded := (nbytes + 3) / 4 * 4 //XDR opaque data is padded to 4 bytes.
+	if cap(c.data) < padded {
+		c.data = make([]byte, padded)
+	}
+	c.data = c.data[:padded]
+	if _, err := io.ReadFull(r, c.data); err != nil {
+		return wrap(err)
+	}
+	c.data = c.data[:nbytes]
+	return nil
+}
+
+// writeFrame writes a frame to w.
+func writeFrame(w io.Writer, f *frame) error {
+	buf := make([]byte, 0, 92+len(f.coords.data))
+	putInt := func(i int32) { buf = binary.BigEndian.AppendUint32(buf, uint32(i)) }
+	putFloat := func(f float32) { buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(f)) }
+	natoms := f.coords.natoms
+	putInt(magic)
+	putInt(int32(natoms))
+	putInt(f.step)
+	putFloat(f.time)
+	for _, v := range f.box {
+		putFloat(v)
+	}
+	putInt(int32(natoms))
+	if natoms <= 9 {
+		for _, v := range f.raw[:3*natoms] {
+			putFloat(v)
+		}
+		_, err := w.Write(buf)
+		return err
+	}
+	c := &f.coords
+	putFloat(c.prec)
+	for _, v := range c.minint {
+		putInt(v)
+	}
+	for _, v := range c.maxint {
+		putInt(v)
+	}
+	putInt(c.smallidx)
+	putInt(int32(len(c.data)))
+	buf = append(buf, c.data...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
 //Errors
 
-//Error is an error with xtb trajectories, compatible with goChem
+// errDecorate is a helper function that asserts that the error is
+// implements chem.Error and decorates the error with the caller's name before returning it.
+// if used with a non-chem.Error error, it will cause a panic.
+func errDecorate(err error, caller string) error {
+	if err == nil {
+		return nil
+	}
+	err2 := err.(chem.Error)
+	err2.Decorate(caller)
+	return err2
+}
+
+// Error is an error with xtc trajectories, compatible with goChem
 type Error struct {
 	message  string
 	filename string //the input file that has problems, or empty string if none.
@@ -274,10 +514,13 @@ func (err Error) Format() string { return "xtc" }
 func (err Error) Critical() bool { return err.critical }
 
 const (
-	TrajUnIni    = "Traj object uninitialized to read"
-	ReadError    = "Error reading frame"
-	UnableToOpen = "Unable to open file"
-	EOF          = "EOF"
+	TrajUnIni      = "Traj object uninitialized to read"
+	TrajUnIniWrite = "Traj object uninitialized to write"
+	ReadError      = "Error reading frame"
+	WriteError     = "Error writing frame"
+	UnableToOpen   = "Unable to open file"
+	WrongFormat    = "Wrong format in the XTC file or frame"
+	EOF            = "EOF"
 )
 
 type lastFrameError struct {
@@ -285,7 +528,7 @@ type lastFrameError struct {
 	fileName string
 }
 
-//lastFrameError does nothing
+// lastFrameError does nothing
 func (E lastFrameError) NormalLastFrameTermination() {}
 
 func (E lastFrameError) FileName() string { return E.fileName }
@@ -26,7 +26,13 @@
 package xtc
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
 	chem "github.com/rmera/gochem"
@@ -42,7 +48,7 @@ func TestXTC(Te *testing.T) {
 	fmt.Println("First test")
 	traj, err := New("../../test/test.xtc")
 	if err != nil {
-		Te.Error(err)
+		Te.Fatal(err)
 	}
 	i := 0
 	coords := v3.Zeros(traj.Len())
@@ -88,7 +94,7 @@ func TestFrameXTC(Te *testing.T) {
 func TestFrameXTCConc(Te *testing.T) {
 	traj, err := New("../../test/test.xtc")
 	if err != nil {
-		Te.Error(err)
+		Te.Fatal(err)
 	}
 	frames := make([]*v3.Matrix, 3, 3)
 	for i, _ := range frames {
@@ -150,3 +156,294 @@ func SecondRow(channelin, channelout chan *v3.Matrix, current, other int) {
 	}
 	return
 }
+
+// waterBox returns natoms atoms grouped in water-like clusters, so the
+// run-length part of the XTC compression is exercised.
+func waterBox(natoms int, seed int64) *v3.Matrix {
+	r := rand.New(rand.NewSource(seed))
+	m := v3.Zeros(natoms)
+	for i := 0; i < natoms; i += 3 {
+		o := []float64{40 * r.Float64(), 40 * r.Float64(), 40 * r.Float64()}
+		for j := i; j < i+3 && j < natoms; j++ {
+			for k := 0; k < 3; k++ {
+				m.Set(j, k, o[k]+r.Float64()-0.5)
+			}
+		}
+	}
+	return m
+}
+
+// xtcRoundTrip writes the given frames to a temporary XTC file, reads them back
+// and checks that the coordinates and box agree within the XTC precision.
+func xtcRoundTrip(Te *testing.T, frames []*v3.Matrix) {
+	natoms := frames[0].NVecs()
+	name := filepath.Join(Te.TempDir(), "roundtrip.xtc")
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	w.SetTimeStep(2)
+	box := []float64{40, 0, 0, 0, 41, 0, 0, 0, 42}
+	for _, f := range frames {
+		if err := w.WNext(f, box); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if traj.Len() != natoms {
+		Te.Fatalf("Read %d atoms, expected %d", traj.Len(), natoms)
+	}
+	coords := v3.Zeros(natoms)
+	rbox := make([]float64, 9)
+	for i, f := range frames {
+		if err := traj.Next(coords, rbox); err != nil {
+			Te.Fatal(err)
+		}
+		if traj.Step() != i || math.Abs(traj.Time()-2*float64(i)) > 1e-5 || math.Abs(rbox[4]-41) > 1e-4 {
+			Te.Errorf("Wrong step, time or box in frame %d: %d %f %v", i, traj.Step(), traj.Time(), rbox)
+		}
+		for j := 0; j < natoms; j++ {
+			for k := 0; k < 3; k++ {
+				//precision is 0.001 nm, i.e. 0.01 A.
+				if d := math.Abs(coords.At(j, k) - f.At(j, k)); d > 0.0051 {
+					Te.Fatalf("Frame %d atom %d differs by %f: %v %v", i, j, d, coords.VecView(j), f.VecView(j))
+				}
+			}
+		}
+	}
+	if err := traj.Next(coords); err == nil {
+		Te.Errorf("Expected the end of the trajectory")
+	} else if _, ok := err.(chem.LastFrameError); !ok {
+		Te.Error(err)
+	}
+}
+
+func TestXTCRoundTrip(Te *testing.T) {
+	for _, natoms := range []int{4, 9, 10, 300, 3001} {
+		frames := make([]*v3.Matrix, 4)
+		for i := range frames {
+			frames[i] = waterBox(natoms, int64(natoms+i))
+		}
+		Te.Run(fmt.Sprint(natoms), func(Te *testing.T) { xtcRoundTrip(Te, frames) })
+	}
+}
+
+// TestXTCRewrite reads the test trajectory, writes it again and checks that
+// the new file has the same coordinates.
+func TestXTCRewrite(Te *testing.T) {
+	if _, err := os.Stat("../../test/test.xtc"); err != nil {
+		Te.Skip("test trajectory not available")
+	}
+	traj, err := New("../../test/test.xtc")
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var frames []*v3.Matrix
+	for {
+		coords := v3.Zeros(traj.Len())
+		if err := traj.Next(coords); err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok {
+				Te.Fatal(err)
+			}
+			break
+		}
+		frames = append(frames, coords)
+	}
+	if len(frames) == 0 {
+		Te.Fatal("No frames read")
+	}
+	xtcRoundTrip(Te, frames)
+}
+
+func TestXTCConcRoundTrip(Te *testing.T) {
+	natoms := 30
+	name := filepath.Join(Te.TempDir(), "conc.xtc")
+	w, err := NewWriter(name, natoms)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	var written []*v3.Matrix
+	for i := 0; i < 7; i++ {
+		written = append(written, waterBox(natoms, int64(i)))
+		w.WNext(written[i])
+	}
+	w.Close()
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	frames := []*v3.Matrix{v3.Zeros(natoms), nil, v3.Zeros(natoms)}
+	read := 0
+	for {
+		chans, err := traj.NextConc(frames)
+		for _, c := range chans {
+			if c != nil {
+				m := <-c
+				if math.Abs(m.At(5, 1)-written[read].At(5, 1)) > 0.0051 {
+					Te.Errorf("Frame %d has wrong coordinates: %v %v", read, m.VecView(5), written[read].VecView(5))
+				}
+			}
+			read++
+		}
+		if err != nil {
+			if _, ok := err.(chem.LastFrameError); !ok {
+				Te.Fatal(err)
+			}
+			break
+		}
+	}
+	if read != 7 {
+		Te.Errorf("Read %d frames, expected 7", read)
+	}
+}
+
+func TestXTCSeek(Te *testing.T) {
+	for _, natoms := range []int{5, 300} {
+		const nframes = 7
+		name := filepath.Join(Te.TempDir(), "seek.xtc")
+		w, err := NewWriter(name, natoms)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		frames := make([]*v3.Matrix, nframes)
+		for f := range frames {
+			frames[f] = waterBox(natoms, int64(f))
+			if err := w.WNext(frames[f]); err != nil {
+				Te.Fatal(err)
+			}
+		}
+		w.Close()
+		traj, err := New(name)
+		if err != nil {
+			Te.Fatal(err)
+		}
+		var _ chem.Seeker = traj
+		if n := traj.NFrames(); n != nframes {
+			Te.Fatalf("NFrames returned %d, expected %d", n, nframes)
+		}
+		coords := v3.Zeros(natoms)
+		//forward, backwards, and after the end of the trajectory was reached.
+		for _, f := range []int{4, 1, 6, 0} {
+			if err := traj.Seek(f); err != nil {
+				Te.Fatal(err)
+			}
+			for ; ; f++ {
+				if traj.Tell() != f {
+					Te.Fatalf("Tell returned %d, expected %d", traj.Tell(), f)
+				}
+				if err := traj.Next(coords); err != nil {
+					if _, ok := err.(chem.LastFrameError); !ok || f != nframes {
+						Te.Fatal(f, err)
+					}
+					break
+				}
+				if d := math.Abs(coords.At(natoms-1, 2) - frames[f].At(natoms-1, 2)); d > 0.0051 {
+					Te.Fatalf("Wrong coordinates in frame %d (%d atoms)", f, natoms)
+				}
+			}
+		}
+		if err := traj.Seek(nframes); err == nil {
+			Te.Error("Seek beyond the last frame didn't fail")
+		}
+		traj.Close()
+	}
+}
+
+// gmxXTC is a two-frame trajectory of 24 atoms (8 water-like clusters and a
+// lone atom) written by the GROMACS xdrfile library (write_xtc) with precision 1000.
+// The frames have steps 10 and 20, times 0.02 and 0.04 ps and the box 3, 3.5, 4 nm. In the second
+// frame, each atom i is displaced 0.0123*(i%5) nm along each axis from its position in the first.
+const gmxXTC = "" +
+	"000007cb000000180000000a3ca3d70a4040000000000000000000000000000040600000000000000000000000000000" +
+	"4080000000000018447a000000000018000000c8fffffb50000009c400000c1c00000384000000160000005aeb443a37" +
+	"2789a206ffb0137f836a42889a206ffb0115b248372804da1f2c6e4185951690a20114d202ecd10d1ce8dc9907ee195b" +
+	"1f0e8ffa36a42880453480bb346db448372841fb8656c7c3ae59d1690951d4d0130c0de83e100000000007cb00000018" +
+	"000000143d23d70a40400000000000000000000000000000406000000000000000000000000000004080000000000018" +
+	"447a000000000018000000c8fffffb75000009e900000c41000003b5000000140000005fef3b1f1c21f273b13c224ddc" +
+	"420922933fd39812ceecb724555ba06de440a2262824ee6725133a69ab998a14e2c5d9956e58439597c9143a1d144c69" +
+	"a6a133d9d5874392899d34d380b724a0e87250b72c1eaa08676048e67251a6a07d45c200"
+
+// gmxXTCFirst returns the coordinates, in A, of the first frame in gmxXTC.
+func gmxXTCFirst() *v3.Matrix {
+	m := v3.Zeros(24)
+	for w := 0; w < 8; w++ {
+		o := []float64{3*float64(w%2) + 1, 4.5*float64((w/2)%2) + 2, 6*float64(w/4) + 3}
+		for k := 0; k < 3; k++ {
+			m.Set(3*w, k, o[k])
+			m.Set(3*w+1, k, o[k])
+			m.Set(3*w+2, k, o[k])
+		}
+		m.Set(3*w+1, 0, o[0]+0.757)
+		m.Set(3*w+1, 1, o[1]+0.586)
+		m.Set(3*w+2, 0, o[0]-0.757)
+		m.Set(3*w+2, 1, o[1]+0.586)
+	}
+	m.Set(23, 0, 25)
+	m.Set(23, 1, 31)
+	m.Set(23, 2, -12)
+	return m
+}
+
+// TestGROMACSXTC reads a trajectory written by GROMACS, and checks that writing
+// the frames read gives back the same file.
+func TestGROMACSXTC(Te *testing.T) {
+	data, err := hex.DecodeString(gmxXTC)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	dir := Te.TempDir()
+	name := filepath.Join(dir, "gmx.xtc")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		Te.Fatal(err)
+	}
+	traj, err := New(name)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	defer traj.Close()
+	if traj.Len() != 24 {
+		Te.Fatalf("Read %d atoms, expected 24", traj.Len())
+	}
+	want := gmxXTCFirst()
+	rname := filepath.Join(dir, "rewritten.xtc")
+	w, err := NewWriter(rname, 24)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	coords := v3.Zeros(24)
+	box := make([]float64, 9)
+	for f := 0; f < 2; f++ {
+		if err := traj.Next(coords, box); err != nil {
+			Te.Fatal(err)
+		}
+		if traj.Step() != 10*(f+1) || math.Abs(traj.Time()-0.02*float64(f+1)) > 1e-6 || box[0] != 30 || box[4] != 35 || box[8] != 40 || box[1] != 0 {
+			Te.Errorf("Wrong step, time or box in frame %d: %d %f %v", f, traj.Step(), traj.Time(), box)
+		}
+		for i := 0; i < 24; i++ {
+			for k := 0; k < 3; k++ {
+				//The precision is 0.001 nm, i.e. 0.01 A.
+				if d := math.Abs(coords.At(i, k) - want.At(i, k) - 0.123*float64(f*(i%5))); d > 0.0051 {
+					Te.Fatalf("Frame %d, atom %d differs by %f A from the expected position", f, i, d)
+				}
+			}
+		}
+		if err := w.WNextFull(coords, traj.Step(), traj.Time(), box); err != nil {
+			Te.Fatal(err)
+		}
+	}
+	w.Close()
+	if err := traj.Next(coords); err == nil {
+		Te.Errorf("Expected the end of the trajectory")
+	}
+	rewritten, err := os.ReadFile(rname)
+	if err != nil {
+		Te.Fatal(err)
+	}
+	if !bytes.Equal(rewritten, data) {
+		Te.Errorf("The rewritten trajectory differs from the one written by GROMACS:\n%x\n%x", rewritten, data)
+	}
+}
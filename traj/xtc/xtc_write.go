@@ -0,0 +1,133 @@
+/*
+ * xtc_write.go, part of gochem
+ *
+ * Copyright 2026 Raul Mera Adasme <rmera_changeforat_chem-dot-helsinki-dot-fi>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License  as published by
+ * the Free Software Foundation; either version 2.1 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston,
+ * MA 02110-1301, USA.
+ */
+
+package xtc
+
+import (
+	"bufio"
+	"os"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// XTCWObj is a GROMACS XTC trajectory opened for writing.
+type XTCWObj struct {
+	natoms   int
+	filename string
+	writable bool
+	prec     float32
+	frames   int
+	dt       float64
+	fhandle  *os.File
+	xtc      *bufio.Writer
+	buffer   []float32
+	frame    frame
+}
+
+// NewWriter creates a XTC trajectory with name filename, for natoms atoms, for writing.
+// precision is the inverse of the precision with which the coordinates (in nm) are stored,
+// the default, as in GROMACS, is 1000 (i.e. 0.001 nm).
+func NewWriter(filename string, natoms int, precision ...float64) (*XTCWObj, error) {
+	X := new(XTCWObj)
+	X.natoms = natoms
+	X.filename = filename
+	X.prec = 1000
+	X.dt = 1
+	if len(precision) > 0 && precision[0] > 0 {
+		X.prec = float32(precision[0])
+	}
+	if natoms <= 0 {
+		return nil, Error{"The number of atoms must be positive", filename, []string{"NewWriter"}, true}
+	}
+	var err error
+	X.fhandle, err = os.Create(filename)
+	if err != nil {
+		return nil, Error{UnableToOpen + ": " + err.Error(), filename, []string{"os.Create", "NewWriter"}, true}
+	}
+	X.xtc = bufio.NewWriter(X.fhandle)
+	X.buffer = make([]float32, 3*natoms)
+	X.writable = true
+	return X, nil
+}
+
+// Len returns the number of atoms per frame in the trajectory.
+func (X *XTCWObj) Len() int {
+	return X.natoms
+}
+
+// SetTimeStep sets the time, in ps, between frames written with WNext. The default is 1 ps.
+func (X *XTCWObj) SetTimeStep(dt float64) {
+	X.dt = dt
+}
+
+// Close flushes and closes the underlying file.
+func (X *XTCWObj) Close() {
+	if !X.writable {
+		return
+	}
+	X.xtc.Flush()
+	X.fhandle.Close()
+	X.writable = false
+}
+
+// WNext writes coords, and the box, if given, as the next frame of the trajectory.
+// The step of the frame will be the number of frames written before it, and the time, the step times
+// the time step set with SetTimeStep.
+func (X *XTCWObj) WNext(coords *v3.Matrix, box ...[]float64) error {
+	err := X.WNextFull(coords, X.frames, float64(X.frames)*X.dt, box...)
+	return errDecorate(err, "WNext")
+}
+
+// WNextFull writes coords as the next frame in the trajectory, with the given step and time (in ps).
+// The box vectors are written if a slice of at least 9 elements is given. Otherwise, a zero box is written.
+func (X *XTCWObj) WNextFull(coords *v3.Matrix, step int, time float64, box ...[]float64) error {
+	if !X.writable {
+		return Error{TrajUnIniWrite, X.filename, []string{"WNextFull"}, true}
+	}
+	if coords == nil || coords.NVecs() != X.natoms {
+		return Error{"Coordinates don't match the trajectory size", X.filename, []string{"WNextFull"}, true}
+	}
+	f := &X.frame
+	f.step = int32(step)
+	f.time = float32(time)
+	f.box = [9]float32{}
+	if len(box) > 0 && len(box[0]) >= 9 {
+		for i := range f.box {
+			f.box[i] = float32(box[0][i] / nm2A)
+		}
+	}
+	for i := 0; i < X.natoms; i++ {
+		for j := 0; j < 3; j++ {
+			X.buffer[3*i+j] = float32(coords.At(i, j) / nm2A)
+		}
+	}
+	if X.natoms <= 9 {
+		f.coords.natoms = X.natoms
+		f.raw = X.buffer
+	} else if err := f.coords.compress(X.buffer, X.natoms, X.prec); err != nil {
+		return Error{WriteError + ": " + err.Error(), X.filename, []string{"WNextFull"}, true}
+	}
+	if err := writeFrame(X.xtc, f); err != nil {
+		return Error{WriteError + ": " + err.Error(), X.filename, []string{"WNextFull"}, true}
+	}
+	X.frames++
+	return nil
+}
@@ -0,0 +1,262 @@
+/*
+ * trajwrite.go, part of gochem.
+ *
+ *
+ * Copyright 2026 rmeraaatacademicosdotutadotcl
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as
+ * published by the Free Software Foundation; either version 2.1 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General
+ * Public License along with this program.  If not, see
+ * <http://www.gnu.org/licenses/>.
+ *
+ * goChem is developed at Universidad de Tarapaca (UTA)
+ *
+ *
+ */
+
+package chem
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	v3 "github.com/rmera/gochem/v3"
+)
+
+// This file contains writers for multi-frame XYZ, Gro and PDB files that write
+// one frame at the time, and so implement TrajWriter.
+
+// streamWriter contains what is common to the trajectory writers for text formats.
+type streamWriter struct {
+	out    io.Writer
+	file   io.Closer //the file, if the writer opened it, nil otherwise.
+	mol    Atomer
+	frames int //frames written so far.
+	closed bool
+}
+
+// newStreamFileWriter creates the file name and returns a streamWriter for it.
+func newStreamFileWriter(name string, mol Atomer) (streamWriter, error) {
+	f, err := createWrite(name)
+	if err != nil {
+		return streamWriter{}, err
+	}
+	return streamWriter{out: f, file: f, mol: mol}, nil
+}
+
+// check returns an error if the writer is closed, or if coords doesn't match its topology.
+func (S *streamWriter) check(coords *v3.Matrix, caller string) error {
+	if S.closed {
+		return CError{"The writer has been closed", []string{caller}}
+	}
+	if S.mol == nil || coords == nil || S.mol.Len() != coords.NVecs() {
+		return CError{"Ref and Coords dont have the same number of atoms", []string{caller}}
+	}
+	return nil
+}
+
+// close closes the file, if the writer created it, and returns the error obtained, if any.
+// For compressed files, the last data is written only when the file is closed.
+func (S *streamWriter) close() error {
+	if S.closed {
+		return nil
+	}
+	S.closed = true
+	if S.file != nil {
+		return S.file.Close()
+	}
+	return nil
+}
+
+// frameBox returns the box given to a WNext method, or nil if no box was given, or
+// if it doesn't span a volume, as the all-zeros box of frames with no box information.
+func frameBox(box [][]float64) *Box {
+	if len(box) == 0 {
+		return nil
+	}
+	b, err := NewBox(box[0])
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// XYZWriter writes multi-frame XYZ files, one frame at the time.
+// The box vectors, if given, are written in the comment line of the frame,
+// as in the extended XYZ format.
+type XYZWriter struct {
+	streamWriter
+}
+
+// NewXYZWriter returns a writer for XYZ trajectories with the atoms in mol to out.
+func NewXYZWriter(out io.Writer, mol Atomer) *XYZWriter {
+	return &XYZWriter{streamWriter{out: out, mol: mol}}
+}
+
+// NewXYZFileWriter creates the file name and returns a writer for XYZ trajectories with the atoms in mol to it.
+// If the file exists, it will be overwritten.
+func NewXYZFileWriter(name string, mol Atomer) (*XYZWriter, error) {
+	s, err := newStreamFileWriter(name, mol)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"createWrite", "NewXYZFileWriter"}}
+	}
+	return &XYZWriter{s}, nil
+}
+
+// WNext writes coords, and the box, if given, as the next frame.
+func (X *XYZWriter) WNext(coords *v3.Matrix, box ...[]float64) error {
+	if err := X.check(coords, "XYZWriter.WNext"); err != nil {
+		return err
+	}
+	comment := ""
+	if b := frameBox(box); b != nil {
+		v := make([]string, 0, 9)
+		for _, c := range b.Vectors() {
+			v = append(v, fmt.Sprintf("%.6f", c))
+		}
+		comment = fmt.Sprintf("Lattice=\"%s\"", strings.Join(v, " "))
+	}
+	if err := xyzWrite(X.out, coords, X.mol, comment); err != nil {
+		return errDecorate(err, "XYZWriter.WNext")
+	}
+	X.frames++
+	return nil
+}
+
+// Close closes the file, if the writer created it. Nothing can be written after Close.
+func (X *XYZWriter) Close() {
+	X.CloseErr()
+}
+
+// CloseErr is like Close, but returns the error obtained when closing the file, which,
+// for compressed files, means that the file is incomplete.
+func (X *XYZWriter) CloseErr() error {
+	return errDecorate(X.close(), "XYZWriter.CloseErr")
+}
+
+// GroWriter writes multi-frame Gromacs gro files, one frame at the time.
+type GroWriter struct {
+	streamWriter
+}
+
+// NewGroWriter returns a writer for gro trajectories with the atoms in mol to out.
+func NewGroWriter(out io.Writer, mol Atomer) *GroWriter {
+	return &GroWriter{streamWriter{out: out, mol: mol}}
+}
+
+// NewGroFileWriter creates the file name and returns a writer for gro trajectories with the atoms in mol to it.
+// If the file exists, it will be overwritten.
+func NewGroFileWriter(name string, mol Atomer) (*GroWriter, error) {
+	s, err := newStreamFileWriter(name, mol)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"createWrite", "NewGroFileWriter"}}
+	}
+	return &GroWriter{s}, nil
+}
+
+// WNext writes coords, and the box, if given, as the next frame. Frames
+// with no box get a zero box.
+func (G *GroWriter) WNext(coords *v3.Matrix, box ...[]float64) error {
+	if err := G.check(coords, "GroWriter.WNext"); err != nil {
+		return err
+	}
+	if err := groSnapWrite(coords, G.mol, G.out, frameBox(box)); err != nil {
+		return errDecorate(err, "GroWriter.WNext")
+	}
+	G.frames++
+	return nil
+}
+
+// Close closes the file, if the writer created it. Nothing can be written after Close.
+func (G *GroWriter) Close() {
+	G.CloseErr()
+}
+
+// CloseErr is like Close, but returns the error obtained when closing the file, which,
+// for compressed files, means that the file is incomplete.
+func (G *GroWriter) CloseErr() error {
+	return errDecorate(G.close(), "GroWriter.CloseErr")
+}
+
+// PDBWriter writes multi-PDB files, one frame (MODEL) at the time.
+// The unit cell is written in a CRYST1 record before the first frame. It is taken from
+// the box of the first frame, if given, or from mol, if it is a *Molecule with a unit cell.
+// The CONECT records are written when the writer is closed.
+type PDBWriter struct {
+	streamWriter
+}
+
+// NewPDBWriter returns a writer for multi-PDB trajectories with the atoms in mol to out.
+func NewPDBWriter(out io.Writer, mol Atomer) *PDBWriter {
+	return &PDBWriter{streamWriter{out: out, mol: mol}}
+}
+
+// NewPDBFileWriter creates the file name and returns a writer for multi-PDB trajectories with the atoms in mol to it.
+// If the file exists, it will be overwritten.
+func NewPDBFileWriter(name string, mol Atomer) (*PDBWriter, error) {
+	s, err := newStreamFileWriter(name, mol)
+	if err != nil {
+		return nil, CError{err.Error(), []string{"createWrite", "NewPDBFileWriter"}}
+	}
+	return &PDBWriter{s}, nil
+}
+
+// WNext writes coords as the next model. All b-factors are written as zero.
+func (P *PDBWriter) WNext(coords *v3.Matrix, box ...[]float64) error {
+	if err := P.check(coords, "PDBWriter.WNext"); err != nil {
+		return err
+	}
+	iowriterError := func(err error) error {
+		return CError{"Failed to write in io.Writer" + err.Error(), []string{"io.Writer.Write", "PDBWriter.WNext"}}
+	}
+	header := ""
+	if P.frames == 0 {
+		header = "REMARK WRITTEN WITH GOCHEM :-)\n" + pdbCryst1(P.mol)
+		if b := frameBox(box); b != nil {
+			header = "REMARK WRITTEN WITH GOCHEM :-)\n" + pdbCryst1Cell(b.UnitCell())
+		}
+	}
+	_, err := io.WriteString(P.out, fmt.Sprintf("%sMODEL %d\n", header, P.frames+1)) //The model number starts with one
+	if err != nil {
+		return iowriterError(err)
+	}
+	err = pdbWrite(P.out, coords, P.mol, nil, false)
+	if err != nil {
+		return errDecorate(err, "PDBWriter.WNext")
+	}
+	_, err = io.WriteString(P.out, "MDL\n") //pdbWrite leaves us with "END".
+	if err != nil {
+		return iowriterError(err)
+	}
+	P.frames++
+	return nil
+}
+
+// Close writes the CONECT records, if any frame was written, and closes the file, if the writer
+// created it. Nothing can be written after Close.
+func (P *PDBWriter) Close() {
+	P.CloseErr()
+}
+
+// CloseErr is like Close, but returns the error obtained when writing the CONECT records or
+// closing the file, which, for compressed files, means that the file is incomplete.
+func (P *PDBWriter) CloseErr() error {
+	var err error
+	if !P.closed && P.frames > 0 {
+		_, err = io.WriteString(P.out, pdbConect(P.mol)+"END\n")
+	}
+	if cerr := P.close(); err == nil {
+		err = cerr
+	}
+	return errDecorate(err, "PDBWriter.CloseErr")
+}
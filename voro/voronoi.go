@@ -45,8 +45,8 @@ import (
 	"os"
 
 	chem "github.com/rmera/gochem"
+	"github.com/rmera/gochem/spatial"
 	v3 "github.com/rmera/gochem/v3"
-	"gonum.org/v1/gonum/stat/combin"
 )
 
 const (
@@ -317,35 +317,23 @@ func PlaneBetweenAtoms(at1, at2 *v3.Matrix, i, j int) *VPlane {
 
 // get all planes between all possible pairs of atoms
 // which are not farther away from each other than cutoff
+// The pairs are found with a cell list, so only close atoms are compared.
 func GetPlanes(atoms *v3.Matrix, mol chem.Atomer, cutoff float64, noHs ...bool) VPSlice {
 	var noH bool //false by default (it's zero-value)
 	if len(noHs) != 0 {
 		noH = noHs[0]
 	}
-	var ai, aj *v3.Matrix
-	//ai := v3.Zeros(1)
-	//aj := v3.Zeros(1)
-	tot := atoms.NVecs()
-	planes := make([]*VPlane, 0, combin.Binomial(tot, 2)/2) //I couldn't resist adding the binomial coefficient, sorry.
-	for i := 0; i < tot; i++ {
-		ai = atoms.VecView(i)
-		ati := mol.Atom(i)
-		if noH && ati.Symbol == "H" {
+	cells, err := spatial.NewCellList(atoms, cutoff)
+	if err != nil {
+		return VPSlice{} //only happens for non-positive cutoffs, in which case there are no planes.
+	}
+	pairs := cells.Pairs(cutoff)
+	planes := make([]*VPlane, 0, len(pairs))
+	for _, p := range pairs {
+		if noH && (mol.Atom(p.I).Symbol == "H" || mol.Atom(p.J).Symbol == "H") {
 			continue
 		}
-		for j := i + 1; j < tot; j++ {
-			aj = atoms.VecView(j)
-			atj := mol.Atom(j)
-			if noH && atj.Symbol == "H" {
-				continue
-			}
-			t := PlaneBetweenAtoms(ai, aj, i, j)
-			if t.Distance*2.0 > cutoff {
-				continue
-			}
-			planes = append(planes, t)
-
-		}
+		planes = append(planes, PlaneBetweenAtoms(atoms.VecView(p.I), atoms.VecView(p.J), p.I, p.J))
 	}
 	return VPSlice(planes)
 }